@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/affinode/gpu-idle-exporter/internal/aggregator"
+)
+
+// runAggregator serves the merged metrics of the remote exporters listed in
+// targets (see aggregator.ParseTargets) until interrupted. NVML is not used.
+func runAggregator(targets string, timeout time.Duration, httpPort, metricsPath string, serverTLS *httpTLS, protect func(http.Handler) http.Handler) {
+	parsed, err := aggregator.ParseTargets(targets)
+	if err != nil {
+		log.Fatalf("Invalid AGGREGATE_TARGETS: %v", err)
+	}
+	log.Printf("Aggregator mode: merging %d remote exporter(s) (timeout=%v, port=%s, path=%s); NVML is not used",
+		len(parsed), timeout, httpPort, metricsPath)
+	for _, t := range parsed {
+		log.Printf("  node %s: %s", t.Node, t.URL)
+	}
+
+	// A private registry: the remote series carry their own process and Go
+	// runtime metrics, which would clash with ours without a node label.
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(aggregator.New(parsed, timeout, aggregator.WithLogger(logger)))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, protect(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+	if err := serveHTTP(ctx, newHTTPServer(httpPort, mux, httpTimeoutsFromEnv()), serverTLS); err != nil && err != context.Canceled {
+		log.Fatalf("Service error: %v", err)
+	}
+	log.Println("GPU Idle Metrics Exporter stopped")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// basicAuth is the optional HTTP Basic Auth on the metrics endpoints, for
+// setups where client certificates are overkill.
+type basicAuth struct {
+	username, password string
+}
+
+// basicAuthFromEnv reads METRICS_USERNAME and METRICS_PASSWORD. It returns
+// nil if neither is set.
+func basicAuthFromEnv() (*basicAuth, error) {
+	username, password := os.Getenv("METRICS_USERNAME"), os.Getenv("METRICS_PASSWORD")
+	switch {
+	case username == "" && password == "":
+		return nil, nil
+	case username == "" || password == "":
+		return nil, errors.New("METRICS_USERNAME and METRICS_PASSWORD must be set together")
+	}
+	return &basicAuth{username: username, password: password}, nil
+}
+
+// wrap returns h behind the credentials, answering 401 with a
+// WWW-Authenticate challenge when they're missing or wrong. A nil
+// basicAuth returns h unchanged.
+func (a *basicAuth) wrap(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || !a.matches(username, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gpu-idle-exporter", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// matches compares the credentials in constant time. Both sides are hashed
+// first so the comparison doesn't leak their lengths either.
+func (a *basicAuth) matches(username, password string) bool {
+	hash := func(s string) []byte {
+		sum := sha256.Sum256([]byte(s))
+		return sum[:]
+	}
+	userOK := subtle.ConstantTimeCompare(hash(username), hash(a.username))
+	passOK := subtle.ConstantTimeCompare(hash(password), hash(a.password))
+	return userOK&passOK == 1
+}
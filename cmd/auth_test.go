@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	auth := &basicAuth{username: "prometheus", password: "s3cret"}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("gpu_idle_up 1\n"))
+	})))
+	handler := newHTTPServer("0", mux, httpTimeoutsFromEnv()).Handler
+
+	for _, c := range []struct {
+		name               string
+		path               string
+		username, password string
+		setAuth            bool
+		want               int
+	}{
+		{name: "correct", path: "/metrics", username: "prometheus", password: "s3cret", setAuth: true, want: http.StatusOK},
+		{name: "wrong password", path: "/metrics", username: "prometheus", password: "guess", setAuth: true, want: http.StatusUnauthorized},
+		{name: "wrong username", path: "/metrics", username: "admin", password: "s3cret", setAuth: true, want: http.StatusUnauthorized},
+		{name: "missing", path: "/metrics", want: http.StatusUnauthorized},
+		{name: "healthz without credentials", path: "/healthz", want: http.StatusOK},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, c.path, nil)
+			if c.setAuth {
+				req.SetBasicAuth(c.username, c.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != c.want {
+				t.Fatalf("expected status %d, got %d", c.want, rec.Code)
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if (c.want == http.StatusUnauthorized) != (challenge != "") {
+				t.Errorf("unexpected WWW-Authenticate %q with status %d", challenge, rec.Code)
+			}
+		})
+	}
+}
+
+func TestBasicAuthFromEnv(t *testing.T) {
+	if auth, err := basicAuthFromEnv(); auth != nil || err != nil {
+		t.Errorf("expected no auth by default, got %+v, %v", auth, err)
+	}
+	t.Setenv("METRICS_USERNAME", "prometheus")
+	if _, err := basicAuthFromEnv(); err == nil {
+		t.Error("expected an error for a username without a password")
+	}
+	t.Setenv("METRICS_PASSWORD", "s3cret")
+	if auth, err := basicAuthFromEnv(); err != nil || auth == nil {
+		t.Errorf("expected auth to be configured, got %+v, %v", auth, err)
+	}
+}
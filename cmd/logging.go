@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// logger is the leveled logger for structured events, handed to the
+// tracker and collector too. Set by setupLogging.
+var logger = slog.Default()
+
+// parseLogLevel parses LOG_LEVEL: debug, info, warn or error. Empty means
+// info.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo, err
+	}
+	return level, nil
+}
+
+// setupLogging sets logger from LOG_FORMAT and the level: "text", the
+// default, keeps the standard log output, with structured events rendered
+// as key=value pairs; "json" writes one JSON object per line to w, for log
+// pipelines. In JSON mode the plain log.Printf lines are carried as the
+// msg field of an INFO record, so every line parses; anything that must
+// survive LOG_LEVEL=warn, such as configuration warnings, goes through
+// logger instead. The level applies to structured events in both formats,
+// and to the plain lines in JSON mode.
+func setupLogging(format string, level slog.Level, w io.Writer) error {
+	switch format {
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+		slog.SetDefault(logger)
+		return nil
+	case "", "text":
+		logger = slog.New(levelHandler{level: level, Handler: slog.Default().Handler()})
+		return nil
+	default:
+		logger = slog.New(levelHandler{level: level, Handler: slog.Default().Handler()})
+		return fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+}
+
+// levelHandler filters the records of a handler by level. The standard
+// log-backed default handler always logs at info and above, and can't be
+// made the slog default again without looping through the log package.
+type levelHandler struct {
+	level slog.Level
+	slog.Handler
+}
+
+func (h levelHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{level: h.level, Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{level: h.level, Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restoreLogging undoes setupLogging at the end of a test.
+func restoreLogging(t *testing.T) {
+	prev, prevLogger, prevOut, prevFlags := slog.Default(), logger, log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		logger = prevLogger
+		log.SetOutput(prevOut)
+		log.SetFlags(prevFlags)
+	})
+}
+
+func TestSetupLoggingJSON(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	if err := setupLogging("json", slog.LevelInfo, &buf); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("idle: process became idle", "event", "idle", "gpu", 0, "pid", 1234)
+	log.Printf("Found %d GPU(s)", 8)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got:\n%s", buf.String())
+	}
+	var event, plain map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("structured line isn't JSON: %v", err)
+	}
+	if event["event"] != "idle" || event["gpu"] != 0.0 || event["pid"] != 1234.0 || event["level"] != "INFO" {
+		t.Errorf("unexpected structured record %v", event)
+	}
+	// Lines still logged with log.Printf are carried in msg
+	if err := json.Unmarshal([]byte(lines[1]), &plain); err != nil {
+		t.Fatalf("plain line isn't JSON: %v", err)
+	}
+	if plain["msg"] != "Found 8 GPU(s)" {
+		t.Errorf("unexpected plain record %v", plain)
+	}
+}
+
+func TestSetupLoggingLevel(t *testing.T) {
+	restoreLogging(t)
+	ctx := context.Background()
+	for _, format := range []string{"text", "json"} {
+		if err := setupLogging(format, slog.LevelWarn, &bytes.Buffer{}); err != nil {
+			t.Fatal(err)
+		}
+		if logger.Enabled(ctx, slog.LevelInfo) || !logger.Enabled(ctx, slog.LevelWarn) {
+			t.Errorf("%s: expected only warn and above enabled", format)
+		}
+	}
+	// Debug, for the tracker's per-process transitions
+	if err := setupLogging("text", slog.LevelDebug, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		t.Error("expected debug enabled")
+	}
+	if err := setupLogging("logfmt", slog.LevelInfo, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"WARN":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		if got, err := parseLogLevel(in); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestWarningsSurviveWarnLevel(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	if err := setupLogging("json", slog.LevelWarn, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// GPU 0 matches the poll interval; GPU 1's window expires between polls
+	logSampleWindows(map[int]time.Duration{0: 10 * time.Second, 1: time.Second}, 10*time.Second)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the warning, got:\n%s", buf.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["level"] != "WARN" || record["event"] != "sample_window_mismatch" || record["gpu"] != 1.0 {
+		t.Errorf("unexpected record %v", record)
+	}
+}
@@ -2,69 +2,347 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/affinode/gpu-idle-exporter/internal/collector"
+	"github.com/affinode/gpu-idle-exporter/internal/enrich"
 	"github.com/affinode/gpu-idle-exporter/internal/exporter"
 	"github.com/affinode/gpu-idle-exporter/internal/idle"
+	"github.com/affinode/gpu-idle-exporter/internal/listconfig"
+	"github.com/affinode/gpu-idle-exporter/internal/tracing"
 )
 
+var tracer = otel.Tracer("github.com/affinode/gpu-idle-exporter/cmd")
+
 func main() {
+	selftestFlag := flag.Bool("selftest", false, "run one collection, print a report of every GPU and process, and exit non-zero if critical NVML calls fail")
+	flag.Parse()
+	logLevel, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Printf("Invalid LOG_LEVEL, using info: %v", err)
+	}
+	if err := setupLogging(os.Getenv("LOG_FORMAT"), logLevel, os.Stderr); err != nil {
+		log.Printf("Invalid LOG_FORMAT, using text: %v", err)
+	}
+
 	// Parse configuration from environment
 	pollInterval := getEnvDuration("POLL_INTERVAL", 5*time.Second)
+	maxBackoff := getEnvDuration("POLL_MAX_BACKOFF", time.Minute)
+	procReadTimeout := getEnvDuration("PROC_READ_TIMEOUT", time.Second)
+	nvmlCallTimeout := getEnvDuration("NVML_CALL_TIMEOUT", collector.DefaultCallTimeout)
+	includeUtilOnly := getEnvBool("INCLUDE_UTIL_ONLY_PROCESSES", false)
 	httpPort := getEnvOrDefault("HTTP_PORT", "9835")
+	metricsPath := getEnvOrDefault("METRICS_PATH", "/metrics")
+	if err := checkMetricsPath(metricsPath); err != nil {
+		log.Fatalf("Invalid METRICS_PATH: %v", err)
+	}
+	serverTLS, err := httpTLSFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	auth, err := basicAuthFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid basic auth configuration: %v", err)
+	}
+	if auth != nil && serverTLS == nil {
+		logger.Warn("METRICS_USERNAME is set without TLS_CERT_FILE; credentials are sent in the clear", "event", "insecure_config")
+	}
+	// Everything but /healthz, which liveness probes reach without
+	// credentials or a client certificate
+	protect := func(h http.Handler) http.Handler { return serverTLS.wrap(auth.wrap(h)) }
+	tracesEndpoint := os.Getenv("OTEL_TRACES_ENDPOINT")
+	mockCfg, mockMode := mockConfigFromEnv()
+
+	if targets := os.Getenv("AGGREGATE_TARGETS"); targets != "" {
+		runAggregator(targets, getEnvDuration("AGGREGATE_TIMEOUT", 5*time.Second), httpPort, metricsPath, serverTLS, protect)
+		return
+	}
+
+	version, commit := buildVersion()
+	log.Printf("GPU Idle Metrics Exporter %s (%s) starting (poll=%v, port=%s, path=%s)", version, commit, pollInterval, httpPort, metricsPath)
 
-	log.Printf("GPU Idle Metrics Exporter starting (poll=%v, port=%s)", pollInterval, httpPort)
-
-	// Initialize NVML
-	ret := nvml.Init()
-	if ret != nvml.SUCCESS {
-		log.Fatalf("Failed to initialize NVML: %v", nvml.ErrorString(ret))
-	}
-	defer nvml.Shutdown()
-	log.Println("NVML initialized successfully")
-
-	// Log GPU info
-	count, ret := nvml.DeviceGetCount()
-	if ret == nvml.SUCCESS {
-		log.Printf("Found %d GPU(s)", count)
-		for i := 0; i < count; i++ {
-			if device, ret := nvml.DeviceGetHandleByIndex(i); ret == nvml.SUCCESS {
-				name, _ := device.GetName()
-				uuid, _ := device.GetUUID()
-				log.Printf("  GPU %d: %s (%s)", i, name, uuid)
+	// Tracing is a no-op unless an OTLP endpoint is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), tracesEndpoint)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			log.Printf("tracing shutdown error: %v", err)
+		}
+	}()
+	if tracesEndpoint != "" {
+		log.Printf("Exporting poll traces to %s", tracesEndpoint)
+	}
+
+	podLabels := getEnvBool("PROCESS_LABEL_POD", false)
+	cgroupLabel := getEnvBool("PROCESS_LABEL_CGROUP", false)
+	// Sidecar metadata files are found by container ID, which needs pod attribution
+	metaTemplate := os.Getenv("PROCESS_META_TEMPLATE")
+	// Namespace idle policies need each process's namespace, found by pod UID
+	namespaceConfig := os.Getenv("NAMESPACE_IDLE_CONFIG")
+	var podLogDir string
+	if namespaceConfig != "" {
+		podLogDir = getEnvOrDefault("POD_LOG_DIR", collector.DefaultPodLogDir)
+	}
+	var coll snapshotCollector
+	// startNVML initializes NVML and probes the sample window of each GPU;
+	// nil in synthetic mode
+	var startNVML func(ctx context.Context) (map[int]time.Duration, error)
+	if mockMode {
+		// Synthetic mode: no NVML, generated processes with churn
+		log.Printf("Synthetic mode: %d process(es) on %d GPU(s), churn %.1f%% per poll, seed %d; NVML is not used",
+			mockCfg.Processes, mockCfg.GPUs, mockCfg.ChurnRate*100, mockCfg.Seed)
+		coll = collector.NewMock(mockCfg)
+	} else {
+		// A misread filter would export GPUs that were meant to be left
+		// alone, so it's fatal rather than ignored
+		include, err := collector.ParseGPUSet(os.Getenv("GPU_INCLUDE"))
+		if err != nil {
+			log.Fatalf("Invalid GPU_INCLUDE: %v", err)
+		}
+		exclude, err := collector.ParseGPUSet(os.Getenv("GPU_EXCLUDE"))
+		if err != nil {
+			log.Fatalf("Invalid GPU_EXCLUDE: %v", err)
+		}
+
+		nvmlColl := collector.New(
+			collector.WithLogger(logger),
+			collector.WithGPUFilter(include, exclude),
+			collector.WithProcReadTimeout(procReadTimeout),
+			collector.WithCallTimeout(nvmlCallTimeout),
+			collector.WithUtilOnlyProcesses(includeUtilOnly),
+			collector.WithPersistencedCheck(getEnvBool("CHECK_PERSISTENCED", false)),
+			collector.WithPodAttribution(podLabels || cgroupLabel || metaTemplate != "" || podLogDir != ""),
+			collector.WithPodNamespaces(podLogDir),
+		)
+		coll = nvmlColl
+		retry := nvmlInitRetry{
+			attempts: getEnvInt("NVML_INIT_MAX_ATTEMPTS", 10),
+			base:     time.Second,
+			max:      getEnvDuration("NVML_INIT_MAX_BACKOFF", 30*time.Second),
+		}
+		startNVML = func(ctx context.Context) (map[int]time.Duration, error) {
+			if err := initNVML(ctx, nvml.Init, retry); err != nil {
+				return nil, err
 			}
+			logGPUs()
+			windows := nvmlColl.ProbeSampleWindows()
+			logSampleWindows(windows, pollInterval)
+			return windows, nil
 		}
 	}
 
-	// Build constant labels from environment (for deployment mode identification)
-	constLabels := prometheus.Labels{}
-	for _, pair := range []struct{ env, label string }{
-		{"NODE_NAME", "node"},
-		{"POD_NAME", "pod"},
-		{"POD_NAMESPACE", "namespace"},
-	} {
-		if v := os.Getenv(pair.env); v != "" {
-			constLabels[pair.label] = v
+	if *selftestFlag || getEnvBool("SELFTEST", false) {
+		if startNVML != nil {
+			if _, err := startNVML(context.Background()); err != nil {
+				log.Fatal(err)
+			}
 		}
+		// os.Exit skips NVML's shutdown, which a one-shot run doesn't need
+		if !selftest(context.Background(), coll, os.Stdout) {
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
+	// Build constant labels from environment (for deployment mode identification)
+	constLabels := constLabelsFromEnv()
+
 	// Create components
-	coll := collector.New()
-	tracker := idle.NewTracker()
-	prom := exporter.New(constLabels)
+	globalPolicy := idle.DefaultPolicy
+	if threshold := getEnvInt("IDLE_SM_THRESHOLD", 0); threshold >= 0 && threshold <= 100 {
+		globalPolicy.SmThreshold = uint32(threshold)
+	} else {
+		log.Printf("Invalid IDLE_SM_THRESHOLD=%d (want 0-100), using default %d", threshold, idle.DefaultPolicy.SmThreshold)
+	}
+	trackerOpts := []idle.Option{idle.WithDefaultPolicy(globalPolicy), idle.WithLogger(logger)}
+	if namespaceConfig != "" {
+		policies, err := idle.ParseNamespacePolicies(namespaceConfig, globalPolicy)
+		if err != nil {
+			log.Printf("Invalid NAMESPACE_IDLE_CONFIG, using global idle policy for all namespaces: %v", err)
+		} else {
+			trackerOpts = append(trackerOpts, idle.WithNamespacePolicies(policies))
+			log.Printf("Loaded idle policies for %d namespace(s)", len(policies))
+		}
+	}
+	switch mode := idle.TransitionLogging(getEnvOrDefault("LOG_TRANSITIONS", string(idle.LogTransitionsSampled))); mode {
+	case idle.LogTransitionsOff, idle.LogTransitionsSampled, idle.LogTransitionsAll:
+		trackerOpts = append(trackerOpts, idle.WithTransitionLogging(mode))
+	default:
+		log.Printf("Invalid LOG_TRANSITIONS=%q, using %s", mode, idle.LogTransitionsSampled)
+	}
+	if n := getEnvInt("IDLE_CONFIRM_POLLS", 1); n > 1 {
+		trackerOpts = append(trackerOpts, idle.WithIdleConfirmCount(n))
+		log.Printf("Processes go idle after %d consecutive idle polls", n)
+	}
+	if polls := getEnvInt("IDLE_MEMORY_STABLE_POLLS", 0); polls > 0 {
+		deltaMiB := getEnvInt("IDLE_MEMORY_STABLE_DELTA_MIB", 64)
+		trackerOpts = append(trackerOpts, idle.WithMemoryStability(polls, uint64(deltaMiB)<<20))
+		log.Printf("Idle episodes require memory stable within %d MiB for %d poll(s)", deltaMiB, polls)
+	}
+	if d := getEnvDuration("IDLE_MIN_EPISODE_DURATION", 0); d > 0 {
+		trackerOpts = append(trackerOpts, idle.WithMinEpisodeDuration(d))
+		log.Printf("Idle episodes shorter than %v are not reported", d)
+	}
+	if alpha := getEnvFloat("UTIL_SMOOTHING_FACTOR", idle.DefaultUtilSmoothing); alpha > 0 && alpha <= 1 {
+		trackerOpts = append(trackerOpts, idle.WithUtilSmoothing(alpha))
+	} else {
+		log.Printf("Invalid UTIL_SMOOTHING_FACTOR=%v (want 0 < factor <= 1), using default %v", alpha, idle.DefaultUtilSmoothing)
+	}
+	staleTimeout := getEnvDuration("STALE_TIMEOUT", idle.DefaultStaleTimeout)
+	if staleTimeout <= 0 {
+		log.Printf("Invalid STALE_TIMEOUT=%v, using default %v", staleTimeout, idle.DefaultStaleTimeout)
+		staleTimeout = idle.DefaultStaleTimeout
+	}
+	if staleTimeout < minStalePolls*pollInterval {
+		logger.Warn("STALE_TIMEOUT is less than the minimum poll intervals; vanished processes may be forgotten before they are reported stale",
+			"event", "invalid_config", "stale_timeout", staleTimeout, "min_polls", minStalePolls, "min_timeout", minStalePolls*pollInterval)
+	}
+	trackerOpts = append(trackerOpts, idle.WithStaleTimeout(staleTimeout))
+	if w := getEnvFloat("IDLE_POWER_FLOOR_WATTS", 0); w > 0 {
+		trackerOpts = append(trackerOpts, idle.WithPowerFloorWatts(w))
+		log.Printf("Processes on GPUs drawing %gW or more are never idle", w)
+	}
+	lists := &listOptions{invalid: make(map[string]int)}
+	exporterOpts := []exporter.Option{exporter.WithLogger(logger)}
+	// A misread entry at worst lets a system GPU show up as waste, so
+	// invalid entries are dropped rather than fatal
+	systemGPUs, err := collector.ParseGPUSet(os.Getenv("SYSTEM_GPUS"))
+	lists.record("SYSTEM_GPUS", err)
+	if !systemGPUs.Empty() {
+		trackerOpts = append(trackerOpts, idle.WithSystemGPUs(systemGPUs))
+		exporterOpts = append(exporterOpts, exporter.WithSystemGPUs(systemGPUs))
+		log.Printf("Processes on system GPUs %s are left out of idle aggregates", os.Getenv("SYSTEM_GPUS"))
+	}
+	tracker := idle.NewTracker(trackerOpts...)
+	if !getEnvBool("EMIT_ACTIVE_PROCESSES", true) {
+		exporterOpts = append(exporterOpts, exporter.WithIdleProcessesOnly())
+		log.Printf("Per-process metrics are emitted for idle processes only")
+	}
+	switch mode := exporter.ProcessMetricsMode(getEnvOrDefault("PROCESS_METRICS_MODE", string(exporter.ProcessMetricsDetailed))); mode {
+	case exporter.ProcessMetricsDetailed:
+	case exporter.ProcessMetricsAggregated:
+		exporterOpts = append(exporterOpts, exporter.WithProcessMetricsMode(mode))
+		log.Printf("Per-process metrics are aggregated by process name and GPU, without pid")
+	default:
+		log.Printf("Invalid PROCESS_METRICS_MODE=%q, using %s", mode, exporter.ProcessMetricsDetailed)
+	}
+	exporterOpts = append(exporterOpts, exporter.WithReclaimSafetyDuration(
+		getEnvDuration("RECLAIM_SAFETY_DURATION", exporter.DefaultReclaimSafetyDuration)))
+	squatFraction := getEnvFloat("SQUAT_MEMORY_FRACTION", exporter.DefaultSquatMemoryFraction)
+	if squatFraction <= 0 || squatFraction > 1 {
+		log.Printf("Invalid SQUAT_MEMORY_FRACTION=%v (want 0 < fraction <= 1), using default %v", squatFraction, exporter.DefaultSquatMemoryFraction)
+		squatFraction = exporter.DefaultSquatMemoryFraction
+	}
+	exporterOpts = append(exporterOpts, exporter.WithSquatDetection(squatFraction,
+		getEnvDuration("SQUAT_MIN_IDLE_DURATION", exporter.DefaultSquatMinIdle)))
+	if entries := lists.get("ECC_EXPECTED", "", nil); len(entries) > 0 {
+		exporterOpts = append(exporterOpts, exporter.WithECCExpected(entries))
+	}
+	if getEnvBool("ENABLE_NATIVE_HISTOGRAMS", false) {
+		factor := getEnvFloat("NATIVE_HISTOGRAM_BUCKET_FACTOR", exporter.DefaultNativeBucketFactor)
+		if factor <= 1 {
+			log.Printf("Invalid NATIVE_HISTOGRAM_BUCKET_FACTOR=%v (want > 1), using default %v", factor, exporter.DefaultNativeBucketFactor)
+			factor = exporter.DefaultNativeBucketFactor
+		}
+		exporterOpts = append(exporterOpts, exporter.WithNativeHistograms(factor))
+		log.Printf("Emitting native histograms (bucket factor %v); scrape with the protobuf format", factor)
+	}
+	if n := getEnvInt("GPU_MAX_PROCESSES", 0); n > 0 {
+		exporterOpts = append(exporterOpts, exporter.WithMaxProcessesPerGPU(n))
+	}
+	switch style := getEnvOrDefault("METRIC_STYLE", "native"); style {
+	case "native":
+	case "dcgm":
+		exporterOpts = append(exporterOpts, exporter.WithDCGMMetrics())
+		log.Println("Also emitting dcgm-exporter compatible device metrics")
+	default:
+		log.Printf("Invalid METRIC_STYLE=%q, using native metrics only", style)
+	}
+	if v := os.Getenv("DEVICE_LABELS"); v != "" {
+		labels, err := exporter.ParseDeviceLabels(v)
+		lists.record("DEVICE_LABELS", err)
+		if labels != nil {
+			exporterOpts = append(exporterOpts, exporter.WithDeviceLabels(labels))
+		} else {
+			log.Printf("Using the default DEVICE_LABELS %v", exporter.DefaultDeviceLabels)
+		}
+	}
+	// One resolver, and so one cache, for both per-user metrics and user labels
+	var users *collector.UserResolver
+	if path := os.Getenv("PASSWD_FILE"); path != "" {
+		names, err := collector.ReadPasswd(path)
+		users = collector.NewUserResolver(names)
+		if err != nil {
+			log.Printf("Failed to read PASSWD_FILE, per-user idle memory disabled: %v", err)
+		} else {
+			exporterOpts = append(exporterOpts, exporter.WithUserNames(users.Name))
+			log.Printf("Resolving idle memory per user from %s (%d users)", path, len(names))
+		}
+	}
+	var enrichers []exporter.Enricher
+	if cgroupLabel {
+		enrichers = append(enrichers, enrich.Cgroup{})
+	}
+	if podLabels {
+		enrichers = append(enrichers, enrich.Pod{})
+	}
+	if getEnvBool("PROCESS_LABEL_USER", false) {
+		if users == nil {
+			users = collector.NewUserResolver(nil)
+		}
+		enrichers = append(enrichers, enrich.NewUser(users.Name))
+	}
+	if metaTemplate != "" {
+		fields := lists.get("PROCESS_META_FIELDS", "job,owner,team", enrich.CheckLabelName)
+		meta, err := enrich.NewMeta(metaTemplate, fields, getEnvDuration("PROCESS_META_REFRESH", time.Minute))
+		if err != nil {
+			log.Printf("Invalid PROCESS_META_TEMPLATE, ignoring: %v", err)
+		} else {
+			enrichers = append(enrichers, meta)
+		}
+	}
+	if vars := lists.get("PROCESS_LABEL_ENV_VARS", "", enrich.CheckLabelName); len(vars) > 0 {
+		env, err := enrich.NewEnv(collector.ProcReader("/proc", procReadTimeout), vars)
+		if err != nil {
+			log.Printf("Invalid PROCESS_LABEL_ENV_VARS, ignoring: %v", err)
+		} else {
+			enrichers = append(enrichers, env)
+		}
+	}
+	if len(enrichers) > 0 {
+		exporterOpts = append(exporterOpts, exporter.WithEnrichers(enrichers...))
+	}
+	prom := exporter.New(constLabels, exporterOpts...)
 	prom.Register()
+	prom.SetConfig(tracker.GlobalPolicy(), tracker.StaleTimeout(), pollInterval)
+	prom.SetBuildInfo(version, commit)
+	for option, n := range lists.invalid {
+		prom.RecordInvalidConfigEntries(option, n)
+	}
 
 	// Context with signal handling
 	ctx, cancel := context.WithCancel(context.Background())
@@ -78,61 +356,114 @@ func main() {
 		cancel()
 	}()
 
+	// Air-gapped nodes can't be scraped; their metrics are collected by
+	// copying a file instead, which makes the HTTP server optional
+	var metricsOut *metricsFile
+	if path := os.Getenv("METRICS_FILE"); path != "" {
+		metricsOut = &metricsFile{
+			path:    path,
+			gather:  prometheus.DefaultGatherer,
+			timeout: getEnvDuration("METRICS_FILE_TIMEOUT", 5*time.Second),
+		}
+		log.Printf("Writing metrics to %s after every poll", path)
+	}
+	httpEnabled := getEnvBool("HTTP_ENABLED", true)
+	if !httpEnabled && metricsOut == nil {
+		log.Fatal("HTTP_ENABLED=false needs METRICS_FILE, or metrics can't be collected at all")
+	}
+	// Profiles expose internals and cost CPU while taken, so opt-in only
+	pprofEnabled, pprofPort := getEnvBool("ENABLE_PPROF", false), os.Getenv("PPROF_PORT")
+	if pprofEnabled && pprofPort == "" && !httpEnabled {
+		log.Fatal("ENABLE_PPROF=true with HTTP_ENABLED=false needs PPROF_PORT")
+	}
+	if pprofEnabled {
+		log.Printf("Serving pprof profiles under %s on port %s", pprofPath, getEnvOrDefault("PPROF_PORT", httpPort))
+	}
+
+	markEnded := getEnvBool("MARK_ENDED_ON_SHUTDOWN", false)
+	shutdownDrain := getEnvDuration("SHUTDOWN_DRAIN_PERIOD", 15*time.Second)
+	if !httpEnabled {
+		// Nothing scrapes during a drain; the ended state goes to the file
+		shutdownDrain = 0
+	}
+	if markEnded && shutdownDrain+httpShutdownTimeout > kubernetesGracePeriod {
+		logger.Warn("SHUTDOWN_DRAIN_PERIOD plus the HTTP shutdown exceeds Kubernetes' default termination grace period; raise terminationGracePeriodSeconds",
+			"event", "invalid_config", "drain", shutdownDrain, "http_shutdown", httpShutdownTimeout, "grace_period", kubernetesGracePeriod)
+	}
+
 	g, gctx := errgroup.WithContext(ctx)
 
-	// Goroutine 1: Polling loop
+	// Goroutine 1: Polling loop, restarted if it panics so a bug in one
+	// poll cycle doesn't take the HTTP server down with it. NVML is
+	// initialized here rather than up front, so /healthz answers while the
+	// driver is still loading instead of the pod crash-looping.
+	pollDone := make(chan struct{})
 	g.Go(func() error {
-		ticker := time.NewTicker(pollInterval)
-		defer ticker.Stop()
-
-		// Run once immediately
-		poll(coll, tracker, prom)
-
-		for {
-			select {
-			case <-gctx.Done():
-				return gctx.Err()
-			case <-ticker.C:
-				poll(coll, tracker, prom)
+		defer close(pollDone)
+		if startNVML != nil {
+			windows, err := startNVML(gctx)
+			if err != nil {
+				return err
 			}
+			defer nvml.Shutdown()
+			prom.SetNVMLInitialized(true)
+			versions := readSystemVersions()
+			log.Printf("NVIDIA driver %s, CUDA %s, NVML %s", versions.driver, versions.cuda, versions.nvml)
+			prom.SetSystemInfo(versions.driver, versions.cuda, versions.nvml)
+			prom.SetSampleWindows(windows)
 		}
-	})
-
-	// Goroutine 2: HTTP server
-	g.Go(func() error {
-		mux := http.NewServeMux()
-		mux.Handle("/metrics", promhttp.Handler())
-		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("ok\n"))
-		})
-
-		srv := &http.Server{
-			Addr:    ":" + httpPort,
-			Handler: mux,
+		backoff := &pollBackoff{base: pollInterval, max: maxBackoff}
+		pollOnce := func(ctx context.Context) error {
+			err := poll(ctx, coll, tracker, prom)
+			if metricsOut != nil {
+				metricsOut.update()
+			}
+			return err
 		}
+		return supervise(gctx, "poll loop", pollRestartDelay, prom.RecordPollRestart, func(ctx context.Context) error {
+			return pollLoop(ctx, pollOnce, backoff, prom)
+		})
+	})
 
-		errCh := make(chan error, 1)
-		go func() {
-			log.Printf("HTTP server listening on :%s (/metrics, /healthz)", httpPort)
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				errCh <- fmt.Errorf("http server error: %w", err)
+	// Goroutine 2: HTTP server. With MARK_ENDED_ON_SHUTDOWN it outlives
+	// the polling loop by the drain period, so a final scrape sees every
+	// process ended.
+	httpCtx, stopHTTP := gctx, func() {}
+	if markEnded {
+		httpCtx, stopHTTP = context.WithCancel(context.Background())
+	}
+	httpDone := make(chan struct{})
+	if httpEnabled {
+		g.Go(func() error {
+			defer close(httpDone)
+			mux := http.NewServeMux()
+			mux.Handle(metricsPath, protect(promhttp.Handler()))
+			mux.Handle(path.Join(metricsPath, "metadata"), protect(prom.MetadataHandler()))
+			if pprofEnabled && pprofPort == "" {
+				registerPprof(mux, protect)
 			}
-		}()
+			return serveHTTP(httpCtx, newHTTPServer(httpPort, mux, httpTimeoutsFromEnv()), serverTLS)
+		})
+	}
+	if pprofEnabled && pprofPort != "" {
+		g.Go(func() error {
+			mux := http.NewServeMux()
+			registerPprof(mux, protect)
+			return serveHTTP(gctx, newHTTPServer(pprofPort, mux, pprofTimeouts()), serverTLS)
+		})
+	}
 
-		select {
-		case err := <-errCh:
-			return err
-		case <-gctx.Done():
-			log.Println("HTTP server shutting down...")
-			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer shutdownCancel()
-			if err := srv.Shutdown(shutdownCtx); err != nil {
-				return fmt.Errorf("http server shutdown error: %w", err)
+	// Goroutine 3: end-of-life marking, then the HTTP server's shutdown
+	if markEnded {
+		g.Go(func() error {
+			defer stopHTTP()
+			markEndedOnShutdown(pollDone, httpDone, prom, shutdownDrain)
+			if metricsOut != nil {
+				metricsOut.update()
 			}
-			return gctx.Err()
-		}
-	})
+			return nil
+		})
+	}
 
 	if err := g.Wait(); err != nil && err != context.Canceled {
 		log.Fatalf("Service error: %v", err)
@@ -141,15 +472,356 @@ func main() {
 	log.Println("GPU Idle Metrics Exporter stopped")
 }
 
+// minStalePolls is the fewest poll intervals STALE_TIMEOUT should span
+// without a warning.
+const minStalePolls = 3
+
+// httpShutdownTimeout bounds how long the HTTP server waits for in-flight
+// requests on shutdown.
+const httpShutdownTimeout = 5 * time.Second
+
+// kubernetesGracePeriod is Kubernetes' default terminationGracePeriodSeconds,
+// after which the container is killed.
+const kubernetesGracePeriod = 30 * time.Second
+
+// endMarker marks every tracked process as ended; implemented by the exporter.
+type endMarker interface {
+	MarkProcessesEnded() int
+}
+
+// markEndedOnShutdown waits for the polling loop to stop, marks every
+// process ended and then waits out the drain period, so Prometheus gets a
+// final scrape of the terminal state before the HTTP server stops. It
+// returns early if the HTTP server stops first.
+func markEndedOnShutdown(pollDone, httpDone <-chan struct{}, m endMarker, drain time.Duration) {
+	select {
+	case <-pollDone:
+	case <-httpDone:
+		return
+	}
+	n := m.MarkProcessesEnded()
+	log.Printf("Marked %d process(es) ended; serving metrics for %v before exiting", n, drain)
+	select {
+	case <-time.After(drain):
+	case <-httpDone:
+	}
+}
+
+// logSampleWindows logs each GPU's utilization sample window and warns
+// where the poll interval is poorly aligned with it, since that silently
+// skews idle detection.
+func logSampleWindows(windows map[int]time.Duration, pollInterval time.Duration) {
+	gpus := make([]int, 0, len(windows))
+	for gpu := range windows {
+		gpus = append(gpus, gpu)
+	}
+	sort.Ints(gpus)
+	for _, gpu := range gpus {
+		w := windows[gpu]
+		problem, recommended, mismatched := collector.SampleWindowMismatch(w, pollInterval)
+		if !mismatched {
+			log.Printf("  GPU %d: utilization sample window %v", gpu, w)
+			continue
+		}
+		logger.Warn("utilization sample window is poorly aligned with POLL_INTERVAL", "event", "sample_window_mismatch",
+			"gpu", gpu, "window", w, "poll_interval", pollInterval, "problem", problem, "recommended_poll_interval", recommended)
+	}
+}
+
+// minHTTPWriteTimeout is the lowest accepted HTTP_WRITE_TIMEOUT: rendering
+// /metrics on a dense node with many processes can take several seconds.
+const minHTTPWriteTimeout = 5 * time.Second
+
+// httpTimeouts are the HTTP server's connection timeouts. Without them slow
+// or idle clients can hold connections open indefinitely.
+type httpTimeouts struct {
+	read  time.Duration // reading the whole request, headers included
+	write time.Duration // from the end of the request headers to the end of the response
+	idle  time.Duration // keep-alive connections between requests
+}
+
+// httpTimeoutsFromEnv reads HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and
+// HTTP_IDLE_TIMEOUT. A write timeout below minHTTPWriteTimeout is replaced
+// by the default.
+func httpTimeoutsFromEnv() httpTimeouts {
+	const defaultWrite = 30 * time.Second
+	t := httpTimeouts{
+		read:  getEnvDuration("HTTP_READ_TIMEOUT", 10*time.Second),
+		write: getEnvDuration("HTTP_WRITE_TIMEOUT", defaultWrite),
+		idle:  getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	}
+	if t.write < minHTTPWriteTimeout {
+		log.Printf("HTTP_WRITE_TIMEOUT=%v is below the minimum %v, using default %v", t.write, minHTTPWriteTimeout, defaultWrite)
+		t.write = defaultWrite
+	}
+	return t
+}
+
+// healthzPath is where the liveness endpoint is served, whatever METRICS_PATH is.
+const healthzPath = "/healthz"
+
+// checkMetricsPath checks that p can be the path of the metrics endpoint.
+func checkMetricsPath(p string) error {
+	if !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("%q does not start with /", p)
+	}
+	if path.Clean(p) == healthzPath {
+		return fmt.Errorf("%q is reserved for the liveness endpoint", p)
+	}
+	return nil
+}
+
+// newHTTPServer returns a server for mux, plus /healthz, on port.
+func newHTTPServer(port string, mux *http.ServeMux, timeouts httpTimeouts) *http.Server {
+	mux.HandleFunc(healthzPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+
+	return &http.Server{
+		Addr:         ":" + port,
+		Handler:      mux,
+		ReadTimeout:  timeouts.read,
+		WriteTimeout: timeouts.write,
+		IdleTimeout:  timeouts.idle,
+	}
+}
+
+// serveHTTP runs srv, over TLS if serverTLS is non-nil, until ctx is
+// cancelled, then shuts it down gracefully.
+func serveHTTP(ctx context.Context, srv *http.Server, serverTLS *httpTLS) error {
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if serverTLS != nil {
+			srv.TLSConfig = serverTLS.config
+			log.Printf("HTTPS server listening on %s (client certificates required: %v)",
+				srv.Addr, serverTLS.requireClientCert)
+			err = srv.ListenAndServeTLS(serverTLS.certFile, serverTLS.keyFile)
+		} else {
+			log.Printf("HTTP server listening on %s", srv.Addr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			errCh <- fmt.Errorf("http server error: %w", err)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		log.Println("HTTP server shutting down...")
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer shutdownCancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("http server shutdown error: %w", err)
+		}
+		return ctx.Err()
+	}
+}
+
+// snapshotCollector is the collection step of a poll cycle.
+type snapshotCollector interface {
+	Collect(ctx context.Context) (*collector.Snapshot, error)
+}
+
 // poll runs one collection cycle: collect -> track idle -> update Prometheus.
-func poll(coll *collector.Collector, tracker *idle.Tracker, prom *exporter.Exporter) {
-	snap, err := coll.Collect()
+// Each stage is recorded as a child span of a "poll" span, and its
+// duration in gpu_idle_scrape_stage_seconds. While NVML re-initializes,
+// metrics are left at their last values and marked suspended.
+// The collection error, if any, is returned so the caller can back off.
+func poll(ctx context.Context, coll snapshotCollector, tracker *idle.Tracker, prom *exporter.Exporter) error {
+	ctx, span := tracer.Start(ctx, "poll")
+	defer span.End()
+
+	collectStart := time.Now()
+	snap, err := coll.Collect(ctx)
+	prom.RecordCollection(time.Since(collectStart), err)
 	if err != nil {
-		log.Printf("collection error: %v", err)
-		return
+		span.RecordError(err)
+		logger.Error("collection error", "event", "collection_error", "err", err)
+		if errors.Is(err, collector.ErrReinitializing) {
+			prom.SetCollectionSuspended(true)
+			prom.SetNVMLReinitializing(true)
+		}
+		return err
 	}
+	prom.SetNVMLReinitializing(false)
+	// Until NVML has recovered every GPU the snapshot may be missing some
+	// or hold garbage: keep the last values rather than export dips
+	prom.SetCollectionSuspended(snap.Partial)
+	if snap.Partial {
+		span.SetAttributes(attribute.Bool("partial", true))
+		return nil
+	}
+	span.SetAttributes(
+		attribute.Int("gpu.count", len(snap.Devices)),
+		attribute.Int("process.count", len(snap.Processes)),
+	)
+
+	_, trackSpan := tracer.Start(ctx, "tracker.Update")
+	trackStart := time.Now()
 	states := tracker.Update(snap)
-	prom.UpdateMetrics(snap, states)
+	trackDuration := time.Since(trackStart)
+	trackSpan.End()
+	if tracker.ClockSkewed() {
+		prom.RecordClockSkew()
+	}
+	prom.RecordEpisodes(tracker.ClosedEpisodes())
+	prom.SetDeviceIdle(tracker.Devices())
+	prom.RecordProcessChurn(tracker.NewProcesses(), tracker.NewProcessRate())
+
+	_, updateSpan := tracer.Start(ctx, "exporter.UpdateMetrics")
+	updateStart := time.Now()
+	prom.UpdateMetrics(snap, states, tracker.Stale())
+	updateDuration := time.Since(updateStart)
+	updateSpan.End()
+	prom.RecordStageTimings(snap.Stages, trackDuration, updateDuration)
+	prom.SetLastCollection(snap.Timestamp)
+	return nil
+}
+
+// pollLoop polls until ctx is cancelled. Collection failures are
+// recoverable: they are logged and stretch the interval exponentially (up
+// to the backoff's max) to go easy on a broken driver, but never end the
+// loop. Only the context's error is returned.
+func pollLoop(ctx context.Context, pollOnce func(context.Context) error, backoff *pollBackoff, prom *exporter.Exporter) error {
+	timer := time.NewTimer(0) // run once immediately
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			delay := backoff.next(pollOnce(ctx))
+			prom.SetConsecutiveFailures(backoff.failures)
+			if delay > backoff.base {
+				logger.Warn("collection failing, backing off", "event", "collection_backoff", "failures", backoff.failures, "next_attempt", delay)
+			}
+			timer.Reset(delay)
+		}
+	}
+}
+
+// pollRestartDelay is how long supervise waits before restarting the
+// polling loop after a panic, so a panic on every poll doesn't spin.
+const pollRestartDelay = time.Second
+
+// supervise runs fn, restarting it after delay whenever it panics, and
+// calls onRestart before each restart. It returns fn's error once fn
+// returns normally, or the context's error if ctx is cancelled meanwhile.
+func supervise(ctx context.Context, name string, delay time.Duration, onRestart func(), fn func(context.Context) error) error {
+	for {
+		panicked, err := runRecovered(ctx, name, fn)
+		if !panicked {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		logger.Warn("restarting after panic", "event", "restart", "goroutine", name)
+		onRestart()
+	}
+}
+
+// runRecovered runs fn, converting a panic into panicked=true.
+func runRecovered(ctx context.Context, name string, fn func(context.Context) error) (panicked bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("recovered panic", "event", "panic", "goroutine", name, "panic", r, "stack", string(debug.Stack()))
+			panicked = true
+		}
+	}()
+	return false, fn(ctx)
+}
+
+// pollBackoff computes the delay before the next poll: the base interval
+// while healthy, doubling per consecutive failure up to max.
+type pollBackoff struct {
+	base     time.Duration
+	max      time.Duration
+	failures int
+}
+
+// next records the outcome of a poll and returns the delay before the next one.
+func (b *pollBackoff) next(err error) time.Duration {
+	if err == nil {
+		if b.failures > 0 {
+			logger.Info("collection recovered", "event", "collection_recovered", "failures", b.failures)
+		}
+		b.failures = 0
+		return b.base
+	}
+	b.failures++
+	delay := b.base
+	for i := 1; i < b.failures && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	return delay
+}
+
+// deploymentModes are the accepted values of DEPLOYMENT_MODE.
+var deploymentModes = map[string]bool{
+	"daemonset":  true,
+	"deployment": true,
+	"sidecar":    true,
+	"standalone": true,
+}
+
+// constLabelsFromEnv builds the constant labels attached to every metric:
+// node/pod/namespace from the downward API, and the deployment mode.
+func constLabelsFromEnv() prometheus.Labels {
+	constLabels := prometheus.Labels{}
+	for _, pair := range []struct{ env, label string }{
+		{"NODE_NAME", "node"},
+		{"POD_NAME", "pod"},
+		{"POD_NAMESPACE", "namespace"},
+	} {
+		if v := os.Getenv(pair.env); v != "" {
+			constLabels[pair.label] = v
+		}
+	}
+
+	if v := os.Getenv("DEPLOYMENT_MODE"); v != "" {
+		mode := strings.ToLower(v)
+		if deploymentModes[mode] {
+			constLabels["mode"] = mode
+		} else {
+			log.Printf("Invalid DEPLOYMENT_MODE=%q (want daemonset, deployment, sidecar or standalone), omitting mode label", v)
+		}
+	}
+	return constLabels
+}
+
+// listOptions reads comma-separated list options from the environment with
+// listconfig.Parse, logging and tallying the entries it drops so config
+// mistakes are loud rather than silently ignored.
+type listOptions struct {
+	invalid map[string]int // option -> entries dropped
+}
+
+// get returns the valid entries of the list option key, or of defaultValue
+// if it is unset. validate may be nil.
+func (l *listOptions) get(key, defaultValue string, validate func(string) error) []string {
+	entries, err := listconfig.Parse(getEnvOrDefault(key, defaultValue), listconfig.MaxEntries, validate)
+	l.record(key, err)
+	return entries
+}
+
+// record logs and tallies the entries err reports dropped from option key.
+func (l *listOptions) record(key string, err error) {
+	if err == nil {
+		return
+	}
+	log.Printf("Invalid entries in %s, ignoring them: %v", key, err)
+	l.invalid[key] += listconfig.Dropped(err)
 }
 
 // getEnvOrDefault returns the value of an environment variable or a default.
@@ -160,6 +832,48 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvBool parses a boolean from an environment variable or returns a default.
+func getEnvBool(key string, defaultValue bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using default %v: %v", key, v, defaultValue, err)
+		return defaultValue
+	}
+	return b
+}
+
+// getEnvInt parses an integer from an environment variable or returns a default.
+func getEnvInt(key string, defaultValue int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using default %d: %v", key, v, defaultValue, err)
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvFloat parses a float from an environment variable or returns a default.
+func getEnvFloat(key string, defaultValue float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using default %v: %v", key, v, defaultValue, err)
+		return defaultValue
+	}
+	return f
+}
+
 // getEnvDuration parses a duration from an environment variable or returns a default.
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	v := os.Getenv(key)
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+	"github.com/affinode/gpu-idle-exporter/internal/exporter"
+	"github.com/affinode/gpu-idle-exporter/internal/idle"
+)
+
+// staticCollector returns the same snapshot on every call.
+type staticCollector struct {
+	snap *collector.Snapshot
+	span trace.SpanContext // span of the context Collect was called with
+}
+
+func (s *staticCollector) Collect(ctx context.Context) (*collector.Snapshot, error) {
+	s.span = trace.SpanContextFromContext(ctx)
+	return s.snap, nil
+}
+
+func TestPollTraceSpans(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	coll := &staticCollector{snap: &collector.Snapshot{
+		Timestamp:    time.Now(),
+		Devices:      []collector.DeviceInfo{{Index: 0, UUID: "GPU-0"}},
+		Processes:    []collector.ProcessSample{{GPU: 0, PID: 100, UsedMemory: 1 << 30}},
+		ProcessNames: map[uint32]string{100: "python"},
+	}}
+
+	poll(context.Background(), coll, idle.NewTracker(), exporter.New(prometheus.Labels{}))
+
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range sr.Ended() {
+		byName[s.Name()] = s
+	}
+	root, ok := byName["poll"]
+	if !ok {
+		t.Fatal("missing poll span")
+	}
+	// The collector's own spans hang off the poll span
+	if coll.span.SpanID() != root.SpanContext().SpanID() {
+		t.Error("collector not called with the poll span's context")
+	}
+	for _, name := range []string{"tracker.Update", "exporter.UpdateMetrics"} {
+		s, ok := byName[name]
+		if !ok {
+			t.Errorf("missing %s span", name)
+			continue
+		}
+		if s.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of poll", name)
+		}
+	}
+	attrs := make(map[string]int64)
+	for _, kv := range root.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsInt64()
+	}
+	if attrs["gpu.count"] != 1 || attrs["process.count"] != 1 {
+		t.Errorf("unexpected poll attributes: %v", attrs)
+	}
+}
+
+func TestPollStageTimings(t *testing.T) {
+	// exporter.New registers with the default registerer
+	reg := prometheus.NewRegistry()
+	prev := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = reg
+	defer func() { prometheus.DefaultRegisterer = prev }()
+
+	prom := exporter.New(prometheus.Labels{})
+	prom.Register()
+	mock := collector.NewMock(collector.MockConfig{GPUs: 2, Processes: 5, Seed: 1})
+	if err := poll(context.Background(), mock, idle.NewTracker(), prom); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := make(map[string]bool)
+	for _, mf := range families {
+		if mf.GetName() != "gpu_idle_scrape_stage_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if v := m.GetGauge().GetValue(); v < 0 {
+				t.Errorf("negative duration %v for %v", v, m.GetLabel())
+			}
+			for _, l := range m.GetLabel() {
+				stages[l.GetValue()] = true
+			}
+		}
+	}
+	for _, stage := range []string{"device_collect", "process_collect", "name_resolve", "tracker_update", "metric_update"} {
+		if !stages[stage] {
+			t.Errorf("missing stage %q", stage)
+		}
+	}
+}
+
+// scriptedCollector returns its results in order, then repeats the last.
+type scriptedCollector struct {
+	results []scriptedResult
+}
+
+type scriptedResult struct {
+	snap *collector.Snapshot
+	err  error
+}
+
+func (s *scriptedCollector) Collect(ctx context.Context) (*collector.Snapshot, error) {
+	r := s.results[0]
+	if len(s.results) > 1 {
+		s.results = s.results[1:]
+	}
+	return r.snap, r.err
+}
+
+// gaugeValue returns the value of the first series of the named gauge.
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == name && len(mf.GetMetric()) > 0 {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("no %s series", name)
+	return 0
+}
+
+func TestPollSuspendedDuringReinit(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prev := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = reg
+	defer func() { prometheus.DefaultRegisterer = prev }()
+
+	t0 := time.Now()
+	snapAt := func(i int, util uint32) *collector.Snapshot {
+		return &collector.Snapshot{
+			Timestamp:    t0.Add(time.Duration(i) * 10 * time.Second),
+			Devices:      []collector.DeviceInfo{{Index: 0, UUID: "GPU-0", Utilization: util}},
+			ProcessNames: map[uint32]string{},
+		}
+	}
+	// The partial snapshot right after re-initialization reads garbage
+	garbage := snapAt(2, 0)
+	garbage.Partial = true
+	coll := &scriptedCollector{results: []scriptedResult{
+		{snap: snapAt(0, 80)},
+		{err: fmt.Errorf("%w after GPU is lost", collector.ErrReinitializing)},
+		{snap: garbage},
+		{snap: snapAt(3, 60)},
+	}}
+	prom := exporter.New(prometheus.Labels{})
+	prom.Register()
+	prom.SetNVMLInitialized(true)
+	tracker := idle.NewTracker()
+
+	for i, want := range []struct {
+		suspended, util, initialized float64
+	}{
+		{0, 80, 1},
+		{1, 80, 0}, // re-initializing: last values held
+		{1, 80, 1}, // partial collection: not exported
+		{0, 60, 1}, // full collection: resumed
+	} {
+		poll(context.Background(), coll, tracker, prom)
+		if got := gaugeValue(t, reg, "gpu_idle_collection_suspended"); got != want.suspended {
+			t.Errorf("poll %d: expected suspended=%v, got %v", i, want.suspended, got)
+		}
+		if got := gaugeValue(t, reg, "gpu_idle_nvml_initialized"); got != want.initialized {
+			t.Errorf("poll %d: expected nvml_initialized=%v, got %v", i, want.initialized, got)
+		}
+		if got := gaugeValue(t, reg, "gpu_idle_device_utilization_percent"); got != want.util {
+			t.Errorf("poll %d: expected utilization %v, got %v", i, want.util, got)
+		}
+	}
+}
+
+// counterValue returns the value of the first series of the named counter.
+func counterValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == name && len(mf.GetMetric()) > 0 {
+			return mf.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("no %s series", name)
+	return 0
+}
+
+func TestPollCollectionMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prev := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = reg
+	defer func() { prometheus.DefaultRegisterer = prev }()
+
+	t0 := time.Unix(1700000000, 0)
+	partial := &collector.Snapshot{Timestamp: t0.Add(20 * time.Second), ProcessNames: map[uint32]string{}, Partial: true}
+	coll := &scriptedCollector{results: []scriptedResult{
+		{snap: &collector.Snapshot{Timestamp: t0, ProcessNames: map[uint32]string{}}},
+		{err: errors.New("NVML: unknown error")},
+		{snap: partial},
+	}}
+	prom := exporter.New(prometheus.Labels{})
+	prom.Register()
+	tracker := idle.NewTracker()
+
+	for i, want := range []struct {
+		errors, last float64
+	}{
+		{0, 1700000000},
+		{1, 1700000000}, // failed: timestamp not advanced
+		{1, 1700000000}, // partial: not exported, so not advanced either
+	} {
+		poll(context.Background(), coll, tracker, prom)
+		if got := counterValue(t, reg, "gpu_idle_collector_errors_total"); got != want.errors {
+			t.Errorf("poll %d: expected %v errors, got %v", i, want.errors, got)
+		}
+		if got := gaugeValue(t, reg, "gpu_idle_last_collection_timestamp_seconds"); got != want.last {
+			t.Errorf("poll %d: expected last collection at %v, got %v", i, want.last, got)
+		}
+		if got := gaugeValue(t, reg, "gpu_idle_collector_duration_seconds"); got < 0 {
+			t.Errorf("poll %d: negative collection duration %v", i, got)
+		}
+	}
+}
+
+// flakyCollector fails the first n calls, then returns an empty snapshot.
+type flakyCollector struct {
+	failures int
+	calls    int
+}
+
+func (f *flakyCollector) Collect(ctx context.Context) (*collector.Snapshot, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("NVML: GPU is lost")
+	}
+	return &collector.Snapshot{Timestamp: time.Now(), ProcessNames: map[uint32]string{}}, nil
+}
+
+func TestPollBackoff(t *testing.T) {
+	coll := &flakyCollector{failures: 5}
+	tracker := idle.NewTracker()
+	prom := exporter.New(prometheus.Labels{})
+	b := &pollBackoff{base: 5 * time.Second, max: 30 * time.Second}
+
+	expected := []time.Duration{
+		5 * time.Second,  // failure 1: first retry at normal cadence
+		10 * time.Second, // failure 2
+		20 * time.Second, // failure 3
+		30 * time.Second, // failure 4: capped
+		30 * time.Second, // failure 5: capped
+		5 * time.Second,  // success: back to normal cadence
+		5 * time.Second,  // still healthy
+	}
+	for i, w := range expected {
+		got := b.next(poll(context.Background(), coll, tracker, prom))
+		if got != w {
+			t.Errorf("poll %d: expected delay %v, got %v", i+1, w, got)
+		}
+	}
+	if b.failures != 0 {
+		t.Errorf("expected failure count to reset after recovery, got %d", b.failures)
+	}
+}
+
+func TestPollLoopRestartsAfterPanic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, gctx := errgroup.WithContext(ctx)
+
+	var restarts int
+	resumed := make(chan struct{})
+	polls := 0
+	pollOnce := func(context.Context) error {
+		polls++
+		switch polls {
+		case 1:
+			panic("simulated poll panic")
+		case 2:
+			close(resumed)
+		}
+		return nil
+	}
+	g.Go(func() error {
+		backoff := &pollBackoff{base: time.Millisecond, max: time.Millisecond}
+		return supervise(gctx, "poll loop", time.Millisecond, func() { restarts++ }, func(ctx context.Context) error {
+			return pollLoop(ctx, pollOnce, backoff, exporter.New(prometheus.Labels{}))
+		})
+	})
+	// Stands in for the HTTP server: it runs until the group is cancelled
+	g.Go(func() error {
+		<-gctx.Done()
+		return gctx.Err()
+	})
+
+	select {
+	case <-resumed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll loop did not restart after a panic")
+	}
+	if gctx.Err() != nil {
+		t.Fatal("the panic cancelled the server goroutine")
+	}
+	cancel()
+	if err := g.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the group to end on cancellation, got %v", err)
+	}
+	if restarts != 1 {
+		t.Errorf("expected 1 restart, got %d", restarts)
+	}
+}
+
+func TestPollLoopSurvivesCollectionFailures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	coll := &flakyCollector{failures: 3}
+	tracker := idle.NewTracker()
+	prom := exporter.New(prometheus.Labels{})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pollLoop(ctx, func(ctx context.Context) error {
+			err := poll(ctx, coll, tracker, prom)
+			if coll.calls == 5 {
+				cancel()
+			}
+			return err
+		}, &pollBackoff{base: time.Millisecond, max: time.Millisecond}, prom)
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the loop to end only on cancellation, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll loop did not keep polling through collection failures")
+	}
+}
+
+func TestConstLabelsDeploymentMode(t *testing.T) {
+	t.Setenv("NODE_NAME", "gpu-node-1")
+	t.Setenv("DEPLOYMENT_MODE", "DaemonSet")
+	labels := constLabelsFromEnv()
+	if labels["mode"] != "daemonset" || labels["node"] != "gpu-node-1" {
+		t.Errorf("unexpected const labels: %v", labels)
+	}
+
+	t.Setenv("DEPLOYMENT_MODE", "kubelet-plugin")
+	if _, ok := constLabelsFromEnv()["mode"]; ok {
+		t.Error("invalid DEPLOYMENT_MODE should not produce a mode label")
+	}
+}
+
+func TestCheckMetricsPath(t *testing.T) {
+	for p, valid := range map[string]bool{
+		"/metrics":          true,
+		"/gpu-idle/metrics": true,
+		"metrics":           false,
+		"":                  false,
+		"/healthz":          false,
+		"/healthz/":         false,
+	} {
+		if err := checkMetricsPath(p); (err == nil) != valid {
+			t.Errorf("checkMetricsPath(%q) = %v, expected valid=%v", p, err, valid)
+		}
+	}
+}
+
+func TestHTTPServerTimeouts(t *testing.T) {
+	srv := newHTTPServer("9835", http.NewServeMux(), httpTimeoutsFromEnv())
+	if srv.ReadTimeout != 10*time.Second || srv.WriteTimeout != 30*time.Second || srv.IdleTimeout != 2*time.Minute {
+		t.Errorf("unexpected default timeouts: read=%v write=%v idle=%v", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+
+	t.Setenv("HTTP_READ_TIMEOUT", "3s")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "1m")
+	t.Setenv("HTTP_IDLE_TIMEOUT", "30s")
+	srv = newHTTPServer("9835", http.NewServeMux(), httpTimeoutsFromEnv())
+	if srv.ReadTimeout != 3*time.Second || srv.WriteTimeout != time.Minute || srv.IdleTimeout != 30*time.Second {
+		t.Errorf("unexpected configured timeouts: read=%v write=%v idle=%v", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+
+	// Too short to render a large scrape
+	t.Setenv("HTTP_WRITE_TIMEOUT", "100ms")
+	if got := httpTimeoutsFromEnv().write; got != 30*time.Second {
+		t.Errorf("expected a write timeout below the floor to fall back to 30s, got %v", got)
+	}
+}
+
+func TestSelftest(t *testing.T) {
+	var out strings.Builder
+	mock := collector.NewMock(collector.MockConfig{GPUs: 2, Processes: 5, Seed: 1})
+	if !selftest(context.Background(), mock, &out) {
+		t.Fatalf("expected selftest to pass against the mock collector:\n%s", out.String())
+	}
+	report := out.String()
+	for _, want := range []string{"== GPUs (2) ==", "GPU-mock-0001", "== Processes (5) ==", "selftest passed"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report is missing %q:\n%s", want, report)
+		}
+	}
+
+	out.Reset()
+	if selftest(context.Background(), &flakyCollector{failures: 1}, &out) {
+		t.Error("expected selftest to fail when collection fails")
+	}
+
+	out.Reset()
+	panicked := &staticCollector{snap: &collector.Snapshot{
+		Devices:      []collector.DeviceInfo{{Index: 1}},
+		PanickedGPUs: []int{0},
+	}}
+	if selftest(context.Background(), panicked, &out) {
+		t.Errorf("expected selftest to fail when a GPU panicked:\n%s", out.String())
+	}
+}
+
+// countingMarker counts MarkProcessesEnded calls.
+type countingMarker struct{ calls atomic.Int32 }
+
+func (m *countingMarker) MarkProcessesEnded() int {
+	m.calls.Add(1)
+	return 3
+}
+
+func TestMarkEndedOnShutdown(t *testing.T) {
+	pollDone, httpDone := make(chan struct{}), make(chan struct{})
+	marker := &countingMarker{}
+	returned := make(chan time.Time)
+	go func() {
+		markEndedOnShutdown(pollDone, httpDone, marker, 50*time.Millisecond)
+		returned <- time.Now()
+	}()
+
+	// Still polling: nothing is marked yet
+	time.Sleep(20 * time.Millisecond)
+	if marker.calls.Load() != 0 {
+		t.Fatal("processes marked ended while the polling loop was still running")
+	}
+
+	// Shutdown: the polling loop stops, processes are ended and the HTTP
+	// server is kept up for the drain period
+	stopped := time.Now()
+	close(pollDone)
+	at := <-returned
+	if marker.calls.Load() != 1 {
+		t.Errorf("expected processes to be marked ended once, got %d", marker.calls.Load())
+	}
+	if at.Sub(stopped) < 50*time.Millisecond {
+		t.Errorf("expected the drain period to be waited out, returned after %v", at.Sub(stopped))
+	}
+
+	// The HTTP server failing first skips the marking
+	marker = &countingMarker{}
+	close(httpDone)
+	markEndedOnShutdown(make(chan struct{}), httpDone, marker, time.Hour)
+	if marker.calls.Load() != 0 {
+		t.Error("expected no marking once the HTTP server has stopped")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricsFile writes the text exposition of a registry to a file after
+// every poll, for air-gapped nodes whose metrics are collected by copying
+// the file rather than scraping. The file is replaced atomically, so a
+// copy never sees a partial write.
+type metricsFile struct {
+	path    string
+	gather  prometheus.Gatherer
+	timeout time.Duration // how long a poll waits for the write
+
+	writing atomic.Bool // a write is in flight, possibly past its timeout
+}
+
+// update writes the file, waiting at most the timeout so a slow disk
+// doesn't hold up polling. A write that times out carries on in the
+// background, and updates are skipped until it finishes.
+func (f *metricsFile) update() {
+	if !f.writing.CompareAndSwap(false, true) {
+		logger.Warn("metrics file: previous write still in progress, skipping this update", "event", "metrics_file_skipped", "path", f.path)
+		return
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer f.writing.Store(false)
+		done <- writeMetricsFile(f.path, f.gather)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			logger.Error("metrics file: write failed", "event", "metrics_file_error", "path", f.path, "err", err)
+		}
+	case <-time.After(f.timeout):
+		logger.Warn("metrics file: write is taking longer than the timeout, continuing without it", "event", "metrics_file_timeout",
+			"path", f.path, "timeout", f.timeout)
+	}
+}
+
+// writeMetricsFile writes the metrics g gathers to a temporary file next to
+// path, then renames it over path.
+func writeMetricsFile(path string, g prometheus.Gatherer) (err error) {
+	families, err := g.Gather()
+	if err != nil {
+		return fmt.Errorf("gathering metrics: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	enc := expfmt.NewEncoder(tmp, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	// CreateTemp makes the file private; the copying process may run as
+	// another user
+	if err := tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestWriteMetricsFileAtomic(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_value", Help: "Test."})
+	reg.MustRegister(g)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.prom")
+
+	g.Set(1)
+	if err := writeMetricsFile(path, reg); err != nil {
+		t.Fatal(err)
+	}
+	// A reader that opened the file before the update keeps seeing the
+	// complete old version, rather than a truncated or mixed one
+	old, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	g.Set(2)
+	if err := writeMetricsFile(path, reg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "test_value 1\n") {
+		t.Errorf("expected the old reader to see the first version, got:\n%s", data)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "# TYPE test_value gauge\ntest_value 2\n") {
+		t.Errorf("expected the updated exposition, got:\n%s", data)
+	}
+
+	// A failed update leaves the last good file and no temporary files
+	if err := writeMetricsFile(path, failingGatherer{}); err == nil {
+		t.Fatal("expected an error from a failing gatherer")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(data) {
+		t.Errorf("expected the file unchanged after a failed update, got:\n%s", after)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the metrics file in %s, got %d entries", dir, len(entries))
+	}
+}
+
+type failingGatherer struct{}
+
+func (failingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	return nil, errors.New("collector failed")
+}
+
+// blockingGatherer blocks until released, like a write to a hung disk.
+type blockingGatherer struct {
+	release chan struct{}
+}
+
+func (b blockingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	<-b.release
+	return nil, nil
+}
+
+func TestMetricsFileUpdateTimeout(t *testing.T) {
+	g := blockingGatherer{release: make(chan struct{})}
+	f := &metricsFile{path: filepath.Join(t.TempDir(), "metrics.prom"), gather: g, timeout: 10 * time.Millisecond}
+
+	start := time.Now()
+	f.update()
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("expected update to give up after its timeout, waited %v", waited)
+	}
+	if !f.writing.Load() {
+		t.Fatal("expected the timed-out write to still be in flight")
+	}
+	f.update() // skipped rather than piling up another write
+
+	close(g.release)
+	for f.writing.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := os.Stat(f.path); err != nil {
+		t.Errorf("expected the file written once the write completed: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+)
+
+// mockProcessesPerGPU is the synthetic process count per GPU when
+// MOCK_MODE is set without MOCK_PROCESS_COUNT.
+const mockProcessesPerGPU = 4
+
+// mockConfigFromEnv reads the synthetic mode settings: MOCK_MODE,
+// MOCK_PROCESS_COUNT, MOCK_GPUS, MOCK_CHURN_RATE and MOCK_SEED. Synthetic
+// mode runs without NVML, for local development, CI and load tests on
+// machines without NVIDIA GPUs. It is enabled by MOCK_MODE=true or a
+// positive MOCK_PROCESS_COUNT. A MOCK_SEED other than 0 makes the
+// generated snapshots the same on every run.
+func mockConfigFromEnv() (cfg collector.MockConfig, enabled bool) {
+	cfg = collector.MockConfig{
+		GPUs:      getEnvInt("MOCK_GPUS", 8),
+		Processes: getEnvInt("MOCK_PROCESS_COUNT", 0),
+		ChurnRate: getEnvFloat("MOCK_CHURN_RATE", 0.05),
+		Seed:      int64(getEnvInt("MOCK_SEED", 0)),
+	}
+	enabled = getEnvBool("MOCK_MODE", false) || cfg.Processes > 0
+	if enabled && cfg.Processes <= 0 {
+		cfg.Processes = mockProcessesPerGPU * max(cfg.GPUs, 1)
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	return cfg, enabled
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+)
+
+func TestMockConfigFromEnv(t *testing.T) {
+	if _, enabled := mockConfigFromEnv(); enabled {
+		t.Fatal("expected synthetic mode off by default")
+	}
+
+	t.Setenv("MOCK_MODE", "true")
+	t.Setenv("MOCK_GPUS", "4")
+	cfg, enabled := mockConfigFromEnv()
+	if !enabled || cfg.GPUs != 4 || cfg.Processes != 4*mockProcessesPerGPU {
+		t.Errorf("expected MOCK_MODE to enable %d processes on 4 GPUs, got %+v (enabled %v)", 4*mockProcessesPerGPU, cfg, enabled)
+	}
+
+	// A fixed seed gives the same snapshots every run, e.g. in CI
+	t.Setenv("MOCK_SEED", "42")
+	cfg, _ = mockConfigFromEnv()
+	snap := func() ([]uint32, []uint64) {
+		s, err := collector.NewMock(cfg).Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var utils []uint32
+		var mems []uint64
+		for _, p := range s.Processes {
+			utils = append(utils, p.SmUtil)
+			mems = append(mems, p.UsedMemory)
+		}
+		return utils, mems
+	}
+	u1, m1 := snap()
+	u2, m2 := snap()
+	if len(u1) != 4*mockProcessesPerGPU || !reflect.DeepEqual(u1, u2) || !reflect.DeepEqual(m1, m2) {
+		t.Errorf("expected identical snapshots from MOCK_SEED, got %v/%v and %v/%v", u1, m1, u2, m2)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// nvmlInitRetry configures how NVML initialization is retried at startup,
+// when the driver may still be loading after a node reboot.
+type nvmlInitRetry struct {
+	attempts int           // total attempts; at least 1 is made
+	base     time.Duration // delay after the first failure, doubling per failure
+	max      time.Duration // cap on the delay
+}
+
+// initNVML calls init until it succeeds, the attempts run out or ctx is
+// cancelled, backing off exponentially between attempts.
+func initNVML(ctx context.Context, init func() nvml.Return, r nvmlInitRetry) error {
+	delay := r.base
+	for attempt := 1; ; attempt++ {
+		ret := init()
+		if ret == nvml.SUCCESS {
+			logger.Info("NVML initialized", "event", "nvml_init", "attempts", attempt)
+			return nil
+		}
+		if attempt >= r.attempts {
+			return fmt.Errorf("failed to initialize NVML after %d attempt(s): %v", attempt, nvml.ErrorString(ret))
+		}
+		logger.Warn("failed to initialize NVML, retrying", "event", "nvml_init_failed",
+			"attempt", attempt, "max_attempts", r.attempts, "err", nvml.ErrorString(ret), "retry_in", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, r.max)
+	}
+}
+
+// systemVersions are the versions of the NVIDIA software stack on the
+// node; each is empty if NVML can't report it.
+type systemVersions struct {
+	driver, cuda, nvml string
+}
+
+// readSystemVersions queries the driver, CUDA and NVML versions. NVML
+// must be initialized.
+func readSystemVersions() systemVersions {
+	var v systemVersions
+	if driver, ret := nvml.SystemGetDriverVersion(); ret == nvml.SUCCESS {
+		v.driver = driver
+	}
+	if cuda, ret := nvml.SystemGetCudaDriverVersion(); ret == nvml.SUCCESS {
+		v.cuda = formatCUDAVersion(cuda)
+	}
+	if lib, ret := nvml.SystemGetNVMLVersion(); ret == nvml.SUCCESS {
+		v.nvml = lib
+	}
+	return v
+}
+
+// formatCUDAVersion formats a CUDA version as NVML encodes it,
+// 1000*major + 10*minor, e.g. 12040 -> "12.4".
+func formatCUDAVersion(v int) string {
+	return fmt.Sprintf("%d.%d", v/1000, v%1000/10)
+}
+
+// logGPUs logs the name and UUID of every GPU NVML sees.
+func logGPUs() {
+	count, ret := nvml.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return
+	}
+	logger.Info("found GPUs", "event", "gpus_found", "gpus", count)
+	for i := 0; i < count; i++ {
+		if device, ret := nvml.DeviceGetHandleByIndex(i); ret == nvml.SUCCESS {
+			name, _ := device.GetName()
+			uuid, _ := device.GetUUID()
+			logger.Info("found GPU", "event", "gpu_found", "gpu", i, "model", name, "uuid", uuid)
+		}
+	}
+}
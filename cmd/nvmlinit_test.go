@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+func TestInitNVMLRetry(t *testing.T) {
+	retry := nvmlInitRetry{attempts: 4, base: time.Millisecond, max: 2 * time.Millisecond}
+	// failing fails the first n calls with the driver not yet loaded
+	failing := func(n int) (init func() nvml.Return, calls *int) {
+		calls = new(int)
+		return func() nvml.Return {
+			*calls++
+			if *calls <= n {
+				return nvml.ERROR_DRIVER_NOT_LOADED
+			}
+			return nvml.SUCCESS
+		}, calls
+	}
+
+	t.Run("driver loads", func(t *testing.T) {
+		init, calls := failing(2)
+		if err := initNVML(context.Background(), init, retry); err != nil {
+			t.Fatal(err)
+		}
+		if *calls != 3 {
+			t.Errorf("expected 3 attempts, got %d", *calls)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		init, calls := failing(10)
+		if err := initNVML(context.Background(), init, retry); err == nil {
+			t.Fatal("expected an error once the attempts run out")
+		}
+		if *calls != 4 {
+			t.Errorf("expected 4 attempts, got %d", *calls)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		init, calls := failing(10)
+		if err := initNVML(ctx, init, retry); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if *calls != 1 {
+			t.Errorf("expected no retry after cancellation, got %d attempts", *calls)
+		}
+	})
+}
+
+func TestFormatCUDAVersion(t *testing.T) {
+	for v, want := range map[int]string{12040: "12.4", 11080: "11.8", 12000: "12.0"} {
+		if got := formatCUDAVersion(v); got != want {
+			t.Errorf("formatCUDAVersion(%d) = %q, want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofPath is where the profiling handlers are served with ENABLE_PPROF.
+const pprofPath = "/debug/pprof/"
+
+// registerPprof registers the net/http/pprof handlers on mux, each wrapped
+// by protect. They're registered explicitly rather than through the
+// package's side effect on http.DefaultServeMux, which the exporter
+// doesn't serve.
+func registerPprof(mux *http.ServeMux, protect func(http.Handler) http.Handler) {
+	mux.Handle(pprofPath, protect(http.HandlerFunc(pprof.Index)))
+	mux.Handle(pprofPath+"cmdline", protect(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle(pprofPath+"profile", protect(http.HandlerFunc(pprof.Profile)))
+	mux.Handle(pprofPath+"symbol", protect(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle(pprofPath+"trace", protect(http.HandlerFunc(pprof.Trace)))
+}
+
+// pprofTimeouts are the timeouts of the separate PPROF_PORT server: like
+// the main server's, but without a write timeout, since CPU profiles and
+// traces stream for as long as the request asks (30s by default).
+func pprofTimeouts() httpTimeouts {
+	t := httpTimeoutsFromEnv()
+	t.write = 0
+	return t
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterPprof(t *testing.T) {
+	mux := http.NewServeMux()
+	registerPprof(mux, (&basicAuth{username: "prometheus", password: "s3cret"}).wrap)
+
+	get := func(path string, withAuth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if withAuth {
+			req.SetBasicAuth("prometheus", "s3cret")
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get(pprofPath+"goroutine?debug=1", true); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine profile") {
+		t.Errorf("expected the goroutine profile, got %d:\n%s", rec.Code, rec.Body)
+	}
+	if rec := get(pprofPath+"cmdline", true); rec.Code != http.StatusOK {
+		t.Errorf("expected the command line, got %d", rec.Code)
+	}
+	if rec := get(pprofPath, false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected profiles behind basic auth, got %d", rec.Code)
+	}
+}
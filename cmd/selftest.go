@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+)
+
+// nvmlChecker is implemented by collectors that can validate the NVML calls
+// they depend on (the real collector, not the synthetic one).
+type nvmlChecker interface {
+	Check() []collector.CheckResult
+}
+
+// selftest runs one collection and writes a human-readable report of every
+// GPU and process to w. It returns false if a critical NVML call failed,
+// collection failed, or a GPU could not be collected.
+func selftest(ctx context.Context, coll snapshotCollector, w io.Writer) bool {
+	ok := true
+
+	if checker, isChecker := coll.(nvmlChecker); isChecker {
+		fmt.Fprintln(w, "== NVML checks ==")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, r := range checker.Check() {
+			status := "ok"
+			switch {
+			case r.Err != nil && r.Critical:
+				status = "FAIL"
+				ok = false
+			case r.Err != nil:
+				status = "warn"
+			}
+			gpu := "-"
+			if r.GPU >= 0 {
+				gpu = fmt.Sprint(r.GPU)
+			}
+			detail := ""
+			if r.Err != nil {
+				detail = r.Err.Error()
+			}
+			fmt.Fprintf(tw, "%s\tGPU %s\t%s\t%s\n", status, gpu, r.Call, detail)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+
+	snap, err := coll.Collect(ctx)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL: collection error: %v\n", err)
+		return false
+	}
+	writeStatus(w, snap)
+
+	if len(snap.Devices) == 0 {
+		fmt.Fprintln(w, "FAIL: no GPUs collected")
+		ok = false
+	}
+	if len(snap.PanickedGPUs) > 0 {
+		fmt.Fprintf(w, "FAIL: collection panicked on GPU(s) %v\n", snap.PanickedGPUs)
+		ok = false
+	}
+	if len(snap.TimedOutGPUs) > 0 {
+		fmt.Fprintf(w, "FAIL: NVML calls timed out on GPU(s) %v\n", snap.TimedOutGPUs)
+		ok = false
+	}
+	if ok {
+		fmt.Fprintln(w, "selftest passed")
+	} else {
+		fmt.Fprintln(w, "selftest FAILED")
+	}
+	return ok
+}
+
+// writeStatus formats a snapshot as human-readable tables of GPUs and processes.
+func writeStatus(w io.Writer, snap *collector.Snapshot) {
+	const mib = 1 << 20
+
+	fmt.Fprintf(w, "== GPUs (%d) ==\n", len(snap.Devices))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GPU\tNAME\tUUID\tSERIAL\tPCI\tBOARD\tUTIL%\tMEM USED/TOTAL MiB\tPOWER W\tTEMP C\tCPUS\tMIG")
+	for _, d := range snap.Devices {
+		mig := "off"
+		if d.MigEnabled {
+			mig = fmt.Sprintf("%d instance(s)", len(d.MigInstances))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%.2f\t%d/%d\t%.1f\t%d\t%s\t%s\n",
+			d.Index, d.Name, d.UUID, orDash(d.Serial), orDash(d.PCIBusID), orDash(d.BoardID), d.UtilizationFine,
+			d.MemoryUsed/mib, d.MemoryTotal/mib, d.PowerWatts, d.TempCelsius, orDash(d.CPUAffinity), mig)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n== Processes (%d) ==\n", len(snap.Processes))
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GPU\tPID\tNAME\tUID\tMEM MiB\tSM%\tMEM%\tENC%\tDEC%\tGPU FDS\tFLAGS")
+	for _, p := range snap.Processes {
+		uid, fds := "-", "-"
+		if v, ok := snap.ProcessUIDs[p.PID]; ok {
+			uid = fmt.Sprint(v)
+		}
+		if v, ok := snap.GPUFds[p.PID]; ok {
+			fds = fmt.Sprint(v)
+		}
+		var flags []string
+		if p.Memoryless {
+			flags = append(flags, "memoryless")
+		}
+		if p.MigInstance != "" {
+			flags = append(flags, "mig="+p.MigInstance)
+		}
+		if p.Namespace != "" {
+			flags = append(flags, "ns="+p.Namespace)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			p.GPU, p.PID, snap.ProcessNames[p.PID], uid, p.UsedMemory/mib,
+			p.SmUtil, p.EngineUtil.Memory, p.EngineUtil.Encoder, p.EngineUtil.Decoder, fds, orDash(strings.Join(flags, ",")))
+	}
+	tw.Flush()
+
+	if snap.ProcReadTimeouts > 0 {
+		fmt.Fprintf(w, "\n%d /proc read(s) timed out\n", snap.ProcReadTimeouts)
+	}
+	fmt.Fprintln(w)
+}
+
+// orDash returns s, or "-" if s is empty, for table cells.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// httpTLS is the optional TLS configuration of the HTTP server, so it can be
+// scraped over (m)TLS without a proxy sidecar.
+type httpTLS struct {
+	certFile, keyFile string // loaded by ListenAndServeTLS
+	config            *tls.Config
+	requireClientCert bool // a client CA is set; see wrap
+}
+
+// httpTLSFromEnv reads TLS_CERT_FILE, TLS_KEY_FILE and TLS_CLIENT_CA_FILE.
+// It returns nil if TLS isn't configured. With a client CA file, clients
+// of the endpoints behind wrap must present a certificate signed by one of
+// its CAs.
+func httpTLSFromEnv() (*httpTLS, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	clientCAFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	switch {
+	case certFile == "" && keyFile == "":
+		if clientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	case certFile == "" || keyFile == "":
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	t := &httpTLS{certFile: certFile, keyFile: keyFile, config: &tls.Config{MinVersion: tls.VersionTLS12}}
+	if clientCAFile == "" {
+		return t, nil
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates in client CA file %s", clientCAFile)
+	}
+	// Verified if given, required only by wrap: kubelet probes of /healthz
+	// can't present a certificate
+	t.config.ClientCAs = pool
+	t.config.ClientAuth = tls.VerifyClientCertIfGiven
+	t.requireClientCert = true
+	return t, nil
+}
+
+// wrap returns h behind the client certificate check, answering 403 to
+// requests without a certificate verified against the client CA. A nil
+// httpTLS, or one without a client CA, returns h unchanged.
+func (t *httpTLS) wrap(h http.Handler) http.Handler {
+	if t == nil || !t.requireClientCert {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "Client certificate required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
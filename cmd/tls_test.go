@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key for TLS tests, signed by parent or
+// self-signed if parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM writes the certificate and key to dir, returning their paths.
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestHTTPTLSFromEnv(t *testing.T) {
+	if serverTLS, err := httpTLSFromEnv(); serverTLS != nil || err != nil {
+		t.Errorf("expected no TLS by default, got %+v, %v", serverTLS, err)
+	}
+	t.Setenv("TLS_CERT_FILE", "server.crt")
+	if _, err := httpTLSFromEnv(); err == nil {
+		t.Error("expected an error for a certificate without a key")
+	}
+	t.Setenv("TLS_KEY_FILE", "server.key")
+	t.Setenv("TLS_CLIENT_CA_FILE", filepath.Join(t.TempDir(), "missing.crt"))
+	if _, err := httpTLSFromEnv(); err == nil {
+		t.Error("expected an error for a missing client CA file")
+	}
+}
+
+func TestServeHTTPMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test CA", nil, true)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "exporter", ca, false).writePEM(t, dir, "server")
+	client := newTestCert(t, "prometheus", ca, false)
+	stranger := newTestCert(t, "stranger", newTestCert(t, "other CA", nil, true), false)
+
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	t.Setenv("TLS_CLIENT_CA_FILE", caFile)
+	serverTLS, err := httpTLSFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reserve a free port for the server
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", serverTLS.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	srv := newHTTPServer(fmt.Sprint(port), mux, httpTimeoutsFromEnv())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serveHTTP(ctx, srv, serverTLS) }()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(path string, certs ...tls.Certificate) error {
+		c := &http.Client{Timeout: time.Second, Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		resp, err := c.Get(fmt.Sprintf("https://127.0.0.1:%d%s", port, path))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("status %s", resp.Status)
+		}
+		return nil
+	}
+	// Wait for the server to come up
+	deadline := time.Now().Add(5 * time.Second)
+	for err := get("/metrics", client.tlsCertificate()); err != nil; err = get("/metrics", client.tlsCertificate()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("client with a certificate: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := get("/metrics"); err == nil {
+		t.Error("expected a client without a certificate to be rejected")
+	}
+	if err := get("/metrics", stranger.tlsCertificate()); err == nil {
+		t.Error("expected a certificate from another CA to be rejected")
+	}
+	// Kubelet probes present no certificate
+	if err := get("/healthz"); err != nil {
+		t.Errorf("expected /healthz without a certificate to answer: %v", err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
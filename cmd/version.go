@@ -0,0 +1,33 @@
+package main
+
+import "runtime/debug"
+
+// Version and Commit identify the build. Release builds set them with
+// -ldflags "-X main.Version=v1.2.0 -X main.Commit=<git sha>"; see the Makefile.
+var Version, Commit string
+
+// buildVersion returns Version and Commit, falling back to what the Go
+// toolchain recorded in the binary for builds without -ldflags.
+func buildVersion() (version, commit string) {
+	version, commit = Version, Commit
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return orUnknown(version), orUnknown(commit)
+	}
+	if version == "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && commit == "" {
+			commit = s.Value
+		}
+	}
+	return orUnknown(version), orUnknown(commit)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,292 @@
+// Package aggregator merges the metrics of several remote exporter
+// instances into one node-labelled view, for sites where each GPU host
+// can't be scraped directly.
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/affinode/gpu-idle-exporter/internal/listconfig"
+)
+
+// nodeLabel is added to every re-exposed series, replacing any node label
+// the remote instance attached itself.
+const nodeLabel = "node"
+
+// Target is a remote exporter instance.
+type Target struct {
+	Node string // value of the node label on the target's series
+	URL  string // the target's /metrics endpoint
+}
+
+// ParseTargets parses a comma-separated list of targets, each either
+// "node=URL" or a bare URL, e.g.
+//
+//	gpu-a=http://10.0.0.5:9835,http://10.0.0.6:9835/metrics
+//
+// A bare URL's node is its host:port. URLs without a path get /metrics.
+// Every invalid entry is reported, in a *listconfig.Error.
+func ParseTargets(s string) ([]Target, error) {
+	entries, err := listconfig.Parse(s, listconfig.MaxEntries, func(entry string) error {
+		_, err := parseTarget(entry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var targets []Target
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		t, _ := parseTarget(entry)
+		if seen[t.Node] {
+			return nil, fmt.Errorf("target %q: duplicate node %q", entry, t.Node)
+		}
+		seen[t.Node] = true
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	return targets, nil
+}
+
+// parseTarget parses a single "node=URL" or bare URL target.
+func parseTarget(entry string) (Target, error) {
+	var node string
+	raw := entry
+	if i := strings.Index(entry, "="); i >= 0 && !strings.Contains(entry[:i], "://") {
+		node, raw = entry[:i], entry[i+1:]
+		if node == "" {
+			return Target{}, errors.New("empty node name")
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Target{}, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Target{}, errors.New("want an http(s) URL")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/metrics"
+	}
+	if node == "" {
+		node = u.Host
+	}
+	return Target{Node: node, URL: u.String()}, nil
+}
+
+// Aggregator is a prometheus.Collector that scrapes every target on each
+// collection and re-exposes their series with a node label. A target that
+// can't be scraped is reported down and contributes no other series.
+//
+// Targets should run the same version and configuration: families whose
+// type differs from another node's for the same metric, and series whose
+// label names differ, are dropped.
+type Aggregator struct {
+	targets []Target
+	client  *http.Client
+	logger  *slog.Logger
+
+	upDesc *prometheus.Desc
+}
+
+// Option configures an Aggregator.
+type Option func(*Aggregator)
+
+// WithLogger sets the logger for failed scrapes and dropped series. The
+// default is slog.Default() at construction.
+func WithLogger(l *slog.Logger) Option {
+	return func(a *Aggregator) { a.logger = l }
+}
+
+// New creates an Aggregator. timeout bounds each target's scrape.
+func New(targets []Target, timeout time.Duration, opts ...Option) *Aggregator {
+	a := &Aggregator{
+		targets: targets,
+		client:  &http.Client{Timeout: timeout},
+		logger:  slog.Default(),
+		upDesc: prometheus.NewDesc("gpu_idle_aggregator_target_up",
+			"1 if the remote exporter for this node was scraped successfully, 0 otherwise.",
+			[]string{nodeLabel}, nil),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Describe sends only the aggregator's own metric, which keeps the
+// collector checked at registration. The remote series aren't known in
+// advance and stay undescribed, which the registry accepts unless it is
+// pedantic; merger keeps them consistent with each other instead.
+func (a *Aggregator) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.upDesc
+}
+
+// Collect scrapes all targets concurrently and emits their merged series.
+func (a *Aggregator) Collect(ch chan<- prometheus.Metric) {
+	results := make([]map[string]*dto.MetricFamily, len(a.targets))
+	var wg sync.WaitGroup
+	for i, t := range a.targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			families, err := a.scrape(context.Background(), t.URL)
+			if err != nil {
+				a.logger.Warn("aggregator: scrape failed", "event", "scrape_error", "node", t.Node, "url", t.URL, "err", err)
+				return
+			}
+			results[i] = families
+		}(i, t)
+	}
+	wg.Wait()
+
+	m := merger{
+		logger: a.logger,
+		help:   make(map[string]string),
+		types:  make(map[string]dto.MetricType),
+		labels: make(map[string]string),
+	}
+	for i, t := range a.targets {
+		up := 0.0
+		if results[i] != nil {
+			up = 1
+			m.emit(ch, t.Node, results[i])
+		}
+		ch <- prometheus.MustNewConstMetric(a.upDesc, prometheus.GaugeValue, up, t.Node)
+	}
+}
+
+// scrape fetches and parses one target's metrics in the text format.
+func (a *Aggregator) scrape(ctx context.Context, u string) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Ask for the text format, which is all the parser understands
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// merger keeps the help text, type and label names of each metric
+// consistent across nodes within one collection, as the registry requires.
+type merger struct {
+	logger *slog.Logger
+	help   map[string]string         // metric name -> help of the first node that had it
+	types  map[string]dto.MetricType // metric name -> type of the first node that had it
+	labels map[string]string         // metric name -> sorted label names, joined
+}
+
+// emit sends one node's families as const metrics labelled with node.
+func (m *merger) emit(ch chan<- prometheus.Metric, node string, families map[string]*dto.MetricFamily) {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mf := families[name]
+		if typ, ok := m.types[name]; !ok {
+			m.types[name] = mf.GetType()
+		} else if typ != mf.GetType() {
+			m.logger.Warn("aggregator: dropping metric, type differs from other nodes", "event", "metric_dropped",
+				"metric", name, "node", node, "type", mf.GetType().String(), "other_type", typ.String())
+			continue
+		}
+		help, ok := m.help[name]
+		if !ok {
+			help = mf.GetHelp()
+			m.help[name] = help
+		}
+		for _, metric := range mf.GetMetric() {
+			labels := prometheus.Labels{nodeLabel: node}
+			for _, lp := range metric.GetLabel() {
+				if lp.GetName() != nodeLabel {
+					labels[lp.GetName()] = lp.GetValue()
+				}
+			}
+			if !m.consistent(name, labels) {
+				m.logger.Warn("aggregator: dropping series, label names differ from other nodes", "event", "metric_dropped",
+					"metric", name, "node", node)
+				continue
+			}
+			desc := prometheus.NewDesc(name, help, nil, labels)
+			if cm, err := constMetric(desc, mf.GetType(), metric); err != nil {
+				m.logger.Warn("aggregator: dropping series", "event", "metric_dropped", "metric", name, "node", node, "err", err)
+			} else {
+				ch <- cm
+			}
+		}
+	}
+}
+
+// consistent reports whether labels has the same names as earlier series
+// of the metric, remembering them for the first series.
+func (m *merger) consistent(name string, labels prometheus.Labels) bool {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	joined := strings.Join(keys, "\x00")
+	if prev, ok := m.labels[name]; ok {
+		return prev == joined
+	}
+	m.labels[name] = joined
+	return true
+}
+
+// constMetric converts a parsed sample to a const metric with desc.
+func constMetric(desc *prometheus.Desc, typ dto.MetricType, metric *dto.Metric) (prometheus.Metric, error) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, metric.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, metric.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, metric.GetUntyped().GetValue())
+	case dto.MetricType_HISTOGRAM:
+		h := metric.GetHistogram()
+		buckets := make(map[float64]uint64, len(h.GetBucket()))
+		for _, b := range h.GetBucket() {
+			if !math.IsInf(b.GetUpperBound(), 1) { // implied by the sample count
+				buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+			}
+		}
+		return prometheus.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets)
+	case dto.MetricType_SUMMARY:
+		s := metric.GetSummary()
+		quantiles := make(map[float64]float64, len(s.GetQuantile()))
+		for _, q := range s.GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		return prometheus.NewConstSummary(desc, s.GetSampleCount(), s.GetSampleSum(), quantiles)
+	default:
+		return nil, fmt.Errorf("unsupported metric type %v", typ)
+	}
+}
@@ -0,0 +1,159 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/affinode/gpu-idle-exporter/internal/listconfig"
+)
+
+// remote serves a registry standing in for one exporter instance.
+func remote(t *testing.T, idleBytes float64, utilObservations ...float64) *httptest.Server {
+	reg := prometheus.NewRegistry()
+	idleMem := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "gpu_idle_memory_total_bytes",
+		Help:        "Total GPU memory in bytes held by all idle processes on this GPU.",
+		ConstLabels: prometheus.Labels{"node": "self-reported"},
+	}, []string{"gpu"})
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gpu_idle_device_utilization_histogram",
+		Help:    "Utilization.",
+		Buckets: []float64{0, 50, 100},
+	})
+	reg.MustRegister(idleMem, hist)
+	idleMem.WithLabelValues("0").Set(idleBytes)
+	for _, v := range utilObservations {
+		hist.Observe(v)
+	}
+	srv := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAggregatorMergesTargets(t *testing.T) {
+	a := remote(t, 1<<30, 0, 80)
+	b := remote(t, 2<<30, 100)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(New([]Target{
+		{Node: "a", URL: a.URL + "/metrics"},
+		{Node: "b", URL: b.URL + "/metrics"},
+		{Node: "down", URL: down.URL + "/metrics"},
+	}, time.Second))
+
+	expected := `
+# HELP gpu_idle_aggregator_target_up 1 if the remote exporter for this node was scraped successfully, 0 otherwise.
+# TYPE gpu_idle_aggregator_target_up gauge
+gpu_idle_aggregator_target_up{node="a"} 1
+gpu_idle_aggregator_target_up{node="b"} 1
+gpu_idle_aggregator_target_up{node="down"} 0
+# HELP gpu_idle_memory_total_bytes Total GPU memory in bytes held by all idle processes on this GPU.
+# TYPE gpu_idle_memory_total_bytes gauge
+gpu_idle_memory_total_bytes{gpu="0",node="a"} 1.073741824e+09
+gpu_idle_memory_total_bytes{gpu="0",node="b"} 2.147483648e+09
+# HELP gpu_idle_device_utilization_histogram Utilization.
+# TYPE gpu_idle_device_utilization_histogram histogram
+gpu_idle_device_utilization_histogram_bucket{node="a",le="0"} 1
+gpu_idle_device_utilization_histogram_bucket{node="a",le="50"} 1
+gpu_idle_device_utilization_histogram_bucket{node="a",le="100"} 2
+gpu_idle_device_utilization_histogram_bucket{node="a",le="+Inf"} 2
+gpu_idle_device_utilization_histogram_sum{node="a"} 80
+gpu_idle_device_utilization_histogram_count{node="a"} 2
+gpu_idle_device_utilization_histogram_bucket{node="b",le="0"} 0
+gpu_idle_device_utilization_histogram_bucket{node="b",le="50"} 0
+gpu_idle_device_utilization_histogram_bucket{node="b",le="100"} 1
+gpu_idle_device_utilization_histogram_bucket{node="b",le="+Inf"} 1
+gpu_idle_device_utilization_histogram_sum{node="b"} 100
+gpu_idle_device_utilization_histogram_count{node="b"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAggregatorDropsConflictingTypes(t *testing.T) {
+	serve := func(exposition string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			w.Write([]byte(exposition))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	a := serve("# HELP gpu_idle_restarts Restarts.\n# TYPE gpu_idle_restarts gauge\ngpu_idle_restarts 2\n")
+	// An older version exposing the same name as a counter
+	b := serve("# HELP gpu_idle_restarts Restarts.\n# TYPE gpu_idle_restarts counter\ngpu_idle_restarts 5\n")
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(New([]Target{{Node: "a", URL: a.URL}, {Node: "b", URL: b.URL}}, time.Second, WithLogger(logger)))
+
+	expected := `
+# HELP gpu_idle_restarts Restarts.
+# TYPE gpu_idle_restarts gauge
+gpu_idle_restarts{node="a"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gpu_idle_restarts"); err != nil {
+		t.Error(err)
+	}
+	// Dropped families are warnings that a level filter keeps
+	var record struct{ Level, Event, Metric, Node string }
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("expected one JSON log record, got %q: %v", logs.String(), err)
+	}
+	if record.Level != "WARN" || record.Event != "metric_dropped" || record.Metric != "gpu_idle_restarts" || record.Node != "b" {
+		t.Errorf("unexpected log record %+v", record)
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	targets, err := ParseTargets(" gpu-a=http://10.0.0.5:9835, http://10.0.0.6:9835/custom ,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Target{
+		{Node: "gpu-a", URL: "http://10.0.0.5:9835/metrics"},
+		{Node: "10.0.0.6:9835", URL: "http://10.0.0.6:9835/custom"},
+	}
+	if len(targets) != len(want) {
+		t.Fatalf("expected %d targets, got %+v", len(want), targets)
+	}
+	for i := range want {
+		if targets[i] != want[i] {
+			t.Errorf("target %d: expected %+v, got %+v", i, want[i], targets[i])
+		}
+	}
+
+	for _, input := range []string{
+		"",
+		"10.0.0.5:9835",
+		"=http://10.0.0.5:9835",
+		"ftp://10.0.0.5",
+		"a=http://10.0.0.5:9835,a=http://10.0.0.6:9835",
+	} {
+		if _, err := ParseTargets(input); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+
+	// Every malformed entry is reported at once
+	_, err = ParseTargets("10.0.0.5:9835,http://10.0.0.6:9835,ftp://10.0.0.7")
+	if n := listconfig.Dropped(err); n != 2 {
+		t.Errorf("expected 2 invalid targets reported, got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// cpuSetWords is the number of bitmask words requested from
+// nvmlDeviceGetCpuAffinity; 16 words covers 1024 CPUs on 64-bit hosts.
+const cpuSetWords = 16
+
+// formatCPUSet renders a CPU bitmask (as returned by GetCpuAffinity, least
+// significant word first) in the compact list format used by cpusets,
+// e.g. "0-15,32-47". An empty mask yields "".
+func formatCPUSet(mask []uint) string {
+	var parts []string
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		if start == end {
+			parts = append(parts, strconv.Itoa(start))
+		} else {
+			parts = append(parts, strconv.Itoa(start)+"-"+strconv.Itoa(end))
+		}
+		start = -1
+	}
+
+	cpu := 0
+	for _, word := range mask {
+		for b := 0; b < bits.UintSize; b, cpu = b+1, cpu+1 {
+			if word&(1<<uint(b)) != 0 {
+				if start < 0 {
+					start = cpu
+				}
+			} else {
+				flush(cpu - 1)
+			}
+		}
+	}
+	flush(cpu - 1)
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,30 @@
+package collector
+
+import (
+	"math/bits"
+	"testing"
+)
+
+func TestFormatCPUSet(t *testing.T) {
+	tests := []struct {
+		name string
+		mask []uint
+		want string
+	}{
+		{"empty", nil, ""},
+		{"none set", []uint{0}, ""},
+		{"single cpu", []uint{1 << 3}, "3"},
+		{"contiguous", []uint{0xffff}, "0-15"},
+		{"ranges and singles", []uint{0b1011_0111}, "0-2,4-5,7"},
+		{"range spanning words", []uint{1 << (bits.UintSize - 1), 0b11}, "63-65"},
+		{"second word only", []uint{0, 0xff}, "64-71"},
+	}
+	if bits.UintSize != 64 {
+		t.Skip("expectations assume 64-bit mask words")
+	}
+	for _, tc := range tests {
+		if got := formatCPUSet(tc.mask); got != tc.want {
+			t.Errorf("%s: formatCPUSet(%b) = %q, want %q", tc.name, tc.mask, got, tc.want)
+		}
+	}
+}
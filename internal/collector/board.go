@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// BoardInfo holds the power draw of one physical board. Multi-GPU boards
+// share a power budget across their GPU dies, so board power is reported
+// once per board rather than summed from the per-GPU readings.
+type BoardInfo struct {
+	ID         string
+	GPUs       []int   // indices of the GPUs on the board, ascending
+	PowerWatts float64 // watts
+}
+
+// boardID returns an identifier for the board a GPU is mounted on. NVML
+// board IDs are shared by all GPUs of a multi-GPU board. If the board ID is
+// unavailable (or zero, which NVML uses for "unknown") the GPU is assumed to
+// be on its own board, identified by its UUID.
+func boardID(device nvml.Device, uuid string) string {
+	if id, ret := device.GetBoardId(); ret == nvml.SUCCESS && id != 0 {
+		return fmt.Sprintf("0x%x", id)
+	}
+	return uuid
+}
+
+// modulePowerWatts reads the module-scoped instantaneous power of a GPU on
+// a multi-GPU board, i.e. the power of the whole board. ok is false if the
+// driver doesn't support the field.
+func modulePowerWatts(device nvml.Device) (watts float64, ok bool) {
+	values := []nvml.FieldValue{{FieldId: nvml.FI_DEV_POWER_INSTANT, ScopeId: nvml.POWER_SCOPE_MODULE}}
+	if ret := device.GetFieldValues(values); ret != nvml.SUCCESS {
+		return 0, false
+	}
+	if nvml.Return(values[0].NvmlReturn) != nvml.SUCCESS {
+		return 0, false
+	}
+	// Field power values are in milliwatts
+	mw, ok := sampleValue(nvml.ValueType(values[0].ValueType), values[0].Value)
+	return mw / 1000.0, ok
+}
+
+// groupBoards groups devices by board and computes each board's power.
+// Single-GPU boards use the GPU's own power reading. For a multi-GPU board
+// the module-scoped reading is used if any of its GPUs reported one;
+// otherwise the per-GPU readings are GPU-scoped and are summed.
+func groupBoards(devices []DeviceInfo) []BoardInfo {
+	byID := make(map[string]*BoardInfo)
+	modulePower := make(map[string]float64)
+	var ids []string
+	for _, d := range devices {
+		b, ok := byID[d.BoardID]
+		if !ok {
+			b = &BoardInfo{ID: d.BoardID}
+			byID[d.BoardID] = b
+			ids = append(ids, d.BoardID)
+		}
+		b.GPUs = append(b.GPUs, d.Index)
+		b.PowerWatts += d.PowerWatts
+		if d.BoardPowerWatts > 0 {
+			modulePower[d.BoardID] = d.BoardPowerWatts
+		}
+	}
+
+	sort.Strings(ids)
+	boards := make([]BoardInfo, 0, len(ids))
+	for _, id := range ids {
+		b := byID[id]
+		if p, ok := modulePower[id]; ok {
+			b.PowerWatts = p
+		}
+		sort.Ints(b.GPUs)
+		boards = append(boards, *b)
+	}
+	return boards
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+func TestGroupBoards(t *testing.T) {
+	devices := []DeviceInfo{
+		{Index: 0, BoardID: "0x100", PowerWatts: 150, BoardPowerWatts: 400},
+		{Index: 1, BoardID: "0x100", PowerWatts: 140, BoardPowerWatts: 400},
+		{Index: 2, BoardID: "GPU-c", PowerWatts: 300},
+		{Index: 3, BoardID: "0x200", PowerWatts: 100},
+		{Index: 4, BoardID: "0x200", PowerWatts: 120},
+	}
+	got := groupBoards(devices)
+	want := []BoardInfo{
+		{ID: "0x100", GPUs: []int{0, 1}, PowerWatts: 400}, // module-scoped reading, not summed
+		{ID: "0x200", GPUs: []int{3, 4}, PowerWatts: 220}, // no module reading: GPU-scoped, summed
+		{ID: "GPU-c", GPUs: []int{2}, PowerWatts: 300},    // single-GPU board
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groupBoards:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestCollectMultiGPUBoard(t *testing.T) {
+	onBoard := func(uuid string) nvml.Device {
+		dev := fakeDevice(uuid, nil, nil)
+		dev.GetBoardIdFunc = func() (uint32, nvml.Return) { return 0xabc, nvml.SUCCESS }
+		dev.GetMultiGpuBoardFunc = func() (int, nvml.Return) { return 1, nvml.SUCCESS }
+		dev.GetFieldValuesFunc = func(values []nvml.FieldValue) nvml.Return {
+			for i := range values {
+				if values[i].FieldId == nvml.FI_DEV_POWER_INSTANT && values[i].ScopeId == nvml.POWER_SCOPE_MODULE {
+					values[i].ValueType = uint32(nvml.VALUE_TYPE_UNSIGNED_INT)
+					binary.LittleEndian.PutUint32(values[i].Value[:4], 480000)
+				}
+			}
+			return nvml.SUCCESS
+		}
+		return dev
+	}
+	c := newTestCollector(onBoard("GPU-0"), onBoard("GPU-1"), fakeDevice("GPU-2", nil, nil))
+
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	want := []BoardInfo{
+		{ID: "0xabc", GPUs: []int{0, 1}, PowerWatts: 480},
+		{ID: "GPU-2", GPUs: []int{2}, PowerWatts: 250},
+	}
+	if !reflect.DeepEqual(snap.Boards, want) {
+		t.Errorf("boards:\n got %+v\nwant %+v", snap.Boards, want)
+	}
+}
@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// WithPodAttribution reads each process's /proc/<pid>/cgroup, once per
+// poll, to fill in ProcessSample.Cgroup, PodUID and ContainerID. It needs
+// host process visibility (hostPID: true) to see processes in other pods.
+func WithPodAttribution(enabled bool) Option {
+	return func(c *Collector) { c.podAttribution = enabled }
+}
+
+// podUIDRE matches the pod UID in a kubelet cgroup path element:
+// "pod<uid>" with the cgroupfs driver, "kubepods-<qos>-pod<uid>.slice" with
+// the systemd driver, which writes the UID's dashes as underscores. Static
+// pods have a 32-digit hash instead of a UUID.
+var podUIDRE = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12}|[0-9a-f]{32})(?:\.slice)?$`)
+
+// containerIDRE matches a container ID as the last cgroup path element:
+// bare with the cgroupfs driver, or "<runtime>-<id>.scope" (cri-containerd,
+// crio, docker) with the systemd driver.
+var containerIDRE = regexp.MustCompile(`^(?:[a-z-]+-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// procCgroup is what a process's cgroup file tells about it.
+type procCgroup struct {
+	path        string // path in the v2 hierarchy, or in the v1 memory controller's on v1-only hosts
+	podUID      string // empty outside a Kubernetes pod
+	containerID string // empty outside a container, or for a pod-level cgroup such as the pause container's sandbox
+}
+
+// parseCgroup parses the contents of /proc/<pid>/cgroup, for both cgroup v1
+// and v2 layouts.
+func parseCgroup(data []byte) procCgroup {
+	var cg procCgroup
+	var v1 string
+	for _, line := range strings.Split(string(data), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path; on v1 every controller
+		// has its own line, but all place the process in the same pod
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			cg.path = parts[2]
+		}
+		for _, ctrl := range strings.Split(parts[1], ",") {
+			if ctrl == "memory" {
+				v1 = parts[2]
+			}
+		}
+		if cg.podUID == "" {
+			cg.podUID, cg.containerID = parsePodPath(parts[2])
+		}
+	}
+	if cg.path == "" {
+		cg.path = v1
+	}
+	return cg
+}
+
+// parsePodPath extracts the pod UID and container ID from a cgroup path.
+func parsePodPath(path string) (podUID, containerID string) {
+	elems := strings.Split(strings.Trim(path, "/"), "/")
+	for i, elem := range elems {
+		m := podUIDRE.FindStringSubmatch(elem)
+		if m == nil {
+			continue
+		}
+		podUID = strings.ReplaceAll(m[1], "_", "-")
+		if i+1 < len(elems) {
+			if m := containerIDRE.FindStringSubmatch(elems[len(elems)-1]); m != nil {
+				containerID = m[1]
+			}
+		}
+		return podUID, containerID
+	}
+	return "", ""
+}
+
+// readCgroup reads and parses the cgroup file of pid. The result is empty
+// if the file can't be read in time.
+func (c *Collector) readCgroup(ctx context.Context, pid uint32) (cg procCgroup, timedOut bool) {
+	data, err := c.readProcFile(ctx, filepath.Join(c.procRoot, fmt.Sprint(pid), "cgroup"))
+	if errors.Is(err, errProcReadTimeout) {
+		c.logger.Warn("collector: reading process cgroup timed out", "event", "proc_read_timeout", "pid", pid, "timeout", c.procReadTimeout)
+		return procCgroup{}, true
+	}
+	if err != nil {
+		return procCgroup{}, false
+	}
+	return parseCgroup(data), false
+}
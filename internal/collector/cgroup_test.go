@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+const testContainerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseCgroup(t *testing.T) {
+	for _, tc := range []struct {
+		name, cgroup             string
+		wantPath                 string
+		wantPod, wantContainerID string
+	}{
+		{
+			name:            "v1 cgroupfs",
+			cgroup:          "12:memory:/kubepods/burstable/pod6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a/" + testContainerID + "\n11:cpu,cpuacct:/kubepods/burstable/pod6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a/" + testContainerID + "\n",
+			wantPath:        "/kubepods/burstable/pod6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a/" + testContainerID,
+			wantPod:         "6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a",
+			wantContainerID: testContainerID,
+		},
+		{
+			name:            "v1 guaranteed QoS",
+			cgroup:          "4:devices:/kubepods/pod6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a/" + testContainerID + "\n",
+			wantPod:         "6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a",
+			wantContainerID: testContainerID,
+		},
+		{
+			name:            "v2 systemd containerd",
+			cgroup:          "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod6b7ce1a2_9d4f_4c1e_8a55_2f0e3b9c1d7a.slice/cri-containerd-" + testContainerID + ".scope\n",
+			wantPath:        "/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod6b7ce1a2_9d4f_4c1e_8a55_2f0e3b9c1d7a.slice/cri-containerd-" + testContainerID + ".scope",
+			wantPod:         "6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a",
+			wantContainerID: testContainerID,
+		},
+		{
+			name:            "v2 systemd cri-o",
+			cgroup:          "0::/kubepods.slice/kubepods-pod6b7ce1a2_9d4f_4c1e_8a55_2f0e3b9c1d7a.slice/crio-" + testContainerID + ".scope\n",
+			wantPath:        "/kubepods.slice/kubepods-pod6b7ce1a2_9d4f_4c1e_8a55_2f0e3b9c1d7a.slice/crio-" + testContainerID + ".scope",
+			wantPod:         "6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a",
+			wantContainerID: testContainerID,
+		},
+		{
+			name:     "static pod sandbox",
+			cgroup:   "0::/kubepods/burstable/pod0f1e2d3c4b5a69788796a5b4c3d2e1f0\n",
+			wantPath: "/kubepods/burstable/pod0f1e2d3c4b5a69788796a5b4c3d2e1f0",
+			wantPod:  "0f1e2d3c4b5a69788796a5b4c3d2e1f0",
+		},
+		{
+			name:     "systemd service",
+			cgroup:   "0::/system.slice/nvidia-persistenced.service\n",
+			wantPath: "/system.slice/nvidia-persistenced.service",
+		},
+		{
+			name:     "docker outside Kubernetes",
+			cgroup:   "0::/system.slice/docker-" + testContainerID + ".scope\n",
+			wantPath: "/system.slice/docker-" + testContainerID + ".scope",
+		},
+		{
+			name:     "v1 memory controller",
+			cgroup:   "12:cpu,cpuacct:/a\n11:memory:/jobs/b\n1:name=systemd:/c\n",
+			wantPath: "/jobs/b",
+		},
+		{
+			name:     "hybrid prefers v2",
+			cgroup:   "11:memory:/v1path\n0::/v2path\n",
+			wantPath: "/v2path",
+		},
+		{name: "empty"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cg := parseCgroup([]byte(tc.cgroup))
+			if cg.path != tc.wantPath {
+				t.Errorf("expected path %q, got %q", tc.wantPath, cg.path)
+			}
+			if cg.podUID != tc.wantPod || cg.containerID != tc.wantContainerID {
+				t.Errorf("expected pod %q container %q, got pod %q container %q", tc.wantPod, tc.wantContainerID, cg.podUID, cg.containerID)
+			}
+		})
+	}
+}
+
+func TestCollectPodAttribution(t *testing.T) {
+	root := t.TempDir()
+	cgroups := map[string]string{
+		"100": "0::/kubepods/besteffort/pod6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a/" + testContainerID + "\n",
+		"200": "0::/user.slice/user-1000.slice/session-1.scope\n",
+		// 300 has no /proc entry (e.g. in another PID namespace)
+	}
+	for pid, cgroup := range cgroups {
+		if err := os.MkdirAll(filepath.Join(root, pid), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, pid, "cgroup"), []byte(cgroup), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	procs := []nvml.ProcessInfo{{Pid: 100, UsedGpuMemory: 1 << 30}, {Pid: 200, UsedGpuMemory: 1 << 30}, {Pid: 300, UsedGpuMemory: 1 << 30}}
+
+	c := newTestCollector(fakeDevice("GPU-0", procs, nil), fakeDevice("GPU-1", procs[:1], nil))
+	c.procRoot = root
+	WithPodAttribution(true)(c)
+	reads := make(map[string]int)
+	c.readFile = func(name string) ([]byte, error) {
+		reads[name]++
+		return os.ReadFile(name)
+	}
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(snap.Processes) != 4 {
+		t.Fatalf("expected 4 process samples, got %d", len(snap.Processes))
+	}
+	for _, p := range snap.Processes {
+		var wantPod, wantContainerID string
+		if p.PID == 100 {
+			wantPod, wantContainerID = "6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a", testContainerID
+		}
+		if p.PodUID != wantPod || p.ContainerID != wantContainerID {
+			t.Errorf("GPU %d PID %d: expected pod %q container %q, got pod %q container %q",
+				p.GPU, p.PID, wantPod, wantContainerID, p.PodUID, p.ContainerID)
+		}
+		if want := strings.TrimSuffix(strings.TrimPrefix(cgroups[fmt.Sprint(p.PID)], "0::"), "\n"); p.Cgroup != want {
+			t.Errorf("PID %d: expected cgroup %q, got %q", p.PID, want, p.Cgroup)
+		}
+	}
+	// PID 100 is on both GPUs, but its cgroup is read once
+	if n := reads[filepath.Join(root, "100", "cgroup")]; n != 1 {
+		t.Errorf("expected the cgroup of PID 100 read once, got %d", n)
+	}
+
+	// Disabled by default
+	c = newTestCollector(fakeDevice("GPU-0", procs, nil))
+	c.procRoot = root
+	if snap, err = c.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, p := range snap.Processes {
+		if p.PodUID != "" {
+			t.Errorf("PID %d: expected no pod without pod attribution, got %q", p.PID, p.PodUID)
+		}
+	}
+}
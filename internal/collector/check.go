@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// CheckResult is the outcome of one NVML call made by Check.
+type CheckResult struct {
+	Call     string // NVML function, e.g. "GetComputeRunningProcesses"
+	GPU      int    // device index; -1 for calls not tied to a device
+	Critical bool   // idle detection can't work without this call
+	Err      error  // nil on success
+}
+
+// checkCall describes one per-device NVML call exercised by Check.
+type checkCall struct {
+	name     string
+	critical bool
+	call     func(nvml.Device) nvml.Return
+}
+
+// deviceChecks are the per-device calls the collector depends on. Critical
+// ones feed idle detection; the rest only enrich the metrics and may be
+// unsupported on some GPUs.
+var deviceChecks = []checkCall{
+	{"GetUUID", true, func(d nvml.Device) nvml.Return { _, ret := d.GetUUID(); return ret }},
+	{"GetMemoryInfo", true, func(d nvml.Device) nvml.Return { _, ret := d.GetMemoryInfo(); return ret }},
+	{"GetComputeRunningProcesses", true, func(d nvml.Device) nvml.Return {
+		_, ret := d.GetComputeRunningProcesses()
+		return ret
+	}},
+	{"GetProcessUtilization", true, func(d nvml.Device) nvml.Return {
+		// NOT_FOUND just means no samples since the timestamp
+		if _, ret := d.GetProcessUtilization(0); ret != nvml.ERROR_NOT_FOUND {
+			return ret
+		}
+		return nvml.SUCCESS
+	}},
+	{"GetUtilizationRates", false, func(d nvml.Device) nvml.Return { _, ret := d.GetUtilizationRates(); return ret }},
+	{"GetPowerUsage", false, func(d nvml.Device) nvml.Return { _, ret := d.GetPowerUsage(); return ret }},
+	{"GetTemperature", false, func(d nvml.Device) nvml.Return {
+		_, ret := d.GetTemperature(nvml.TEMPERATURE_GPU)
+		return ret
+	}},
+	{"GetFanSpeed", false, func(d nvml.Device) nvml.Return { _, ret := d.GetFanSpeed(); return ret }},
+	{"GetPcieThroughput", false, func(d nvml.Device) nvml.Return {
+		_, ret := d.GetPcieThroughput(nvml.PCIE_UTIL_TX_BYTES)
+		return ret
+	}},
+	{"GetEncoderUtilization", false, func(d nvml.Device) nvml.Return { _, _, ret := d.GetEncoderUtilization(); return ret }},
+	{"GetDecoderUtilization", false, func(d nvml.Device) nvml.Return { _, _, ret := d.GetDecoderUtilization(); return ret }},
+	{"GetPowerManagementLimit", false, func(d nvml.Device) nvml.Return { _, ret := d.GetPowerManagementLimit(); return ret }},
+	{"GetEnforcedPowerLimit", false, func(d nvml.Device) nvml.Return { _, ret := d.GetEnforcedPowerLimit(); return ret }},
+	{"GetPersistenceMode", false, func(d nvml.Device) nvml.Return { _, ret := d.GetPersistenceMode(); return ret }},
+	{"GetEccMode", false, func(d nvml.Device) nvml.Return { _, _, ret := d.GetEccMode(); return ret }},
+	{"GetCurrentClocksThrottleReasons", false, func(d nvml.Device) nvml.Return {
+		_, ret := d.GetCurrentClocksThrottleReasons()
+		return ret
+	}},
+	{"GetClockInfo", false, func(d nvml.Device) nvml.Return { _, ret := d.GetClockInfo(nvml.CLOCK_SM); return ret }},
+	{"GetSerial", false, func(d nvml.Device) nvml.Return { _, ret := d.GetSerial(); return ret }},
+	{"GetVbiosVersion", false, func(d nvml.Device) nvml.Return { _, ret := d.GetVbiosVersion(); return ret }},
+	{"GetBoardPartNumber", false, func(d nvml.Device) nvml.Return { _, ret := d.GetBoardPartNumber(); return ret }},
+}
+
+// Check calls each NVML function the collector relies on, once per GPU,
+// and reports which fail. It is meant for validating a node's driver and
+// permission setup, not for the polling loop.
+func (c *Collector) Check() []CheckResult {
+	count, ret := c.lib.DeviceGetCount()
+	results := []CheckResult{{Call: "DeviceGetCount", GPU: -1, Critical: true, Err: nvmlError(ret)}}
+	if ret != nvml.SUCCESS {
+		return results
+	}
+
+	for i := 0; i < count; i++ {
+		device, ret := c.lib.DeviceGetHandleByIndex(i)
+		results = append(results, CheckResult{Call: "DeviceGetHandleByIndex", GPU: i, Critical: true, Err: nvmlError(ret)})
+		if ret != nvml.SUCCESS {
+			continue
+		}
+		for _, chk := range deviceChecks {
+			results = append(results, CheckResult{Call: chk.name, GPU: i, Critical: chk.critical, Err: nvmlError(chk.call(device))})
+		}
+	}
+	return results
+}
+
+// nvmlError converts an NVML return code to an error; nil for SUCCESS.
+func nvmlError(ret nvml.Return) error {
+	if ret == nvml.SUCCESS {
+		return nil
+	}
+	return fmt.Errorf("%v", nvml.ErrorString(ret))
+}
@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+func TestCheck(t *testing.T) {
+	broken := fakeDevice("GPU-1", nil, nil)
+	broken.GetComputeRunningProcessesFunc = func() ([]nvml.ProcessInfo, nvml.Return) {
+		return nil, nvml.ERROR_NO_PERMISSION
+	}
+	broken.GetPowerUsageFunc = func() (uint32, nvml.Return) { return 0, nvml.ERROR_NOT_SUPPORTED }
+
+	results := newTestCollector(fakeDevice("GPU-0", nil, nil), broken).Check()
+	if want := 1 + 2*(1+len(deviceChecks)); len(results) != want {
+		t.Fatalf("expected %d results, got %d", want, len(results))
+	}
+
+	failed := make(map[string]bool)
+	for _, r := range results {
+		if r.Err != nil {
+			failed[fmt.Sprintf("%s@%d", r.Call, r.GPU)] = r.Critical
+		}
+	}
+	if len(failed) != 2 {
+		t.Errorf("expected 2 failed calls, got %v", failed)
+	}
+	if critical, ok := failed["GetComputeRunningProcesses@1"]; !ok || !critical {
+		t.Errorf("expected a critical GetComputeRunningProcesses failure on GPU 1, got %v", failed)
+	}
+	if critical, ok := failed["GetPowerUsage@1"]; !ok || critical {
+		t.Errorf("expected a non-critical GetPowerUsage failure on GPU 1, got %v", failed)
+	}
+}
+
+func TestCheckDeviceCountFailure(t *testing.T) {
+	c := New()
+	c.lib = failingNVML{}
+	results := c.Check()
+	if len(results) != 1 || results[0].Err == nil || !results[0].Critical {
+		t.Errorf("expected a single critical DeviceGetCount failure, got %+v", results)
+	}
+}
+
+// failingNVML fails every call, as when the driver isn't loaded.
+type failingNVML struct{}
+
+func (failingNVML) Init() nvml.Return     { return nvml.ERROR_DRIVER_NOT_LOADED }
+func (failingNVML) Shutdown() nvml.Return { return nvml.ERROR_UNINITIALIZED }
+
+func (failingNVML) DeviceGetCount() (int, nvml.Return) { return 0, nvml.ERROR_DRIVER_NOT_LOADED }
+
+func (failingNVML) DeviceGetHandleByIndex(int) (nvml.Device, nvml.Return) {
+	return nil, nvml.ERROR_DRIVER_NOT_LOADED
+}
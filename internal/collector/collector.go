@@ -1,25 +1,89 @@
 package collector
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"strings"
+	"runtime/debug"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/affinode/gpu-idle-exporter/internal/collector")
+
 // DeviceInfo holds device-level metrics for a single GPU.
 type DeviceInfo struct {
-	Index       int
-	UUID        string
-	Name        string
-	MemoryUsed  uint64  // bytes
-	MemoryTotal uint64  // bytes
-	Utilization uint32  // percent 0-100
-	PowerWatts  float64 // watts
-	TempCelsius uint32  // degrees C
+	Index    int
+	UUID     string
+	Name     string
+	PCIBusID string // e.g. "00000000:07:00.0"; empty if unavailable
+	Serial   string // board serial number, for RMAs; empty if unsupported (e.g. consumer cards)
+	// Firmware and board revision, for fleet audits; empty if the query failed
+	VbiosVersion    string
+	BoardPartNumber string
+	MemoryUsed      uint64 // bytes
+	MemoryTotal     uint64 // bytes
+	Utilization     uint32 // percent 0-100
+	// UtilizationFine is the mean of the driver's utilization samples over
+	// the poll window, with sub-percent resolution. Equals Utilization if
+	// the samples are unavailable.
+	UtilizationFine float64
+	// MemoryUtilization is the percent of time the memory controller was
+	// busy reading or writing device memory
+	MemoryUtilization uint32
+	// Persistence mode keeps the driver initialized with no clients; only
+	// valid if HasPersistenceMode
+	PersistenceMode    bool
+	HasPersistenceMode bool
+	// ECC mode now and after the next reboot; only valid if HasEccMode,
+	// since consumer GPUs have no ECC
+	EccEnabled bool
+	EccPending bool
+	HasEccMode bool
+	// Bitmask of why clocks are currently held down (see ThrottleReasons);
+	// only valid if HasThrottleReasons
+	ThrottleReasons    uint64
+	HasThrottleReasons bool
+	PowerWatts         float64 // watts
+	// Configured and enforced power limits in watts; 0 if unsupported
+	PowerLimitWatts         float64
+	EnforcedPowerLimitWatts float64
+	TempCelsius             uint32 // degrees C
+	// Current clocks in MHz; 0 if the query failed
+	SmClockMHz       uint32
+	MemClockMHz      uint32
+	GraphicsClockMHz uint32
+	// Fan speed as a percentage of maximum; only valid if HasFanSpeed, since
+	// passively cooled modules don't report one
+	FanSpeedPercent uint32
+	HasFanSpeed     bool
+	// PCIe throughput in KB/s, sampled by the driver over a short window, so
+	// the first poll may read 0; only valid if HasPcieThroughput
+	PcieTxKBps        uint32
+	PcieRxKBps        uint32
+	HasPcieThroughput bool
+	// Video engine utilization percent over the driver's sampling period;
+	// only valid if HasCodecUtil
+	EncoderUtil  uint32
+	DecoderUtil  uint32
+	HasCodecUtil bool
+	CPUAffinity  string // CPUs closest to the GPU in cpuset list format, e.g. "0-15,32-47"; empty if unsupported
+
+	MigCapable   bool          // the GPU supports MIG; MigEnabled and MigPending are only meaningful if set
+	MigEnabled   bool          // MIG mode is currently enabled
+	MigPending   bool          // MIG mode after the next GPU reset; differs from MigEnabled while a change is pending
+	MigInstances []MigInstance // MIG GPU instances; empty unless MigEnabled
+
+	BoardID         string  // board the GPU is mounted on; the GPU UUID if the board ID is unknown
+	BoardPowerWatts float64 // module-scoped power of a multi-GPU board; 0 if unavailable or single-GPU
 }
 
 // ProcessSample holds per-process data from NVML for a single GPU.
@@ -28,6 +92,63 @@ type ProcessSample struct {
 	PID        uint32
 	UsedMemory uint64 // bytes
 	SmUtil     uint32 // percent 0-100
+	Namespace  string // Kubernetes namespace of the owning pod; empty if unattributed or without WithPodNamespaces
+
+	// Cgroup is the process's cgroup path. PodUID and ContainerID identify
+	// the Kubernetes pod and container the process runs in, from that
+	// path; empty for processes outside a pod. All are empty without
+	// WithPodAttribution.
+	Cgroup      string
+	PodUID      string
+	ContainerID string
+
+	// EngineUtil breaks the process's utilization down by engine. SM
+	// equals SmUtil; the others are 0 on drivers that don't report them.
+	EngineUtil EngineUtil
+
+	// MigInstance is the GPU instance ID the process runs in on a
+	// MIG-enabled GPU; empty otherwise.
+	MigInstance string
+
+	// Memoryless marks a process seen only in the utilization samples, not
+	// among the processes holding memory (e.g. a short-lived kernel launch).
+	// UsedMemory is 0. Only reported with WithUtilOnlyProcesses.
+	Memoryless bool
+
+	// ActiveStreams is the number of CUDA streams the process has live on
+	// this GPU, valid only if HasActiveStreams. NVML doesn't expose it; it
+	// is filled in by the stream counter configured with WithStreamCounter.
+	ActiveStreams    int
+	HasActiveStreams bool
+
+	// DataMovedBytes is the cumulative NVLink and PCIe traffic of the
+	// process on this GPU, valid only if HasDataMoved. NVML doesn't expose
+	// it per process; it is filled in by the counter configured with
+	// WithDataMovementCounter.
+	DataMovedBytes uint64
+	HasDataMoved   bool
+
+	// UtilSampled is true if NVML returned a utilization sample for the
+	// process this poll, the newest taken at UtilSampleTime. Processes
+	// without one are assumed idle. UtilUnavailable is set instead if the
+	// per-process utilization query failed, so SmUtil is only a default.
+	UtilSampled     bool
+	UtilSampleTime  time.Time
+	UtilUnavailable bool
+}
+
+// EngineUtil is a process's utilization (percent 0-100) per GPU engine,
+// from nvmlDeviceGetProcessUtilization.
+type EngineUtil struct {
+	SM      uint32 // streaming multiprocessors (compute)
+	Memory  uint32 // memory controller, including copy engine traffic
+	Encoder uint32 // NVENC
+	Decoder uint32 // NVDEC
+}
+
+// Busiest returns the highest utilization across all engines.
+func (u EngineUtil) Busiest() uint32 {
+	return max(u.SM, u.Memory, u.Encoder, u.Decoder)
 }
 
 // Snapshot is the result of a single collection cycle.
@@ -36,69 +157,408 @@ type Snapshot struct {
 	Devices      []DeviceInfo
 	Processes    []ProcessSample
 	ProcessNames map[uint32]string // pid -> process name from /proc/<pid>/comm
+	GPUFds       map[uint32]int    // pid -> open /dev/nvidia* fds; absent if /proc/<pid>/fd is unreadable
+	ProcessUIDs  map[uint32]uint32 // pid -> real UID from /proc/<pid>/status; absent if unreadable
+	Suspended    map[uint32]bool   // pids stopped or frozen per /proc/<pid>/status, so unable to do work
+	Boards       []BoardInfo       // devices grouped by physical board
+	PanickedGPUs []int             // GPU indices whose collection panicked and was skipped
+	TimedOutGPUs []int             // GPU indices whose NVML calls timed out and were skipped
+	DeviceCount  int               // GPUs reported by NVML, including filtered and skipped ones
+
+	ProcReadTimeouts int // /proc reads that exceeded the timeout this cycle
+
+	// Partial marks a collection made while NVML is recovering from a
+	// re-initialization that missed some GPUs, other than ones whose calls
+	// timed out. Its values are unreliable and shouldn't be exported.
+	Partial bool
+
+	Stages StageTimings // time spent in each stage of this cycle
+
+	// nvidia-persistenced status; only valid if PersistencedChecked
+	PersistencedChecked bool
+	PersistencedRunning bool
+}
+
+// StageTimings breaks down the time a collection cycle took.
+type StageTimings struct {
+	DeviceCollect  time.Duration // NVML device queries and the persistenced check
+	ProcessCollect time.Duration // NVML process queries, stream and data-movement counters
+	NameResolve    time.Duration // /proc reads: names, GPU fds, UIDs and pods
 }
 
 // Collector handles NVML device and process metrics collection.
 type Collector struct {
+	lib      nvmlClient
+	procRoot string // mount point of procfs, normally /proc
+
+	// readFile reads a /proc file; replaceable in tests. Each call is bounded
+	// by procReadTimeout because reads can block on processes in
+	// uninterruptible sleep.
+	readFile        func(name string) ([]byte, error)
+	procReadTimeout time.Duration
+	// countFds counts a process's GPU fds under a procfs root
+	// (countGPUFds); replaceable in tests and bounded the same way.
+	countFds func(procRoot string, pid uint32) (int, error)
+	// utilOnly includes PIDs with utilization but no memory allocation.
+	utilOnly bool
+	// podAttribution reads each process's pod and container from its cgroup.
+	podAttribution bool
+	// podLogDir is the kubelet's pod log directory, whose entries map pod
+	// UIDs to namespaces; empty if namespaces aren't resolved.
+	podLogDir  string
+	namespaces map[string]string // pod UID -> namespace, from the last listing
+	// countStreams reports live CUDA streams per process; nil if unavailable.
+	countStreams StreamCounter
+	// countDataMoved reports cumulative per-process data movement; nil if unavailable.
+	countDataMoved DataMovementCounter
+	// names caches the last successfully read name per PID, used when a read
+	// times out. Rebuilt each cycle so exited PIDs are dropped.
+	names map[uint32]string
+	// checkPersistenced looks for nvidia-persistenced each cycle;
+	// persistencedPID is where it was last found, 0 if not found.
+	checkPersistenced bool
+	persistencedPID   uint32
+	// gpuInclude and gpuExclude select the GPUs to collect (WithGPUFilter)
+	gpuInclude, gpuExclude GPUSet
+	// reinitializing is set from an NVML re-initialization until a
+	// collection reaches every GPU again.
+	reinitializing bool
+
+	// callTimeout bounds the NVML calls collecting each GPU; 0 disables it.
+	callTimeout time.Duration
+
+	// mu guards the fields below, which a GPU collection abandoned on
+	// timeout may still update when its calls return.
+	mu sync.Mutex
 	// lastSampleTime tracks the last timestamp per device index for
 	// nvmlDeviceGetProcessUtilization, which returns samples since a given timestamp.
 	lastSampleTime map[int]uint64
+	// lastUtilSampleTime is the same for nvmlDeviceGetSamples(GPU_UTILIZATION_SAMPLES).
+	lastUtilSampleTime map[int]uint64
+	// blocked marks GPUs whose collection timed out and hasn't returned yet.
+	blocked map[int]bool
+	// generation counts NVML re-initializations. A GPU collection records
+	// its sample timestamps only if no re-initialization happened since it
+	// started, so one abandoned before it can't store timestamps of the old
+	// NVML session.
+	generation uint64
+
+	// deviceUUIDs maps the index of each GPU seen in the last collection to
+	// its UUID, to detect GPUs appearing, disappearing or being renumbered.
+	// nil before the first collection.
+	deviceUUIDs map[int]string
+
+	logger *slog.Logger
+}
+
+// Option configures a Collector.
+type Option func(*Collector)
+
+// WithLogger sets the logger for collection errors and NVML events. The
+// default is slog.Default() at construction.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Collector) { c.logger = l }
+}
+
+// WithProcReadTimeout bounds each /proc read (e.g. /proc/<pid>/comm).
+func WithProcReadTimeout(d time.Duration) Option {
+	return func(c *Collector) { c.procReadTimeout = d }
+}
+
+// WithUtilOnlyProcesses includes processes that report utilization on any engine but
+// hold no GPU memory, flagged as Memoryless. Without it they are dropped.
+func WithUtilOnlyProcesses(enabled bool) Option {
+	return func(c *Collector) { c.utilOnly = enabled }
+}
+
+// StreamCounter returns the number of active CUDA streams process pid has
+// on GPU gpu. ok is false if the count is unknown for that process.
+type StreamCounter func(gpu int, pid uint32) (streams int, ok bool)
+
+// WithStreamCounter sets the source of per-process CUDA stream counts.
+// NVML has no such query, so the count must come from elsewhere, e.g. an
+// in-process CUPTI agent. Without a counter, stream counts are omitted.
+func WithStreamCounter(f StreamCounter) Option {
+	return func(c *Collector) { c.countStreams = f }
+}
+
+// DataMovementCounter returns the cumulative bytes process pid has moved
+// over NVLink and PCIe on GPU gpu. ok is false if the count is unknown for
+// that process.
+type DataMovementCounter func(gpu int, pid uint32) (bytes uint64, ok bool)
+
+// WithDataMovementCounter sets the source of per-process data movement,
+// e.g. an agent reading communication library counters. Without a counter,
+// idle detection relies on utilization alone.
+func WithDataMovementCounter(f DataMovementCounter) Option {
+	return func(c *Collector) { c.countDataMoved = f }
 }
 
 // New creates a new Collector.
-func New() *Collector {
-	return &Collector{
-		lastSampleTime: make(map[int]uint64),
+func New(opts ...Option) *Collector {
+	c := &Collector{
+		lib:                nvmlLib{},
+		procRoot:           "/proc",
+		readFile:           os.ReadFile,
+		countFds:           countGPUFds,
+		procReadTimeout:    time.Second,
+		callTimeout:        DefaultCallTimeout,
+		names:              make(map[uint32]string),
+		lastSampleTime:     make(map[int]uint64),
+		lastUtilSampleTime: make(map[int]uint64),
+		blocked:            make(map[int]bool),
+		logger:             slog.Default(),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Collect queries NVML for all GPU device and per-process metrics.
-func (c *Collector) Collect() (*Snapshot, error) {
+// A span is recorded for the whole collection and for each device.
+func (c *Collector) Collect(ctx context.Context) (*Snapshot, error) {
+	ctx, span := tracer.Start(ctx, "collector.Collect")
+	defer span.End()
+
+	// time.Now carries a monotonic clock reading, so durations between
+	// snapshots are unaffected by wall-clock steps. The tracker still guards
+	// against timestamps that lost it (see idle.Tracker.ClockSkewed).
 	snap := &Snapshot{
 		Timestamp:    time.Now(),
 		ProcessNames: make(map[uint32]string),
+		GPUFds:       make(map[uint32]int),
+		ProcessUIDs:  make(map[uint32]uint32),
+		Suspended:    make(map[uint32]bool),
 	}
 
-	count, ret := nvml.DeviceGetCount()
+	count, ret := c.lib.DeviceGetCount()
+	if needsReinit(ret) {
+		err := c.reinit(ret)
+		span.RecordError(err)
+		return nil, err
+	}
 	if ret != nvml.SUCCESS {
-		return nil, fmt.Errorf("DeviceGetCount: %v", nvml.ErrorString(ret))
+		err := fmt.Errorf("DeviceGetCount: %v", nvml.ErrorString(ret))
+		span.RecordError(err)
+		return nil, err
 	}
 
+	missed := make(map[int]bool) // GPUs whose handle or metrics couldn't be read
 	for i := 0; i < count; i++ {
-		device, ret := nvml.DeviceGetHandleByIndex(i)
-		if ret != nvml.SUCCESS {
-			log.Printf("collector: DeviceGetHandleByIndex(%d): %v", i, nvml.ErrorString(ret))
+		_, devSpan := tracer.Start(ctx, "collector.device", trace.WithAttributes(attribute.Int("gpu", i)))
+
+		di, procs, err := c.collectGPUTimeout(i, &snap.Stages)
+		if errors.Is(err, errGPUSkipped) {
+			devSpan.End()
+			continue
+		}
+		if errors.Is(err, errNoHandle) {
+			c.logger.Warn("collector: DeviceGetHandleByIndex failed", "event", "collection_error", "gpu", i, "err", err)
+			devSpan.End()
+			missed[i] = true
+			continue
+		}
+		if errors.Is(err, errCallTimeout) || errors.Is(err, errCallBlocked) {
+			c.logger.Warn("collector: skipping GPU", "event", "collection_timeout", "gpu", i, "timeout", c.callTimeout, "err", err)
+			devSpan.RecordError(err)
+			devSpan.End()
+			snap.TimedOutGPUs = append(snap.TimedOutGPUs, i)
+			missed[i] = true
+			continue
+		}
+		var lost gpuLostError
+		if errors.As(err, &lost) {
+			devSpan.RecordError(err)
+			devSpan.End()
+			if !c.reinitializing {
+				err := c.reinit(lost.ret)
+				span.RecordError(err)
+				return nil, err
+			}
+			// Still recovering from the last re-initialization
+			c.logger.Warn("collector: skipping GPU", "event", "collection_error", "gpu", i, "err", err)
+			missed[i] = true
+			continue
+		}
+		if err != nil {
+			c.logger.Warn("collector: skipping GPU", "event", "collection_error", "gpu", i, "err", err)
+			devSpan.RecordError(err)
+			devSpan.End()
+			snap.PanickedGPUs = append(snap.PanickedGPUs, i)
+			missed[i] = true
 			continue
 		}
-
-		di := c.collectDevice(i, device)
 		snap.Devices = append(snap.Devices, di)
-
-		procs := c.collectProcesses(i, device)
 		snap.Processes = append(snap.Processes, procs...)
+
+		devSpan.SetAttributes(attribute.Int("process.count", len(procs)))
+		devSpan.End()
+	}
+
+	snap.DeviceCount = count
+	snap.Boards = groupBoards(snap.Devices)
+	c.trackDevices(count, snap.Devices, missed)
+
+	if c.reinitializing {
+		// A GPU whose calls time out stays hung across the
+		// re-initialization; it is reported in TimedOutGPUs and mustn't
+		// hold back the metrics of the others.
+		if len(missed) > len(snap.TimedOutGPUs) || count == 0 {
+			snap.Partial = true
+		} else {
+			c.logger.Info("collector: NVML re-initialized, collected all GPUs", "event", "nvml_reinit", "gpus", count)
+			c.reinitializing = false
+		}
 	}
 
-	// Read process names from /proc/<pid>/comm
+	if c.checkPersistenced {
+		start := time.Now()
+		snap.PersistencedChecked = true
+		snap.PersistencedRunning = c.persistencedRunning(ctx)
+		snap.Stages.DeviceCollect += time.Since(start)
+	}
+
+	start := time.Now()
+	if c.countStreams != nil {
+		for i := range snap.Processes {
+			p := &snap.Processes[i]
+			p.ActiveStreams, p.HasActiveStreams = c.countStreams(p.GPU, p.PID)
+		}
+	}
+	if c.countDataMoved != nil {
+		for i := range snap.Processes {
+			p := &snap.Processes[i]
+			p.DataMovedBytes, p.HasDataMoved = c.countDataMoved(p.GPU, p.PID)
+		}
+	}
+
+	snap.Stages.ProcessCollect += time.Since(start)
+
+	// Read process names from /proc/<pid>/comm and count open GPU fds
+	start = time.Now()
+	names := make(map[uint32]string, len(snap.Processes))
 	for _, p := range snap.Processes {
 		if _, exists := snap.ProcessNames[p.PID]; !exists {
-			snap.ProcessNames[p.PID] = readProcessName(p.PID)
+			name, timedOut := c.readProcessName(ctx, p.PID)
+			if timedOut {
+				snap.ProcReadTimeouts++
+			}
+			if name != "unknown" {
+				names[p.PID] = name
+			}
+			snap.ProcessNames[p.PID] = name
+			n, ok, timedOut := c.readGPUFds(ctx, p.PID)
+			if timedOut {
+				snap.ProcReadTimeouts++
+			}
+			if ok {
+				snap.GPUFds[p.PID] = n
+			}
+			status, timedOut := c.readProcessStatus(ctx, p.PID)
+			if timedOut {
+				snap.ProcReadTimeouts++
+			}
+			if status.hasUID {
+				snap.ProcessUIDs[p.PID] = status.uid
+			}
+			if status.suspended {
+				snap.Suspended[p.PID] = true
+			}
 		}
 	}
+	c.names = names
 
+	if c.podAttribution {
+		cgroups := make(map[uint32]procCgroup)
+		var listed bool // the pod log directory was listed this collection
+		for i := range snap.Processes {
+			p := &snap.Processes[i]
+			cg, ok := cgroups[p.PID]
+			if !ok {
+				var timedOut bool
+				cg, timedOut = c.readCgroup(ctx, p.PID)
+				if timedOut {
+					snap.ProcReadTimeouts++
+				}
+				cgroups[p.PID] = cg
+			}
+			p.Cgroup, p.PodUID, p.ContainerID = cg.path, cg.podUID, cg.containerID
+			if c.podLogDir != "" && p.PodUID != "" {
+				var timedOut bool
+				p.Namespace, timedOut = c.podNamespace(ctx, p.PodUID, &listed)
+				if timedOut {
+					snap.ProcReadTimeouts++
+				}
+			}
+		}
+	}
+	snap.Stages.NameResolve = time.Since(start)
+
+	span.SetAttributes(
+		attribute.Int("gpu.count", len(snap.Devices)),
+		attribute.Int("process.count", len(snap.Processes)),
+	)
 	return snap, nil
 }
 
-// collectDevice gathers device-level metrics for a single GPU.
-func (c *Collector) collectDevice(index int, device nvml.Device) DeviceInfo {
+// collectGPU looks up the GPU at index and gathers its device and process
+// metrics, returning errGPUSkipped if the GPU filter skips it and
+// errNoHandle if it can't be looked up. The lookup is part of the
+// collection so the call timeout bounds it too. A panic in the NVML
+// bindings (seen on malformed driver responses) is recovered and returned
+// as an error so the remaining GPUs are still collected. gen is the
+// generation the collection started in. Time spent is added to stages.
+func (c *Collector) collectGPU(index int, gen uint64, stages *StageTimings) (di DeviceInfo, procs []ProcessSample, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("collector: recovered panic collecting GPU", "event", "collector_panic", "gpu", index, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic in NVML bindings: %v", r)
+		}
+	}()
+	start := time.Now()
+	device, ret := c.lib.DeviceGetHandleByIndex(index)
+	if needsReinit(ret) {
+		return di, nil, gpuLostError{ret}
+	}
+	if ret != nvml.SUCCESS {
+		return di, nil, fmt.Errorf("%w: %s", errNoHandle, nvml.ErrorString(ret))
+	}
+	if c.skipGPU(index, device) {
+		return di, nil, errGPUSkipped
+	}
+	di, err = c.collectDevice(index, device, gen)
+	stages.DeviceCollect += time.Since(start)
+	if err != nil {
+		return di, nil, err
+	}
+	start = time.Now()
+	procs, err = c.collectProcesses(index, device, di, gen)
+	stages.ProcessCollect += time.Since(start)
+	return di, procs, err
+}
+
+// collectDevice gathers device-level metrics for a single GPU. A
+// gpuLostError is returned if the GPU's UUID can't be read until NVML is
+// re-initialized.
+func (c *Collector) collectDevice(index int, device nvml.Device, gen uint64) (DeviceInfo, error) {
 	di := DeviceInfo{Index: index}
 
 	if name, ret := device.GetName(); ret == nvml.SUCCESS {
 		di.Name = name
 	}
-	if uuid, ret := device.GetUUID(); ret == nvml.SUCCESS {
+	uuid, ret := device.GetUUID()
+	if needsReinit(ret) {
+		return di, gpuLostError{ret}
+	}
+	if ret == nvml.SUCCESS {
 		di.UUID = uuid
 	}
 
+	if pci, ret := device.GetPciInfo(); ret == nvml.SUCCESS {
+		di.PCIBusID = pciBusID(pci)
+	}
+
 	if memInfo, ret := device.GetMemoryInfo(); ret == nvml.SUCCESS {
 		di.MemoryUsed = memInfo.Used
 		di.MemoryTotal = memInfo.Total
@@ -106,38 +566,145 @@ func (c *Collector) collectDevice(index int, device nvml.Device) DeviceInfo {
 
 	if utilRates, ret := device.GetUtilizationRates(); ret == nvml.SUCCESS {
 		di.Utilization = utilRates.Gpu
+		di.MemoryUtilization = utilRates.Memory
+	}
+	di.UtilizationFine = float64(di.Utilization)
+	c.mu.Lock()
+	lastUtilTS := c.lastUtilSampleTime[index]
+	c.mu.Unlock()
+	if vt, samples, ret := device.GetSamples(nvml.GPU_UTILIZATION_SAMPLES, lastUtilTS); ret == nvml.SUCCESS {
+		if avg, latest, ok := averageSamples(vt, samples); ok {
+			di.UtilizationFine = avg
+			c.mu.Lock()
+			if c.generation == gen {
+				c.lastUtilSampleTime[index] = latest
+			}
+			c.mu.Unlock()
+		}
 	}
 
 	// GetPowerUsage returns milliwatts
 	if power, ret := device.GetPowerUsage(); ret == nvml.SUCCESS {
 		di.PowerWatts = float64(power) / 1000.0
 	}
+	if mode, ret := device.GetPersistenceMode(); ret == nvml.SUCCESS {
+		di.PersistenceMode = mode == nvml.FEATURE_ENABLED
+		di.HasPersistenceMode = true
+	}
+	if current, pending, ret := device.GetEccMode(); ret == nvml.SUCCESS {
+		di.EccEnabled = current == nvml.FEATURE_ENABLED
+		di.EccPending = pending == nvml.FEATURE_ENABLED
+		di.HasEccMode = true
+	}
+	if limit, ret := device.GetPowerManagementLimit(); ret == nvml.SUCCESS {
+		di.PowerLimitWatts = float64(limit) / 1000.0
+	}
+	if limit, ret := device.GetEnforcedPowerLimit(); ret == nvml.SUCCESS {
+		di.EnforcedPowerLimitWatts = float64(limit) / 1000.0
+	}
+
+	di.BoardID = boardID(device, di.UUID)
+	if multi, ret := device.GetMultiGpuBoard(); ret == nvml.SUCCESS && multi != 0 {
+		if watts, ok := modulePowerWatts(device); ok {
+			di.BoardPowerWatts = watts
+		}
+	}
+
+	if reasons, ret := device.GetCurrentClocksThrottleReasons(); ret == nvml.SUCCESS {
+		di.ThrottleReasons = reasons
+		di.HasThrottleReasons = true
+	}
+	if clock, ret := device.GetClockInfo(nvml.CLOCK_SM); ret == nvml.SUCCESS {
+		di.SmClockMHz = clock
+	}
+	if clock, ret := device.GetClockInfo(nvml.CLOCK_MEM); ret == nvml.SUCCESS {
+		di.MemClockMHz = clock
+	}
+	if clock, ret := device.GetClockInfo(nvml.CLOCK_GRAPHICS); ret == nvml.SUCCESS {
+		di.GraphicsClockMHz = clock
+	}
+
+	if speed, ret := device.GetFanSpeed(); ret == nvml.SUCCESS {
+		di.FanSpeedPercent = speed
+		di.HasFanSpeed = true
+	}
+
+	if tx, ret := device.GetPcieThroughput(nvml.PCIE_UTIL_TX_BYTES); ret == nvml.SUCCESS {
+		if rx, ret := device.GetPcieThroughput(nvml.PCIE_UTIL_RX_BYTES); ret == nvml.SUCCESS {
+			di.PcieTxKBps, di.PcieRxKBps = tx, rx
+			di.HasPcieThroughput = true
+		}
+	}
+
+	// Both return the utilization and the sampling period it covers
+	if enc, _, ret := device.GetEncoderUtilization(); ret == nvml.SUCCESS {
+		if dec, _, ret := device.GetDecoderUtilization(); ret == nvml.SUCCESS {
+			di.EncoderUtil, di.DecoderUtil = enc, dec
+			di.HasCodecUtil = true
+		}
+	}
+
+	if serial, ret := device.GetSerial(); ret == nvml.SUCCESS {
+		di.Serial = serial
+	}
+	if vbios, ret := device.GetVbiosVersion(); ret == nvml.SUCCESS {
+		di.VbiosVersion = vbios
+	}
+	if part, ret := device.GetBoardPartNumber(); ret == nvml.SUCCESS {
+		di.BoardPartNumber = part
+	}
 
 	if temp, ret := device.GetTemperature(nvml.TEMPERATURE_GPU); ret == nvml.SUCCESS {
 		di.TempCelsius = temp
 	}
 
-	return di
+	if mask, ret := device.GetCpuAffinity(cpuSetWords); ret == nvml.SUCCESS {
+		di.CPUAffinity = formatCPUSet(mask)
+	}
+
+	if current, pending, ok := migMode(device); ok {
+		di.MigCapable = true
+		di.MigEnabled = current
+		di.MigPending = pending
+		if current {
+			di.MigInstances = c.collectMigInstances(index, device)
+		}
+	}
+
+	return di, nil
 }
 
-// collectProcesses gathers per-process metrics for a single GPU.
-func (c *Collector) collectProcesses(gpuIndex int, device nvml.Device) []ProcessSample {
+// collectProcesses gathers per-process metrics for a single GPU, whose
+// device metrics are di. On a MIG-enabled GPU each process is tagged with
+// the GPU instance it runs in. A gpuLostError is returned if the processes
+// can't be listed until NVML is re-initialized.
+func (c *Collector) collectProcesses(gpuIndex int, device nvml.Device, di DeviceInfo, gen uint64) ([]ProcessSample, error) {
+	mig := di.MigEnabled
 	// Get processes holding GPU memory
 	procs, ret := device.GetComputeRunningProcesses()
+	if needsReinit(ret) {
+		return nil, gpuLostError{ret}
+	}
 	if ret != nvml.SUCCESS {
-		log.Printf("collector: GetComputeRunningProcesses(GPU %d): %v", gpuIndex, nvml.ErrorString(ret))
-		return nil
+		c.logger.Warn("collector: GetComputeRunningProcesses failed", "event", "collection_error", "gpu", gpuIndex, "err", nvml.ErrorString(ret))
+		return nil, nil
+	}
+	if len(procs) == 0 && mig {
+		procs = migProcesses(di.MigInstances)
 	}
-	if len(procs) == 0 {
-		return nil
+	if len(procs) == 0 && !c.utilOnly {
+		return nil, nil
 	}
 
 	// Get per-process utilization samples since last poll
+	c.mu.Lock()
 	lastTS := c.lastSampleTime[gpuIndex]
+	c.mu.Unlock()
 	utilSamples, ret := device.GetProcessUtilization(lastTS)
-	if ret != nvml.SUCCESS && ret != nvml.ERROR_NOT_FOUND {
+	utilUnavailable := ret != nvml.SUCCESS && ret != nvml.ERROR_NOT_FOUND
+	if utilUnavailable {
 		// NOT_FOUND is returned when no samples are available (all processes idle) — not an error
-		log.Printf("collector: GetProcessUtilization(GPU %d): %v", gpuIndex, nvml.ErrorString(ret))
+		c.logger.Warn("collector: GetProcessUtilization failed", "event", "collection_error", "gpu", gpuIndex, "err", nvml.ErrorString(ret))
 	}
 
 	// Update lastSampleTime to the max timestamp from results
@@ -148,53 +715,96 @@ func (c *Collector) collectProcesses(gpuIndex int, device nvml.Device) []Process
 				maxTS = s.TimeStamp
 			}
 		}
-		c.lastSampleTime[gpuIndex] = maxTS
+		c.mu.Lock()
+		if c.generation == gen {
+			c.lastSampleTime[gpuIndex] = maxTS
+		}
+		c.mu.Unlock()
 	}
 
-	// Build PID -> max SmUtil map from utilization samples
-	utilMap := make(map[uint32]uint32, len(utilSamples))
+	// Build PID -> max per-engine utilization map from utilization samples
+	utilMap := make(map[uint32]EngineUtil, len(utilSamples))
+	sampleTS := make(map[uint32]uint64, len(utilSamples))
 	for _, s := range utilSamples {
-		if s.SmUtil > utilMap[s.Pid] {
-			utilMap[s.Pid] = s.SmUtil
+		u := utilMap[s.Pid]
+		u.SM = max(u.SM, s.SmUtil)
+		u.Memory = max(u.Memory, s.MemUtil)
+		u.Encoder = max(u.Encoder, s.EncUtil)
+		u.Decoder = max(u.Decoder, s.DecUtil)
+		utilMap[s.Pid] = u
+		sampleTS[s.Pid] = max(sampleTS[s.Pid], s.TimeStamp)
+	}
+	// setSampleInfo records where a process's utilization came from
+	setSampleInfo := func(sample *ProcessSample) {
+		sample.UtilUnavailable = utilUnavailable
+		if ts, ok := sampleTS[sample.PID]; ok {
+			sample.UtilSampled = true
+			// Sample timestamps are CPU time in microseconds since the epoch
+			sample.UtilSampleTime = time.UnixMicro(int64(ts))
 		}
 	}
 
+	// MIG GPUs don't report per-process utilization; where an instance
+	// reports its own, its processes default to it instead of 0, so a busy
+	// instance doesn't look idle. UtilUnavailable stays set.
+	var instanceUtil map[string]uint32
+	if mig && utilUnavailable {
+		instanceUtil = migUtilization(di.MigInstances)
+	}
+
 	// Merge: for each process with memory allocated, look up its utilization.
 	// Processes absent from utilSamples default to SmUtil=0 (idle).
 	samples := make([]ProcessSample, 0, len(procs))
+	withMemory := make(map[uint32]bool, len(procs))
 	for _, p := range procs {
-		samples = append(samples, ProcessSample{
+		withMemory[p.Pid] = true
+		sample := ProcessSample{
 			GPU:        gpuIndex,
 			PID:        p.Pid,
 			UsedMemory: p.UsedGpuMemory,
-			SmUtil:     utilMap[p.Pid],
-		})
+			SmUtil:     utilMap[p.Pid].SM,
+			EngineUtil: utilMap[p.Pid],
+		}
+		if mig {
+			sample.MigInstance = strconv.FormatUint(uint64(p.GpuInstanceId), 10)
+			if util, ok := instanceUtil[sample.MigInstance]; ok {
+				sample.SmUtil = util
+				sample.EngineUtil.SM = util
+			}
+		}
+		setSampleInfo(&sample)
+		samples = append(samples, sample)
 	}
 
-	return samples
+	// Processes busy on some engine but holding no memory, if requested
+	if c.utilOnly {
+		for pid, util := range utilMap {
+			if withMemory[pid] || util.Busiest() == 0 {
+				continue
+			}
+			sample := ProcessSample{
+				GPU:        gpuIndex,
+				PID:        pid,
+				SmUtil:     util.SM,
+				EngineUtil: util,
+				Memoryless: true,
+			}
+			setSampleInfo(&sample)
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples, nil
 }
 
-// readProcessName reads the process name from /proc/<pid>/comm.
-// The result is sanitized: control characters and null bytes are stripped
-// (null bytes would break the stale-key delimiter in the exporter), and
-// the name is truncated to 64 characters.
-func readProcessName(pid uint32) string {
-	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
-	if err != nil {
-		return "unknown"
-	}
-	name := strings.TrimSpace(string(data))
-	name = strings.Map(func(r rune) rune {
-		if r < 0x20 || r == 0x7f { // strip control characters including \x00
-			return -1
+// pciBusID returns the NUL-terminated bus ID from NVML PCI info.
+func pciBusID(pci nvml.PciInfo) string {
+	b := make([]byte, 0, len(pci.BusId))
+	for _, c := range pci.BusId {
+		if c == 0 {
+			break
 		}
-		return r
-	}, name)
-	if len(name) > 64 {
-		name = name[:64]
-	}
-	if name == "" {
-		return "unknown"
+		b = append(b, byte(c))
 	}
-	return name
+	return string(b)
 }
@@ -0,0 +1,873 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// fakeNVML serves a fixed list of device handles.
+type fakeNVML struct {
+	devices []nvml.Device
+}
+
+func (f *fakeNVML) Init() nvml.Return     { return nvml.SUCCESS }
+func (f *fakeNVML) Shutdown() nvml.Return { return nvml.SUCCESS }
+
+func (f *fakeNVML) DeviceGetCount() (int, nvml.Return) {
+	return len(f.devices), nvml.SUCCESS
+}
+
+func (f *fakeNVML) DeviceGetHandleByIndex(index int) (nvml.Device, nvml.Return) {
+	if index < 0 || index >= len(f.devices) {
+		return nil, nvml.ERROR_INVALID_ARGUMENT
+	}
+	return f.devices[index], nvml.SUCCESS
+}
+
+// fakeDevice returns a mock device with every call used by the collector stubbed.
+// Callers override individual funcs to exercise specific branches.
+func fakeDevice(uuid string, procs []nvml.ProcessInfo, util []nvml.ProcessUtilizationSample) *mock.Device {
+	return &mock.Device{
+		GetNameFunc: func() (string, nvml.Return) { return "NVIDIA A100-SXM4-40GB", nvml.SUCCESS },
+		GetUUIDFunc: func() (string, nvml.Return) { return uuid, nvml.SUCCESS },
+		GetMemoryInfoFunc: func() (nvml.Memory, nvml.Return) {
+			return nvml.Memory{Total: 40 << 30, Used: 8 << 30}, nvml.SUCCESS
+		},
+		GetUtilizationRatesFunc: func() (nvml.Utilization, nvml.Return) {
+			return nvml.Utilization{Gpu: 42, Memory: 10}, nvml.SUCCESS
+		},
+		GetPowerUsageFunc:                   func() (uint32, nvml.Return) { return 250000, nvml.SUCCESS },
+		GetCurrentClocksThrottleReasonsFunc: func() (uint64, nvml.Return) { return nvml.ClocksThrottleReasonGpuIdle, nvml.SUCCESS },
+		GetPersistenceModeFunc:              func() (nvml.EnableState, nvml.Return) { return nvml.FEATURE_ENABLED, nvml.SUCCESS },
+		GetEccModeFunc: func() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+			return nvml.FEATURE_ENABLED, nvml.FEATURE_ENABLED, nvml.SUCCESS
+		},
+		GetPowerManagementLimitFunc: func() (uint32, nvml.Return) { return 400000, nvml.SUCCESS },
+		GetEnforcedPowerLimitFunc:   func() (uint32, nvml.Return) { return 300000, nvml.SUCCESS },
+		GetTemperatureFunc:          func(nvml.TemperatureSensors) (uint32, nvml.Return) { return 55, nvml.SUCCESS },
+		GetComputeRunningProcessesFunc: func() ([]nvml.ProcessInfo, nvml.Return) {
+			return procs, nvml.SUCCESS
+		},
+		GetProcessUtilizationFunc: func(uint64) ([]nvml.ProcessUtilizationSample, nvml.Return) {
+			if len(util) == 0 {
+				return nil, nvml.ERROR_NOT_FOUND
+			}
+			return util, nvml.SUCCESS
+		},
+		GetMigModeFunc:      func() (int, int, nvml.Return) { return 0, 0, nvml.ERROR_NOT_SUPPORTED },
+		GetCpuAffinityFunc:  func(int) ([]uint, nvml.Return) { return nil, nvml.ERROR_NOT_SUPPORTED },
+		GetSerialFunc:       func() (string, nvml.Return) { return "1320221234567", nvml.SUCCESS },
+		GetVbiosVersionFunc: func() (string, nvml.Return) { return "92.00.45.00.03", nvml.SUCCESS },
+		GetBoardPartNumberFunc: func() (string, nvml.Return) {
+			return "900-21001-0000-000", nvml.SUCCESS
+		},
+		GetClockInfoFunc: func(clock nvml.ClockType) (uint32, nvml.Return) {
+			return map[nvml.ClockType]uint32{nvml.CLOCK_SM: 1410, nvml.CLOCK_MEM: 1215, nvml.CLOCK_GRAPHICS: 1400}[clock], nvml.SUCCESS
+		},
+		GetFanSpeedFunc:           func() (uint32, nvml.Return) { return 38, nvml.SUCCESS },
+		GetEncoderUtilizationFunc: func() (uint32, uint32, nvml.Return) { return 0, 167000, nvml.SUCCESS },
+		GetDecoderUtilizationFunc: func() (uint32, uint32, nvml.Return) { return 0, 167000, nvml.SUCCESS },
+		GetPcieThroughputFunc: func(counter nvml.PcieUtilCounter) (uint32, nvml.Return) {
+			if counter == nvml.PCIE_UTIL_TX_BYTES {
+				return 12000, nvml.SUCCESS
+			}
+			return 3400, nvml.SUCCESS
+		},
+		GetBoardIdFunc:       func() (uint32, nvml.Return) { return 0, nvml.ERROR_NOT_SUPPORTED },
+		GetMultiGpuBoardFunc: func() (int, nvml.Return) { return 0, nvml.SUCCESS },
+		GetPciInfoFunc: func() (nvml.PciInfo, nvml.Return) {
+			var pci nvml.PciInfo
+			for i, c := range "00000000:07:00.0" {
+				pci.BusId[i] = int8(c)
+			}
+			return pci, nvml.SUCCESS
+		},
+		GetSamplesFunc: func(nvml.SamplingType, uint64) (nvml.ValueType, []nvml.Sample, nvml.Return) {
+			return 0, nil, nvml.ERROR_NOT_SUPPORTED
+		},
+	}
+}
+
+func newTestCollector(devices ...nvml.Device) *Collector {
+	c := New()
+	c.lib = &fakeNVML{devices: devices}
+	return c
+}
+
+func TestCollectTraceSpans(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	c := newTestCollector(
+		fakeDevice("GPU-0", []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 30}}, nil),
+		fakeDevice("GPU-1", nil, nil),
+	)
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(snap.Devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(snap.Devices))
+	}
+
+	spans := sr.Ended()
+	var root sdktrace.ReadOnlySpan
+	var devices []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		switch s.Name() {
+		case "collector.Collect":
+			root = s
+		case "collector.device":
+			devices = append(devices, s)
+		}
+	}
+	if root == nil {
+		t.Fatal("missing collector.Collect span")
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 collector.device spans, got %d", len(devices))
+	}
+	for _, d := range devices {
+		if d.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("device span %v is not a child of collector.Collect", d.Attributes())
+		}
+	}
+	attrs := make(map[string]int64)
+	for _, kv := range root.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsInt64()
+	}
+	if attrs["gpu.count"] != 2 || attrs["process.count"] != 1 {
+		t.Errorf("unexpected collector.Collect attributes: %v", attrs)
+	}
+}
+
+func TestCollectRecoversDevicePanic(t *testing.T) {
+	bad := fakeDevice("GPU-bad", nil, nil)
+	bad.GetMemoryInfoFunc = func() (nvml.Memory, nvml.Return) {
+		panic("malformed driver response")
+	}
+	good := fakeDevice("GPU-good", []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 20}}, nil)
+
+	c := newTestCollector(bad, good)
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect should not fail on a per-device panic: %v", err)
+	}
+	if len(snap.Devices) != 1 || snap.Devices[0].UUID != "GPU-good" {
+		t.Fatalf("expected only the healthy GPU to be collected, got %+v", snap.Devices)
+	}
+	if len(snap.Processes) != 1 || snap.Processes[0].GPU != 1 {
+		t.Errorf("expected the healthy GPU's process to be collected, got %+v", snap.Processes)
+	}
+	if len(snap.PanickedGPUs) != 1 || snap.PanickedGPUs[0] != 0 {
+		t.Errorf("expected GPU 0 to be reported as panicked, got %v", snap.PanickedGPUs)
+	}
+}
+
+func TestCollectCallTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	hung := fakeDevice("GPU-hung", nil, nil)
+	hung.GetNameFunc = func() (string, nvml.Return) {
+		calls.Add(1)
+		<-release // fell off the bus
+		return "NVIDIA A100-SXM4-40GB", nvml.SUCCESS
+	}
+	good := fakeDevice("GPU-good", []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 20}}, nil)
+
+	c := New(WithCallTimeout(20 * time.Millisecond))
+	c.lib = &fakeNVML{devices: []nvml.Device{hung, good}}
+	for poll := 0; poll < 2; poll++ {
+		snap, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("Collect should not fail on a hung GPU: %v", err)
+		}
+		if len(snap.Devices) != 1 || snap.Devices[0].UUID != "GPU-good" || len(snap.Processes) != 1 {
+			t.Fatalf("poll %d: expected only the healthy GPU, got %+v", poll, snap.Devices)
+		}
+		if len(snap.TimedOutGPUs) != 1 || snap.TimedOutGPUs[0] != 0 {
+			t.Errorf("poll %d: expected GPU 0 to be reported as timed out, got %v", poll, snap.TimedOutGPUs)
+		}
+	}
+	// The blocked calls aren't repeated each poll
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the hung GPU to be queried once, got %d", n)
+	}
+
+	// Once the calls return, the GPU is collected again
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		snap, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		if len(snap.TimedOutGPUs) == 0 {
+			if len(snap.Devices) != 2 {
+				t.Errorf("expected both GPUs after recovery, got %+v", snap.Devices)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("GPU 0 still skipped after its calls returned")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// hungHandleNVML blocks the handle lookup of GPU 0 until release is closed.
+type hungHandleNVML struct {
+	*fakeNVML
+	release chan struct{}
+}
+
+func (f *hungHandleNVML) DeviceGetHandleByIndex(index int) (nvml.Device, nvml.Return) {
+	if index == 0 {
+		<-f.release
+	}
+	return f.fakeNVML.DeviceGetHandleByIndex(index)
+}
+
+func TestCollectCallTimeoutBeforeCollection(t *testing.T) {
+	good := fakeDevice("GPU-good", nil, nil)
+
+	// The handle lookup itself blocks
+	release := make(chan struct{})
+	defer close(release)
+	c := New(WithCallTimeout(20 * time.Millisecond))
+	c.lib = &hungHandleNVML{fakeNVML: &fakeNVML{devices: []nvml.Device{fakeDevice("GPU-hung", nil, nil), good}}, release: release}
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect should not fail on a hung handle lookup: %v", err)
+	}
+	if len(snap.Devices) != 1 || len(snap.TimedOutGPUs) != 1 || snap.TimedOutGPUs[0] != 0 {
+		t.Errorf("expected GPU 0 timed out and GPU 1 collected, got %+v, timed out %v", snap.Devices, snap.TimedOutGPUs)
+	}
+
+	// The UUID read of a filter naming UUIDs blocks
+	hung := fakeDevice("GPU-hung", nil, nil)
+	hung.GetUUIDFunc = func() (string, nvml.Return) {
+		<-release
+		return "GPU-hung", nvml.SUCCESS
+	}
+	exclude, err := ParseGPUSet("GPU-other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = New(WithCallTimeout(20*time.Millisecond), WithGPUFilter(GPUSet{}, exclude))
+	c.lib = &fakeNVML{devices: []nvml.Device{hung, good}}
+	snap, err = c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect should not fail on a hung filter check: %v", err)
+	}
+	if len(snap.Devices) != 1 || len(snap.TimedOutGPUs) != 1 || snap.TimedOutGPUs[0] != 0 {
+		t.Errorf("expected GPU 0 timed out and GPU 1 collected, got %+v, timed out %v", snap.Devices, snap.TimedOutGPUs)
+	}
+}
+
+func TestCollectDeviceChanges(t *testing.T) {
+	var buf bytes.Buffer
+	a, b := fakeDevice("GPU-a", nil, nil), fakeDevice("GPU-b", nil, nil)
+	lib := &fakeNVML{devices: []nvml.Device{a, b}}
+	c := New(WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	c.lib = lib
+	collect := func() string {
+		t.Helper()
+		buf.Reset()
+		snap, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		if snap.DeviceCount != len(lib.devices) {
+			t.Errorf("expected a device count of %d, got %d", len(lib.devices), snap.DeviceCount)
+		}
+		return buf.String()
+	}
+
+	if out := collect(); strings.Contains(out, "devices_changed") {
+		t.Errorf("first collection reported a change: %s", out)
+	}
+	// A GPU skipped for one poll isn't gone
+	broken := fakeDevice("GPU-b", nil, nil)
+	broken.GetMemoryInfoFunc = func() (nvml.Memory, nvml.Return) { panic("malformed driver response") }
+	lib.devices = []nvml.Device{a, broken}
+	if out := collect(); strings.Contains(out, "devices_changed") {
+		t.Errorf("skipped GPU reported as a change: %s", out)
+	}
+	// Renumbered after a reset
+	lib.devices = []nvml.Device{b, a}
+	if out := collect(); !strings.Contains(out, "event=devices_changed gpus=2 added=[] removed=[] moved=\"[GPU-a GPU-b]\"") {
+		t.Errorf("expected both GPUs reported moved, got: %s", out)
+	}
+	lib.devices = []nvml.Device{b}
+	if out := collect(); !strings.Contains(out, "gpus=1 added=[] removed=[GPU-a] moved=[]") {
+		t.Errorf("expected GPU-a reported removed, got: %s", out)
+	}
+	if out := collect(); strings.Contains(out, "devices_changed") {
+		t.Errorf("unchanged GPUs reported as a change: %s", out)
+	}
+}
+
+func TestCollectMigInstances(t *testing.T) {
+	migDevice := func(giID int, total uint64) *mock.Device {
+		return &mock.Device{
+			GetGpuInstanceIdFunc: func() (int, nvml.Return) { return giID, nvml.SUCCESS },
+			GetUUIDFunc:          func() (string, nvml.Return) { return "MIG-" + string(rune('a'+giID)), nvml.SUCCESS },
+			GetMemoryInfoFunc: func() (nvml.Memory, nvml.Return) {
+				return nvml.Memory{Total: total}, nvml.SUCCESS
+			},
+			GetUtilizationRatesFunc: func() (nvml.Utilization, nvml.Return) {
+				return nvml.Utilization{}, nvml.ERROR_NOT_SUPPORTED
+			},
+			GetComputeRunningProcessesFunc: func() ([]nvml.ProcessInfo, nvml.Return) {
+				return nil, nvml.SUCCESS
+			},
+		}
+	}
+	migs := map[int]nvml.Device{0: migDevice(1, 10<<30), 2: migDevice(2, 20<<30)}
+
+	dev := fakeDevice("GPU-0", []nvml.ProcessInfo{
+		{Pid: 1 << 30, UsedGpuMemory: 1 << 30, GpuInstanceId: 2},
+	}, nil)
+	dev.GetMigModeFunc = func() (int, int, nvml.Return) {
+		return nvml.DEVICE_MIG_ENABLE, nvml.DEVICE_MIG_ENABLE, nvml.SUCCESS
+	}
+	dev.GetMaxMigDeviceCountFunc = func() (int, nvml.Return) { return 7, nvml.SUCCESS }
+	dev.GetMigDeviceHandleByIndexFunc = func(i int) (nvml.Device, nvml.Return) {
+		if m, ok := migs[i]; ok {
+			return m, nvml.SUCCESS
+		}
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+
+	snap, err := newTestCollector(dev).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	d := snap.Devices[0]
+	if !d.MigEnabled || len(d.MigInstances) != 2 {
+		t.Fatalf("expected 2 MIG instances, got %+v", d.MigInstances)
+	}
+	if d.MigInstances[0].ID != "1" || d.MigInstances[0].MemoryTotal != 10<<30 {
+		t.Errorf("unexpected first instance: %+v", d.MigInstances[0])
+	}
+	if snap.Processes[0].MigInstance != "2" {
+		t.Errorf("expected process tagged with MIG instance 2, got %q", snap.Processes[0].MigInstance)
+	}
+}
+
+func TestCollectMigProcessesUnprivileged(t *testing.T) {
+	// Without admin privileges the parent GPU lists no processes and
+	// per-process utilization is unavailable; each MIG device lists its own
+	// processes, and this driver reports instance utilization.
+	migDevice := func(giID int, pid uint32, util uint32) *mock.Device {
+		return &mock.Device{
+			GetGpuInstanceIdFunc: func() (int, nvml.Return) { return giID, nvml.SUCCESS },
+			GetUUIDFunc:          func() (string, nvml.Return) { return "MIG-" + string(rune('a'+giID)), nvml.SUCCESS },
+			GetMemoryInfoFunc: func() (nvml.Memory, nvml.Return) {
+				return nvml.Memory{Total: 10 << 30, Used: 2 << 30}, nvml.SUCCESS
+			},
+			GetUtilizationRatesFunc: func() (nvml.Utilization, nvml.Return) {
+				return nvml.Utilization{Gpu: util}, nvml.SUCCESS
+			},
+			GetComputeRunningProcessesFunc: func() ([]nvml.ProcessInfo, nvml.Return) {
+				return []nvml.ProcessInfo{{Pid: pid, UsedGpuMemory: 2 << 30}}, nvml.SUCCESS
+			},
+		}
+	}
+	migs := []nvml.Device{migDevice(1, 1<<30, 0), migDevice(2, 1<<30+1, 65)}
+
+	dev := fakeDevice("GPU-0", nil, nil)
+	dev.GetMigModeFunc = func() (int, int, nvml.Return) {
+		return nvml.DEVICE_MIG_ENABLE, nvml.DEVICE_MIG_ENABLE, nvml.SUCCESS
+	}
+	dev.GetProcessUtilizationFunc = func(uint64) ([]nvml.ProcessUtilizationSample, nvml.Return) {
+		return nil, nvml.ERROR_NOT_SUPPORTED
+	}
+	dev.GetMaxMigDeviceCountFunc = func() (int, nvml.Return) { return len(migs), nvml.SUCCESS }
+	dev.GetMigDeviceHandleByIndexFunc = func(i int) (nvml.Device, nvml.Return) { return migs[i], nvml.SUCCESS }
+
+	snap, err := newTestCollector(dev).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; !d.MigInstances[1].HasUtilization || d.MigInstances[1].Utilization != 65 {
+		t.Errorf("expected instance 2 at 65%%, got %+v", d.MigInstances[1])
+	}
+	if len(snap.Processes) != 2 {
+		t.Fatalf("expected the processes of both MIG instances, got %+v", snap.Processes)
+	}
+	for _, p := range snap.Processes {
+		want := map[string]uint32{"1": 0, "2": 65}[p.MigInstance]
+		if p.SmUtil != want || p.EngineUtil.SM != want {
+			t.Errorf("PID %d in instance %q: expected the instance's %d%% utilization, got %d", p.PID, p.MigInstance, want, p.SmUtil)
+		}
+		if !p.UtilUnavailable {
+			t.Errorf("PID %d: expected per-process utilization to be marked unavailable", p.PID)
+		}
+	}
+}
+
+func TestCollectUtilOnlyProcesses(t *testing.T) {
+	procs := []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 30}}
+	util := []nvml.ProcessUtilizationSample{
+		{Pid: 1 << 30, SmUtil: 10, TimeStamp: 1},
+		{Pid: 1<<30 + 1, SmUtil: 35, TimeStamp: 1}, // kernel launch without an allocation
+	}
+
+	snap, err := newTestCollector(fakeDevice("GPU-0", procs, util)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(snap.Processes) != 1 {
+		t.Fatalf("util-only PID should be dropped by default, got %+v", snap.Processes)
+	}
+
+	c := New(WithUtilOnlyProcesses(true))
+	c.lib = &fakeNVML{devices: []nvml.Device{fakeDevice("GPU-0", procs, util)}}
+	snap, err = c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(snap.Processes) != 2 {
+		t.Fatalf("expected 2 processes with util-only enabled, got %+v", snap.Processes)
+	}
+	p := snap.Processes[1]
+	if p.PID != 1<<30+1 || !p.Memoryless || p.UsedMemory != 0 || p.SmUtil != 35 {
+		t.Errorf("unexpected util-only sample: %+v", p)
+	}
+	if snap.Processes[0].Memoryless {
+		t.Errorf("process holding memory flagged memoryless: %+v", snap.Processes[0])
+	}
+
+	// Busy on the decoder only, without an allocation
+	util = append(util, nvml.ProcessUtilizationSample{Pid: 1<<30 + 2, DecUtil: 60, TimeStamp: 1})
+	c = New(WithUtilOnlyProcesses(true))
+	c.lib = &fakeNVML{devices: []nvml.Device{fakeDevice("GPU-0", procs, util)}}
+	snap, err = c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(snap.Processes) != 3 {
+		t.Errorf("expected the decoder-only PID to be kept, got %+v", snap.Processes)
+	}
+}
+
+func TestCollectStreamCounter(t *testing.T) {
+	procs := []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 30}, {Pid: 1<<30 + 1, UsedGpuMemory: 1 << 30}}
+
+	snap, err := newTestCollector(fakeDevice("GPU-0", procs, nil)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, p := range snap.Processes {
+		if p.HasActiveStreams {
+			t.Errorf("stream count reported without a counter: %+v", p)
+		}
+	}
+
+	c := New(WithStreamCounter(func(gpu int, pid uint32) (int, bool) {
+		return 4, pid == 1<<30
+	}))
+	c.lib = &fakeNVML{devices: []nvml.Device{fakeDevice("GPU-0", procs, nil)}}
+	snap, err = c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if p := snap.Processes[0]; !p.HasActiveStreams || p.ActiveStreams != 4 {
+		t.Errorf("expected 4 active streams, got %+v", p)
+	}
+	if p := snap.Processes[1]; p.HasActiveStreams {
+		t.Errorf("expected unknown stream count, got %+v", p)
+	}
+}
+
+func TestCollectEngineUtilization(t *testing.T) {
+	procs := []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 30}}
+	util := []nvml.ProcessUtilizationSample{
+		{Pid: 1 << 30, SmUtil: 0, MemUtil: 30, EncUtil: 0, DecUtil: 5, TimeStamp: 1},
+		{Pid: 1 << 30, SmUtil: 2, MemUtil: 45, EncUtil: 0, DecUtil: 0, TimeStamp: 2},
+	}
+	snap, err := newTestCollector(fakeDevice("GPU-0", procs, util)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	want := EngineUtil{SM: 2, Memory: 45, Decoder: 5}
+	if got := snap.Processes[0].EngineUtil; got != want {
+		t.Errorf("expected per-engine maxima %+v, got %+v", want, got)
+	}
+}
+
+func TestCollectUtilSampleInfo(t *testing.T) {
+	procs := []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 30}, {Pid: 1<<30 + 1, UsedGpuMemory: 1 << 30}}
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	util := []nvml.ProcessUtilizationSample{
+		{Pid: 1 << 30, SmUtil: 10, TimeStamp: uint64(ts.UnixMicro()) - 5},
+		{Pid: 1 << 30, SmUtil: 20, TimeStamp: uint64(ts.UnixMicro())},
+	}
+	snap, err := newTestCollector(fakeDevice("GPU-0", procs, util)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if p := snap.Processes[0]; !p.UtilSampled || !p.UtilSampleTime.Equal(ts) || p.UtilUnavailable {
+		t.Errorf("expected a sample at %v, got %+v", ts, p)
+	}
+	if p := snap.Processes[1]; p.UtilSampled || p.UtilUnavailable {
+		t.Errorf("expected no sample for the second process, got %+v", p)
+	}
+
+	// The per-process utilization query is unsupported
+	dev := fakeDevice("GPU-0", procs, nil)
+	dev.GetProcessUtilizationFunc = func(uint64) ([]nvml.ProcessUtilizationSample, nvml.Return) {
+		return nil, nvml.ERROR_NOT_SUPPORTED
+	}
+	snap, err = newTestCollector(dev).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, p := range snap.Processes {
+		if !p.UtilUnavailable {
+			t.Errorf("PID %d: expected utilization flagged unavailable", p.PID)
+		}
+	}
+}
+
+func TestCollectProcessesErrors(t *testing.T) {
+	procs := []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 30}}
+
+	// A failed process query drops that GPU's processes, not the GPU
+	broken := fakeDevice("GPU-0", procs, nil)
+	broken.GetComputeRunningProcessesFunc = func() ([]nvml.ProcessInfo, nvml.Return) {
+		return nil, nvml.ERROR_UNKNOWN
+	}
+	snap, err := newTestCollector(broken, fakeDevice("GPU-1", procs, nil)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(snap.Devices) != 2 {
+		t.Fatalf("expected both GPUs reported, got %+v", snap.Devices)
+	}
+	if len(snap.Processes) != 1 || snap.Processes[0].GPU != 1 {
+		t.Errorf("expected only GPU 1's process, got %+v", snap.Processes)
+	}
+
+	// No samples since the last poll: the process is idle, and its
+	// utilization isn't unavailable
+	var since []uint64
+	dev := fakeDevice("GPU-0", procs, nil)
+	dev.GetProcessUtilizationFunc = func(lastTS uint64) ([]nvml.ProcessUtilizationSample, nvml.Return) {
+		since = append(since, lastTS)
+		if len(since) == 1 {
+			return []nvml.ProcessUtilizationSample{{Pid: 1 << 30, SmUtil: 60, TimeStamp: 200}, {Pid: 1 << 30, SmUtil: 40, TimeStamp: 100}}, nvml.SUCCESS
+		}
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+	c := newTestCollector(dev)
+	if _, err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	snap, err = c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if p := snap.Processes[0]; p.SmUtil != 0 || p.UtilSampled || p.UtilUnavailable {
+		t.Errorf("expected an idle, available sample, got %+v", p)
+	}
+	// The second poll asks for samples after the newest one seen
+	if len(since) != 2 || since[0] != 0 || since[1] != 200 {
+		t.Errorf("expected polls since 0 then 200, got %v", since)
+	}
+}
+
+func TestCollectSerial(t *testing.T) {
+	snap, err := newTestCollector(fakeDevice("GPU-0", nil, nil)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if got := snap.Devices[0].Serial; got != "1320221234567" {
+		t.Errorf("expected serial 1320221234567, got %q", got)
+	}
+
+	// Consumer cards don't support the query
+	dev := fakeDevice("GPU-0", nil, nil)
+	dev.GetSerialFunc = func() (string, nvml.Return) { return "", nvml.ERROR_NOT_SUPPORTED }
+	snap, err = newTestCollector(dev).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if got := snap.Devices[0].Serial; got != "" {
+		t.Errorf("expected no serial, got %q", got)
+	}
+}
+
+func TestCollectFirmware(t *testing.T) {
+	dev := fakeDevice("GPU-0", nil, nil)
+	snap, err := newTestCollector(dev).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; d.VbiosVersion != "92.00.45.00.03" || d.BoardPartNumber != "900-21001-0000-000" {
+		t.Errorf("expected VBIOS 92.00.45.00.03 and part number 900-21001-0000-000, got %q and %q", d.VbiosVersion, d.BoardPartNumber)
+	}
+
+	// A failed query leaves its field empty without affecting the other
+	dev.GetBoardPartNumberFunc = func() (string, nvml.Return) { return "", nvml.ERROR_NOT_SUPPORTED }
+	snap, err = newTestCollector(dev).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; d.VbiosVersion != "92.00.45.00.03" || d.BoardPartNumber != "" {
+		t.Errorf("expected only the VBIOS version, got %q and %q", d.VbiosVersion, d.BoardPartNumber)
+	}
+}
+
+func TestCollectClocks(t *testing.T) {
+	dev := fakeDevice("GPU-0", nil, nil)
+	snap, err := newTestCollector(dev).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; d.SmClockMHz != 1410 || d.MemClockMHz != 1215 || d.GraphicsClockMHz != 1400 {
+		t.Errorf("unexpected clocks: SM=%d mem=%d graphics=%d", d.SmClockMHz, d.MemClockMHz, d.GraphicsClockMHz)
+	}
+
+	dev.GetClockInfoFunc = func(nvml.ClockType) (uint32, nvml.Return) { return 0, nvml.ERROR_NOT_SUPPORTED }
+	snap, err = newTestCollector(dev).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; d.SmClockMHz != 0 || d.MemClockMHz != 0 || d.GraphicsClockMHz != 0 {
+		t.Errorf("expected zero clocks when unsupported, got SM=%d mem=%d graphics=%d", d.SmClockMHz, d.MemClockMHz, d.GraphicsClockMHz)
+	}
+}
+
+func TestCollectFanSpeed(t *testing.T) {
+	aircooled := fakeDevice("GPU-0", nil, nil)
+	passive := fakeDevice("GPU-1", nil, nil)
+	passive.GetFanSpeedFunc = func() (uint32, nvml.Return) { return 0, nvml.ERROR_NOT_SUPPORTED }
+
+	snap, err := newTestCollector(aircooled, passive).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; !d.HasFanSpeed || d.FanSpeedPercent != 38 {
+		t.Errorf("expected fan speed 38%%, got %d%% (supported=%v)", d.FanSpeedPercent, d.HasFanSpeed)
+	}
+	if d := snap.Devices[1]; d.HasFanSpeed {
+		t.Errorf("expected no fan speed on a passively cooled GPU, got %d%%", d.FanSpeedPercent)
+	}
+}
+
+func TestCollectMigModePending(t *testing.T) {
+	dev := fakeDevice("GPU-0", nil, nil)
+	dev.GetMigModeFunc = func() (int, int, nvml.Return) {
+		return nvml.DEVICE_MIG_DISABLE, nvml.DEVICE_MIG_ENABLE, nvml.SUCCESS
+	}
+	noMig := fakeDevice("GPU-1", nil, nil)
+
+	snap, err := newTestCollector(dev, noMig).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; !d.MigCapable || d.MigEnabled || !d.MigPending {
+		t.Errorf("expected MIG disabled with enable pending, got capable=%v enabled=%v pending=%v", d.MigCapable, d.MigEnabled, d.MigPending)
+	}
+	if d := snap.Devices[1]; d.MigCapable {
+		t.Error("expected a GPU without MIG support not to be MIG-capable")
+	}
+}
+
+func TestCollectPcieThroughput(t *testing.T) {
+	dev := fakeDevice("GPU-0", nil, nil)
+	oldDriver := fakeDevice("GPU-1", nil, nil)
+	oldDriver.GetPcieThroughputFunc = func(nvml.PcieUtilCounter) (uint32, nvml.Return) { return 0, nvml.ERROR_NOT_SUPPORTED }
+
+	snap, err := newTestCollector(dev, oldDriver).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; !d.HasPcieThroughput || d.PcieTxKBps != 12000 || d.PcieRxKBps != 3400 {
+		t.Errorf("unexpected PCIe throughput: tx=%d rx=%d (supported=%v)", d.PcieTxKBps, d.PcieRxKBps, d.HasPcieThroughput)
+	}
+	if snap.Devices[1].HasPcieThroughput {
+		t.Error("expected no PCIe throughput when unsupported")
+	}
+}
+
+func TestCollectCodecUtilization(t *testing.T) {
+	dev := fakeDevice("GPU-0", nil, nil)
+	// Utilization first, then the sampling period in microseconds
+	dev.GetEncoderUtilizationFunc = func() (uint32, uint32, nvml.Return) { return 65, 167000, nvml.SUCCESS }
+	dev.GetDecoderUtilizationFunc = func() (uint32, uint32, nvml.Return) { return 12, 167000, nvml.SUCCESS }
+	noCodec := fakeDevice("GPU-1", nil, nil)
+	noCodec.GetEncoderUtilizationFunc = func() (uint32, uint32, nvml.Return) { return 0, 0, nvml.ERROR_NOT_SUPPORTED }
+
+	snap, err := newTestCollector(dev, noCodec).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; !d.HasCodecUtil || d.EncoderUtil != 65 || d.DecoderUtil != 12 {
+		t.Errorf("unexpected codec utilization: encoder=%d decoder=%d (supported=%v)", d.EncoderUtil, d.DecoderUtil, d.HasCodecUtil)
+	}
+	if snap.Devices[1].HasCodecUtil {
+		t.Error("expected no codec utilization when unsupported")
+	}
+}
+
+func TestCollectPowerLimits(t *testing.T) {
+	dev := fakeDevice("GPU-0", nil, nil)
+	unsupported := fakeDevice("GPU-1", nil, nil)
+	unsupported.GetPowerManagementLimitFunc = func() (uint32, nvml.Return) { return 0, nvml.ERROR_NOT_SUPPORTED }
+	unsupported.GetEnforcedPowerLimitFunc = func() (uint32, nvml.Return) { return 0, nvml.ERROR_NOT_SUPPORTED }
+
+	snap, err := newTestCollector(dev, unsupported).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; d.PowerLimitWatts != 400 || d.EnforcedPowerLimitWatts != 300 {
+		t.Errorf("expected limits 400W/300W, got %vW/%vW", d.PowerLimitWatts, d.EnforcedPowerLimitWatts)
+	}
+	if d := snap.Devices[1]; d.PowerLimitWatts != 0 || d.EnforcedPowerLimitWatts != 0 {
+		t.Errorf("expected no limits when unsupported, got %vW/%vW", d.PowerLimitWatts, d.EnforcedPowerLimitWatts)
+	}
+}
+
+func TestCollectEccMode(t *testing.T) {
+	enabled := fakeDevice("GPU-0", nil, nil)
+	disabled := fakeDevice("GPU-1", nil, nil)
+	disabled.GetEccModeFunc = func() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+		return nvml.FEATURE_DISABLED, nvml.FEATURE_ENABLED, nvml.SUCCESS
+	}
+	consumer := fakeDevice("GPU-2", nil, nil)
+	consumer.GetEccModeFunc = func() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+		return 0, 0, nvml.ERROR_NOT_SUPPORTED
+	}
+
+	snap, err := newTestCollector(enabled, disabled, consumer).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; !d.HasEccMode || !d.EccEnabled || !d.EccPending {
+		t.Errorf("GPU 0: expected ECC enabled, got %+v", d)
+	}
+	if d := snap.Devices[1]; !d.HasEccMode || d.EccEnabled || !d.EccPending {
+		t.Errorf("GPU 1: expected ECC disabled until the next reboot, got enabled=%v pending=%v", d.EccEnabled, d.EccPending)
+	}
+	if snap.Devices[2].HasEccMode {
+		t.Error("GPU 2: expected no ECC mode when unsupported")
+	}
+}
+
+func TestCollectMemoryUtilization(t *testing.T) {
+	snap, err := newTestCollector(fakeDevice("GPU-0", nil, nil)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if d := snap.Devices[0]; d.Utilization != 42 || d.MemoryUtilization != 10 {
+		t.Errorf("expected SM 42%% and memory 10%%, got %d%% and %d%%", d.Utilization, d.MemoryUtilization)
+	}
+}
+
+func TestThrottleReasons(t *testing.T) {
+	tests := []struct {
+		mask uint64
+		want []string
+	}{
+		{0, nil},
+		{nvml.ClocksThrottleReasonGpuIdle, []string{"idle"}},
+		{nvml.ClocksThrottleReasonSwPowerCap | nvml.ClocksThrottleReasonHwSlowdown, []string{"sw_power_cap", "hw_slowdown"}},
+		{nvml.ClocksThrottleReasonHwSlowdown | nvml.ClocksThrottleReasonHwThermalSlowdown | nvml.ClocksThrottleReasonSwThermalSlowdown,
+			[]string{"hw_slowdown", "sw_thermal", "hw_thermal"}},
+	}
+	for _, tc := range tests {
+		d := DeviceInfo{ThrottleReasons: tc.mask, HasThrottleReasons: true}
+		var got []string
+		for _, r := range ThrottleReasons {
+			if d.Throttled(r) {
+				got = append(got, r.Name)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("mask %#x: expected %v, got %v", tc.mask, tc.want, got)
+		}
+	}
+}
+
+func TestCollectDataMovement(t *testing.T) {
+	procs := []nvml.ProcessInfo{
+		{Pid: 1 << 30, UsedGpuMemory: 1 << 30},
+		{Pid: 1<<30 + 1, UsedGpuMemory: 1 << 30},
+	}
+	c := New(WithDataMovementCounter(func(gpu int, pid uint32) (uint64, bool) {
+		return 5 << 30, pid == 1<<30
+	}))
+	c.lib = &fakeNVML{devices: []nvml.Device{fakeDevice("GPU-0", procs, nil)}}
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if p := snap.Processes[0]; !p.HasDataMoved || p.DataMovedBytes != 5<<30 {
+		t.Errorf("expected 5 GiB moved, got %+v", p)
+	}
+	if p := snap.Processes[1]; p.HasDataMoved {
+		t.Errorf("expected unknown data movement, got %+v", p)
+	}
+}
+
+func TestProbeSampleWindows(t *testing.T) {
+	// Device samples span 6s; the process sample is 2s older still
+	withSamples := fakeDevice("GPU-0", nil, []nvml.ProcessUtilizationSample{{Pid: 100, TimeStamp: 1_000_000}})
+	withSamples.GetSamplesFunc = func(nvml.SamplingType, uint64) (nvml.ValueType, []nvml.Sample, nvml.Return) {
+		return nvml.VALUE_TYPE_UNSIGNED_INT, []nvml.Sample{{TimeStamp: 3_000_000}, {TimeStamp: 6_000_000}, {TimeStamp: 9_000_000}}, nvml.SUCCESS
+	}
+	// A single process sample and no device samples: unknown
+	single := fakeDevice("GPU-1", nil, []nvml.ProcessUtilizationSample{{Pid: 200, TimeStamp: 5_000_000}})
+
+	c := newTestCollector(withSamples, single)
+	got := c.ProbeSampleWindows()
+	if want := map[int]time.Duration{0: 8 * time.Second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected windows %v, got %v", want, got)
+	}
+	if len(c.lastSampleTime) != 0 || len(c.lastUtilSampleTime) != 0 {
+		t.Error("probing must not advance the collection timestamps")
+	}
+}
+
+func TestSampleWindowMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		window, poll    time.Duration
+		mismatched      bool
+		wantRecommended time.Duration
+	}{
+		{window: 5 * time.Second, poll: 5 * time.Second, mismatched: false},
+		{window: 5 * time.Second, poll: 6 * time.Second, mismatched: false},
+		{window: 5 * time.Second, poll: 15 * time.Second, mismatched: true, wantRecommended: 5 * time.Second},
+		{window: 20 * time.Second, poll: 5 * time.Second, mismatched: true, wantRecommended: 20 * time.Second},
+		{window: 300 * time.Millisecond, poll: 5 * time.Second, mismatched: true, wantRecommended: time.Second},
+		{window: 0, poll: 5 * time.Second, mismatched: false},
+	} {
+		problem, recommended, mismatched := SampleWindowMismatch(tc.window, tc.poll)
+		if mismatched != tc.mismatched {
+			t.Errorf("window %v, poll %v: expected mismatched=%v, got %v (%s)", tc.window, tc.poll, tc.mismatched, mismatched, problem)
+			continue
+		}
+		if mismatched && recommended != tc.wantRecommended {
+			t.Errorf("window %v, poll %v: expected recommended %v, got %v", tc.window, tc.poll, tc.wantRecommended, recommended)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package collector
+
+import "github.com/NVIDIA/go-nvml/pkg/nvml"
+
+// Test doubles for the external tests, which can't reach the unexported ones.
+var FakeDevice = fakeDevice
+
+// NewTestCollector returns a Collector reading procRoot, with a fake NVML
+// reporting devices.
+func NewTestCollector(procRoot string, devices []nvml.Device, opts ...Option) *Collector {
+	c := New(opts...)
+	c.lib = &fakeNVML{devices: devices}
+	c.procRoot = procRoot
+	return c
+}
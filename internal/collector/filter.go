@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+
+	"github.com/affinode/gpu-idle-exporter/internal/listconfig"
+)
+
+// GPUSet is a set of GPUs given by index or UUID.
+type GPUSet struct {
+	indices map[int]bool
+	uuids   map[string]bool
+}
+
+// ParseGPUSet parses a comma-separated list of GPU indices and UUIDs, e.g.
+// "0,1,GPU-5a9d3c1e-...". UUIDs are matched case-insensitively. Invalid
+// entries are left out of the set and reported in a *listconfig.Error.
+func ParseGPUSet(s string) (GPUSet, error) {
+	set := GPUSet{indices: make(map[int]bool), uuids: make(map[string]bool)}
+	entries, err := listconfig.Parse(s, listconfig.MaxEntries, validateGPU)
+	for _, v := range entries {
+		if isGPUUUID(v) {
+			set.uuids[strings.ToLower(v)] = true
+		} else {
+			index, _ := strconv.Atoi(v)
+			set.indices[index] = true
+		}
+	}
+	return set, err
+}
+
+// validateGPU checks that entry is a GPU index or UUID.
+func validateGPU(entry string) error {
+	if isGPUUUID(entry) {
+		return nil
+	}
+	if index, err := strconv.Atoi(entry); err != nil || index < 0 {
+		return errors.New("want a GPU index or a GPU- UUID")
+	}
+	return nil
+}
+
+// isGPUUUID reports whether entry looks like a GPU UUID.
+func isGPUUUID(entry string) bool {
+	return len(entry) > len("GPU-") && strings.EqualFold(entry[:len("GPU-")], "GPU-")
+}
+
+// Empty reports whether the set names no GPUs.
+func (s GPUSet) Empty() bool {
+	return len(s.indices) == 0 && len(s.uuids) == 0
+}
+
+// Contains reports whether the GPU at index, with the given UUID, is in the set.
+func (s GPUSet) Contains(index int, uuid string) bool {
+	return s.indices[index] || (uuid != "" && s.uuids[strings.ToLower(uuid)])
+}
+
+// WithGPUFilter restricts collection to the included GPUs, or all if
+// include is empty, minus the excluded ones. Exclusion wins over
+// inclusion. Skipped GPUs are neither collected nor scanned for processes
+// and keep their NVML index, so the others' gpu labels don't shift.
+func WithGPUFilter(include, exclude GPUSet) Option {
+	return func(c *Collector) {
+		c.gpuInclude = include
+		c.gpuExclude = exclude
+	}
+}
+
+// skipGPU reports whether the GPU at index is filtered out. Its UUID is
+// only read if the filter names UUIDs.
+func (c *Collector) skipGPU(index int, device nvml.Device) bool {
+	if c.gpuInclude.Empty() && c.gpuExclude.Empty() {
+		return false
+	}
+	var uuid string
+	if len(c.gpuInclude.uuids) > 0 || len(c.gpuExclude.uuids) > 0 {
+		uuid, _ = device.GetUUID()
+	}
+	if c.gpuExclude.Contains(index, uuid) {
+		return true
+	}
+	return !c.gpuInclude.Empty() && !c.gpuInclude.Contains(index, uuid)
+}
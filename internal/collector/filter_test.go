@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+
+	"github.com/affinode/gpu-idle-exporter/internal/listconfig"
+)
+
+func TestParseGPUSet(t *testing.T) {
+	set, err := ParseGPUSet(" 0, 2 ,GPU-ABC,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.Contains(0, "") || !set.Contains(2, "") || set.Contains(1, "") {
+		t.Errorf("unexpected indices %v", set.indices)
+	}
+	if !set.Contains(5, "GPU-abc") {
+		t.Error("expected UUIDs to match case-insensitively")
+	}
+	if set, _ := ParseGPUSet(""); !set.Empty() {
+		t.Error("expected an empty set")
+	}
+	for _, bad := range []string{"-1", "gpu0", "MIG-abc", "GPU-"} {
+		if _, err := ParseGPUSet(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	// Valid entries survive invalid ones
+	set, err = ParseGPUSet("1,gpu0,-1")
+	if n := listconfig.Dropped(err); n != 2 || !set.Contains(1, "") {
+		t.Errorf("expected GPU 1 kept and 2 entries dropped, got %v dropped (%v)", n, err)
+	}
+}
+
+func TestCollectGPUFilter(t *testing.T) {
+	devices := []nvml.Device{
+		fakeDevice("GPU-a", []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 30}}, nil),
+		fakeDevice("GPU-b", []nvml.ProcessInfo{{Pid: 1<<30 + 1, UsedGpuMemory: 1 << 30}}, nil),
+		fakeDevice("GPU-c", []nvml.ProcessInfo{{Pid: 1<<30 + 2, UsedGpuMemory: 1 << 30}}, nil),
+	}
+	for _, tc := range []struct {
+		name             string
+		include, exclude string
+		want             []int
+	}{
+		{"no filter", "", "", []int{0, 1, 2}},
+		{"include by index", "0,2", "", []int{0, 2}},
+		{"include by UUID", "GPU-b", "", []int{1}},
+		{"exclude by index", "", "1", []int{0, 2}},
+		{"exclude by UUID", "", "GPU-a,GPU-c", []int{1}},
+		{"exclude wins", "0,GPU-b", "GPU-a", []int{1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			include, err := ParseGPUSet(tc.include)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exclude, err := ParseGPUSet(tc.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := newTestCollector(devices...)
+			WithGPUFilter(include, exclude)(c)
+			snap, err := c.Collect(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gpus, procGPUs []int
+			for _, d := range snap.Devices {
+				gpus = append(gpus, d.Index)
+			}
+			for _, p := range snap.Processes {
+				procGPUs = append(procGPUs, p.GPU)
+			}
+			if !reflect.DeepEqual(gpus, tc.want) || !reflect.DeepEqual(procGPUs, tc.want) {
+				t.Errorf("expected GPUs %v, got devices on %v and processes on %v", tc.want, gpus, procGPUs)
+			}
+		})
+	}
+}
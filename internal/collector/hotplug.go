@@ -0,0 +1,50 @@
+package collector
+
+import "sort"
+
+// trackDevices compares the GPUs of this collection, by UUID, with those of
+// the last one, and logs GPUs that appeared, disappeared or moved to
+// another index, as after a GPU reset or a driver reload. GPUs skipped
+// this cycle (missed) keep their last UUID, so a GPU that failed one poll
+// isn't reported gone.
+func (c *Collector) trackDevices(count int, devices []DeviceInfo, missed map[int]bool) {
+	current := make(map[int]string, count)
+	for _, d := range devices {
+		current[d.Index] = d.UUID
+	}
+	for index, uuid := range c.deviceUUIDs {
+		if missed[index] && index < count {
+			current[index] = uuid
+		}
+	}
+	prev := c.deviceUUIDs
+	c.deviceUUIDs = current
+	if prev == nil {
+		return // first collection
+	}
+
+	prevIndex := make(map[string]int, len(prev))
+	for index, uuid := range prev {
+		prevIndex[uuid] = index
+	}
+	var added, removed, moved []string
+	for index, uuid := range current {
+		if p, ok := prevIndex[uuid]; !ok {
+			added = append(added, uuid)
+		} else if p != index {
+			moved = append(moved, uuid)
+		}
+		delete(prevIndex, uuid)
+	}
+	for uuid := range prevIndex {
+		removed = append(removed, uuid)
+	}
+	if len(added) == 0 && len(removed) == 0 && len(moved) == 0 {
+		return
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(moved)
+	c.logger.Warn("collector: GPU set changed", "event", "devices_changed", "gpus", count,
+		"added", added, "removed", removed, "moved", moved, "previous_gpus", len(prev))
+}
@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// MigInstance holds metrics for one MIG GPU instance of a physical GPU.
+type MigInstance struct {
+	ID          string // GPU instance ID, matches ProcessSample.MigInstance
+	UUID        string
+	MemoryUsed  uint64 // bytes, as reported by NVML for the instance
+	MemoryTotal uint64 // bytes, the instance's memory capacity
+
+	// Utilization is the instance's compute utilization, percent 0-100,
+	// valid only if HasUtilization. Many drivers don't report it for MIG
+	// devices.
+	Utilization    uint32
+	HasUtilization bool
+
+	// procs are the compute processes listed by the MIG device itself,
+	// tagged with its GPU instance ID
+	procs []nvml.ProcessInfo
+}
+
+// migMode reports the current and pending MIG mode of device. A pending
+// mode different from the current one takes effect at the next GPU reset.
+// GPUs without MIG support report ERROR_NOT_SUPPORTED and return ok=false.
+func migMode(device nvml.Device) (current, pending, ok bool) {
+	cur, pend, ret := device.GetMigMode()
+	if ret != nvml.SUCCESS {
+		return false, false, false
+	}
+	return cur == nvml.DEVICE_MIG_ENABLE, pend == nvml.DEVICE_MIG_ENABLE, true
+}
+
+// collectMigInstances enumerates the MIG devices of a MIG-enabled GPU.
+// Unpopulated MIG slots return ERROR_NOT_FOUND and are skipped.
+func (c *Collector) collectMigInstances(gpuIndex int, device nvml.Device) []MigInstance {
+	max, ret := device.GetMaxMigDeviceCount()
+	if ret != nvml.SUCCESS {
+		c.logger.Warn("collector: GetMaxMigDeviceCount failed", "event", "collection_error", "gpu", gpuIndex, "err", nvml.ErrorString(ret))
+		return nil
+	}
+
+	var instances []MigInstance
+	for i := 0; i < max; i++ {
+		mig, ret := device.GetMigDeviceHandleByIndex(i)
+		if ret == nvml.ERROR_NOT_FOUND {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			c.logger.Warn("collector: GetMigDeviceHandleByIndex failed", "event", "collection_error", "gpu", gpuIndex, "mig_index", i, "err", nvml.ErrorString(ret))
+			continue
+		}
+
+		giID, ret := mig.GetGpuInstanceId()
+		if ret != nvml.SUCCESS {
+			c.logger.Warn("collector: GetGpuInstanceId failed", "event", "collection_error", "gpu", gpuIndex, "mig_index", i, "err", nvml.ErrorString(ret))
+			continue
+		}
+		inst := MigInstance{ID: strconv.Itoa(giID)}
+		if uuid, ret := mig.GetUUID(); ret == nvml.SUCCESS {
+			inst.UUID = uuid
+		}
+		if memInfo, ret := mig.GetMemoryInfo(); ret == nvml.SUCCESS {
+			inst.MemoryUsed = memInfo.Used
+			inst.MemoryTotal = memInfo.Total
+		}
+		if util, ret := mig.GetUtilizationRates(); ret == nvml.SUCCESS {
+			inst.Utilization = util.Gpu
+			inst.HasUtilization = true
+		}
+		if procs, ret := mig.GetComputeRunningProcesses(); ret == nvml.SUCCESS {
+			for _, p := range procs {
+				p.GpuInstanceId = uint32(giID)
+				inst.procs = append(inst.procs, p)
+			}
+		}
+		instances = append(instances, inst)
+	}
+	return instances
+}
+
+// migProcesses returns the compute processes listed by each MIG instance.
+// Without admin privileges the parent GPU lists no processes once MIG is
+// enabled, but each MIG device still lists its own.
+func migProcesses(instances []MigInstance) []nvml.ProcessInfo {
+	var procs []nvml.ProcessInfo
+	for _, inst := range instances {
+		procs = append(procs, inst.procs...)
+	}
+	return procs
+}
+
+// migUtilization returns the utilization of each MIG instance that reports
+// one, by instance ID.
+func migUtilization(instances []MigInstance) map[string]uint32 {
+	util := make(map[string]uint32)
+	for _, inst := range instances {
+		if inst.HasUtilization {
+			util[inst.ID] = inst.Utilization
+		}
+	}
+	return util
+}
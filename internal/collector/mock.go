@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// MockConfig configures a MockCollector.
+type MockConfig struct {
+	GPUs      int     // number of synthetic GPUs
+	Processes int     // number of concurrently running processes across all GPUs
+	ChurnRate float64 // fraction of processes that exit and are replaced by new PIDs each cycle (0-1)
+	Seed      int64   // random seed, for reproducible runs
+}
+
+// mockProcess is one synthetic GPU process.
+type mockProcess struct {
+	gpu  int
+	pid  uint32
+	mem  uint64
+	name string
+	uid  uint32
+	idle bool
+}
+
+// mockProcessNames are the comm values given to synthetic processes.
+var mockProcessNames = []string{"python", "python3", "torchrun", "tritonserver", "vllm", "jupyter"}
+
+// mockUsers is the number of distinct UIDs synthetic processes run as.
+const mockUsers = 20
+
+// mockGPUMemory is the memory capacity of every synthetic GPU.
+const mockGPUMemory = 80 << 30
+
+// MockCollector generates synthetic snapshots without touching NVML. It is
+// meant for load testing: processes get randomized memory and utilization,
+// and a configurable fraction churns every cycle, exercising stale cleanup
+// and cardinality handling at scale.
+type MockCollector struct {
+	cfg     MockConfig
+	rng     *rand.Rand
+	now     func() time.Time
+	nextPID uint32
+	procs   []mockProcess
+}
+
+// NewMock creates a MockCollector with cfg.Processes processes already running.
+func NewMock(cfg MockConfig) *MockCollector {
+	if cfg.GPUs < 1 {
+		cfg.GPUs = 1
+	}
+	if cfg.ChurnRate < 0 {
+		cfg.ChurnRate = 0
+	}
+	if cfg.ChurnRate > 1 {
+		cfg.ChurnRate = 1
+	}
+	m := &MockCollector{
+		cfg:     cfg,
+		rng:     rand.New(rand.NewSource(cfg.Seed)),
+		now:     time.Now,
+		nextPID: 10000,
+	}
+	for i := 0; i < cfg.Processes; i++ {
+		m.procs = append(m.procs, m.spawn())
+	}
+	return m
+}
+
+// spawn creates a new synthetic process with a fresh PID.
+func (m *MockCollector) spawn() mockProcess {
+	m.nextPID++
+	return mockProcess{
+		gpu:  m.rng.Intn(m.cfg.GPUs),
+		pid:  m.nextPID,
+		mem:  uint64(256+m.rng.Intn(16*1024)) << 20, // 256 MiB - 16 GiB
+		name: mockProcessNames[m.rng.Intn(len(mockProcessNames))],
+		uid:  uint32(1000 + m.rng.Intn(mockUsers)),
+		idle: m.rng.Float64() < 0.3,
+	}
+}
+
+// Collect advances the simulation by one cycle and returns its snapshot.
+func (m *MockCollector) Collect(ctx context.Context) (*Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("mock collect: %w", err)
+	}
+
+	// Churn: replace a fraction of processes with new ones
+	churn := int(float64(len(m.procs)) * m.cfg.ChurnRate)
+	for i := 0; i < churn; i++ {
+		m.procs[m.rng.Intn(len(m.procs))] = m.spawn()
+	}
+
+	snap := &Snapshot{
+		Timestamp:    m.now(),
+		ProcessNames: make(map[uint32]string, len(m.procs)),
+		GPUFds:       make(map[uint32]int, len(m.procs)),
+		ProcessUIDs:  make(map[uint32]uint32, len(m.procs)),
+	}
+	devices := make([]DeviceInfo, m.cfg.GPUs)
+	for i := range devices {
+		devices[i] = DeviceInfo{
+			Index:                   i,
+			UUID:                    fmt.Sprintf("GPU-mock-%04d", i),
+			BoardID:                 fmt.Sprintf("GPU-mock-%04d", i),
+			Name:                    "Mock GPU",
+			PCIBusID:                fmt.Sprintf("00000000:%02x:00.0", i+1),
+			Serial:                  fmt.Sprintf("%013d", i),
+			VbiosVersion:            "96.00.5E.00.01",
+			BoardPartNumber:         "900-21001-0000-000",
+			MemoryTotal:             mockGPUMemory,
+			PowerWatts:              60,
+			PowerLimitWatts:         400,
+			EnforcedPowerLimitWatts: 400,
+			PersistenceMode:         true,
+			HasPersistenceMode:      true,
+			EccEnabled:              true,
+			EccPending:              true,
+			HasEccMode:              true,
+			TempCelsius:             35,
+			SmClockMHz:              1410,
+			MemClockMHz:             1215,
+			GraphicsClockMHz:        1400,
+		}
+	}
+
+	for i := range m.procs {
+		p := &m.procs[i]
+		// Occasionally flip between idle and active
+		if m.rng.Float64() < 0.05 {
+			p.idle = !p.idle
+		}
+		var util uint32
+		if !p.idle {
+			util = uint32(1 + m.rng.Intn(100))
+		}
+
+		snap.Processes = append(snap.Processes, ProcessSample{
+			GPU:            p.gpu,
+			PID:            p.pid,
+			UsedMemory:     p.mem,
+			SmUtil:         util,
+			EngineUtil:     EngineUtil{SM: util},
+			UtilSampled:    util > 0,
+			UtilSampleTime: snap.Timestamp,
+		})
+		snap.ProcessNames[p.pid] = p.name
+		snap.GPUFds[p.pid] = 2
+		snap.ProcessUIDs[p.pid] = p.uid
+
+		d := &devices[p.gpu]
+		d.MemoryUsed += p.mem
+		if util > d.Utilization {
+			d.Utilization = util
+		}
+	}
+	for i := range devices {
+		d := &devices[i]
+		if d.MemoryUsed > d.MemoryTotal {
+			d.MemoryUsed = d.MemoryTotal
+		}
+		d.UtilizationFine = float64(d.Utilization)
+		d.MemoryUtilization = d.Utilization / 2
+		d.PowerWatts += 3 * float64(d.Utilization)
+	}
+	snap.Devices = devices
+	snap.DeviceCount = len(devices)
+	snap.Boards = groupBoards(devices)
+
+	return snap, nil
+}
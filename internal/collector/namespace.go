@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+)
+
+// DefaultPodLogDir is where the kubelet keeps a log directory per pod.
+const DefaultPodLogDir = "/var/log/pods"
+
+// WithPodNamespaces fills in ProcessSample.Namespace for processes in a
+// pod (see WithPodAttribution, which it needs). The kubelet names each
+// pod's log directory under dir <namespace>_<pod>_<uid>, so the namespace
+// is found by pod UID without access to the Kubernetes API; dir has to be
+// mounted from the host. Empty disables it.
+func WithPodNamespaces(dir string) Option {
+	return func(c *Collector) { c.podLogDir = dir }
+}
+
+// podNamespace returns the namespace of pod uid, or "" if it isn't known.
+// The pod log directory is listed again for a pod not seen before, at most
+// once per collection (listed), so a new pod is found on the poll after
+// its directory appears.
+func (c *Collector) podNamespace(ctx context.Context, uid string, listed *bool) (ns string, timedOut bool) {
+	if ns, ok := c.namespaces[uid]; ok || *listed {
+		return ns, false
+	}
+	*listed = true
+	entries, err := withProcTimeout(ctx, c.procReadTimeout, func() ([]os.DirEntry, error) { return os.ReadDir(c.podLogDir) })
+	if errors.Is(err, errProcReadTimeout) {
+		c.logger.Warn("collector: listing pod log directory timed out", "event", "proc_read_timeout", "dir", c.podLogDir, "timeout", c.procReadTimeout)
+		return "", true
+	}
+	if err != nil {
+		c.logger.Warn("collector: listing pod log directory failed", "event", "collection_error", "dir", c.podLogDir, "err", err)
+		return "", false
+	}
+	// Rebuilt from scratch, so deleted pods are dropped
+	namespaces := make(map[string]string, len(entries))
+	for _, e := range entries {
+		// Namespace and pod names can't contain underscores
+		parts := strings.Split(e.Name(), "_")
+		if e.IsDir() && len(parts) == 3 {
+			namespaces[parts[2]] = parts[0]
+		}
+	}
+	c.namespaces = namespaces
+	return namespaces[uid], false
+}
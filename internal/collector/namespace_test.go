@@ -0,0 +1,128 @@
+package collector_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+	"github.com/affinode/gpu-idle-exporter/internal/exporter"
+	"github.com/affinode/gpu-idle-exporter/internal/idle"
+)
+
+const (
+	researchPod  = "6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a"
+	inferencePod = "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+)
+
+// writeFile creates path and its parent directories.
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNamespacePolicies runs collections through the tracker: the
+// collector finds each process's namespace, which selects its idle policy.
+func TestNamespacePolicies(t *testing.T) {
+	procRoot, podLogDir := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(procRoot, "100", "cgroup"), "0::/kubepods/besteffort/pod"+researchPod+"/ctr\n")
+	writeFile(t, filepath.Join(procRoot, "200", "cgroup"), "0::/kubepods/burstable/pod"+inferencePod+"/ctr\n")
+	writeFile(t, filepath.Join(procRoot, "300", "cgroup"), "0::/user.slice/user-1000.slice/session-1.scope\n")
+	for _, dir := range []string{"research_notebook-0_" + researchPod, "inference_server-1_" + inferencePod, "malformed"} {
+		if err := os.MkdirAll(filepath.Join(podLogDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	procs := []nvml.ProcessInfo{{Pid: 100, UsedGpuMemory: 1 << 30}, {Pid: 200, UsedGpuMemory: 1 << 30}, {Pid: 300, UsedGpuMemory: 1 << 30}}
+	util := []nvml.ProcessUtilizationSample{{Pid: 100, SmUtil: 3}, {Pid: 200, SmUtil: 3}, {Pid: 300, SmUtil: 3}}
+	c := collector.NewTestCollector(procRoot, []nvml.Device{collector.FakeDevice("GPU-0", procs, util)},
+		collector.WithPodAttribution(true), collector.WithPodNamespaces(podLogDir))
+	policies, err := idle.ParseNamespacePolicies(`{"inference": {"smThreshold": 5}}`, idle.DefaultPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := idle.NewTracker(idle.WithNamespacePolicies(policies))
+
+	var states []idle.ProcessIdleState
+	for poll := 0; poll < 2; poll++ {
+		snap, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap.Timestamp = snap.Timestamp.Add(time.Duration(poll) * time.Minute)
+		states = tracker.Update(snap)
+	}
+
+	// At 3% SM only the inference namespace's raised threshold counts as idle
+	want := map[uint32]bool{100: false, 200: true, 300: false}
+	for _, ps := range states {
+		if ps.IsIdle != want[ps.PID] {
+			t.Errorf("PID %d: expected idle=%v, got %v", ps.PID, want[ps.PID], ps.IsIdle)
+		}
+	}
+}
+
+// TestNamespaceExempt follows an exempt namespace from the collector to the
+// exporter: its idle processes are reported idle, but not reclaimable.
+func TestNamespaceExempt(t *testing.T) {
+	procRoot, podLogDir := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(procRoot, "100", "cgroup"), "0::/kubepods/besteffort/pod"+researchPod+"/ctr\n")
+	writeFile(t, filepath.Join(procRoot, "200", "cgroup"), "0::/kubepods/burstable/pod"+inferencePod+"/ctr\n")
+	for _, dir := range []string{"research_notebook-0_" + researchPod, "inference_server-1_" + inferencePod} {
+		if err := os.MkdirAll(filepath.Join(podLogDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	procs := []nvml.ProcessInfo{{Pid: 100, UsedGpuMemory: 1 << 30}, {Pid: 200, UsedGpuMemory: 2 << 30}}
+	c := collector.NewTestCollector(procRoot, []nvml.Device{collector.FakeDevice("GPU-0", procs, nil)},
+		collector.WithPodAttribution(true), collector.WithPodNamespaces(podLogDir))
+	policies, err := idle.ParseNamespacePolicies(`{"research": {"exempt": true}}`, idle.DefaultPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := idle.NewTracker(idle.WithNamespacePolicies(policies))
+	e := exporter.New(nil, exporter.WithReclaimSafetyDuration(0))
+	e.Register()
+
+	for poll := 0; poll < 2; poll++ {
+		snap, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap.Timestamp = snap.Timestamp.Add(time.Duration(poll) * time.Minute)
+		states := tracker.Update(snap)
+		e.UpdateMetrics(snap, states, tracker.Stale())
+		if poll == 0 {
+			continue
+		}
+		for _, ps := range states {
+			if !ps.IsIdle || ps.Exempt != (ps.PID == 100) {
+				t.Errorf("PID %d: expected idle with exempt=%v, got idle=%v exempt=%v", ps.PID, ps.PID == 100, ps.IsIdle, ps.Exempt)
+			}
+		}
+	}
+
+	// Only the inference process's memory can be reclaimed
+	expected := `
+# HELP gpu_idle_device_safely_reclaimable_bytes GPU memory in bytes held by non-exempt processes idle for at least the reclaim safety duration on this GPU. [unit=bytes] [stability=stable]
+# TYPE gpu_idle_device_safely_reclaimable_bytes gauge
+gpu_idle_device_safely_reclaimable_bytes{gpu="0"} 2.147483648e+09
+`
+	if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "gpu_idle_device_safely_reclaimable_bytes"); err != nil {
+		t.Error(err)
+	}
+}
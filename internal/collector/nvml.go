@@ -0,0 +1,32 @@
+package collector
+
+import "github.com/NVIDIA/go-nvml/pkg/nvml"
+
+// nvmlClient is the subset of the package-level NVML API used by the collector.
+// Device handles are returned as nvml.Device, which is itself an interface, so
+// tests can substitute fakes for both the library and individual GPUs.
+type nvmlClient interface {
+	Init() nvml.Return
+	Shutdown() nvml.Return
+	DeviceGetCount() (int, nvml.Return)
+	DeviceGetHandleByIndex(index int) (nvml.Device, nvml.Return)
+}
+
+// nvmlLib forwards to the real NVML bindings.
+type nvmlLib struct{}
+
+func (nvmlLib) Init() nvml.Return {
+	return nvml.Init()
+}
+
+func (nvmlLib) Shutdown() nvml.Return {
+	return nvml.Shutdown()
+}
+
+func (nvmlLib) DeviceGetCount() (int, nvml.Return) {
+	return nvml.DeviceGetCount()
+}
+
+func (nvmlLib) DeviceGetHandleByIndex(index int) (nvml.Device, nvml.Return) {
+	return nvml.DeviceGetHandleByIndex(index)
+}
@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// persistencedComm is how nvidia-persistenced appears in /proc/<pid>/comm,
+// which the kernel truncates to 15 characters.
+const persistencedComm = "nvidia-persiste"
+
+// WithPersistencedCheck looks for a running nvidia-persistenced on every
+// collection and reports it in Snapshot.PersistencedRunning. It needs
+// visibility of host processes (hostPID in Kubernetes); without it the
+// daemon is never found.
+func WithPersistencedCheck(enabled bool) Option {
+	return func(c *Collector) { c.checkPersistenced = enabled }
+}
+
+// persistencedRunning reports whether nvidia-persistenced is running. The
+// PID found last time is checked first, so the full /proc scan only runs
+// when the daemon restarted or is down.
+func (c *Collector) persistencedRunning(ctx context.Context) bool {
+	if c.persistencedPID != 0 && c.isPersistenced(ctx, c.persistencedPID) {
+		return true
+	}
+	c.persistencedPID = 0
+
+	entries, err := os.ReadDir(c.procRoot)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		pid, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		if c.isPersistenced(ctx, uint32(pid)) {
+			c.persistencedPID = uint32(pid)
+			return true
+		}
+	}
+	return false
+}
+
+// isPersistenced reports whether pid is nvidia-persistenced.
+func (c *Collector) isPersistenced(ctx context.Context, pid uint32) bool {
+	data, err := c.readProcFile(ctx, filepath.Join(c.procRoot, strconv.FormatUint(uint64(pid), 10), "comm"))
+	return err == nil && strings.TrimSpace(string(data)) == persistencedComm
+}
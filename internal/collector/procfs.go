@@ -0,0 +1,237 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errProcReadTimeout is returned by readProcFile when a read exceeds the deadline.
+var errProcReadTimeout = errors.New("/proc read timed out")
+
+// maxFdScan bounds how many entries of /proc/<pid>/fd are inspected per
+// process, so a process with a huge fd table can't stall collection.
+const maxFdScan = 4096
+
+// countGPUFds counts the open file descriptors of pid that refer to NVIDIA
+// device nodes (/dev/nvidia*), by resolving the symlinks in <procRoot>/<pid>/fd.
+// At most maxFdScan entries are inspected. Descriptors that vanish or can't be
+// resolved mid-scan are skipped; an error is returned only if the fd directory
+// itself can't be read (typically permission denied or the process exited).
+func countGPUFds(procRoot string, pid uint32) (int, error) {
+	dir := filepath.Join(procRoot, fmt.Sprint(pid), "fd")
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	entries, err := f.ReadDir(maxFdScan)
+	if err != nil && len(entries) == 0 {
+		return 0, err
+	}
+
+	count := 0
+	for _, e := range entries {
+		target, err := os.Readlink(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue // fd closed between ReadDir and Readlink
+		}
+		if strings.HasPrefix(target, "/dev/nvidia") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// readProcFile reads a file under /proc with a timeout (see withProcTimeout).
+func (c *Collector) readProcFile(ctx context.Context, name string) ([]byte, error) {
+	return withProcTimeout(ctx, c.procReadTimeout, func() ([]byte, error) { return c.readFile(name) })
+}
+
+// ProcReader returns a function reading <procRoot>/<pid>/<file> within
+// timeout, like the collector's own /proc reads, for readers outside the
+// collector such as the exporter's enrichers.
+func ProcReader(procRoot string, timeout time.Duration) func(pid uint32, file string) ([]byte, error) {
+	return func(pid uint32, file string) ([]byte, error) {
+		return withProcTimeout(context.Background(), timeout, func() ([]byte, error) {
+			return os.ReadFile(filepath.Join(procRoot, fmt.Sprint(pid), file))
+		})
+	}
+}
+
+// withProcTimeout runs a /proc read with a timeout. The read runs in its
+// own goroutine; if it is stuck (e.g. the process is in uninterruptible
+// sleep) the goroutine is abandoned and finishes whenever the kernel lets
+// it, and errProcReadTimeout is returned.
+func withProcTimeout[T any](ctx context.Context, timeout time.Duration, read func() (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		v   T
+		err error
+	}
+	ch := make(chan result, 1) // buffered so an abandoned read doesn't leak forever
+	go func() {
+		v, err := read()
+		ch <- result{v, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, errProcReadTimeout
+	}
+}
+
+// readGPUFds counts the GPU fds of pid (see countGPUFds) within the /proc
+// read timeout. ok is false if the fd directory can't be read or the scan
+// timed out.
+func (c *Collector) readGPUFds(ctx context.Context, pid uint32) (n int, ok, timedOut bool) {
+	n, err := withProcTimeout(ctx, c.procReadTimeout, func() (int, error) { return c.countFds(c.procRoot, pid) })
+	if errors.Is(err, errProcReadTimeout) {
+		c.logger.Warn("collector: counting GPU fds timed out", "event", "proc_read_timeout", "pid", pid, "timeout", c.procReadTimeout)
+		return 0, false, true
+	}
+	return n, err == nil, false
+}
+
+// readProcessName reads the process name from /proc/<pid>/comm.
+// The result is sanitized: control characters and null bytes are stripped
+// (null bytes would break the stale-key delimiter in the exporter), and
+// the name is truncated to 64 characters. If the read times out, the name
+// cached from an earlier cycle (or "unknown") is returned with timedOut set.
+func (c *Collector) readProcessName(ctx context.Context, pid uint32) (name string, timedOut bool) {
+	data, err := c.readProcFile(ctx, filepath.Join(c.procRoot, fmt.Sprint(pid), "comm"))
+	if errors.Is(err, errProcReadTimeout) {
+		c.logger.Warn("collector: reading process name timed out", "event", "proc_read_timeout", "pid", pid, "timeout", c.procReadTimeout)
+		if cached, ok := c.names[pid]; ok {
+			return cached, true
+		}
+		return "unknown", true
+	}
+	if err != nil {
+		return "unknown", false
+	}
+	return sanitizeProcessName(data), false
+}
+
+// sanitizeProcessName strips whitespace and control characters from a raw
+// comm value and truncates it to 64 characters.
+func sanitizeProcessName(data []byte) string {
+	name := strings.TrimSpace(string(data))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f { // strip control characters including \x00
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+// processStatus is what the collector reads from /proc/<pid>/status.
+type processStatus struct {
+	uid       uint32 // real UID, valid only if hasUID
+	hasUID    bool
+	suspended bool
+}
+
+// readProcessStatus reads the real UID and scheduler state of pid from
+// /proc/<pid>/status. The zero processStatus is returned if the file can't
+// be read in time.
+func (c *Collector) readProcessStatus(ctx context.Context, pid uint32) (st processStatus, timedOut bool) {
+	data, err := c.readProcFile(ctx, filepath.Join(c.procRoot, fmt.Sprint(pid), "status"))
+	if errors.Is(err, errProcReadTimeout) {
+		c.logger.Warn("collector: reading process status timed out", "event", "proc_read_timeout", "pid", pid, "timeout", c.procReadTimeout)
+		return processStatus{}, true
+	}
+	if err != nil {
+		return processStatus{}, false
+	}
+	st.uid, st.hasUID = parseStatusUID(data)
+	st.suspended = parseStatusSuspended(data)
+	return st, false
+}
+
+// parseStatusSuspended reports whether the "State:" line of a
+// /proc/<pid>/status file, the same state as field 3 of /proc/<pid>/stat,
+// says the process can't run: stopped by a signal (T, e.g. SIGSTOP),
+// stopped by a debugger (t) or in uninterruptible sleep (D), which is
+// where the cgroup v1 freezer leaves frozen tasks.
+func parseStatusSuspended(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		rest, found := strings.CutPrefix(line, "State:")
+		if !found {
+			continue
+		}
+		switch state := strings.TrimSpace(rest); {
+		case strings.HasPrefix(state, "T"), strings.HasPrefix(state, "t"), strings.HasPrefix(state, "D"):
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// parseStatusUID extracts the real UID from the "Uid:" line of a
+// /proc/<pid>/status file ("Uid:\t<real>\t<effective>\t<saved>\t<fs>").
+func parseStatusUID(data []byte) (uint32, bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		rest, found := strings.CutPrefix(line, "Uid:")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return 0, false
+		}
+		uid, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		return uint32(uid), true
+	}
+	return 0, false
+}
+
+// ReadPasswd maps UIDs to user names from a passwd(5) file, e.g. the
+// host's /etc/passwd. Malformed lines are skipped.
+func ReadPasswd(path string) (map[uint32]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uint32]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// name:password:UID:GID:GECOS:directory:shell
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] == "" {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, dup := names[uint32(uid)]; !dup {
+			names[uint32(uid)] = fields[0]
+		}
+	}
+	return names, nil
+}
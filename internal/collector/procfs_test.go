@@ -0,0 +1,254 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+func TestCountGPUFds(t *testing.T) {
+	root := t.TempDir()
+	fdDir := filepath.Join(root, "1234", "fd")
+	if err := os.MkdirAll(fdDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{
+		"0": "/dev/null",
+		"1": "/dev/pts/0",
+		"3": "/dev/nvidiactl",
+		"4": "/dev/nvidia0",
+		"5": "/dev/nvidia-uvm",
+		"6": "socket:[12345]",
+		"7": "/tmp/nvidia-notes.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(fdDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := countGPUFds(root, 1234)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 GPU fds, got %d", n)
+	}
+}
+
+func TestCountGPUFdsMissingProcess(t *testing.T) {
+	if _, err := countGPUFds(t.TempDir(), 999); err == nil {
+		t.Error("expected an error for a process without an fd directory")
+	}
+}
+
+func TestReadProcessNameTimeout(t *testing.T) {
+	const slowPID, fastPID = 1111, 2222
+	block := make(chan struct{})
+	defer close(block)
+
+	stuck := false
+	c := newTestCollector(fakeDevice("GPU-0", []nvml.ProcessInfo{
+		{Pid: slowPID, UsedGpuMemory: 1 << 30},
+		{Pid: fastPID, UsedGpuMemory: 1 << 30},
+	}, nil))
+	c.procRoot = t.TempDir()
+	c.procReadTimeout = 20 * time.Millisecond
+	c.readFile = func(name string) ([]byte, error) {
+		if stuck && strings.Contains(name, fmt.Sprint(slowPID)) && filepath.Base(name) == "comm" {
+			<-block // process in uninterruptible sleep
+		}
+		return []byte(filepath.Base(filepath.Dir(name)) + "-proc\n"), nil
+	}
+
+	// First cycle: both reads succeed and populate the cache.
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.ProcReadTimeouts != 0 || snap.ProcessNames[slowPID] != "1111-proc" {
+		t.Fatalf("unexpected first cycle: timeouts=%d names=%v", snap.ProcReadTimeouts, snap.ProcessNames)
+	}
+
+	// Second cycle: the slow PID's read blocks. Collection still completes,
+	// falling back to the cached name.
+	stuck = true
+	start := time.Now()
+	snap, err = c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("collection blocked for %v on a stuck /proc read", elapsed)
+	}
+	if snap.ProcReadTimeouts != 1 {
+		t.Errorf("expected 1 proc read timeout, got %d", snap.ProcReadTimeouts)
+	}
+	if got := snap.ProcessNames[slowPID]; got != "1111-proc" {
+		t.Errorf("expected cached name for timed-out read, got %q", got)
+	}
+	if got := snap.ProcessNames[fastPID]; got != "2222-proc" {
+		t.Errorf("expected fast PID name to be read normally, got %q", got)
+	}
+}
+
+func TestReadProcessNameTimeoutWithoutCache(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	c := New()
+	c.procReadTimeout = 10 * time.Millisecond
+	c.readFile = func(string) ([]byte, error) {
+		<-block
+		return nil, nil
+	}
+	name, timedOut := c.readProcessName(context.Background(), 42)
+	if !timedOut || name != "unknown" {
+		t.Errorf("expected (unknown, true), got (%q, %v)", name, timedOut)
+	}
+}
+
+func TestCountGPUFdsTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	c := newTestCollector(fakeDevice("GPU-0", []nvml.ProcessInfo{{Pid: 1111, UsedGpuMemory: 1 << 30}}, nil))
+	c.procRoot = t.TempDir()
+	c.procReadTimeout = 20 * time.Millisecond
+	c.readFile = func(name string) ([]byte, error) { return []byte("python\n"), nil }
+	c.countFds = func(string, uint32) (int, error) {
+		<-block // fd table of a process in uninterruptible sleep
+		return 0, nil
+	}
+
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.ProcReadTimeouts != 1 {
+		t.Errorf("expected 1 proc read timeout, got %d", snap.ProcReadTimeouts)
+	}
+	if _, ok := snap.GPUFds[1111]; ok {
+		t.Errorf("expected no fd count for a timed-out scan, got %v", snap.GPUFds)
+	}
+}
+
+func TestProcReader(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "100"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "100", "environ"), []byte("A=1\x00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Opening a FIFO without a writer blocks, like a read of a process in
+	// uninterruptible sleep
+	fifo := filepath.Join(root, "100", "cgroup")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	t.Cleanup(func() {
+		if f, err := os.OpenFile(fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			f.Close() // releases the abandoned read
+		}
+	})
+
+	read := ProcReader(root, 20*time.Millisecond)
+	if data, err := read(100, "environ"); err != nil || string(data) != "A=1\x00" {
+		t.Errorf("expected the environment, got %q (%v)", data, err)
+	}
+	if _, err := read(100, "cgroup"); !errors.Is(err, errProcReadTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestParseStatusUID(t *testing.T) {
+	status := "Name:\tpython\nUmask:\t0022\nState:\tS (sleeping)\nUid:\t1001\t1001\t1001\t1001\nGid:\t100\t100\t100\t100\n"
+	if uid, ok := parseStatusUID([]byte(status)); !ok || uid != 1001 {
+		t.Errorf("expected UID 1001, got %d (ok=%v)", uid, ok)
+	}
+	if _, ok := parseStatusUID([]byte("Name:\tpython\n")); ok {
+		t.Error("expected no UID without a Uid line")
+	}
+}
+
+func TestParseStatusSuspended(t *testing.T) {
+	for state, want := range map[string]bool{
+		"R (running)":      false,
+		"S (sleeping)":     false,
+		"T (stopped)":      true,
+		"t (tracing stop)": true,
+		"D (disk sleep)":   true,
+		"Z (zombie)":       false,
+	} {
+		status := "Name:\tpython\nState:\t" + state + "\nUid:\t1001\t1001\t1001\t1001\n"
+		if got := parseStatusSuspended([]byte(status)); got != want {
+			t.Errorf("state %q: expected suspended=%v, got %v", state, want, got)
+		}
+	}
+	if parseStatusSuspended([]byte("Name:\tpython\n")) {
+		t.Error("expected not suspended without a State line")
+	}
+}
+
+func TestReadPasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	passwd := "root:x:0:0:root:/root:/bin/bash\n# comment\nalice:x:1001:100::/home/alice:/bin/sh\nbroken-line\nbob:x:notanumber:100::/:/bin/sh\n"
+	if err := os.WriteFile(path, []byte(passwd), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ReadPasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "root" || names[1001] != "alice" {
+		t.Errorf("unexpected users: %v", names)
+	}
+}
+
+func TestPersistencedRunning(t *testing.T) {
+	root := t.TempDir()
+	writeComm := func(pid, comm string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(root, pid), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, pid, "comm"), []byte(comm+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeComm("1", "systemd")
+	writeComm("812", "nvidia-persiste") // truncated by the kernel
+	if err := os.MkdirAll(filepath.Join(root, "sys"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCollector(fakeDevice("GPU-0", nil, nil))
+	c.procRoot = root
+	c.checkPersistenced = true
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if !snap.PersistencedChecked || !snap.PersistencedRunning {
+		t.Errorf("expected nvidia-persistenced to be found, got checked=%v running=%v", snap.PersistencedChecked, snap.PersistencedRunning)
+	}
+	if c.persistencedPID != 812 {
+		t.Errorf("expected the daemon's PID to be remembered, got %d", c.persistencedPID)
+	}
+
+	// The daemon exits
+	if err := os.RemoveAll(filepath.Join(root, "812")); err != nil {
+		t.Fatal(err)
+	}
+	if snap, _ = c.Collect(context.Background()); snap.PersistencedRunning {
+		t.Error("expected nvidia-persistenced to be reported down")
+	}
+}
@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// ErrReinitializing is returned by Collect when NVML lost its GPUs and is
+// being re-initialized. Collections stay unreliable until one completes
+// with every GPU again; until then snapshots are marked Partial.
+var ErrReinitializing = errors.New("NVML is re-initializing")
+
+// needsReinit reports whether an NVML error means the library has to be
+// re-initialized before it can see the GPUs again.
+func needsReinit(ret nvml.Return) bool {
+	return ret == nvml.ERROR_GPU_IS_LOST || ret == nvml.ERROR_UNINITIALIZED
+}
+
+// gpuLostError is returned by a GPU's collection when an NVML call on it
+// failed with an error that needs re-initialization.
+type gpuLostError struct{ ret nvml.Return }
+
+func (e gpuLostError) Error() string { return "GPU lost: " + nvml.ErrorString(e.ret) }
+
+// reinit shuts NVML down and initializes it again after ret. Device handles
+// and sample timestamps from before are invalid afterwards. If Init fails,
+// the next Collect sees ERROR_UNINITIALIZED and tries again.
+func (c *Collector) reinit(ret nvml.Return) error {
+	c.logger.Warn("collector: re-initializing NVML; metrics are held until a full collection succeeds", "event", "nvml_reinit", "err", nvml.ErrorString(ret))
+	c.reinitializing = true
+	c.mu.Lock()
+	c.generation++
+	c.lastSampleTime = make(map[int]uint64)
+	c.lastUtilSampleTime = make(map[int]uint64)
+	c.mu.Unlock()
+	c.lib.Shutdown()
+	if ret := c.lib.Init(); ret != nvml.SUCCESS {
+		return fmt.Errorf("%w: Init: %v", ErrReinitializing, nvml.ErrorString(ret))
+	}
+	return fmt.Errorf("%w after %v", ErrReinitializing, nvml.ErrorString(ret))
+}
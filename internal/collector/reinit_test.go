@@ -0,0 +1,172 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// lostNVML is a fakeNVML whose count and handles fail with scripted errors.
+type lostNVML struct {
+	fakeNVML
+	countRet  nvml.Return
+	handleRet map[int]nvml.Return
+	inits     int
+	shutdowns int
+}
+
+func (l *lostNVML) Init() nvml.Return {
+	l.inits++
+	return nvml.SUCCESS
+}
+
+func (l *lostNVML) Shutdown() nvml.Return {
+	l.shutdowns++
+	return nvml.SUCCESS
+}
+
+func (l *lostNVML) DeviceGetCount() (int, nvml.Return) {
+	if l.countRet != nvml.SUCCESS {
+		return 0, l.countRet
+	}
+	return l.fakeNVML.DeviceGetCount()
+}
+
+func (l *lostNVML) DeviceGetHandleByIndex(index int) (nvml.Device, nvml.Return) {
+	if ret, ok := l.handleRet[index]; ok {
+		return nil, ret
+	}
+	return l.fakeNVML.DeviceGetHandleByIndex(index)
+}
+
+func TestCollectReinit(t *testing.T) {
+	lib := &lostNVML{fakeNVML: fakeNVML{devices: []nvml.Device{fakeDevice("GPU-0", nil, nil), fakeDevice("GPU-1", nil, nil)}}}
+	c := New()
+	c.lib = lib
+
+	// The GPUs are lost: NVML is re-initialized
+	lib.countRet = nvml.ERROR_GPU_IS_LOST
+	if _, err := c.Collect(context.Background()); !errors.Is(err, ErrReinitializing) {
+		t.Fatalf("expected ErrReinitializing, got %v", err)
+	}
+	if lib.shutdowns != 1 || lib.inits != 1 {
+		t.Errorf("expected one Shutdown and Init, got %d and %d", lib.shutdowns, lib.inits)
+	}
+
+	// Only one GPU is back yet: the snapshot is partial
+	lib.countRet = nvml.SUCCESS
+	lib.handleRet = map[int]nvml.Return{1: nvml.ERROR_GPU_IS_LOST}
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Partial {
+		t.Error("expected a partial snapshot while a GPU is still missing after re-initialization")
+	}
+
+	// Every GPU is back: collection resumes
+	lib.handleRet = nil
+	if snap, _ = c.Collect(context.Background()); snap.Partial || len(snap.Devices) != 2 {
+		t.Errorf("expected a full snapshot of 2 GPUs, got partial=%v with %d", snap.Partial, len(snap.Devices))
+	}
+
+	// Outside re-initialization a missing GPU doesn't hold back the others
+	lib.handleRet = map[int]nvml.Return{1: nvml.ERROR_UNKNOWN}
+	if snap, _ = c.Collect(context.Background()); snap.Partial {
+		t.Error("expected a missing GPU not to mark the snapshot partial once re-initialized")
+	}
+}
+
+func TestCollectReinitFromDevice(t *testing.T) {
+	lostUUID := fakeDevice("GPU-1", nil, nil)
+	lib := &lostNVML{fakeNVML: fakeNVML{devices: []nvml.Device{fakeDevice("GPU-0", nil, nil), lostUUID}}}
+	c := New()
+	c.lib = lib
+
+	// The count still succeeds, but a handle reports the GPU lost
+	lib.handleRet = map[int]nvml.Return{1: nvml.ERROR_GPU_IS_LOST}
+	if _, err := c.Collect(context.Background()); !errors.Is(err, ErrReinitializing) {
+		t.Fatalf("expected ErrReinitializing from a lost handle, got %v", err)
+	}
+	lib.handleRet = nil
+	if snap, err := c.Collect(context.Background()); err != nil || snap.Partial {
+		t.Fatalf("expected a full collection after recovery, got %v (partial=%v)", err, snap != nil && snap.Partial)
+	}
+
+	// A metric call on a valid handle reports it
+	lostUUID.GetUUIDFunc = func() (string, nvml.Return) { return "", nvml.ERROR_GPU_IS_LOST }
+	if _, err := c.Collect(context.Background()); !errors.Is(err, ErrReinitializing) {
+		t.Fatalf("expected ErrReinitializing from a lost GPU's metrics, got %v", err)
+	}
+	if lib.shutdowns != 2 || lib.inits != 2 {
+		t.Errorf("expected two re-initializations, got %d Shutdowns and %d Inits", lib.shutdowns, lib.inits)
+	}
+	// While recovering, the GPU is only missing
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Partial || len(snap.Devices) != 1 {
+		t.Errorf("expected a partial snapshot of the other GPU, got partial=%v with %d", snap.Partial, len(snap.Devices))
+	}
+}
+
+func TestCollectReinitHungGPU(t *testing.T) {
+	release := make(chan struct{})
+	hung := fakeDevice("GPU-hung", []nvml.ProcessInfo{{Pid: 1 << 30, UsedGpuMemory: 1 << 20}},
+		[]nvml.ProcessUtilizationSample{{Pid: 1 << 30, SmUtil: 50, TimeStamp: 500}})
+	hung.GetNameFunc = func() (string, nvml.Return) {
+		<-release // fell off the bus
+		return "NVIDIA A100-SXM4-40GB", nvml.SUCCESS
+	}
+	lib := &lostNVML{fakeNVML: fakeNVML{devices: []nvml.Device{hung, fakeDevice("GPU-1", nil, nil)}}}
+	c := New(WithCallTimeout(20 * time.Millisecond))
+	c.lib = lib
+
+	if _, err := c.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lib.countRet = nvml.ERROR_GPU_IS_LOST
+	if _, err := c.Collect(context.Background()); !errors.Is(err, ErrReinitializing) {
+		t.Fatalf("expected ErrReinitializing, got %v", err)
+	}
+
+	// GPU 0 is still hung after re-initialization: it is skipped, but the
+	// other GPU's metrics aren't held back
+	lib.countRet = nvml.SUCCESS
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.TimedOutGPUs) != 1 || len(snap.Devices) != 1 {
+		t.Fatalf("expected GPU 0 timed out and GPU 1 collected, got %v and %+v", snap.TimedOutGPUs, snap.Devices)
+	}
+	if snap.Partial {
+		t.Error("expected a hung GPU not to mark the snapshot partial after re-initialization")
+	}
+
+	// The abandoned collection returns; its sample timestamp belongs to
+	// the old NVML session and isn't kept
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		blocked := c.blocked[0]
+		c.mu.Unlock()
+		if !blocked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("GPU 0 still blocked after its calls returned")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ts, ok := c.lastSampleTime[0]; ok {
+		t.Errorf("expected no sample timestamp from before re-initialization, got %d", ts)
+	}
+}
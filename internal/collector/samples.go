@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// sampleValue decodes an NVML sample or field value of the given type.
+// ok is false for value types that don't represent a number.
+func sampleValue(vt nvml.ValueType, raw [8]byte) (v float64, ok bool) {
+	switch vt {
+	case nvml.VALUE_TYPE_DOUBLE:
+		return math.Float64frombits(binary.LittleEndian.Uint64(raw[:])), true
+	case nvml.VALUE_TYPE_UNSIGNED_INT:
+		return float64(binary.LittleEndian.Uint32(raw[:4])), true
+	case nvml.VALUE_TYPE_SIGNED_INT:
+		return float64(int32(binary.LittleEndian.Uint32(raw[:4]))), true
+	case nvml.VALUE_TYPE_UNSIGNED_LONG, nvml.VALUE_TYPE_UNSIGNED_LONG_LONG:
+		return float64(binary.LittleEndian.Uint64(raw[:])), true
+	case nvml.VALUE_TYPE_SIGNED_LONG_LONG:
+		return float64(int64(binary.LittleEndian.Uint64(raw[:]))), true
+	}
+	return 0, false
+}
+
+// averageSamples averages NVML samples, e.g. the driver's ~6 Hz GPU
+// utilization samples over one poll window. Each sample is a whole
+// percent, but their mean has sub-percent resolution, which separates a
+// GPU that is truly at 0% from one running the occasional kernel. latest
+// is the newest sample timestamp, to pass as lastSeenTimestamp next time.
+// ok is false if there are no usable samples.
+func averageSamples(vt nvml.ValueType, samples []nvml.Sample) (avg float64, latest uint64, ok bool) {
+	var sum float64
+	var n int
+	for _, s := range samples {
+		v, valid := sampleValue(vt, s.SampleValue)
+		if !valid {
+			continue
+		}
+		sum += v
+		n++
+		if s.TimeStamp > latest {
+			latest = s.TimeStamp
+		}
+	}
+	if n == 0 {
+		return 0, 0, false
+	}
+	return sum / float64(n), latest, true
+}
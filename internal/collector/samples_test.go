@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+func uintSample(ts uint64, v uint32) nvml.Sample {
+	s := nvml.Sample{TimeStamp: ts}
+	binary.LittleEndian.PutUint32(s.SampleValue[:4], v)
+	return s
+}
+
+func TestAverageSamplesFractional(t *testing.T) {
+	// A near-idle GPU: one 1% sample in eight averages to 0.125%
+	samples := []nvml.Sample{
+		uintSample(100, 0), uintSample(200, 0), uintSample(300, 1), uintSample(400, 0),
+		uintSample(500, 0), uintSample(600, 0), uintSample(700, 0), uintSample(800, 0),
+	}
+	avg, latest, ok := averageSamples(nvml.VALUE_TYPE_UNSIGNED_INT, samples)
+	if !ok {
+		t.Fatal("expected samples to be usable")
+	}
+	if avg != 0.125 {
+		t.Errorf("expected average 0.125, got %v", avg)
+	}
+	if latest != 800 {
+		t.Errorf("expected latest timestamp 800, got %d", latest)
+	}
+
+	if _, _, ok := averageSamples(nvml.VALUE_TYPE_UNSIGNED_INT, nil); ok {
+		t.Error("expected no result for an empty sample buffer")
+	}
+}
+
+func TestCollectFineUtilization(t *testing.T) {
+	var since []uint64
+	dev := fakeDevice("GPU-0", nil, nil)
+	dev.GetSamplesFunc = func(_ nvml.SamplingType, lastSeen uint64) (nvml.ValueType, []nvml.Sample, nvml.Return) {
+		since = append(since, lastSeen)
+		return nvml.VALUE_TYPE_UNSIGNED_INT, []nvml.Sample{uintSample(lastSeen+1, 0), uintSample(lastSeen+2, 1)}, nvml.SUCCESS
+	}
+	c := newTestCollector(dev, fakeDevice("GPU-1", nil, nil))
+
+	snap, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if got := snap.Devices[0].UtilizationFine; got != 0.5 {
+		t.Errorf("expected fine utilization 0.5, got %v", got)
+	}
+	// Without samples the coarse value is used
+	if got := snap.Devices[1].UtilizationFine; got != 42 {
+		t.Errorf("expected fallback to coarse utilization 42, got %v", got)
+	}
+
+	if _, err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(since) != 2 || since[1] != 2 {
+		t.Errorf("expected second poll to request samples after timestamp 2, got %v", since)
+	}
+}
@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// sampleWindowTolerance is how far the poll interval may differ from the
+// driver's sample window, as a fraction of the window, before they count
+// as mismatched.
+const sampleWindowTolerance = 0.25
+
+// ProbeSampleWindows estimates, per GPU index, how far back the driver's
+// utilization sample buffer reaches. It asks for every buffered sample
+// (GetProcessUtilization and GetSamples from timestamp 0) and measures the
+// span between the oldest and newest. GPUs whose buffer holds fewer than
+// two distinct timestamps are omitted. Called once at startup; it doesn't
+// affect what Collect reads.
+func (c *Collector) ProbeSampleWindows() map[int]time.Duration {
+	windows := make(map[int]time.Duration)
+	count, ret := c.lib.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return windows
+	}
+	for i := 0; i < count; i++ {
+		device, ret := c.lib.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS || c.skipGPU(i, device) {
+			continue
+		}
+		var timestamps []uint64
+		if samples, ret := device.GetProcessUtilization(0); ret == nvml.SUCCESS {
+			for _, s := range samples {
+				timestamps = append(timestamps, s.TimeStamp)
+			}
+		}
+		if _, samples, ret := device.GetSamples(nvml.GPU_UTILIZATION_SAMPLES, 0); ret == nvml.SUCCESS {
+			for _, s := range samples {
+				timestamps = append(timestamps, s.TimeStamp)
+			}
+		}
+		if w, ok := sampleWindow(timestamps); ok {
+			windows[i] = w
+		}
+	}
+	return windows
+}
+
+// sampleWindow returns the span covered by sample timestamps, which are in
+// microseconds. ok is false with fewer than two distinct timestamps.
+func sampleWindow(timestamps []uint64) (window time.Duration, ok bool) {
+	if len(timestamps) == 0 {
+		return 0, false
+	}
+	oldest, newest := timestamps[0], timestamps[0]
+	for _, ts := range timestamps[1:] {
+		oldest = min(oldest, ts)
+		newest = max(newest, ts)
+	}
+	if newest == oldest {
+		return 0, false
+	}
+	return time.Duration(newest-oldest) * time.Microsecond, true
+}
+
+// SampleWindowMismatch reports whether pollInterval is poorly aligned with
+// a GPU's sample window, and if so explains the consequence and the
+// interval to use instead. A window longer than the poll interval lets
+// consecutive polls see overlapping samples; a shorter one lets samples
+// expire between polls, so short bursts of activity are missed.
+func SampleWindowMismatch(window, pollInterval time.Duration) (problem string, recommended time.Duration, mismatched bool) {
+	if window <= 0 {
+		return "", 0, false
+	}
+	tolerance := time.Duration(float64(window) * sampleWindowTolerance)
+	recommended = max(window.Round(time.Second), time.Second)
+	switch {
+	case pollInterval > window+tolerance:
+		problem = fmt.Sprintf("samples older than %v expire before the next poll, so activity between polls can be missed", window)
+	case pollInterval < window-tolerance:
+		problem = fmt.Sprintf("each poll reads samples from the last %v, so consecutive polls can overlap", window)
+	default:
+		return "", recommended, false
+	}
+	return problem, recommended, true
+}
@@ -0,0 +1,30 @@
+package collector
+
+import "github.com/NVIDIA/go-nvml/pkg/nvml"
+
+// ThrottleReason is a known reason for the driver holding clocks down, with
+// its bit in the nvmlDeviceGetCurrentClocksThrottleReasons mask.
+type ThrottleReason struct {
+	Name string // label value, e.g. "sw_power_cap"
+	Bit  uint64
+}
+
+// ThrottleReasons lists the decoded throttle reasons. "idle" means clocks
+// are parked because nothing runs, as opposed to being held down while work
+// is waiting.
+var ThrottleReasons = []ThrottleReason{
+	{"idle", nvml.ClocksThrottleReasonGpuIdle},
+	{"applications_clocks", nvml.ClocksThrottleReasonApplicationsClocksSetting},
+	{"sw_power_cap", nvml.ClocksThrottleReasonSwPowerCap},
+	{"hw_slowdown", nvml.ClocksThrottleReasonHwSlowdown},
+	{"sync_boost", nvml.ClocksThrottleReasonSyncBoost},
+	{"sw_thermal", nvml.ClocksThrottleReasonSwThermalSlowdown},
+	{"hw_thermal", nvml.ClocksThrottleReasonHwThermalSlowdown},
+	{"hw_power_brake", nvml.ClocksThrottleReasonHwPowerBrakeSlowdown},
+	{"display_clocks", nvml.ClocksThrottleReasonDisplayClockSetting},
+}
+
+// Throttled reports whether reason is set in the device's throttle reasons.
+func (d DeviceInfo) Throttled(reason ThrottleReason) bool {
+	return d.ThrottleReasons&reason.Bit != 0
+}
@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultCallTimeout bounds the NVML calls collecting one GPU.
+const DefaultCallTimeout = 5 * time.Second
+
+var (
+	// errCallTimeout is returned by collectGPUTimeout when a GPU's NVML
+	// calls exceed the timeout.
+	errCallTimeout = errors.New("NVML calls timed out")
+	// errCallBlocked is returned while the calls of an earlier poll that
+	// timed out are still blocked.
+	errCallBlocked = errors.New("NVML calls from an earlier poll still blocked")
+	// errNoHandle is returned by collectGPU when the GPU's handle can't be
+	// looked up.
+	errNoHandle = errors.New("no device handle")
+	// errGPUSkipped is returned by collectGPU for GPUs the filter skips.
+	errGPUSkipped = errors.New("GPU filtered out")
+)
+
+// WithCallTimeout bounds the NVML calls collecting each GPU, so a GPU that
+// fell off the bus, whose calls can block forever, doesn't stall the
+// polling loop. Such a GPU is skipped and reported in
+// Snapshot.TimedOutGPUs. 0 disables the timeout.
+func WithCallTimeout(d time.Duration) Option {
+	return func(c *Collector) { c.callTimeout = d }
+}
+
+// collectGPUTimeout runs collectGPU, from the handle lookup on, bounded by
+// the call timeout. NVML calls can't be cancelled, so they run in their
+// own goroutine, which is abandoned on timeout like a stuck /proc read.
+// Until it returns, the GPU is skipped rather than queried again, so a
+// wedged GPU holds one goroutine rather than one per poll.
+func (c *Collector) collectGPUTimeout(index int, stages *StageTimings) (DeviceInfo, []ProcessSample, error) {
+	c.mu.Lock()
+	gen := c.generation
+	if c.callTimeout <= 0 {
+		c.mu.Unlock()
+		return c.collectGPU(index, gen, stages)
+	}
+	if c.blocked[index] {
+		c.mu.Unlock()
+		return DeviceInfo{}, nil, errCallBlocked
+	}
+	c.blocked[index] = true
+	c.mu.Unlock()
+
+	type result struct {
+		di     DeviceInfo
+		procs  []ProcessSample
+		stages StageTimings
+		err    error
+	}
+	ch := make(chan result, 1) // buffered so an abandoned collection can finish
+	go func() {
+		var r result
+		r.di, r.procs, r.err = c.collectGPU(index, gen, &r.stages)
+		c.mu.Lock()
+		delete(c.blocked, index)
+		c.mu.Unlock()
+		ch <- r
+	}()
+
+	timer := time.NewTimer(c.callTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		stages.DeviceCollect += r.stages.DeviceCollect
+		stages.ProcessCollect += r.stages.ProcessCollect
+		return r.di, r.procs, r.err
+	case <-timer.C:
+		return DeviceInfo{}, nil, errCallTimeout
+	}
+}
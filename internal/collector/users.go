@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"os/user"
+	"strconv"
+)
+
+// maxCachedUsers bounds the UserResolver cache. When it is full the cache
+// is dropped and rebuilt from the UIDs still seen.
+const maxCachedUsers = 4096
+
+// UserResolver maps UIDs to user names, from the given names (typically
+// the host's passwd file, see ReadPasswd) and otherwise from the system's
+// user database. Database lookups are cached per UID so it isn't queried
+// on every poll. A UID that can't be resolved maps to its number. It isn't
+// safe for concurrent use.
+type UserResolver struct {
+	names  map[uint32]string
+	lookup func(uid string) (*user.User, error)
+	cache  map[uint32]string
+}
+
+// NewUserResolver creates a UserResolver; names may be nil.
+func NewUserResolver(names map[uint32]string) *UserResolver {
+	return &UserResolver{names: names, lookup: user.LookupId}
+}
+
+// Name returns the user name of uid.
+func (r *UserResolver) Name(uid uint32) string {
+	if name, ok := r.names[uid]; ok {
+		return name
+	}
+	if name, ok := r.cache[uid]; ok {
+		return name
+	}
+	if r.cache == nil || len(r.cache) >= maxCachedUsers {
+		r.cache = make(map[uint32]string)
+	}
+	id := strconv.FormatUint(uint64(uid), 10)
+	name := id
+	if usr, err := r.lookup(id); err == nil && usr.Username != "" {
+		name = usr.Username
+	}
+	r.cache[uid] = name
+	return name
+}
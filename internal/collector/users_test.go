@@ -0,0 +1,36 @@
+package collector
+
+import (
+	"os/user"
+	"testing"
+)
+
+func TestUserResolver(t *testing.T) {
+	r := NewUserResolver(map[uint32]string{1001: "alice"})
+	lookups := 0
+	r.lookup = func(uid string) (*user.User, error) {
+		lookups++
+		if uid == "1002" {
+			return &user.User{Uid: uid, Username: "bob"}, nil
+		}
+		return nil, user.UnknownUserIdError(0)
+	}
+
+	for _, tc := range []struct {
+		uid  uint32
+		want string
+	}{
+		{1001, "alice"}, // from the passwd file
+		{1002, "bob"},   // from the user database
+		{1002, "bob"},   // cached
+		{1003, "1003"},  // unresolvable
+		{1003, "1003"},  // cached too
+	} {
+		if got := r.Name(tc.uid); got != tc.want {
+			t.Errorf("UID %d: expected %q, got %q", tc.uid, tc.want, got)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("expected one lookup per UID not in the passwd file, got %d", lookups)
+	}
+}
@@ -0,0 +1,269 @@
+// Package enrich provides built-in exporter.Enrichers that label processes
+// from their /proc entries, collector attribution and local metadata files.
+package enrich
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/affinode/gpu-idle-exporter/internal/idle"
+)
+
+// maxCached bounds each enricher's per-process cache. When it is full the
+// cache is dropped and rebuilt from the live processes.
+const maxCached = 4096
+
+// cacheKey identifies a process. The name guards against a reused PID
+// picking up the previous process's labels.
+type cacheKey struct {
+	pid  uint32
+	name string
+}
+
+// cache memoizes labels per process, since /proc is read on every poll.
+type cache map[cacheKey]map[string]string
+
+// get returns the cached labels for ps, computing them with load on a miss.
+func (c *cache) get(ps idle.ProcessIdleState, load func(pid uint32) map[string]string) map[string]string {
+	key := cacheKey{ps.PID, ps.ProcessName}
+	if labels, ok := (*c)[key]; ok {
+		return labels
+	}
+	if *c == nil || len(*c) >= maxCached {
+		*c = make(cache)
+	}
+	labels := load(ps.PID)
+	(*c)[key] = labels
+	return labels
+}
+
+// Cgroup labels processes with the cgroup path the collector read (see
+// collector.WithPodAttribution), e.g. /kubepods/burstable/pod<uid>/<container>,
+// which identifies the pod, container or systemd unit a process belongs to.
+type Cgroup struct{}
+
+// LabelNames implements exporter.Enricher.
+func (Cgroup) LabelNames() []string {
+	return []string{"cgroup"}
+}
+
+// Labels implements exporter.Enricher. The label is empty if the cgroup
+// couldn't be read.
+func (Cgroup) Labels(ps idle.ProcessIdleState) map[string]string {
+	return map[string]string{"cgroup": ps.Cgroup}
+}
+
+// Pod labels processes with the Kubernetes pod UID and container ID the
+// collector read from their cgroup (see collector.WithPodAttribution).
+// Processes outside a pod get empty labels.
+type Pod struct{}
+
+// LabelNames implements exporter.Enricher.
+func (Pod) LabelNames() []string {
+	return []string{"pod_uid", "container_id"}
+}
+
+// Labels implements exporter.Enricher.
+func (Pod) Labels(ps idle.ProcessIdleState) map[string]string {
+	return map[string]string{"pod_uid": ps.PodUID, "container_id": ps.ContainerID}
+}
+
+// User labels processes with their owner's user name, for chargeback,
+// resolved by name (e.g. collector.UserResolver.Name). A process whose
+// owner is unknown gets an empty label.
+type User struct {
+	name func(uid uint32) string
+}
+
+// NewUser creates a User enricher resolving UIDs with name.
+func NewUser(name func(uid uint32) string) *User {
+	return &User{name: name}
+}
+
+// LabelNames implements exporter.Enricher.
+func (u *User) LabelNames() []string {
+	return []string{"user"}
+}
+
+// Labels implements exporter.Enricher.
+func (u *User) Labels(ps idle.ProcessIdleState) map[string]string {
+	if !ps.HasUID {
+		return nil
+	}
+	return map[string]string{"user": u.name(ps.UID)}
+}
+
+// labelNameRE matches valid Prometheus label names.
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// CheckLabelName checks that name, lower-cased, is a label name an
+// enricher can set, as NewEnv and NewMeta require of their variables and
+// fields.
+func CheckLabelName(name string) error {
+	label := strings.ToLower(name)
+	if !labelNameRE.MatchString(label) || strings.HasPrefix(label, "__") {
+		return fmt.Errorf("%q is not a valid label name", name)
+	}
+	return nil
+}
+
+// Env labels processes with the values of selected environment variables,
+// such as a scheduler's job ID. Each variable becomes a label named after it
+// in lower case, e.g. SLURM_JOB_ID -> slurm_job_id. Reading another user's
+// environment needs CAP_SYS_PTRACE; unreadable values are empty.
+type Env struct {
+	read   func(pid uint32, file string) ([]byte, error)
+	vars   []string
+	labels []string
+	cache  cache
+}
+
+// NewEnv creates an Env enricher for the given variables. read reads a file
+// of a process's /proc entry; it should be bounded, as by
+// collector.ProcReader, since Labels runs during the metrics update and a
+// process in uninterruptible sleep can block the read.
+func NewEnv(read func(pid uint32, file string) ([]byte, error), vars []string) (*Env, error) {
+	e := &Env{read: read}
+	for _, v := range vars {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if err := CheckLabelName(v); err != nil {
+			return nil, fmt.Errorf("environment variable: %w", err)
+		}
+		e.vars = append(e.vars, v)
+		e.labels = append(e.labels, strings.ToLower(v))
+	}
+	return e, nil
+}
+
+// LabelNames implements exporter.Enricher.
+func (e *Env) LabelNames() []string {
+	return e.labels
+}
+
+// Labels implements exporter.Enricher.
+func (e *Env) Labels(ps idle.ProcessIdleState) map[string]string {
+	return e.cache.get(ps, func(pid uint32) map[string]string {
+		data, err := e.read(pid, "environ")
+		if err != nil {
+			return nil
+		}
+		labels := make(map[string]string, len(e.vars))
+		for _, entry := range bytes.Split(data, []byte{0}) {
+			name, value, ok := strings.Cut(string(entry), "=")
+			if !ok {
+				continue
+			}
+			for i, v := range e.vars {
+				if v == name {
+					labels[e.labels[i]] = value
+				}
+			}
+		}
+		return labels
+	})
+}
+
+// ContainerIDPlaceholder is replaced by a process's container ID in the
+// path template of a Meta enricher.
+const ContainerIDPlaceholder = "{container_id}"
+
+// Meta labels processes with fields of a metadata file that a sidecar
+// writes per container, e.g. /etc/gpu-meta/<container-id>.json holding
+// {"job": "train-42", "owner": "alice", "team": "vision"}. Files are found
+// by the container ID the collector read from the process's cgroup (see
+// collector.WithPodAttribution). Each file is re-read at most once per
+// refresh interval, so edits and late-written files are picked up.
+// Processes outside a container, or whose file is missing or malformed,
+// get empty labels.
+type Meta struct {
+	template string
+	fields   []string
+	labels   []string
+	refresh  time.Duration
+	now      func() time.Time
+	files    map[string]metaFile // container ID -> last read
+}
+
+// metaFile is the labels read from a container's metadata file.
+type metaFile struct {
+	labels map[string]string
+	read   time.Time
+}
+
+// NewMeta creates a Meta enricher reading the given top-level fields from
+// the files at template, which must contain ContainerIDPlaceholder. Each
+// field becomes a label named after it in lower case.
+func NewMeta(template string, fields []string, refresh time.Duration) (*Meta, error) {
+	if !strings.Contains(template, ContainerIDPlaceholder) {
+		return nil, fmt.Errorf("metadata path template %q has no %s", template, ContainerIDPlaceholder)
+	}
+	m := &Meta{template: template, refresh: refresh, now: time.Now}
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if err := CheckLabelName(f); err != nil {
+			return nil, fmt.Errorf("metadata field: %w", err)
+		}
+		m.fields = append(m.fields, f)
+		m.labels = append(m.labels, strings.ToLower(f))
+	}
+	return m, nil
+}
+
+// LabelNames implements exporter.Enricher.
+func (m *Meta) LabelNames() []string {
+	return m.labels
+}
+
+// Labels implements exporter.Enricher.
+func (m *Meta) Labels(ps idle.ProcessIdleState) map[string]string {
+	if ps.ContainerID == "" {
+		return nil
+	}
+	now := m.now()
+	if f, ok := m.files[ps.ContainerID]; ok && now.Sub(f.read) < m.refresh {
+		return f.labels
+	}
+	if m.files == nil || len(m.files) >= maxCached {
+		m.files = make(map[string]metaFile)
+	}
+	labels := m.read(ps.ContainerID)
+	m.files[ps.ContainerID] = metaFile{labels: labels, read: now}
+	return labels
+}
+
+// read loads the metadata file of a container. Non-string values are
+// formatted as JSON; nil is returned if the file can't be read or parsed.
+func (m *Meta) read(containerID string) map[string]string {
+	data, err := os.ReadFile(strings.ReplaceAll(m.template, ContainerIDPlaceholder, containerID))
+	if err != nil {
+		return nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	labels := make(map[string]string, len(m.fields))
+	for i, f := range m.fields {
+		raw, ok := doc[f]
+		if !ok {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			s = string(raw)
+		}
+		labels[m.labels[i]] = s
+	}
+	return labels
+}
@@ -0,0 +1,124 @@
+package enrich
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+	"github.com/affinode/gpu-idle-exporter/internal/idle"
+)
+
+// writeProc creates <root>/<pid>/<name> with data.
+func writeProc(t *testing.T, root, pid, name, data string) {
+	t.Helper()
+	dir := filepath.Join(root, pid)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCgroup(t *testing.T) {
+	ps := idle.ProcessIdleState{PID: 100, Cgroup: "/kubepods/burstable/pod123/abc"}
+	if got := (Cgroup{}).Labels(ps)["cgroup"]; got != ps.Cgroup {
+		t.Errorf("expected %q, got %q", ps.Cgroup, got)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	root := t.TempDir()
+	writeProc(t, root, "100", "environ", "HOME=/root\x00SLURM_JOB_ID=4242\x00BILLING_TAG=team-a\x00")
+	e, err := NewEnv(collector.ProcReader(root, time.Second), []string{"SLURM_JOB_ID", " BILLING_TAG", "MISSING", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := e.LabelNames(), []string{"slurm_job_id", "billing_tag", "missing"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("expected label names %v, got %v", want, got)
+	}
+	labels := e.Labels(idle.ProcessIdleState{PID: 100, ProcessName: "python"})
+	if labels["slurm_job_id"] != "4242" || labels["billing_tag"] != "team-a" || labels["missing"] != "" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	// Unreadable environment
+	if labels := e.Labels(idle.ProcessIdleState{PID: 200, ProcessName: "python"}); len(labels) != 0 {
+		t.Errorf("expected no labels for an unreadable environment, got %v", labels)
+	}
+
+	if _, err := NewEnv(collector.ProcReader(root, time.Second), []string{"NOT-A-LABEL"}); err == nil {
+		t.Error("expected an error for a variable that isn't a valid label name")
+	}
+}
+
+func TestPod(t *testing.T) {
+	ps := idle.ProcessIdleState{PID: 100, PodUID: "6b7ce1a2-9d4f-4c1e-8a55-2f0e3b9c1d7a", ContainerID: "abc123"}
+	got := Pod{}.Labels(ps)
+	if got["pod_uid"] != ps.PodUID || got["container_id"] != ps.ContainerID {
+		t.Errorf("unexpected labels %v", got)
+	}
+	if got := (Pod{}).Labels(idle.ProcessIdleState{PID: 200}); got["pod_uid"] != "" || got["container_id"] != "" {
+		t.Errorf("expected empty labels outside a pod, got %v", got)
+	}
+}
+
+func TestUser(t *testing.T) {
+	u := NewUser(func(uid uint32) string { return map[uint32]string{1001: "alice"}[uid] })
+	if got := u.Labels(idle.ProcessIdleState{PID: 100, UID: 1001, HasUID: true})["user"]; got != "alice" {
+		t.Errorf("expected user alice, got %q", got)
+	}
+	if got := u.Labels(idle.ProcessIdleState{PID: 400})["user"]; got != "" {
+		t.Errorf("expected an empty label for an unknown owner, got %q", got)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	dir := t.TempDir()
+	const container = "3f2a9c"
+	path := filepath.Join(dir, container+".json")
+	if err := os.WriteFile(path, []byte(`{"job": "train-42", "Owner": "alice", "team": "vision", "gpus": 8, "extra": "ignored"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMeta(filepath.Join(dir, "meta.json"), []string{"job"}, time.Minute); err == nil {
+		t.Error("expected a template without a container ID placeholder to be rejected")
+	}
+	m, err := NewMeta(filepath.Join(dir, ContainerIDPlaceholder+".json"), []string{"job", "Owner", "team", "gpus"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if got, want := m.LabelNames(), []string{"job", "owner", "team", "gpus"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected label names %v, got %v", want, got)
+	}
+	ps := idle.ProcessIdleState{PID: 100, ContainerID: container}
+	want := map[string]string{"job": "train-42", "owner": "alice", "team": "vision", "gpus": "8"}
+	if got := m.Labels(ps); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// Cached until the refresh interval has passed
+	if err := os.WriteFile(path, []byte(`{"job": "train-43"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Labels(ps)["job"]; got != "train-42" {
+		t.Errorf("expected the cached job, got %q", got)
+	}
+	now = now.Add(time.Minute)
+	if got := m.Labels(ps); !reflect.DeepEqual(got, map[string]string{"job": "train-43"}) {
+		t.Errorf("expected the refreshed file, got %v", got)
+	}
+
+	// No container, or no file: empty labels
+	if got := m.Labels(idle.ProcessIdleState{PID: 200}); len(got) != 0 {
+		t.Errorf("expected no labels outside a container, got %v", got)
+	}
+	if got := m.Labels(idle.ProcessIdleState{PID: 300, ContainerID: "missing"}); len(got) != 0 {
+		t.Errorf("expected no labels without a metadata file, got %v", got)
+	}
+}
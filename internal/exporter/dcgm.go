@@ -0,0 +1,100 @@
+package exporter
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+)
+
+// dcgmLabels are the device labels dcgm-exporter attaches to every series.
+var dcgmLabels = []string{"gpu", "UUID", "device", "modelName"}
+
+// dcgmMetrics mirrors a subset of dcgm-exporter's default device metrics,
+// so dashboards built for dcgm-exporter work unchanged. Names, units and
+// labels follow dcgm-exporter, not this exporter's conventions.
+type dcgmMetrics struct {
+	gpuUtil    *prometheus.GaugeVec // DCGM_FI_DEV_GPU_UTIL, percent
+	fbUsed     *prometheus.GaugeVec // DCGM_FI_DEV_FB_USED, MiB
+	fbFree     *prometheus.GaugeVec // DCGM_FI_DEV_FB_FREE, MiB
+	powerUsage *prometheus.GaugeVec // DCGM_FI_DEV_POWER_USAGE, watts
+	gpuTemp    *prometheus.GaugeVec // DCGM_FI_DEV_GPU_TEMP, degrees C
+
+	// Label sets set by the previous update, keyed by their joined values,
+	// so the series of GPUs that are gone or changed can be deleted
+	prev map[string]prometheus.Labels
+}
+
+func newDCGMMetrics(cat catalog) *dcgmMetrics {
+	return &dcgmMetrics{
+		gpuUtil: cat.gaugeVec(metricDef{
+			Name:      "DCGM_FI_DEV_GPU_UTIL",
+			Help:      "GPU utilization (in %).",
+			Unit:      unitPercent,
+			Stability: stabilityStable,
+		}, dcgmLabels),
+		fbUsed: cat.gaugeVec(metricDef{
+			Name:      "DCGM_FI_DEV_FB_USED",
+			Help:      "Framebuffer memory used (in MiB).",
+			Unit:      unitMebibytes,
+			Stability: stabilityStable,
+		}, dcgmLabels),
+		fbFree: cat.gaugeVec(metricDef{
+			Name:      "DCGM_FI_DEV_FB_FREE",
+			Help:      "Framebuffer memory free (in MiB).",
+			Unit:      unitMebibytes,
+			Stability: stabilityStable,
+		}, dcgmLabels),
+		powerUsage: cat.gaugeVec(metricDef{
+			Name:      "DCGM_FI_DEV_POWER_USAGE",
+			Help:      "Power draw (in W).",
+			Unit:      unitWatts,
+			Stability: stabilityStable,
+		}, dcgmLabels),
+		gpuTemp: cat.gaugeVec(metricDef{
+			Name:      "DCGM_FI_DEV_GPU_TEMP",
+			Help:      "GPU temperature (in C).",
+			Unit:      unitCelsius,
+			Stability: stabilityStable,
+		}, dcgmLabels),
+	}
+}
+
+func (m *dcgmMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.gpuUtil, m.fbUsed, m.fbFree, m.powerUsage, m.gpuTemp}
+}
+
+// update sets the DCGM-named series from the snapshot's devices, and
+// deletes those of GPUs no longer reported under the same labels.
+func (m *dcgmMetrics) update(devices []collector.DeviceInfo) {
+	const mib = 1 << 20
+	current := make(map[string]prometheus.Labels, len(devices))
+	for _, d := range devices {
+		labels := prometheus.Labels{
+			"gpu":       strconv.Itoa(d.Index),
+			"UUID":      d.UUID,
+			"device":    "nvidia" + strconv.Itoa(d.Index),
+			"modelName": d.Name,
+		}
+		current[labels["gpu"]+"\x00"+d.UUID+"\x00"+d.Name] = labels
+		var free uint64
+		if d.MemoryTotal > d.MemoryUsed {
+			free = d.MemoryTotal - d.MemoryUsed
+		}
+		m.gpuUtil.With(labels).Set(float64(d.Utilization))
+		m.fbUsed.With(labels).Set(float64(d.MemoryUsed / mib))
+		m.fbFree.With(labels).Set(float64(free / mib))
+		m.powerUsage.With(labels).Set(d.PowerWatts)
+		m.gpuTemp.With(labels).Set(float64(d.TempCelsius))
+	}
+	for key, labels := range m.prev {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		for _, g := range []*prometheus.GaugeVec{m.gpuUtil, m.fbUsed, m.fbFree, m.powerUsage, m.gpuTemp} {
+			g.Delete(labels)
+		}
+	}
+	m.prev = current
+}
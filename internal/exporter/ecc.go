@@ -0,0 +1,63 @@
+package exporter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+)
+
+// WithECCExpected enables gpu_idle_device_ecc_policy_violation for GPUs
+// expected to run with ECC enabled. Each entry is either a GPU UUID
+// ("GPU-..."), matched exactly, or a model name fragment such as "A100"
+// or "H100", matched case-insensitively against the GPU name.
+func WithECCExpected(entries []string) Option {
+	return func(e *Exporter) {
+		for _, entry := range entries {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				e.eccExpected = append(e.eccExpected, entry)
+			}
+		}
+	}
+}
+
+// expectsECC reports whether d is expected to have ECC enabled.
+func (e *Exporter) expectsECC(d collector.DeviceInfo) bool {
+	for _, entry := range e.eccExpected {
+		if strings.HasPrefix(entry, "GPU-") {
+			if d.UUID == entry {
+				return true
+			}
+		} else if strings.Contains(strings.ToLower(d.Name), strings.ToLower(entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateECCPolicy flags GPUs expected to have ECC enabled that don't, e.g.
+// after ECC was turned off for a benchmark and never turned back on. Only
+// expected GPUs reporting an ECC mode get a series.
+func (e *Exporter) updateECCPolicy(snap *collector.Snapshot) {
+	current := make(map[string]bool, len(snap.Devices))
+	for _, d := range snap.Devices {
+		if !d.HasEccMode || !e.expectsECC(d) {
+			continue
+		}
+		gpuStr := strconv.Itoa(d.Index)
+		current[gpuStr] = true
+		v := 0.0
+		if !d.EccEnabled {
+			v = 1
+		}
+		e.eccViolation.With(prometheus.Labels{"gpu": gpuStr}).Set(v)
+	}
+	for gpu := range e.prevECCPolicy {
+		if !current[gpu] {
+			e.eccViolation.Delete(prometheus.Labels{"gpu": gpu})
+		}
+	}
+	e.prevECCPolicy = current
+}
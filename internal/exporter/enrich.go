@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/affinode/gpu-idle-exporter/internal/idle"
+)
+
+// Enricher attaches site-specific labels (scheduler job IDs, billing tags,
+// ...) to processes. They are exported on gpu_idle_process_info rather than
+// on every per-process series, and can be joined onto those by gpu, pid,
+// process and mig_instance.
+type Enricher interface {
+	// LabelNames returns the names of the labels the enricher sets. They
+	// must not change over the enricher's lifetime.
+	LabelNames() []string
+	// Labels returns label values for a process, keyed by label name;
+	// names it omits are exported empty. It is called for every process on
+	// every poll, so implementations should cache per process.
+	Labels(ps idle.ProcessIdleState) map[string]string
+}
+
+// WithEnrichers enables gpu_idle_process_info with the labels of the given
+// enrichers. A label name that collides with a per-process label (gpu, pid,
+// process, mig_instance) or another enricher's label is dropped.
+func WithEnrichers(enrichers ...Enricher) Option {
+	return func(e *Exporter) { e.enrichers = enrichers }
+}
+
+// newProcessInfo defines gpu_idle_process_info with the enrichers' labels,
+// if there are enrichers. Called once options have been applied, so the
+// dropped labels are logged with the configured logger.
+func (e *Exporter) newProcessInfo() {
+	if len(e.enrichers) == 0 {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, name := range processLabels {
+		seen[name] = true
+	}
+	for _, en := range e.enrichers {
+		for _, name := range en.LabelNames() {
+			if seen[name] {
+				e.logger.Warn("exporter: dropping duplicate enricher label", "event", "invalid_config", "label", name)
+				continue
+			}
+			seen[name] = true
+			e.enrichLabels = append(e.enrichLabels, name)
+		}
+	}
+	e.processInfo = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_process_info",
+		Help:      "Site-specific labels of this process, from the configured enrichers. Join on gpu, pid, process and mig_instance. Always 1.",
+		Unit:      unitInfo,
+		Stability: stabilityStable,
+	}, append(append([]string{}, processLabels...), e.enrichLabels...))
+}
+
+// updateProcessInfo runs the enrichers over the emitted processes and sets
+// gpu_idle_process_info, dropping series for processes that are gone or
+// whose labels changed. In aggregated mode the processes of a group share
+// one series, so the join stays one-to-one; it keeps an enricher label
+// only if all of them have the same value.
+func (e *Exporter) updateProcessInfo(states []idle.ProcessIdleState, emitted map[string]bool) {
+	if e.processInfo == nil {
+		return
+	}
+	groups := make(map[string]prometheus.Labels, len(states))
+	for _, ps := range states {
+		gpuStr := strconv.Itoa(ps.GPU)
+		pidStr := e.pidLabel(ps.PID)
+		key := gpuStr + "\x00" + pidStr + "\x00" + ps.ProcessName + "\x00" + ps.MigInstance
+		if !emitted[key] {
+			continue
+		}
+		labels := prometheus.Labels{"gpu": gpuStr, "pid": pidStr, "process": ps.ProcessName, "mig_instance": ps.MigInstance}
+		for _, name := range e.enrichLabels {
+			labels[name] = ""
+		}
+		for _, en := range e.enrichers {
+			for name, value := range en.Labels(ps) {
+				if _, ok := labels[name]; ok && !isProcessLabel(name) {
+					labels[name] = value
+				}
+			}
+		}
+		if group, ok := groups[key]; ok {
+			for _, name := range e.enrichLabels {
+				if group[name] != labels[name] {
+					group[name] = ""
+				}
+			}
+			continue
+		}
+		groups[key] = labels
+	}
+
+	current := make(map[string]prometheus.Labels, len(groups))
+	for _, labels := range groups {
+		values := make([]string, 0, len(processLabels)+len(e.enrichLabels))
+		for _, name := range processLabels {
+			values = append(values, labels[name])
+		}
+		for _, name := range e.enrichLabels {
+			values = append(values, labels[name])
+		}
+		current[strings.Join(values, "\x00")] = labels
+		e.processInfo.With(labels).Set(1)
+	}
+	for key, labels := range e.prevInfo {
+		if _, ok := current[key]; !ok {
+			e.processInfo.Delete(labels)
+		}
+	}
+	e.prevInfo = current
+}
+
+// isProcessLabel reports whether name is one of the per-process identity
+// labels, which enrichers can't override.
+func isProcessLabel(name string) bool {
+	for _, l := range processLabels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
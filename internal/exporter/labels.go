@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+	"github.com/affinode/gpu-idle-exporter/internal/listconfig"
+)
+
+// DefaultDeviceLabels is the label set of the device-level metrics unless
+// configured otherwise.
+var DefaultDeviceLabels = []string{"gpu", "model", "uuid"}
+
+// deviceLabelValue extracts each supported device label from a DeviceInfo.
+var deviceLabelValue = map[string]func(d collector.DeviceInfo) string{
+	"gpu":        func(d collector.DeviceInfo) string { return strconv.Itoa(d.Index) },
+	"model":      func(d collector.DeviceInfo) string { return d.Name },
+	"uuid":       func(d collector.DeviceInfo) string { return d.UUID },
+	"pci_bus_id": func(d collector.DeviceInfo) string { return d.PCIBusID },
+}
+
+// ParseDeviceLabels parses a comma-separated device label set, e.g.
+// "gpu,uuid". Each label must be one of gpu, model, uuid or pci_bus_id;
+// unknown labels are dropped and reported in a *listconfig.Error. gpu is
+// required because the other metrics join on it: without it no labels are
+// returned.
+func ParseDeviceLabels(s string) ([]string, error) {
+	labels, err := listconfig.Parse(s, len(deviceLabelValue), func(l string) error {
+		if _, ok := deviceLabelValue[l]; !ok {
+			return errors.New("unknown device label (want gpu, model, uuid or pci_bus_id)")
+		}
+		return nil
+	})
+	for _, l := range labels {
+		if l == "gpu" {
+			return labels, err
+		}
+	}
+	return nil, errors.Join(err, fmt.Errorf("device labels %q must include gpu", s))
+}
+
+// WithDeviceLabels sets the label set of the device-level metrics, as
+// returned by ParseDeviceLabels. Fewer labels mean fewer, cheaper series.
+func WithDeviceLabels(labels []string) Option {
+	return func(e *Exporter) { e.deviceLabels = labels }
+}
+
+// roleSystem is the role label value of system GPUs.
+const roleSystem = "system"
+
+// WithSystemGPUs designates GPUs that serve the node itself, such as a
+// display GPU. Their device metrics gain a role="system" label (other
+// GPUs leave it empty, so it's absent), and their processes, marked
+// System by the tracker, are left out of the idle aggregates.
+func WithSystemGPUs(gpus collector.GPUSet) Option {
+	return func(e *Exporter) { e.systemGPUs = gpus }
+}
+
+// deviceLabelValues returns the configured device labels for d.
+func (e *Exporter) deviceLabelValues(d collector.DeviceInfo) prometheus.Labels {
+	labels := make(prometheus.Labels, len(e.deviceLabels))
+	for _, l := range e.deviceLabels {
+		if l == "role" {
+			labels[l] = ""
+			if e.systemGPUs.Contains(d.Index, d.UUID) {
+				labels[l] = roleSystem
+			}
+			continue
+		}
+		labels[l] = deviceLabelValue[l](d)
+	}
+	return labels
+}
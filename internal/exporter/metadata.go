@@ -0,0 +1,151 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Units of exported metrics, rendered into the help text as [unit=...].
+const (
+	unitBytes              = "bytes"
+	unitMebibytes          = "mebibytes"
+	unitByteSeconds        = "byte_seconds"
+	unitSeconds            = "seconds"
+	unitPercent            = "percent"
+	unitRatio              = "ratio"
+	unitWatts              = "watts"
+	unitCelsius            = "celsius"
+	unitMegahertz          = "megahertz"
+	unitKilobytesPerSecond = "kilobytes_per_second"
+	unitPerSecond          = "per_second"
+	unitCount              = "count"
+	unitBoolean            = "boolean"
+	unitInfo               = "info" // constant 1, information carried in labels
+)
+
+// Stability of exported metrics, rendered into the help text as
+// [stability=...]. Experimental metrics may change meaning or be removed
+// without notice.
+const (
+	stabilityStable       = "stable"
+	stabilityExperimental = "experimental"
+)
+
+// nativeMaxBuckets caps the buckets of a native histogram; past it the
+// client library widens them to stay within the cap.
+const nativeMaxBuckets = 160
+
+// metricDef describes one metric. Its help text is rendered with the unit
+// and stability appended, so catalog tooling can parse them from either
+// the exposition format or /metrics/metadata.
+type metricDef struct {
+	Name      string
+	Help      string
+	Unit      string
+	Stability string
+	Buckets   []float64 // histograms only
+
+	// NativeBucketFactor makes a histogram native (sparse) with buckets
+	// growing by at most this factor, in addition to any classic Buckets
+	NativeBucketFactor float64
+}
+
+// help returns the help text with the unit and stability markers.
+func (d metricDef) help() string {
+	return d.Help + " [unit=" + d.Unit + "] [stability=" + d.Stability + "]"
+}
+
+// MetricMetadata describes a registered metric, as served by MetadataHandler.
+type MetricMetadata struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Unit      string   `json:"unit"`
+	Stability string   `json:"stability"`
+	Help      string   `json:"help"`
+	Labels    []string `json:"labels"`
+}
+
+// catalog creates metrics from their definitions and remembers the
+// metadata of each, so Register can list exactly what it registered.
+type catalog map[prometheus.Collector]MetricMetadata
+
+func (c catalog) add(m prometheus.Collector, typ string, d metricDef, labels []string) {
+	c[m] = MetricMetadata{
+		Name:      d.Name,
+		Type:      typ,
+		Unit:      d.Unit,
+		Stability: d.Stability,
+		Help:      d.Help,
+		Labels:    append([]string{}, labels...),
+	}
+}
+
+func (c catalog) gaugeVec(d metricDef, labels []string) *prometheus.GaugeVec {
+	m := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: d.Name, Help: d.help()}, labels)
+	c.add(m, "gauge", d, labels)
+	return m
+}
+
+func (c catalog) gauge(d metricDef) prometheus.Gauge {
+	m := prometheus.NewGauge(prometheus.GaugeOpts{Name: d.Name, Help: d.help()})
+	c.add(m, "gauge", d, nil)
+	return m
+}
+
+func (c catalog) counterVec(d metricDef, labels []string) *prometheus.CounterVec {
+	m := prometheus.NewCounterVec(prometheus.CounterOpts{Name: d.Name, Help: d.help()}, labels)
+	c.add(m, "counter", d, labels)
+	return m
+}
+
+func (c catalog) counter(d metricDef) prometheus.Counter {
+	m := prometheus.NewCounter(prometheus.CounterOpts{Name: d.Name, Help: d.help()})
+	c.add(m, "counter", d, nil)
+	return m
+}
+
+func (c catalog) histogramVec(d metricDef, labels []string) *prometheus.HistogramVec {
+	m := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: d.Name, Help: d.help(), Buckets: d.Buckets}, labels)
+	c.add(m, "histogram", d, labels)
+	return m
+}
+
+func (c catalog) histogram(d metricDef) prometheus.Histogram {
+	m := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:                           d.Name,
+		Help:                           d.help(),
+		Buckets:                        d.Buckets,
+		NativeHistogramBucketFactor:    d.NativeBucketFactor,
+		NativeHistogramMaxBucketNumber: nativeMaxBuckets,
+	})
+	c.add(m, "histogram", d, nil)
+	return m
+}
+
+// register registers the collectors and records their metadata.
+func (e *Exporter) register(cs ...prometheus.Collector) {
+	e.registerer.MustRegister(cs...)
+	for _, c := range cs {
+		if md, ok := e.catalog[c]; ok {
+			e.metadata = append(e.metadata, md)
+		}
+	}
+}
+
+// Metadata returns the registered metrics, sorted by name.
+func (e *Exporter) Metadata() []MetricMetadata {
+	md := append([]MetricMetadata{}, e.metadata...)
+	sort.Slice(md, func(i, j int) bool { return md[i].Name < md[j].Name })
+	return md
+}
+
+// MetadataHandler serves Metadata as JSON, for catalog tooling.
+func (e *Exporter) MetadataHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e.Metadata())
+	})
+}
@@ -0,0 +1,24 @@
+package exporter
+
+// DefaultNativeBucketFactor is the bucket growth factor of native
+// histograms unless configured otherwise: 1.1 resolves idle memory to
+// within about 10%.
+const DefaultNativeBucketFactor = 1.1
+
+// WithNativeHistograms adds gpu_idle_memory_bytes_native, a native (sparse)
+// histogram of each idle process's memory, observed every poll. It gives
+// fleet-wide idle memory quantiles without per-process series. factor is
+// the bucket growth factor, above 1: smaller factors mean finer buckets.
+// Native histograms are only scraped over the protobuf format, which not
+// every scraper supports, so they're opt-in.
+func WithNativeHistograms(factor float64) Option {
+	return func(e *Exporter) {
+		e.idleMemNative = e.catalog.histogram(metricDef{
+			Name:               "gpu_idle_memory_bytes_native",
+			Help:               "GPU memory in bytes held by each idle process, observed every poll, as a native histogram. Processes on system GPUs are not observed.",
+			Unit:               unitBytes,
+			Stability:          stabilityExperimental,
+			NativeBucketFactor: factor,
+		})
+	}
+}
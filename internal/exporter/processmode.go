@@ -0,0 +1,96 @@
+package exporter
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/affinode/gpu-idle-exporter/internal/idle"
+)
+
+// ProcessMetricsMode selects how finely the per-process series are broken
+// down.
+type ProcessMetricsMode string
+
+const (
+	// ProcessMetricsDetailed emits the series of each process, labelled
+	// with its pid.
+	ProcessMetricsDetailed ProcessMetricsMode = "detailed"
+	// ProcessMetricsAggregated merges the processes of the same name on a
+	// GPU into one series, with an empty pid label. The series count then
+	// follows the distinct process names rather than the PIDs, at the cost
+	// of telling the processes apart.
+	ProcessMetricsAggregated ProcessMetricsMode = "aggregated"
+)
+
+// WithProcessMetricsMode sets how the per-process series are broken down.
+// The default is ProcessMetricsDetailed.
+func WithProcessMetricsMode(mode ProcessMetricsMode) Option {
+	return func(e *Exporter) { e.aggregateProcesses = mode == ProcessMetricsAggregated }
+}
+
+// pidLabel returns the pid label of a process's series: empty, so absent,
+// in aggregated mode.
+func (e *Exporter) pidLabel(pid uint32) string {
+	if e.aggregateProcesses {
+		return ""
+	}
+	return strconv.FormatUint(uint64(pid), 10)
+}
+
+// processGroup is what one set of per-process series reports: a single
+// process, or in aggregated mode all the processes of one name on a GPU.
+type processGroup struct {
+	state     idle.ProcessIdleState // the processes merged, see add
+	processes int                   // how many were merged
+	idleDelta time.Duration         // idle time accumulated since the previous poll
+	fds       int                   // open GPU fds, valid only if hasFds
+	hasFds    bool
+	reasons   bool // the processes don't share one idle reason
+}
+
+// add merges ps into the group. Memory, idle memory, idle time, streams,
+// data moved and fds add up; idle duration, residency and utilization take
+// the highest value, confidence the lowest. The group is idle, suspended
+// or memoryless only if all its processes are, and keeps an idle reason
+// only if they share it.
+func (g *processGroup) add(ps idle.ProcessIdleState, idleDelta time.Duration, fds int, hasFds bool) {
+	g.idleDelta += idleDelta
+	if hasFds {
+		g.fds += fds
+		g.hasFds = true
+	}
+	g.processes++
+	if g.processes == 1 {
+		g.state = ps
+		return
+	}
+	s := &g.state
+	s.UsedMemory += ps.UsedMemory
+	s.IdleMemory += ps.IdleMemory
+	s.SmUtil = max(s.SmUtil, ps.SmUtil)
+	s.SmoothedUtil = max(s.SmoothedUtil, ps.SmoothedUtil)
+	s.EngineUtil.SM = max(s.EngineUtil.SM, ps.EngineUtil.SM)
+	s.EngineUtil.Memory = max(s.EngineUtil.Memory, ps.EngineUtil.Memory)
+	s.EngineUtil.Encoder = max(s.EngineUtil.Encoder, ps.EngineUtil.Encoder)
+	s.EngineUtil.Decoder = max(s.EngineUtil.Decoder, ps.EngineUtil.Decoder)
+	s.IdleDuration = max(s.IdleDuration, ps.IdleDuration)
+	s.Residency = max(s.Residency, ps.Residency)
+	s.Confidence = min(s.Confidence, ps.Confidence)
+	s.IsIdle = s.IsIdle && ps.IsIdle
+	s.Suspended = s.Suspended && ps.Suspended
+	s.Memoryless = s.Memoryless && ps.Memoryless
+	if ps.HasActiveStreams {
+		s.ActiveStreams += ps.ActiveStreams
+		s.HasActiveStreams = true
+	}
+	if ps.HasDataMoved {
+		s.DataMoved += ps.DataMoved
+		s.HasDataMoved = true
+	}
+	if ps.IdleReason != s.IdleReason {
+		g.reasons = true
+	}
+	if !s.IsIdle || g.reasons {
+		s.IdleReason = ""
+	}
+}
@@ -1,8 +1,11 @@
 package exporter
 
 import (
+	"log/slog"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -11,158 +14,2048 @@ import (
 )
 
 var (
-	processLabels = []string{"gpu", "pid", "process"}
-	deviceLabels  = []string{"gpu", "model", "uuid"}
-	gpuOnlyLabel  = []string{"gpu"}
+	processLabels       = []string{"gpu", "pid", "process", "mig_instance"}
+	processStatusLabels = []string{"gpu", "pid", "process", "mig_instance", "status"}
+	processReasonLabels = []string{"gpu", "pid", "process", "mig_instance", "reason"}
+	processEngineLabels = []string{"gpu", "pid", "process", "mig_instance", "engine"}
+	nodeProcessLabels   = []string{"pid", "process"}
+	migInstanceLabels   = []string{"gpu", "mig_instance"}
+	physicalGPULabels   = []string{"gpu", "uuid"}
+	cpuAffinityLabels   = []string{"gpu", "cpus"}
+	deviceBoardLabels   = []string{"gpu", "board_id"}
+	deviceSerialLabels  = []string{"gpu", "uuid", "serial"}
+	firmwareLabels      = []string{"gpu", "uuid", "vbios", "part_number"}
+	migModeLabels       = []string{"gpu", "current", "pending"}
+	boardOnlyLabel      = []string{"board_id"}
+	gpuOnlyLabel        = []string{"gpu"}
+	userOnlyLabel       = []string{"user"}
 )
 
+// utilizationBuckets are the upper bounds (percent) of the per-GPU
+// utilization histogram; fine-grained near 0 where reclaim decisions are made.
+var utilizationBuckets = []float64{0, 1, 5, 10, 25, 50, 75, 90, 100}
+
+// episodeDurationBuckets are the upper bounds (seconds) of the idle episode
+// duration histogram: 10s, 30s, 1m, 5m, 15m, 1h and 6h.
+var episodeDurationBuckets = []float64{10, 30, 60, 300, 900, 3600, 21600}
+
 // Exporter manages Prometheus metric registration and updates.
 type Exporter struct {
 	registerer prometheus.Registerer
+	catalog    catalog          // metadata of every metric defined
+	metadata   []MetricMetadata // metadata of the registered metrics
 
 	// Per-process gauges
 	processComputeUtil *prometheus.GaugeVec
+	processSmoothUtil  *prometheus.GaugeVec
 	processMemUsed     *prometheus.GaugeVec
+	processMemFraction *prometheus.GaugeVec
 	processIdleSecs    *prometheus.GaugeVec
+	processIdleTotal   *prometheus.CounterVec
+	processResidency   *prometheus.GaugeVec
+	processSuspended   *prometheus.GaugeVec
 	processIdleMem     *prometheus.GaugeVec
+	processGPUFds      *prometheus.GaugeVec
+	processStatus      *prometheus.GaugeVec
+	processIdleReason  *prometheus.GaugeVec
+	processMemoryless  *prometheus.GaugeVec
+	processConfidence  *prometheus.GaugeVec
+	processEfficiency  *prometheus.GaugeVec
+	processStreams     *prometheus.GaugeVec
+	processDataMoved   *prometheus.CounterVec
+	processEngineUtil  *prometheus.GaugeVec
+
+	// Per-process node-level gauges (across all GPUs a PID occupies)
+	processNodeIdle     *prometheus.GaugeVec
+	processNodeIdleSecs *prometheus.GaugeVec
 
-	// Device-level gauges
+	// Device-level gauges, labelled with deviceLabels, plus role if there
+	// are system GPUs
+	deviceLabels   []string
+	systemGPUs     collector.GPUSet
 	deviceUtil     *prometheus.GaugeVec
+	deviceUtilFine *prometheus.GaugeVec
+	deviceMemUtil  *prometheus.GaugeVec
 	deviceMemUsed  *prometheus.GaugeVec
 	deviceMemTotal *prometheus.GaugeVec
 	devicePower    *prometheus.GaugeVec
-	deviceTemp     *prometheus.GaugeVec
+	// Power limits; series are omitted where unsupported
+	devicePowerLimit         *prometheus.GaugeVec
+	devicePowerLimitEnforced *prometheus.GaugeVec
+	devicePersistence        *prometheus.GaugeVec // omitted where unsupported
+	deviceEccMode            *prometheus.GaugeVec // omitted where unsupported
+	deviceThrottled          *prometheus.GaugeVec // deviceLabels plus reason
+	deviceTemp               *prometheus.GaugeVec
+	// Clocks; series are omitted while the query fails
+	deviceSmClock       *prometheus.GaugeVec
+	deviceMemClock      *prometheus.GaugeVec
+	deviceGraphicsClock *prometheus.GaugeVec
+	// Omitted for passively cooled GPUs
+	deviceFanSpeed *prometheus.GaugeVec
+	// Omitted where the driver doesn't support PCIe counters
+	devicePcieTx *prometheus.GaugeVec
+	devicePcieRx *prometheus.GaugeVec
+	// Omitted where the driver doesn't report video engine utilization
+	deviceEncoderUtil *prometheus.GaugeVec
+	deviceDecoderUtil *prometheus.GaugeVec
+
+	// 1 if the device is busy but no visible process accounts for it
+	deviceUnattributed *prometheus.GaugeVec
+
+	// Per-GPU utilization distribution, observed once per poll
+	deviceUtilHist *prometheus.HistogramVec
+
+	// Device info metrics (constant 1, information carried in labels)
+	deviceCPUAffinity *prometheus.GaugeVec
+	deviceBoard       *prometheus.GaugeVec
+	deviceSerial      *prometheus.GaugeVec
+	deviceFirmware    *prometheus.GaugeVec
+	// Current and pending MIG mode; 1 while a reconfiguration awaits a GPU reset
+	deviceMigMode *prometheus.GaugeVec
+
+	// Board-level gauges (boards may carry several GPUs sharing a power budget)
+	boardPower *prometheus.GaugeVec
 
 	// Aggregate gauges
-	idleMemTotal *prometheus.GaugeVec
+	idleMemTotal       *prometheus.GaugeVec
+	safelyReclaimable  *prometheus.GaugeVec
+	reclaimSafetyDelay time.Duration        // minimum idle duration before memory counts as safely reclaimable
+	idleMemByDuration  *prometheus.GaugeVec // gpu, duration_bucket
+	occupancy          *prometheus.GaugeVec
+	maxProcsPerGPU     int // intended processes per GPU; 0 leaves occupancy unset
+
+	// How long each GPU has been idle as a whole, from the tracker
+	deviceIdleSecs *prometheus.GaugeVec
+	prevDeviceIdle map[string]bool
+
+	// GPUs held almost entirely by one long-idle process (see WithSquatDetection)
+	deviceSquatted *prometheus.GaugeVec
+	squatFraction  float64         // share of GPU memory above which the process squats
+	squatMinIdle   time.Duration   // minimum idle duration before it counts
+	prevSquatted   map[string]bool // gpu, pid and process labels emitted last cycle
+
+	// GPUs expected to run with ECC enabled (WithECCExpected), by UUID or
+	// model name fragment; eccViolation has no series without them
+	eccExpected  []string
+	eccViolation *prometheus.GaugeVec
+
+	// Idle process ownership. userIdleMem and userIdleRatio are only
+	// registered and set with WithUserNames, since a per-user breakdown can
+	// have high cardinality.
+	distinctIdleUsers     *prometheus.GaugeVec
+	nodeDistinctIdleUsers prometheus.Gauge
+	userIdleMem           *prometheus.GaugeVec
+	userIdleRatio         *prometheus.GaugeVec
+	userName              func(uid uint32) string // nil disables userIdleMem
+
+	// MIG instance gauges
+	migMemUsed      *prometheus.GaugeVec
+	migMemTotal     *prometheus.GaugeVec
+	migIdleMemRatio *prometheus.GaugeVec
+	migUtil         *prometheus.GaugeVec
+	migProcesses    *prometheus.GaugeVec
+	migIdleMem      *prometheus.GaugeVec
+	migIdleProcs    *prometheus.GaugeVec
+
+	// Idle memory and processes rolled up to the physical GPU, across its
+	// MIG instances if it has any
+	physIdleMem   *prometheus.GaugeVec
+	physIdleProcs *prometheus.GaugeVec
+
+	// Aggregate counters
+	deviceIdleMemByteSecs *prometheus.CounterVec
+	deviceBusySecs        *prometheus.CounterVec
+
+	// Collector health
+	collectorPanics     *prometheus.CounterVec
+	deviceTimeouts      *prometheus.CounterVec
+	deviceCount         prometheus.Gauge
+	consecutiveFailures prometheus.Gauge
+	collectorDuration   prometheus.Gauge
+	collectorErrors     prometheus.Counter
+	lastCollection      prometheus.Gauge
+	collectionSuspended prometheus.Gauge
+	nvmlInitialized     prometheus.Gauge
+	nvmlReinitializing  bool // nvmlInitialized was cleared by SetNVMLReinitializing
+	buildInfo           *prometheus.GaugeVec
+	systemInfo          *prometheus.GaugeVec
+	procReadTimeouts    prometheus.Counter
+	clockSkews          prometheus.Counter
+	pollRestarts        prometheus.Counter
+	stageSeconds        *prometheus.GaugeVec
+	// No labels; a vector so the series is absent unless the check runs
+	persistencedHealthy *prometheus.GaugeVec
+	idleEpisodes        *prometheus.CounterVec
+	episodeDurations    prometheus.Histogram
+	idleMemNative       prometheus.Histogram // nil unless WithNativeHistograms
+	processesSeen       prometheus.Counter
+	newProcessRate      prometheus.Gauge
+
+	logger *slog.Logger
+
+	// Site-specific process labels (WithEnrichers); processInfo is nil
+	// without enrichers
+	enrichers    []Enricher
+	enrichLabels []string
+	processInfo  *prometheus.GaugeVec
+	prevInfo     map[string]prometheus.Labels // label values joined -> labels emitted last cycle
+
+	// Emit per-process series only for idle processes (WithIdleProcessesOnly)
+	idleOnly bool
+	// Merge per-process series by process name and GPU (WithProcessMetricsMode)
+	aggregateProcesses bool
+
+	// Effective configuration, set once at startup
+	configSmThreshold  prometheus.Gauge
+	configGracePeriod  prometheus.Gauge
+	configStaleTimeout prometheus.Gauge
+	configPollInterval prometheus.Gauge
+	// Entries dropped from list options, per option
+	configInvalidEntries *prometheus.CounterVec
+	// Driver utilization sample window per GPU, probed once at startup
+	sampleWindow *prometheus.GaugeVec
+
+	// dcgm-exporter compatible device metrics; nil unless WithDCGMMetrics
+	dcgm *dcgmMetrics
 
 	// Track which label sets we emitted last cycle for stale series cleanup
 	prevProcessKeys map[string]bool
+	prevIdleTotals  map[string]time.Duration // process key -> IdleTotal already added to processIdleTotal
+	prevStatusKeys  map[string]bool
+	prevReasons     map[string]string // process key -> idle reason emitted last cycle
+	prevNodeKeys    map[string]bool
+	prevMigKeys     map[string]bool
+	prevPhysical    map[string]bool   // gpu and uuid labels emitted last cycle
+	prevAffinity    map[string]string // gpu -> cpus label emitted last cycle
+	prevBoardOf     map[string]string // gpu -> board_id label emitted last cycle
+	prevSerials     map[string]bool   // gpu, uuid and serial labels emitted last cycle
+	prevFirmware    map[string]bool   // gpu, uuid, vbios and part_number labels emitted last cycle
+	prevMigModes    map[string]bool   // gpu, current and pending labels emitted last cycle
+	prevBoards      map[string]bool
+	prevDeviceGPUs  map[string]bool
+	// Device label sets emitted last cycle, by their joined values
+	prevDeviceKeys map[string]prometheus.Labels
+	prevUsers      map[string]bool
+	prevUserRatios map[string]bool
+	prevECCPolicy  map[string]bool
+
+	// Previous cycle's timestamp and per-GPU idle memory, for time integrals
+	prevTimestamp    time.Time
+	prevIdleMemByGPU map[int]uint64
+}
+
+// Option configures an Exporter.
+type Option func(*Exporter)
+
+// WithLogger sets the logger for configuration problems. The default is
+// slog.Default() at construction.
+func WithLogger(l *slog.Logger) Option {
+	return func(e *Exporter) { e.logger = l }
+}
+
+// WithDCGMMetrics additionally emits a subset of device metrics under
+// dcgm-exporter names and labels (DCGM_FI_DEV_GPU_UTIL, DCGM_FI_DEV_FB_USED,
+// ...), so existing DCGM dashboards can be pointed at this exporter. The
+// native gpu_idle_* metrics are emitted as well.
+func WithDCGMMetrics() Option {
+	return func(e *Exporter) { e.dcgm = newDCGMMetrics(e.catalog) }
+}
+
+// WithIdleProcessesOnly emits per-process series only for idle processes;
+// a process's series are removed when it becomes active. Device and
+// aggregate metrics still account for every process. On busy nodes most
+// processes are active, so this cuts cardinality for deployments that only
+// care about waste.
+func WithIdleProcessesOnly() Option {
+	return func(e *Exporter) { e.idleOnly = true }
+}
+
+// DefaultReclaimSafetyDuration is how long a process must have been idle
+// before its memory counts as safely reclaimable, unless overridden with
+// WithReclaimSafetyDuration.
+const DefaultReclaimSafetyDuration = time.Hour
+
+// WithReclaimSafetyDuration sets how long a process must have been idle,
+// with no activity in between, before its memory counts towards
+// gpu_idle_device_safely_reclaimable_bytes.
+func WithReclaimSafetyDuration(d time.Duration) Option {
+	return func(e *Exporter) { e.reclaimSafetyDelay = d }
+}
+
+// WithMaxProcessesPerGPU sets the intended number of concurrent processes
+// per GPU, enabling gpu_idle_device_process_occupancy_ratio. Values below 1
+// leave it disabled.
+func WithMaxProcessesPerGPU(n int) Option {
+	return func(e *Exporter) { e.maxProcsPerGPU = n }
 }
 
 // New creates a new Exporter with all Prometheus metrics defined.
 // Optional constant labels are attached to every metric via WrapRegistererWith.
-func New(constLabels prometheus.Labels) *Exporter {
-	registerer := prometheus.Registerer(prometheus.DefaultRegisterer)
+func New(constLabels prometheus.Labels, opts ...Option) *Exporter {
+	return newExporter(prometheus.DefaultRegisterer, constLabels, opts...)
+}
+
+// newExporter is New with an explicit registerer, so tests can use a private registry.
+func newExporter(registerer prometheus.Registerer, constLabels prometheus.Labels, opts ...Option) *Exporter {
 	if len(constLabels) > 0 {
 		registerer = prometheus.WrapRegistererWith(constLabels, registerer)
 	}
-	return &Exporter{
+	cat := make(catalog)
+	e := &Exporter{
 		registerer: registerer,
-		processComputeUtil: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_process_compute_utilization_percent",
-			Help: "GPU compute (SM) utilization percentage for this process.",
-		}, processLabels),
-		processMemUsed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_process_memory_used_bytes",
-			Help: "GPU memory held by this process in bytes.",
-		}, processLabels),
-		processIdleSecs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_process_idle_seconds",
-			Help: "Duration in seconds this process has been idle (0%% compute while holding memory). 0 when active.",
-		}, processLabels),
-		processIdleMem: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_process_idle_memory_bytes",
-			Help: "GPU memory in bytes held by this process while idle. 0 when active.",
-		}, processLabels),
-
-		deviceUtil: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_device_utilization_percent",
-			Help: "GPU compute utilization percentage (device-level).",
-		}, deviceLabels),
-		deviceMemUsed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_device_memory_used_bytes",
-			Help: "GPU memory currently used in bytes (device-level).",
-		}, deviceLabels),
-		deviceMemTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_device_memory_total_bytes",
-			Help: "GPU total memory in bytes (device-level).",
-		}, deviceLabels),
-		devicePower: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_device_power_watts",
-			Help: "GPU current power draw in watts.",
-		}, deviceLabels),
-		deviceTemp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_device_temperature_celsius",
-			Help: "GPU core temperature in Celsius.",
-		}, deviceLabels),
-
-		idleMemTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "gpu_idle_memory_total_bytes",
-			Help: "Total GPU memory in bytes held by all idle processes on this GPU.",
+		catalog:    cat,
+		processComputeUtil: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_compute_utilization_percent",
+			Help:      "GPU compute (SM) utilization percentage for this process.",
+			Unit:      unitPercent,
+			Stability: stabilityStable,
+		}, processLabels),
+		processSmoothUtil: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_compute_utilization_smoothed_percent",
+			Help:      "Exponentially weighted moving average of this process's GPU compute (SM) utilization percentage across polls.",
+			Unit:      unitPercent,
+			Stability: stabilityStable,
+		}, processLabels),
+		processMemUsed: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_memory_used_bytes",
+			Help:      "GPU memory held by this process in bytes.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, processLabels),
+		processMemFraction: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_memory_fraction",
+			Help:      "Fraction (0-1) of the GPU's total memory held by this process. 0 if the total is unknown.",
+			Unit:      unitRatio,
+			Stability: stabilityStable,
+		}, processLabels),
+		processIdleSecs: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_idle_seconds",
+			Help:      "Duration in seconds this process has been idle: holding memory at or below the configured SM utilization threshold. 0 when active.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}, processLabels),
+		processIdleTotal: cat.counterVec(metricDef{
+			Name:      "gpu_idle_process_idle_seconds_total",
+			Help:      "Idle time in seconds accumulated by this process across idle and active periods.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}, processLabels),
+		processResidency: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_residency_seconds",
+			Help:      "Time in seconds since this process was first seen on the GPU, whether active or idle.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}, processLabels),
+		processSuspended: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_suspended",
+			Help:      "1 while this process is stopped or frozen (state T, t or D), e.g. paused by its scheduler, 0 otherwise. Time suspended is left out of the idle durations.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, processLabels),
+		processIdleMem: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_idle_memory_bytes",
+			Help:      "GPU memory in bytes held by this process while idle. 0 when active.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, processLabels),
+		processGPUFds: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_gpu_fds",
+			Help:      "Open /dev/nvidia* file descriptors held by this process. Many fds with no compute can indicate a handle leak.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, processLabels),
+		processStatus: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_status",
+			Help:      "Process state as an OpenMetrics StateSet: exactly one of status=\"active\", \"idle\" or \"stale\" is 1, the others 0. If enabled, a final status=\"ended\" is set to 1 instead on shutdown.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, processStatusLabels),
+		processIdleReason: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_idle_reason",
+			Help:      "Inferred reason an idle process is idle (never-active, stalled, waiting, finished). 1 for the current reason; absent while active.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, processReasonLabels),
+		processMemoryless: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_memoryless",
+			Help:      "1 if this process reported SM utilization but holds no GPU memory (util-only sample), 0 otherwise. Memoryless processes are never idle.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, processLabels),
+		processConfidence: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_idle_confidence",
+			Help:      "Confidence (0-1) in this process's idle state: 1 with a fresh per-process utilization sample, lower when the sample is stale, absent while the GPU is busy, or unavailable.",
+			Unit:      unitRatio,
+			Stability: stabilityExperimental,
+		}, processLabels),
+		processEfficiency: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_efficiency",
+			Help:      "Smoothed SM utilization percentage per percent of device memory held by this process. 1 means compute in proportion to memory; lower is worse. Absent for processes holding no memory.",
+			Unit:      unitRatio,
+			Stability: stabilityExperimental,
+		}, processLabels),
+		processEngineUtil: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_engine_utilization_percent",
+			Help:      "Utilization percentage of this process per GPU engine (sm, memory, encoder, decoder). Non-SM engines read 0 on drivers without a per-process breakdown.",
+			Unit:      unitPercent,
+			Stability: stabilityStable,
+		}, processEngineLabels),
+		processDataMoved: cat.counterVec(metricDef{
+			Name:      "gpu_idle_process_data_moved_bytes_total",
+			Help:      "Bytes this process moved over NVLink and PCIe. Absent when per-process data movement is unavailable.",
+			Unit:      unitBytes,
+			Stability: stabilityExperimental,
+		}, processLabels),
+		processStreams: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_active_streams",
+			Help:      "Live CUDA streams of this process. Absent when the count is unavailable.",
+			Unit:      unitCount,
+			Stability: stabilityExperimental,
+		}, processLabels),
+
+		processNodeIdle: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_node_idle",
+			Help:      "1 if this process is idle on every GPU it occupies on this node, 0 otherwise.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, nodeProcessLabels),
+		processNodeIdleSecs: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_process_node_idle_seconds",
+			Help:      "Shortest idle duration across all GPUs this process occupies. 0 unless idle on every GPU.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}, nodeProcessLabels),
+
+		deviceUnattributed: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_unattributed_utilization",
+			Help:      "1 if the GPU is busy but no visible process shows SM utilization, so per-process idle accounting is unreliable on this GPU. 0 otherwise.",
+			Unit:      unitBoolean,
+			Stability: stabilityExperimental,
+		}, gpuOnlyLabel),
+
+		deviceUtilHist: cat.histogramVec(metricDef{
+			Name:      "gpu_idle_device_utilization_histogram",
+			Help:      "Distribution of GPU compute utilization percentage, observed once per poll. Shows how much of the time a GPU spends near 0%.",
+			Unit:      unitPercent,
+			Stability: stabilityStable,
+			Buckets:   utilizationBuckets,
 		}, gpuOnlyLabel),
 
+		deviceCPUAffinity: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_cpu_affinity_info",
+			Help:      "CPUs with ideal affinity to this GPU (cpuset list format in the cpus label). Always 1.",
+			Unit:      unitInfo,
+			Stability: stabilityStable,
+		}, cpuAffinityLabels),
+		deviceBoard: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_board_info",
+			Help:      "Board this GPU is mounted on. GPUs of a multi-GPU board share a board_id. Always 1.",
+			Unit:      unitInfo,
+			Stability: stabilityStable,
+		}, deviceBoardLabels),
+		deviceSerial: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_serial_info",
+			Help:      "Serial number of this GPU's board, for RMA and asset tracking. Omitted if unsupported. Always 1.",
+			Unit:      unitInfo,
+			Stability: stabilityStable,
+		}, deviceSerialLabels),
+		deviceFirmware: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_firmware_info",
+			Help:      "VBIOS version and board part number of this GPU, for firmware audits. A label is empty if the GPU doesn't report it; omitted if it reports neither. Always 1.",
+			Unit:      unitInfo,
+			Stability: stabilityStable,
+		}, firmwareLabels),
+		deviceMigMode: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_mig_mode",
+			Help:      "Current and pending MIG mode (enabled or disabled) of MIG-capable GPUs. 1 if the pending mode differs from the current one: the GPU is awaiting a reset and can't be used until then.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, migModeLabels),
+
+		boardPower: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_board_power_watts",
+			Help:      "Current power draw of the board in watts. Use instead of summing gpu_idle_device_power_watts, which can double-count on multi-GPU boards.",
+			Unit:      unitWatts,
+			Stability: stabilityStable,
+		}, boardOnlyLabel),
+
+		idleMemTotal: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_memory_total_bytes",
+			Help:      "Total GPU memory in bytes held by all idle processes on this GPU.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		safelyReclaimable: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_safely_reclaimable_bytes",
+			Help:      "GPU memory in bytes held by non-exempt processes idle for at least the reclaim safety duration on this GPU.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		idleMemByDuration: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_memory_by_duration_bytes",
+			Help:      "GPU memory in bytes held by idle processes on this GPU, by how long they have been idle (0-1m, 1m-10m, 10m-1h, 1h+).",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, []string{"gpu", "duration_bucket"}),
+		occupancy: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_process_occupancy_ratio",
+			Help:      "Processes on this GPU divided by the configured maximum processes per GPU, capped at 1. Absent unless a maximum is configured.",
+			Unit:      unitRatio,
+			Stability: stabilityExperimental,
+		}, gpuOnlyLabel),
+
+		distinctIdleUsers: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_distinct_idle_users",
+			Help:      "Number of distinct users (UIDs) owning at least one idle process on this GPU.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		nodeDistinctIdleUsers: cat.gauge(metricDef{
+			Name:      "gpu_idle_node_distinct_idle_users",
+			Help:      "Number of distinct users (UIDs) owning at least one idle process on any GPU of this node.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}),
+		userIdleMem: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_user_idle_memory_bytes",
+			Help:      "GPU memory in bytes held by this user's idle processes across all GPUs.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, userOnlyLabel),
+		eccViolation: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_ecc_policy_violation",
+			Help:      "1 if this GPU is expected to have ECC enabled but it is disabled, 0 if it is enabled. Only emitted for GPUs configured as expecting ECC.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		deviceIdleSecs: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_idle_seconds",
+			Help:      "How long this GPU has been idle as a whole: utilization at or below the idle threshold and no active process. 0 while it is in use.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		deviceSquatted: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_device_squatted",
+			Help:      "1 while a single idle process holds more than the configured share of this GPU's memory and has been idle for at least the configured duration, e.g. a crashed job squatting on the GPU. Absent otherwise.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, processLabels),
+		userIdleRatio: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_user_idle_memory_ratio",
+			Help:      "Fraction (0-1) of the GPU memory held by this user's processes across all GPUs that is held by idle processes.",
+			Unit:      unitRatio,
+			Stability: stabilityStable,
+		}, userOnlyLabel),
+
+		migMemUsed: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_mig_instance_memory_used_bytes",
+			Help:      "Sum of GPU memory in bytes held by processes in this MIG instance.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, migInstanceLabels),
+		migMemTotal: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_mig_instance_memory_total_bytes",
+			Help:      "Memory capacity in bytes of this MIG instance.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, migInstanceLabels),
+		migIdleMemRatio: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_mig_instance_idle_memory_ratio",
+			Help:      "Fraction (0-1) of this MIG instance's memory capacity held by idle processes.",
+			Unit:      unitRatio,
+			Stability: stabilityStable,
+		}, migInstanceLabels),
+		migUtil: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_mig_instance_utilization_percent",
+			Help:      "Compute utilization percentage of this MIG instance. Absent where the driver doesn't report it.",
+			Unit:      unitPercent,
+			Stability: stabilityExperimental,
+		}, migInstanceLabels),
+		migProcesses: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_mig_instance_processes",
+			Help:      "Number of processes running in this MIG instance.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, migInstanceLabels),
+		migIdleMem: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_mig_instance_idle_memory_bytes",
+			Help:      "GPU memory in bytes held by idle processes in this MIG instance.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, migInstanceLabels),
+		migIdleProcs: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_mig_instance_idle_processes",
+			Help:      "Number of idle processes in this MIG instance.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, migInstanceLabels),
+		physIdleMem: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_physical_gpu_idle_memory_bytes",
+			Help:      "GPU memory in bytes held by idle processes on this physical GPU, summed across its MIG instances. Equals the device's idle memory on GPUs without MIG.",
+			Unit:      unitBytes,
+			Stability: stabilityStable,
+		}, physicalGPULabels),
+		physIdleProcs: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_physical_gpu_idle_processes",
+			Help:      "Number of idle processes on this physical GPU, summed across its MIG instances.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, physicalGPULabels),
+
+		deviceIdleMemByteSecs: cat.counterVec(metricDef{
+			Name:      "gpu_idle_device_idle_memory_byte_seconds_total",
+			Help:      "Cumulative idle GPU memory integrated over time (byte-seconds) on this GPU.",
+			Unit:      unitByteSeconds,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		deviceBusySecs: cat.counterVec(metricDef{
+			Name:      "gpu_idle_busy_gpu_seconds_total",
+			Help:      "Cumulative GPU utilization integrated over time on this GPU: seconds the GPU would have been fully busy to do the same work.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+
+		collectorPanics: cat.counterVec(metricDef{
+			Name:      "gpu_idle_collector_panics_total",
+			Help:      "Number of recovered panics while collecting this GPU. The GPU is skipped for that poll.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		deviceTimeouts: cat.counterVec(metricDef{
+			Name:      "gpu_idle_device_collection_timeout_total",
+			Help:      "Number of polls in which collecting this GPU exceeded the NVML call timeout, or its calls from an earlier poll were still blocked. The GPU is skipped for that poll.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		deviceCount: cat.gauge(metricDef{
+			Name:      "gpu_idle_device_count",
+			Help:      "Number of GPUs NVML reports, including GPUs excluded by the GPU filter or skipped this poll.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}),
+		nvmlInitialized: cat.gauge(metricDef{
+			Name:      "gpu_idle_nvml_initialized",
+			Help:      "1 once NVML has been initialized at startup, 0 while initialization is still being retried. Always 0 in synthetic mode.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}),
+		buildInfo: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_exporter_build_info",
+			Help:      "Version and commit of the running exporter and the Go version it was built with. Always 1.",
+			Unit:      unitInfo,
+			Stability: stabilityStable,
+		}, []string{"version", "commit", "go_version"}),
+		systemInfo: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_system_info",
+			Help:      "Versions of the node's NVIDIA driver, the CUDA version it supports and NVML, read once NVML is initialized. A label is empty if NVML can't report it. Always 1.",
+			Unit:      unitInfo,
+			Stability: stabilityStable,
+		}, []string{"driver_version", "cuda_version", "nvml_version"}),
+		collectionSuspended: cat.gauge(metricDef{
+			Name:      "gpu_idle_collection_suspended",
+			Help:      "1 while NVML is re-initializing and metrics hold their last values from before, 0 otherwise.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}),
+		consecutiveFailures: cat.gauge(metricDef{
+			Name:      "gpu_idle_collector_consecutive_failures",
+			Help:      "Number of consecutive failed collection cycles. 0 when healthy.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}),
+		collectorDuration: cat.gauge(metricDef{
+			Name:      "gpu_idle_collector_duration_seconds",
+			Help:      "Time the latest collection cycle spent collecting from NVML and /proc, whether or not it succeeded.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}),
+		collectorErrors: cat.counter(metricDef{
+			Name:      "gpu_idle_collector_errors_total",
+			Help:      "Number of collection cycles that failed.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}),
+		lastCollection: cat.gauge(metricDef{
+			Name:      "gpu_idle_last_collection_timestamp_seconds",
+			Help:      "Unix time of the latest collection whose results were exported. 0 until the first one.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}),
+		procReadTimeouts: cat.counter(metricDef{
+			Name:      "gpu_idle_collector_proc_read_timeouts_total",
+			Help:      "Number of /proc reads (e.g. process names) that exceeded the read timeout.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}),
+		clockSkews: cat.counter(metricDef{
+			Name:      "gpu_idle_clock_skew_detected_total",
+			Help:      "Number of polls in which snapshot time went backwards. Affected idle durations were clamped to 0.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}),
+		persistencedHealthy: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_persistenced_healthy",
+			Help:      "1 if nvidia-persistenced is running and every GPU reporting a persistence mode has it enabled, 0 otherwise. Absent unless the check is enabled.",
+			Unit:      unitBoolean,
+			Stability: stabilityStable,
+		}, nil),
+		sampleWindow: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_collector_sample_window_seconds",
+			Help:      "How far back the driver's utilization samples reach, probed at startup. A poll interval far from it over- or under-counts activity. Absent where it couldn't be probed.",
+			Unit:      unitSeconds,
+			Stability: stabilityExperimental,
+		}, gpuOnlyLabel),
+		pollRestarts: cat.counter(metricDef{
+			Name:      "gpu_idle_poll_loop_restarts_total",
+			Help:      "Number of times the polling loop was restarted after a panic.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}),
+		stageSeconds: cat.gaugeVec(metricDef{
+			Name:      "gpu_idle_scrape_stage_seconds",
+			Help:      "Time the latest poll spent in each stage: device_collect, process_collect, name_resolve (/proc reads), tracker_update and metric_update.",
+			Unit:      unitSeconds,
+			Stability: stabilityExperimental,
+		}, []string{"stage"}),
+
+		idleEpisodes: cat.counterVec(metricDef{
+			Name:      "gpu_idle_episodes_total",
+			Help:      "Idle episodes that ended on this GPU, because the process became active again or exited. Episodes shorter than the minimum episode duration are not counted.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, gpuOnlyLabel),
+		episodeDurations: cat.histogram(metricDef{
+			Name:      "gpu_idle_episode_duration_seconds",
+			Help:      "Duration of ended idle episodes, observed once per episode. Episodes shorter than the minimum episode duration are not observed.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+			Buckets:   episodeDurationBuckets,
+		}),
+
+		processesSeen: cat.counter(metricDef{
+			Name:      "gpu_idle_processes_seen_total",
+			Help:      "Processes seen for the first time on a GPU. A process on several GPUs counts once per GPU.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}),
+		newProcessRate: cat.gauge(metricDef{
+			Name:      "gpu_idle_new_processes_per_second",
+			Help:      "Rate of processes first seen in the latest poll, per second since the previous poll. Sustained high values indicate crash-looping jobs.",
+			Unit:      unitPerSecond,
+			Stability: stabilityStable,
+		}),
+
+		configSmThreshold: cat.gauge(metricDef{
+			Name:      "gpu_idle_config_sm_threshold",
+			Help:      "Configured SM utilization percentage at or below which a process counts as idle (global policy).",
+			Unit:      unitPercent,
+			Stability: stabilityStable,
+		}),
+		configGracePeriod: cat.gauge(metricDef{
+			Name:      "gpu_idle_config_grace_period_seconds",
+			Help:      "Configured time a process must stay at or below the SM threshold before it is marked idle (global policy).",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}),
+		configStaleTimeout: cat.gauge(metricDef{
+			Name:      "gpu_idle_config_stale_timeout_seconds",
+			Help:      "Configured time a vanished process is still tracked before it is forgotten.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}),
+		configPollInterval: cat.gauge(metricDef{
+			Name:      "gpu_idle_config_poll_interval_seconds",
+			Help:      "Configured interval between NVML polls.",
+			Unit:      unitSeconds,
+			Stability: stabilityStable,
+		}),
+		configInvalidEntries: cat.counterVec(metricDef{
+			Name:      "gpu_idle_config_invalid_entries_total",
+			Help:      "Entries dropped from a list option at startup because they were invalid or over the size limit. Any value above 0 means the configuration isn't fully applied.",
+			Unit:      unitCount,
+			Stability: stabilityStable,
+		}, []string{"option"}),
+
 		prevProcessKeys: make(map[string]bool),
+		prevIdleTotals:  make(map[string]time.Duration),
+		prevStatusKeys:  make(map[string]bool),
+		prevReasons:     make(map[string]string),
+		prevNodeKeys:    make(map[string]bool),
+		prevMigKeys:     make(map[string]bool),
+		prevPhysical:    make(map[string]bool),
+		prevAffinity:    make(map[string]string),
+		prevBoardOf:     make(map[string]string),
+		prevSerials:     make(map[string]bool),
+		prevFirmware:    make(map[string]bool),
+		prevMigModes:    make(map[string]bool),
+		prevBoards:      make(map[string]bool),
+		prevDeviceGPUs:  make(map[string]bool),
+		prevDeviceKeys:  make(map[string]prometheus.Labels),
+		prevUsers:       make(map[string]bool),
+		prevUserRatios:  make(map[string]bool),
+		prevECCPolicy:   make(map[string]bool),
+		prevSquatted:    make(map[string]bool),
+		prevDeviceIdle:  make(map[string]bool),
+
+		logger:             slog.Default(),
+		deviceLabels:       DefaultDeviceLabels,
+		reclaimSafetyDelay: DefaultReclaimSafetyDuration,
+		squatFraction:      DefaultSquatMemoryFraction,
+		squatMinIdle:       DefaultSquatMinIdle,
+	}
+	for _, opt := range opts {
+		opt(e)
 	}
+	if !e.systemGPUs.Empty() {
+		e.deviceLabels = append(e.deviceLabels[:len(e.deviceLabels):len(e.deviceLabels)], "role")
+	}
+	e.newDeviceGauges()
+	e.newProcessInfo()
+	return e
+}
+
+// newDeviceGauges defines the device-level gauges with the configured
+// device label set. Called once options have been applied.
+func (e *Exporter) newDeviceGauges() {
+	labels := e.deviceLabels
+	e.deviceUtil = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_utilization_percent",
+		Help:      "GPU compute utilization percentage (device-level).",
+		Unit:      unitPercent,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceUtilFine = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_utilization_fine_percent",
+		Help:      "GPU compute utilization percentage averaged over the driver's samples since the last poll, with sub-percent resolution.",
+		Unit:      unitPercent,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceMemUtil = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_memory_utilization_percent",
+		Help:      "Percentage of time the GPU memory controller was busy reading or writing device memory.",
+		Unit:      unitPercent,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceMemUsed = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_memory_used_bytes",
+		Help:      "GPU memory currently used in bytes (device-level).",
+		Unit:      unitBytes,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceMemTotal = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_memory_total_bytes",
+		Help:      "GPU total memory in bytes (device-level).",
+		Unit:      unitBytes,
+		Stability: stabilityStable,
+	}, labels)
+	e.devicePower = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_power_watts",
+		Help:      "GPU current power draw in watts.",
+		Unit:      unitWatts,
+		Stability: stabilityStable,
+	}, labels)
+	e.devicePowerLimit = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_power_limit_watts",
+		Help:      "GPU power management limit in watts.",
+		Unit:      unitWatts,
+		Stability: stabilityStable,
+	}, labels)
+	e.devicePowerLimitEnforced = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_power_limit_enforced_watts",
+		Help:      "GPU power limit actually enforced by the driver in watts, the lowest of all limits in effect.",
+		Unit:      unitWatts,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceThrottled = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_throttled",
+		Help:      "1 if clocks are currently held down for this reason, 0 otherwise. reason=\"idle\" means parked with nothing to run. Omitted if unsupported.",
+		Unit:      unitBoolean,
+		Stability: stabilityStable,
+	}, append(append([]string(nil), labels...), "reason"))
+	e.devicePersistence = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_persistence_mode",
+		Help:      "1 if persistence mode is enabled on this GPU, 0 otherwise. Omitted if unsupported.",
+		Unit:      unitBoolean,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceEccMode = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_ecc_mode",
+		Help:      "1 if ECC is enabled on this GPU, 0 otherwise. Changes take effect at the next reboot. Omitted if unsupported.",
+		Unit:      unitBoolean,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceTemp = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_temperature_celsius",
+		Help:      "GPU core temperature in Celsius.",
+		Unit:      unitCelsius,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceSmClock = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_sm_clock_mhz",
+		Help:      "Current SM clock in MHz.",
+		Unit:      unitMegahertz,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceMemClock = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_memory_clock_mhz",
+		Help:      "Current memory clock in MHz.",
+		Unit:      unitMegahertz,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceGraphicsClock = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_graphics_clock_mhz",
+		Help:      "Current graphics clock in MHz.",
+		Unit:      unitMegahertz,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceFanSpeed = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_fan_speed_percent",
+		Help:      "GPU fan speed as a percentage of maximum. Omitted for GPUs without a fan.",
+		Unit:      unitPercent,
+		Stability: stabilityStable,
+	}, labels)
+	e.devicePcieTx = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_pcie_tx_kilobytes_per_second",
+		Help:      "PCIe transmit throughput in KB/s, sampled by the driver over a short window. May read 0 on the first poll.",
+		Unit:      unitKilobytesPerSecond,
+		Stability: stabilityStable,
+	}, labels)
+	e.devicePcieRx = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_pcie_rx_kilobytes_per_second",
+		Help:      "PCIe receive throughput in KB/s, sampled by the driver over a short window. May read 0 on the first poll.",
+		Unit:      unitKilobytesPerSecond,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceEncoderUtil = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_encoder_utilization_percent",
+		Help:      "Video encoder (NVENC) utilization percentage.",
+		Unit:      unitPercent,
+		Stability: stabilityStable,
+	}, labels)
+	e.deviceDecoderUtil = e.catalog.gaugeVec(metricDef{
+		Name:      "gpu_idle_device_decoder_utilization_percent",
+		Help:      "Video decoder (NVDEC) utilization percentage.",
+		Unit:      unitPercent,
+		Stability: stabilityStable,
+	}, labels)
 }
 
 // Register registers all metrics with the Prometheus registry.
 func (e *Exporter) Register() {
-	e.registerer.MustRegister(
+	e.register(
 		e.processComputeUtil,
+		e.processSmoothUtil,
 		e.processMemUsed,
+		e.processMemFraction,
 		e.processIdleSecs,
+		e.processIdleTotal,
+		e.processResidency,
+		e.processSuspended,
 		e.processIdleMem,
+		e.processGPUFds,
+		e.processStatus,
+		e.processIdleReason,
+		e.processMemoryless,
+		e.processConfidence,
+		e.processEfficiency,
+		e.processStreams,
+		e.processDataMoved,
+		e.processEngineUtil,
+		e.processNodeIdle,
+		e.processNodeIdleSecs,
 		e.deviceUtil,
+		e.deviceUtilFine,
+		e.deviceMemUtil,
 		e.deviceMemUsed,
 		e.deviceMemTotal,
 		e.devicePower,
+		e.devicePowerLimit,
+		e.devicePowerLimitEnforced,
+		e.devicePersistence,
+		e.deviceEccMode,
+		e.eccViolation,
+		e.deviceSquatted,
+		e.deviceIdleSecs,
+		e.deviceThrottled,
 		e.deviceTemp,
+		e.deviceSmClock,
+		e.deviceMemClock,
+		e.deviceGraphicsClock,
+		e.deviceFanSpeed,
+		e.devicePcieTx,
+		e.devicePcieRx,
+		e.deviceEncoderUtil,
+		e.deviceDecoderUtil,
+		e.deviceUtilHist,
+		e.deviceUnattributed,
+		e.deviceCPUAffinity,
+		e.deviceBoard,
+		e.deviceSerial,
+		e.deviceFirmware,
+		e.deviceMigMode,
+		e.boardPower,
 		e.idleMemTotal,
+		e.safelyReclaimable,
+		e.idleMemByDuration,
+		e.occupancy,
+		e.distinctIdleUsers,
+		e.nodeDistinctIdleUsers,
+		e.migMemUsed,
+		e.migMemTotal,
+		e.migIdleMemRatio,
+		e.migUtil,
+		e.migProcesses,
+		e.migIdleMem,
+		e.migIdleProcs,
+		e.physIdleMem,
+		e.physIdleProcs,
+		e.deviceIdleMemByteSecs,
+		e.deviceBusySecs,
+		e.collectorPanics,
+		e.deviceTimeouts,
+		e.deviceCount,
+		e.consecutiveFailures,
+		e.collectorDuration,
+		e.collectorErrors,
+		e.lastCollection,
+		e.collectionSuspended,
+		e.nvmlInitialized,
+		e.buildInfo,
+		e.systemInfo,
+		e.procReadTimeouts,
+		e.clockSkews,
+		e.pollRestarts,
+		e.stageSeconds,
+		e.persistencedHealthy,
+		e.idleEpisodes,
+		e.episodeDurations,
+		e.processesSeen,
+		e.configSmThreshold,
+		e.configGracePeriod,
+		e.configStaleTimeout,
+		e.configPollInterval,
+		e.configInvalidEntries,
+		e.sampleWindow,
+		e.newProcessRate,
 	)
+	if e.dcgm != nil {
+		e.register(e.dcgm.collectors()...)
+	}
+	if e.idleMemNative != nil {
+		e.register(e.idleMemNative)
+	}
+	if e.processInfo != nil {
+		e.register(e.processInfo)
+	}
+	if e.userName != nil {
+		e.register(e.userIdleMem, e.userIdleRatio)
+	}
+}
+
+// SetConsecutiveFailures records how many collection cycles in a row have failed.
+func (e *Exporter) SetConsecutiveFailures(n int) {
+	e.consecutiveFailures.Set(float64(n))
+}
+
+// RecordCollection records the time a collection cycle took and counts it
+// as failed if err is non-nil.
+func (e *Exporter) RecordCollection(d time.Duration, err error) {
+	e.collectorDuration.Set(d.Seconds())
+	if err != nil {
+		e.collectorErrors.Inc()
+	}
+}
+
+// SetLastCollection records when the latest exported collection was made.
+func (e *Exporter) SetLastCollection(t time.Time) {
+	e.lastCollection.Set(float64(t.UnixNano()) / 1e9)
+}
+
+// SetBuildInfo records the exporter's version and commit.
+func (e *Exporter) SetBuildInfo(version, commit string) {
+	e.buildInfo.WithLabelValues(version, commit, runtime.Version()).Set(1)
+}
+
+// SetSystemInfo records the versions of the node's NVIDIA software stack.
+// They don't change while the exporter runs, so it is called once.
+func (e *Exporter) SetSystemInfo(driverVersion, cudaVersion, nvmlVersion string) {
+	e.systemInfo.WithLabelValues(driverVersion, cudaVersion, nvmlVersion).Set(1)
+}
+
+// SetNVMLInitialized records whether NVML has been initialized.
+func (e *Exporter) SetNVMLInitialized(initialized bool) {
+	v := 0.0
+	if initialized {
+		v = 1
+	}
+	e.nvmlInitialized.Set(v)
+}
+
+// SetNVMLReinitializing records whether NVML is being re-initialized:
+// gpu_idle_nvml_initialized is 0 from when it starts until a collection
+// succeeds again, which needs NVML initialized.
+func (e *Exporter) SetNVMLReinitializing(reinitializing bool) {
+	if reinitializing {
+		e.nvmlReinitializing = true
+		e.nvmlInitialized.Set(0)
+	} else if e.nvmlReinitializing {
+		e.nvmlReinitializing = false
+		e.nvmlInitialized.Set(1)
+	}
+}
+
+// SetCollectionSuspended records whether metric updates are suspended while
+// NVML re-initializes. The caller skips UpdateMetrics meanwhile, so the
+// other metrics keep their last values.
+func (e *Exporter) SetCollectionSuspended(suspended bool) {
+	v := 0.0
+	if suspended {
+		v = 1
+	}
+	e.collectionSuspended.Set(v)
+}
+
+// SetConfig exposes the effective idle configuration, so dashboards and
+// alerts can refer to it instead of hard-coding it. Namespace overrides are
+// not included.
+func (e *Exporter) SetConfig(policy idle.Policy, staleTimeout, pollInterval time.Duration) {
+	e.configSmThreshold.Set(float64(policy.SmThreshold))
+	e.configGracePeriod.Set(policy.GracePeriod.Seconds())
+	e.configStaleTimeout.Set(staleTimeout.Seconds())
+	e.configPollInterval.Set(pollInterval.Seconds())
+}
+
+// RecordInvalidConfigEntries counts n entries dropped from the list option
+// with the given name, e.g. DEVICE_LABELS.
+func (e *Exporter) RecordInvalidConfigEntries(option string, n int) {
+	e.configInvalidEntries.WithLabelValues(option).Add(float64(n))
+}
+
+// SetSampleWindows exposes the probed utilization sample window per GPU index.
+func (e *Exporter) SetSampleWindows(windows map[int]time.Duration) {
+	for gpu, w := range windows {
+		e.sampleWindow.WithLabelValues(strconv.Itoa(gpu)).Set(w.Seconds())
+	}
+}
+
+// RecordPollRestart counts a restart of the polling loop after a panic.
+func (e *Exporter) RecordPollRestart() {
+	e.pollRestarts.Inc()
+}
+
+// RecordStageTimings records how long each stage of the latest poll took:
+// the collector's stages from the snapshot, then the tracker and metric
+// updates timed by the caller.
+func (e *Exporter) RecordStageTimings(collect collector.StageTimings, trackerUpdate, metricUpdate time.Duration) {
+	e.stageSeconds.WithLabelValues("device_collect").Set(collect.DeviceCollect.Seconds())
+	e.stageSeconds.WithLabelValues("process_collect").Set(collect.ProcessCollect.Seconds())
+	e.stageSeconds.WithLabelValues("name_resolve").Set(collect.NameResolve.Seconds())
+	e.stageSeconds.WithLabelValues("tracker_update").Set(trackerUpdate.Seconds())
+	e.stageSeconds.WithLabelValues("metric_update").Set(metricUpdate.Seconds())
+}
+
+// RecordClockSkew counts a poll in which the tracker saw time go backwards.
+func (e *Exporter) RecordClockSkew() {
+	e.clockSkews.Inc()
+}
+
+// RecordEpisodes counts idle episodes that ended, as returned by the
+// tracker's ClosedEpisodes, and observes their durations.
+func (e *Exporter) RecordEpisodes(episodes []idle.Episode) {
+	for _, ep := range episodes {
+		e.idleEpisodes.WithLabelValues(strconv.Itoa(ep.GPU)).Inc()
+		e.episodeDurations.Observe(ep.Duration().Seconds())
+	}
+}
+
+// SetDeviceIdle records how long each GPU has been idle as a whole, as
+// returned by the tracker's Devices, dropping series for GPUs that are gone.
+func (e *Exporter) SetDeviceIdle(devices []idle.DeviceIdleState) {
+	current := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		gpuStr := strconv.Itoa(d.GPU)
+		current[gpuStr] = true
+		e.deviceIdleSecs.WithLabelValues(gpuStr).Set(d.IdleDuration.Seconds())
+	}
+	for gpu := range e.prevDeviceIdle {
+		if !current[gpu] {
+			e.deviceIdleSecs.DeleteLabelValues(gpu)
+		}
+	}
+	e.prevDeviceIdle = current
+}
+
+// RecordProcessChurn records the processes first seen in the latest poll,
+// and their rate, as reported by the tracker.
+func (e *Exporter) RecordProcessChurn(newProcesses int, perSecond float64) {
+	e.processesSeen.Add(float64(newProcesses))
+	e.newProcessRate.Set(perSecond)
+}
+
+// processEngines are the values of the engine label, in EngineUtil order.
+var processEngines = []string{"sm", "memory", "encoder", "decoder"}
+
+// processStatuses are the mutually exclusive values of the status label.
+var processStatuses = []string{"active", "idle", "stale"}
+
+// setProcessStatus sets the StateSet series for one process so that only
+// the given status is 1.
+func (e *Exporter) setProcessStatus(gpuStr, pidStr, process, mig, status string) {
+	for _, s := range processStatuses {
+		v := 0.0
+		if s == status {
+			v = 1
+		}
+		e.processStatus.With(prometheus.Labels{"gpu": gpuStr, "pid": pidStr, "process": process, "mig_instance": mig, "status": s}).Set(v)
+	}
 }
 
-// UpdateMetrics sets all Prometheus gauges from the latest snapshot and idle states.
-func (e *Exporter) UpdateMetrics(snap *collector.Snapshot, states []idle.ProcessIdleState) {
+// statusEnded is the terminal status set by MarkProcessesEnded. It is not
+// one of processStatuses, so it costs no series during normal operation.
+const statusEnded = "ended"
+
+// MarkProcessesEnded sets every process with status series to
+// status="ended", so a final scrape before the exporter exits shows the
+// processes as over instead of freezing their last state. It returns how
+// many processes were marked. Call it once the polling loop has stopped;
+// the next UpdateMetrics would undo it.
+func (e *Exporter) MarkProcessesEnded() int {
+	for key := range e.prevStatusKeys {
+		parts := strings.SplitN(key, "\x00", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		e.setProcessStatus(parts[0], parts[1], parts[2], parts[3], statusEnded)
+		e.processStatus.With(prometheus.Labels{"gpu": parts[0], "pid": parts[1], "process": parts[2], "mig_instance": parts[3], "status": statusEnded}).Set(1)
+	}
+	return len(e.prevStatusKeys)
+}
+
+// nodeIdle accumulates one PID's idle verdict across its GPUs; in
+// aggregated mode, that of every process of one name.
+type nodeIdle struct {
+	pid         string
+	process     string
+	allIdle     bool
+	minIdleSecs float64
+}
+
+// updateNodeIdle sets the node-level idle gauges: a PID is node-idle only if
+// it is idle on every GPU it occupies, so a distributed job busy on some
+// ranks is not flagged.
+func (e *Exporter) updateNodeIdle(states []idle.ProcessIdleState) {
+	byPID := make(map[string]*nodeIdle)
+	for _, ps := range states {
+		pidStr := e.pidLabel(ps.PID)
+		key := pidStr + "\x00" + ps.ProcessName
+		n, ok := byPID[key]
+		if !ok {
+			n = &nodeIdle{pid: pidStr, process: ps.ProcessName, allIdle: true, minIdleSecs: ps.IdleDuration.Seconds()}
+			byPID[key] = n
+		}
+		if !ps.IsIdle || ps.System {
+			n.allIdle = false
+		}
+		if secs := ps.IdleDuration.Seconds(); secs < n.minIdleSecs {
+			n.minIdleSecs = secs
+		}
+	}
+
+	currentKeys := make(map[string]bool, len(byPID))
+	for key, n := range byPID {
+		labels := prometheus.Labels{"pid": n.pid, "process": n.process}
+		if e.idleOnly && !n.allIdle {
+			continue
+		}
+		currentKeys[key] = true
+
+		if n.allIdle {
+			e.processNodeIdle.With(labels).Set(1)
+			e.processNodeIdleSecs.With(labels).Set(n.minIdleSecs)
+		} else {
+			e.processNodeIdle.With(labels).Set(0)
+			e.processNodeIdleSecs.With(labels).Set(0)
+		}
+	}
+
+	for prevKey := range e.prevNodeKeys {
+		if !currentKeys[prevKey] {
+			parts := strings.SplitN(prevKey, "\x00", 2)
+			if len(parts) == 2 {
+				labels := prometheus.Labels{"pid": parts[0], "process": parts[1]}
+				e.processNodeIdle.Delete(labels)
+				e.processNodeIdleSecs.Delete(labels)
+			}
+		}
+	}
+	e.prevNodeKeys = currentKeys
+}
+
+// updateMigInstances sets per-MIG-instance gauges: memory held by the
+// instance's own processes against the instance's capacity, the process
+// count and utilization. Instances without processes report 0 used.
+func (e *Exporter) updateMigInstances(snap *collector.Snapshot, states []idle.ProcessIdleState) {
+	type migKey struct {
+		gpu      int
+		instance string
+	}
+	used := make(map[migKey]uint64)
+	procs := make(map[migKey]int)
+	for _, p := range snap.Processes {
+		if p.MigInstance != "" {
+			used[migKey{p.GPU, p.MigInstance}] += p.UsedMemory
+			procs[migKey{p.GPU, p.MigInstance}]++
+		}
+	}
+	idleMem := make(map[migKey]uint64)
+	idleProcs := make(map[migKey]int)
+	for _, ps := range states {
+		if ps.MigInstance != "" && !ps.System {
+			idleMem[migKey{ps.GPU, ps.MigInstance}] += ps.IdleMemory
+			if ps.IsIdle {
+				idleProcs[migKey{ps.GPU, ps.MigInstance}]++
+			}
+		}
+	}
+
+	currentKeys := make(map[string]bool)
+	for _, d := range snap.Devices {
+		gpuStr := strconv.Itoa(d.Index)
+		for _, inst := range d.MigInstances {
+			k := migKey{d.Index, inst.ID}
+			labels := prometheus.Labels{"gpu": gpuStr, "mig_instance": inst.ID}
+			currentKeys[gpuStr+"\x00"+inst.ID] = true
+
+			e.migMemUsed.With(labels).Set(float64(used[k]))
+			e.migMemTotal.With(labels).Set(float64(inst.MemoryTotal))
+			ratio := 0.0
+			if inst.MemoryTotal > 0 {
+				ratio = float64(idleMem[k]) / float64(inst.MemoryTotal)
+			}
+			e.migIdleMemRatio.With(labels).Set(ratio)
+			e.migProcesses.With(labels).Set(float64(procs[k]))
+			e.migIdleMem.With(labels).Set(float64(idleMem[k]))
+			e.migIdleProcs.With(labels).Set(float64(idleProcs[k]))
+			setIfKnown(e.migUtil, labels, float64(inst.Utilization), inst.HasUtilization)
+		}
+	}
+
+	for prevKey := range e.prevMigKeys {
+		if !currentKeys[prevKey] {
+			parts := strings.SplitN(prevKey, "\x00", 2)
+			if len(parts) == 2 {
+				labels := prometheus.Labels{"gpu": parts[0], "mig_instance": parts[1]}
+				e.migMemUsed.Delete(labels)
+				e.migMemTotal.Delete(labels)
+				e.migIdleMemRatio.Delete(labels)
+				e.migUtil.Delete(labels)
+				e.migProcesses.Delete(labels)
+				e.migIdleMem.Delete(labels)
+				e.migIdleProcs.Delete(labels)
+			}
+		}
+	}
+	e.prevMigKeys = currentKeys
+}
+
+// updatePhysicalGPUs rolls idle memory and processes up to each physical
+// GPU. Processes in MIG instances are reported against their parent GPU, so
+// summing by GPU covers every instance, and a GPU without MIG rolls up to
+// itself. Together with the per-instance series this lets dashboards switch
+// between physical and instance granularity.
+func (e *Exporter) updatePhysicalGPUs(snap *collector.Snapshot, states []idle.ProcessIdleState) {
+	idleMem := make(map[int]uint64)
+	idleProcs := make(map[int]int)
+	for _, ps := range states {
+		if ps.IsIdle && !ps.System {
+			idleMem[ps.GPU] += ps.IdleMemory
+			idleProcs[ps.GPU]++
+		}
+	}
+	current := make(map[string]bool, len(snap.Devices))
+	for _, d := range snap.Devices {
+		gpuStr := strconv.Itoa(d.Index)
+		current[gpuStr+"\x00"+d.UUID] = true
+		e.physIdleMem.WithLabelValues(gpuStr, d.UUID).Set(float64(idleMem[d.Index]))
+		e.physIdleProcs.WithLabelValues(gpuStr, d.UUID).Set(float64(idleProcs[d.Index]))
+	}
+	for key := range e.prevPhysical {
+		if !current[key] {
+			labels := strings.SplitN(key, "\x00", 2)
+			e.physIdleMem.DeleteLabelValues(labels...)
+			e.physIdleProcs.DeleteLabelValues(labels...)
+		}
+	}
+	e.prevPhysical = current
+}
+
+// idleDurationBuckets partition idle memory by how long it has been idle,
+// separating transient dips from long-idle memory. Each bucket holds
+// durations below its upper bound; the last is unbounded.
+var idleDurationBuckets = []struct {
+	name  string
+	upper time.Duration
+}{
+	{"0-1m", time.Minute},
+	{"1m-10m", 10 * time.Minute},
+	{"10m-1h", time.Hour},
+	{"1h+", 0},
+}
+
+// idleDurationBucket returns the index in idleDurationBuckets for d.
+func idleDurationBucket(d time.Duration) int {
+	for i, b := range idleDurationBuckets[:len(idleDurationBuckets)-1] {
+		if d < b.upper {
+			return i
+		}
+	}
+	return len(idleDurationBuckets) - 1
+}
+
+// efficiency scores a process by the compute it gets out of the memory it
+// holds: SM utilization percent divided by the percent of device memory
+// held. A process holding half the GPU at 5% scores 0.1; one holding 2.5% of
+// it at 90% scores 36. Undefined (ok=false) without memory or a known total.
+func efficiency(smUtil float64, used, total uint64) (score float64, ok bool) {
+	if used == 0 || total == 0 {
+		return 0, false
+	}
+	return smUtil / (float64(used) / float64(total) * 100), true
+}
+
+// Thresholds for gpu_idle_device_unattributed_utilization: the device is
+// above unattributedDeviceUtil percent while no process exceeds
+// unattributedProcessUtil percent SM utilization.
+const (
+	unattributedDeviceUtil  = 10
+	unattributedProcessUtil = 1
+)
+
+// updateUnattributed flags GPUs whose utilization isn't explained by any
+// visible process, e.g. work from processes outside our PID namespace.
+func (e *Exporter) updateUnattributed(snap *collector.Snapshot, states []idle.ProcessIdleState) {
+	maxProcUtil := make(map[int]uint32)
+	for _, ps := range states {
+		maxProcUtil[ps.GPU] = max(maxProcUtil[ps.GPU], ps.SmUtil)
+	}
+	for _, d := range snap.Devices {
+		v := 0.0
+		if d.Utilization > unattributedDeviceUtil && maxProcUtil[d.Index] <= unattributedProcessUtil {
+			v = 1
+		}
+		e.deviceUnattributed.With(prometheus.Labels{"gpu": strconv.Itoa(d.Index)}).Set(v)
+	}
+}
+
+// WithUserNames enables gpu_idle_user_idle_memory_bytes, labelled with the
+// user names that name resolves UIDs to (e.g. collector.UserResolver.Name).
+func WithUserNames(name func(uid uint32) string) Option {
+	return func(e *Exporter) { e.userName = name }
+}
+
+// updateIdleUsers counts the distinct owners of idle processes per GPU and
+// node-wide and, if enabled, breaks idle memory down by user. Processes
+// whose UID couldn't be read are not counted.
+func (e *Exporter) updateIdleUsers(snap *collector.Snapshot, states []idle.ProcessIdleState) {
+	usersByGPU := make(map[int]map[uint32]bool)
+	nodeUsers := make(map[uint32]bool)
+	memByUser := make(map[uint32]uint64)
+	heldByUser := make(map[uint32]uint64)
+	for _, ps := range states {
+		uid, ok := snap.ProcessUIDs[ps.PID]
+		if !ok {
+			continue
+		}
+		heldByUser[uid] += ps.UsedMemory
+		if !ps.IsIdle || ps.System {
+			continue
+		}
+		if usersByGPU[ps.GPU] == nil {
+			usersByGPU[ps.GPU] = make(map[uint32]bool)
+		}
+		usersByGPU[ps.GPU][uid] = true
+		nodeUsers[uid] = true
+		memByUser[uid] += ps.IdleMemory
+	}
+
+	for _, d := range snap.Devices {
+		e.distinctIdleUsers.With(prometheus.Labels{"gpu": strconv.Itoa(d.Index)}).Set(float64(len(usersByGPU[d.Index])))
+	}
+	e.nodeDistinctIdleUsers.Set(float64(len(nodeUsers)))
+
+	if e.userName == nil {
+		return
+	}
+	memByName := make(map[string]uint64, len(memByUser))
+	for uid, mem := range memByUser {
+		memByName[e.userName(uid)] += mem
+	}
+	heldByName := make(map[string]uint64, len(heldByUser))
+	for uid, mem := range heldByUser {
+		heldByName[e.userName(uid)] += mem
+	}
+	currentUsers := make(map[string]bool, len(memByName))
+	for user, mem := range memByName {
+		currentUsers[user] = true
+		e.userIdleMem.With(prometheus.Labels{"user": user}).Set(float64(mem))
+	}
+	for user := range e.prevUsers {
+		if !currentUsers[user] {
+			e.userIdleMem.Delete(prometheus.Labels{"user": user})
+		}
+	}
+	e.prevUsers = currentUsers
+
+	// Users holding no memory have no ratio
+	currentRatios := make(map[string]bool, len(heldByName))
+	for user, held := range heldByName {
+		if held == 0 {
+			continue
+		}
+		currentRatios[user] = true
+		e.userIdleRatio.With(prometheus.Labels{"user": user}).Set(float64(memByName[user]) / float64(held))
+	}
+	for user := range e.prevUserRatios {
+		if !currentRatios[user] {
+			e.userIdleRatio.Delete(prometheus.Labels{"user": user})
+		}
+	}
+	e.prevUserRatios = currentRatios
+}
+
+// updateCPUAffinity sets the CPU affinity info metric, replacing the series
+// if a GPU's affinity changes and dropping it for GPUs that report none.
+func (e *Exporter) updateCPUAffinity(snap *collector.Snapshot) {
+	current := make(map[string]string, len(snap.Devices))
+	for _, d := range snap.Devices {
+		if d.CPUAffinity == "" {
+			continue
+		}
+		gpuStr := strconv.Itoa(d.Index)
+		current[gpuStr] = d.CPUAffinity
+		e.deviceCPUAffinity.With(prometheus.Labels{"gpu": gpuStr, "cpus": d.CPUAffinity}).Set(1)
+	}
+	for gpu, cpus := range e.prevAffinity {
+		if current[gpu] != cpus {
+			e.deviceCPUAffinity.Delete(prometheus.Labels{"gpu": gpu, "cpus": cpus})
+		}
+	}
+	e.prevAffinity = current
+}
+
+// updateThrottled sets one series per known throttle reason for d.
+func (e *Exporter) updateThrottled(d collector.DeviceInfo, labels prometheus.Labels) {
+	for _, r := range collector.ThrottleReasons {
+		reasonLabels := prometheus.Labels{"reason": r.Name}
+		for k, v := range labels {
+			reasonLabels[k] = v
+		}
+		v := 0.0
+		if d.Throttled(r) {
+			v = 1
+		}
+		setIfKnown(e.deviceThrottled, reasonLabels, v, d.HasThrottleReasons)
+	}
+}
+
+// deviceLabelKey joins the values of a device label set, in label order.
+func (e *Exporter) deviceLabelKey(labels prometheus.Labels) string {
+	values := make([]string, len(e.deviceLabels))
+	for i, l := range e.deviceLabels {
+		values[i] = labels[l]
+	}
+	return strings.Join(values, "\x00")
+}
+
+// deleteStaleDevices removes the device-level series of label sets not
+// emitted this cycle: GPUs that disappeared, or whose UUID, model or bus ID
+// changed at an index, as when GPUs come back renumbered after a reset.
+// Without it, a renumbered GPU would carry series under both indices.
+func (e *Exporter) deleteStaleDevices(current map[string]prometheus.Labels) {
+	for key, labels := range e.prevDeviceKeys {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		for _, g := range []*prometheus.GaugeVec{
+			e.deviceUtil, e.deviceUtilFine, e.deviceMemUtil, e.deviceMemUsed, e.deviceMemTotal,
+			e.devicePower, e.devicePowerLimit, e.devicePowerLimitEnforced, e.devicePersistence,
+			e.deviceEccMode, e.deviceTemp, e.deviceSmClock, e.deviceMemClock, e.deviceGraphicsClock,
+			e.deviceFanSpeed, e.devicePcieTx, e.devicePcieRx, e.deviceEncoderUtil, e.deviceDecoderUtil,
+		} {
+			g.Delete(labels)
+		}
+		e.deviceThrottled.DeletePartialMatch(labels)
+	}
+	e.prevDeviceKeys = current
+}
+
+// setIfKnown sets the series to v if ok, and otherwise removes it, so an
+// unsupported reading is omitted rather than reported as 0.
+func setIfKnown(g *prometheus.GaugeVec, labels prometheus.Labels, v float64, ok bool) {
+	if ok {
+		g.With(labels).Set(v)
+	} else {
+		g.Delete(labels)
+	}
+}
+
+// updateSerials sets the serial info metric, dropping series for GPUs that
+// are gone or whose serial or UUID changed (e.g. after a replacement).
+func (e *Exporter) updateSerials(snap *collector.Snapshot) {
+	current := make(map[string]bool, len(snap.Devices))
+	for _, d := range snap.Devices {
+		if d.Serial == "" {
+			continue
+		}
+		gpuStr := strconv.Itoa(d.Index)
+		current[gpuStr+"\x00"+d.UUID+"\x00"+d.Serial] = true
+		e.deviceSerial.With(prometheus.Labels{"gpu": gpuStr, "uuid": d.UUID, "serial": d.Serial}).Set(1)
+	}
+	for key := range e.prevSerials {
+		if !current[key] {
+			parts := strings.SplitN(key, "\x00", 3)
+			e.deviceSerial.Delete(prometheus.Labels{"gpu": parts[0], "uuid": parts[1], "serial": parts[2]})
+		}
+	}
+	e.prevSerials = current
+}
+
+// updateFirmware sets the firmware info metric, dropping series for GPUs
+// that are gone or whose firmware changed (e.g. after a VBIOS update).
+func (e *Exporter) updateFirmware(snap *collector.Snapshot) {
+	current := make(map[string]bool, len(snap.Devices))
+	for _, d := range snap.Devices {
+		if d.VbiosVersion == "" && d.BoardPartNumber == "" {
+			continue
+		}
+		gpuStr := strconv.Itoa(d.Index)
+		current[strings.Join([]string{gpuStr, d.UUID, d.VbiosVersion, d.BoardPartNumber}, "\x00")] = true
+		e.deviceFirmware.WithLabelValues(gpuStr, d.UUID, d.VbiosVersion, d.BoardPartNumber).Set(1)
+	}
+	for key := range e.prevFirmware {
+		if !current[key] {
+			e.deviceFirmware.DeleteLabelValues(strings.SplitN(key, "\x00", 4)...)
+		}
+	}
+	e.prevFirmware = current
+}
+
+// updatePersistenced sets the nvidia-persistenced health if it was checked.
+// The daemon exists to keep persistence mode on, so a GPU with persistence
+// mode off counts as unhealthy even while the daemon runs.
+func (e *Exporter) updatePersistenced(snap *collector.Snapshot) {
+	if !snap.PersistencedChecked {
+		return
+	}
+	healthy := snap.PersistencedRunning
+	for _, d := range snap.Devices {
+		if d.HasPersistenceMode && !d.PersistenceMode {
+			healthy = false
+		}
+	}
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	e.persistencedHealthy.WithLabelValues().Set(v)
+}
+
+// migModeName renders a MIG mode as a label value.
+func migModeName(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// updateMigModes sets the MIG mode of MIG-capable GPUs, flagging those with a
+// reconfiguration pending. GPUs without MIG support are omitted.
+func (e *Exporter) updateMigModes(snap *collector.Snapshot) {
+	current := make(map[string]bool, len(snap.Devices))
+	for _, d := range snap.Devices {
+		if !d.MigCapable {
+			continue
+		}
+		gpuStr := strconv.Itoa(d.Index)
+		cur, pending := migModeName(d.MigEnabled), migModeName(d.MigPending)
+		current[gpuStr+"\x00"+cur+"\x00"+pending] = true
+		v := 0.0
+		if d.MigEnabled != d.MigPending {
+			v = 1
+		}
+		e.deviceMigMode.With(prometheus.Labels{"gpu": gpuStr, "current": cur, "pending": pending}).Set(v)
+	}
+	for key := range e.prevMigModes {
+		if !current[key] {
+			parts := strings.SplitN(key, "\x00", 3)
+			e.deviceMigMode.Delete(prometheus.Labels{"gpu": parts[0], "current": parts[1], "pending": parts[2]})
+		}
+	}
+	e.prevMigModes = current
+}
+
+// updateBoards sets board power and the GPU-to-board mapping, dropping
+// series for boards and GPUs that are no longer reported.
+func (e *Exporter) updateBoards(snap *collector.Snapshot) {
+	boards := make(map[string]bool, len(snap.Boards))
+	boardOf := make(map[string]string, len(snap.Devices))
+	for _, b := range snap.Boards {
+		boards[b.ID] = true
+		e.boardPower.With(prometheus.Labels{"board_id": b.ID}).Set(b.PowerWatts)
+		for _, gpu := range b.GPUs {
+			gpuStr := strconv.Itoa(gpu)
+			boardOf[gpuStr] = b.ID
+			e.deviceBoard.With(prometheus.Labels{"gpu": gpuStr, "board_id": b.ID}).Set(1)
+		}
+	}
+	for id := range e.prevBoards {
+		if !boards[id] {
+			e.boardPower.Delete(prometheus.Labels{"board_id": id})
+		}
+	}
+	for gpu, id := range e.prevBoardOf {
+		if boardOf[gpu] != id {
+			e.deviceBoard.Delete(prometheus.Labels{"gpu": gpu, "board_id": id})
+		}
+	}
+	e.prevBoards = boards
+	e.prevBoardOf = boardOf
+}
+
+// UpdateMetrics sets all Prometheus gauges from the latest snapshot and idle
+// states. stale lists the processes the tracker still remembers but that were
+// absent from the snapshot; they are reported with status="stale" until the
+// tracker cleans them up.
+func (e *Exporter) UpdateMetrics(snap *collector.Snapshot, states, stale []idle.ProcessIdleState) {
+	// GPUs skipped this cycle are missing from snap.Devices, but not gone
+	failedGPUs := make(map[string]bool, len(snap.PanickedGPUs)+len(snap.TimedOutGPUs))
+	for _, gpu := range snap.PanickedGPUs {
+		e.collectorPanics.With(prometheus.Labels{"gpu": strconv.Itoa(gpu)}).Inc()
+		failedGPUs[strconv.Itoa(gpu)] = true
+	}
+	for _, gpu := range snap.TimedOutGPUs {
+		e.deviceTimeouts.With(prometheus.Labels{"gpu": strconv.Itoa(gpu)}).Inc()
+		failedGPUs[strconv.Itoa(gpu)] = true
+	}
+	e.procReadTimeouts.Add(float64(snap.ProcReadTimeouts))
+	e.deviceCount.Set(float64(snap.DeviceCount))
+
 	// --- Device-level metrics ---
+	currentGPUs := make(map[string]bool, len(snap.Devices))
+	currentLabels := make(map[string]prometheus.Labels, len(snap.Devices))
 	for _, d := range snap.Devices {
 		gpuStr := strconv.Itoa(d.Index)
-		labels := prometheus.Labels{"gpu": gpuStr, "model": d.Name, "uuid": d.UUID}
+		currentGPUs[gpuStr] = true
+		labels := e.deviceLabelValues(d)
+		currentLabels[e.deviceLabelKey(labels)] = labels
 
 		e.deviceUtil.With(labels).Set(float64(d.Utilization))
+		e.deviceMemUtil.With(labels).Set(float64(d.MemoryUtilization))
+		e.deviceUtilFine.With(labels).Set(d.UtilizationFine)
 		e.deviceMemUsed.With(labels).Set(float64(d.MemoryUsed))
 		e.deviceMemTotal.With(labels).Set(float64(d.MemoryTotal))
 		e.devicePower.With(labels).Set(d.PowerWatts)
+		setIfKnown(e.devicePowerLimit, labels, d.PowerLimitWatts, d.PowerLimitWatts > 0)
+		setIfKnown(e.devicePowerLimitEnforced, labels, d.EnforcedPowerLimitWatts, d.EnforcedPowerLimitWatts > 0)
+		persistence := 0.0
+		if d.PersistenceMode {
+			persistence = 1
+		}
+		setIfKnown(e.devicePersistence, labels, persistence, d.HasPersistenceMode)
+		ecc := 0.0
+		if d.EccEnabled {
+			ecc = 1
+		}
+		setIfKnown(e.deviceEccMode, labels, ecc, d.HasEccMode)
+		e.updateThrottled(d, labels)
 		e.deviceTemp.With(labels).Set(float64(d.TempCelsius))
+		setIfKnown(e.deviceSmClock, labels, float64(d.SmClockMHz), d.SmClockMHz > 0)
+		setIfKnown(e.deviceMemClock, labels, float64(d.MemClockMHz), d.MemClockMHz > 0)
+		setIfKnown(e.deviceGraphicsClock, labels, float64(d.GraphicsClockMHz), d.GraphicsClockMHz > 0)
+		setIfKnown(e.deviceFanSpeed, labels, float64(d.FanSpeedPercent), d.HasFanSpeed)
+		setIfKnown(e.devicePcieTx, labels, float64(d.PcieTxKBps), d.HasPcieThroughput)
+		setIfKnown(e.devicePcieRx, labels, float64(d.PcieRxKBps), d.HasPcieThroughput)
+		setIfKnown(e.deviceEncoderUtil, labels, float64(d.EncoderUtil), d.HasCodecUtil)
+		setIfKnown(e.deviceDecoderUtil, labels, float64(d.DecoderUtil), d.HasCodecUtil)
+		e.deviceUtilHist.With(prometheus.Labels{"gpu": gpuStr}).Observe(float64(d.Utilization))
+	}
+	for gpu := range e.prevDeviceGPUs {
+		if !currentGPUs[gpu] {
+			e.deviceUtilHist.Delete(prometheus.Labels{"gpu": gpu})
+			e.idleMemTotal.Delete(prometheus.Labels{"gpu": gpu})
+			e.distinctIdleUsers.Delete(prometheus.Labels{"gpu": gpu})
+			e.deviceUnattributed.Delete(prometheus.Labels{"gpu": gpu})
+			e.safelyReclaimable.Delete(prometheus.Labels{"gpu": gpu})
+			e.occupancy.Delete(prometheus.Labels{"gpu": gpu})
+			for _, b := range idleDurationBuckets {
+				e.idleMemByDuration.Delete(prometheus.Labels{"gpu": gpu, "duration_bucket": b.name})
+			}
+			// Counters outlive a failed collection, which they record
+			if !failedGPUs[gpu] {
+				e.deviceIdleMemByteSecs.Delete(prometheus.Labels{"gpu": gpu})
+				e.deviceBusySecs.Delete(prometheus.Labels{"gpu": gpu})
+				e.collectorPanics.Delete(prometheus.Labels{"gpu": gpu})
+				e.deviceTimeouts.Delete(prometheus.Labels{"gpu": gpu})
+			}
+		}
+	}
+	for gpu := range failedGPUs {
+		currentGPUs[gpu] = true
 	}
+	e.prevDeviceGPUs = currentGPUs
+	e.deleteStaleDevices(currentLabels)
+	if e.dcgm != nil {
+		e.dcgm.update(snap.Devices)
+	}
+	e.updateCPUAffinity(snap)
+	e.updateSerials(snap)
+	e.updateFirmware(snap)
+	e.updateMigModes(snap)
+	e.updateBoards(snap)
+
+	e.updateUnattributed(snap, states)
+	e.updatePersistenced(snap)
+	e.updateECCPolicy(snap)
 
 	// --- Per-process metrics + aggregate idle memory ---
 	currentKeys := make(map[string]bool, len(states))
+	idleTotals := make(map[string]time.Duration, len(states))
+	currentReasons := make(map[string]string)
 	idleMemByGPU := make(map[int]uint64)
+	reclaimableByGPU := make(map[int]uint64)
+	procsByGPU := make(map[int]int)
+	idleMemByBucket := make(map[int][]uint64) // GPU -> memory per idleDurationBuckets entry
+	memTotalByGPU := make(map[int]uint64, len(snap.Devices))
+	for _, d := range snap.Devices {
+		memTotalByGPU[d.Index] = d.MemoryTotal
+	}
+	// Series to emit, by key; one per process, or per process name and GPU
+	// in aggregated mode
+	groups := make(map[string]*processGroup, len(states))
+	var groupKeys []string
 
 	for _, ps := range states {
+		procsByGPU[ps.GPU]++
+		if !ps.System {
+			idleMemByGPU[ps.GPU] += ps.IdleMemory
+		}
+		if ps.IsIdle && !ps.System {
+			if e.idleMemNative != nil {
+				e.idleMemNative.Observe(float64(ps.IdleMemory))
+			}
+			if idleMemByBucket[ps.GPU] == nil {
+				idleMemByBucket[ps.GPU] = make([]uint64, len(idleDurationBuckets))
+			}
+			idleMemByBucket[ps.GPU][idleDurationBucket(ps.IdleDuration)] += ps.IdleMemory
+		}
+		if ps.IsIdle && !ps.Exempt && !ps.System && ps.IdleDuration >= e.reclaimSafetyDelay {
+			reclaimableByGPU[ps.GPU] += ps.IdleMemory
+		}
+		if e.idleOnly && !ps.IsIdle {
+			continue
+		}
+
 		gpuStr := strconv.Itoa(ps.GPU)
-		pidStr := strconv.FormatUint(uint64(ps.PID), 10)
-		labels := prometheus.Labels{"gpu": gpuStr, "pid": pidStr, "process": ps.ProcessName}
-		key := gpuStr + "\x00" + pidStr + "\x00" + ps.ProcessName
+		pidKey := gpuStr + "\x00" + strconv.FormatUint(uint64(ps.PID), 10) + "\x00" + ps.ProcessName
+		// A series new this cycle (or recreated) starts at the full total
+		idleDelta := max(ps.IdleTotal-e.prevIdleTotals[pidKey], 0)
+		idleTotals[pidKey] = ps.IdleTotal
+		key := gpuStr + "\x00" + e.pidLabel(ps.PID) + "\x00" + ps.ProcessName + "\x00" + ps.MigInstance
+		g, ok := groups[key]
+		if !ok {
+			g = &processGroup{}
+			groups[key] = g
+			groupKeys = append(groupKeys, key)
+		}
+		fds, hasFds := snap.GPUFds[ps.PID]
+		g.add(ps, idleDelta, fds, hasFds)
+	}
+
+	for _, key := range groupKeys {
+		g := groups[key]
+		ps := g.state
+		gpuStr := strconv.Itoa(ps.GPU)
+		pidStr := e.pidLabel(ps.PID)
+		labels := prometheus.Labels{"gpu": gpuStr, "pid": pidStr, "process": ps.ProcessName, "mig_instance": ps.MigInstance}
 		currentKeys[key] = true
 
 		e.processComputeUtil.With(labels).Set(float64(ps.SmUtil))
+		e.processSmoothUtil.With(labels).Set(ps.SmoothedUtil)
 		e.processMemUsed.With(labels).Set(float64(ps.UsedMemory))
+		memFraction := 0.0
+		if total := memTotalByGPU[ps.GPU]; total > 0 {
+			memFraction = float64(ps.UsedMemory) / float64(total)
+		}
+		e.processMemFraction.With(labels).Set(memFraction)
 		e.processIdleSecs.With(labels).Set(ps.IdleDuration.Seconds())
+		e.processIdleTotal.With(labels).Add(g.idleDelta.Seconds())
+		e.processResidency.With(labels).Set(ps.Residency.Seconds())
+		suspended := 0.0
+		if ps.Suspended {
+			suspended = 1
+		}
+		e.processSuspended.With(labels).Set(suspended)
 		e.processIdleMem.With(labels).Set(float64(ps.IdleMemory))
+		memoryless := 0.0
+		if ps.Memoryless {
+			memoryless = 1
+		}
+		e.processMemoryless.With(labels).Set(memoryless)
+		e.processConfidence.With(labels).Set(ps.Confidence)
+		if score, ok := efficiency(ps.SmoothedUtil, ps.UsedMemory, memTotalByGPU[ps.GPU]); ok {
+			e.processEfficiency.With(labels).Set(score)
+		} else {
+			e.processEfficiency.Delete(labels)
+		}
+		engineUtil := [...]uint32{ps.SmUtil, ps.EngineUtil.Memory, ps.EngineUtil.Encoder, ps.EngineUtil.Decoder}
+		for i, engine := range processEngines {
+			e.processEngineUtil.With(prometheus.Labels{
+				"gpu": gpuStr, "pid": pidStr, "process": ps.ProcessName, "mig_instance": ps.MigInstance, "engine": engine,
+			}).Set(float64(engineUtil[i]))
+		}
+		if g.hasFds {
+			e.processGPUFds.With(labels).Set(float64(g.fds))
+		}
+		if ps.HasActiveStreams {
+			e.processStreams.With(labels).Set(float64(ps.ActiveStreams))
+		} else {
+			e.processStreams.Delete(labels)
+		}
+		if ps.HasDataMoved {
+			e.processDataMoved.With(labels).Add(float64(ps.DataMoved))
+		}
+
+		status := "active"
+		if ps.IsIdle {
+			status = "idle"
+		}
+		e.setProcessStatus(gpuStr, pidStr, ps.ProcessName, ps.MigInstance, status)
+		if ps.IdleReason != "" {
+			currentReasons[key] = ps.IdleReason
+			e.processIdleReason.With(prometheus.Labels{
+				"gpu": gpuStr, "pid": pidStr, "process": ps.ProcessName, "mig_instance": ps.MigInstance, "reason": ps.IdleReason,
+			}).Set(1)
+		}
+	}
 
-		idleMemByGPU[ps.GPU] += ps.IdleMemory
+	e.updateNodeIdle(states)
+	e.updateProcessInfo(states, currentKeys)
+	e.updateSquatted(states, memTotalByGPU)
+
+	// Status for processes that vanished but aren't cleaned up yet
+	currentStatusKeys := make(map[string]bool, len(currentKeys)+len(stale))
+	for key := range currentKeys {
+		currentStatusKeys[key] = true
+	}
+	for _, ps := range stale {
+		gpuStr := strconv.Itoa(ps.GPU)
+		pidStr := e.pidLabel(ps.PID)
+		key := gpuStr + "\x00" + pidStr + "\x00" + ps.ProcessName + "\x00" + ps.MigInstance
+		if currentKeys[key] {
+			continue
+		}
+		if e.idleOnly && !e.prevStatusKeys[key] {
+			// Was active when last seen, so never emitted
+			continue
+		}
+		currentStatusKeys[key] = true
+		e.setProcessStatus(gpuStr, pidStr, ps.ProcessName, ps.MigInstance, "stale")
 	}
 
 	// Aggregate idle memory per GPU
 	for _, d := range snap.Devices {
 		gpuStr := strconv.Itoa(d.Index)
 		e.idleMemTotal.With(prometheus.Labels{"gpu": gpuStr}).Set(float64(idleMemByGPU[d.Index]))
+		e.safelyReclaimable.With(prometheus.Labels{"gpu": gpuStr}).Set(float64(reclaimableByGPU[d.Index]))
+		for i, b := range idleDurationBuckets {
+			var mem uint64
+			if byBucket := idleMemByBucket[d.Index]; byBucket != nil {
+				mem = byBucket[i]
+			}
+			e.idleMemByDuration.With(prometheus.Labels{"gpu": gpuStr, "duration_bucket": b.name}).Set(float64(mem))
+		}
+		if e.maxProcsPerGPU > 0 {
+			e.occupancy.With(prometheus.Labels{"gpu": gpuStr}).Set(min(float64(procsByGPU[d.Index])/float64(e.maxProcsPerGPU), 1))
+		}
+	}
+
+	e.updateMigInstances(snap, states)
+	e.updatePhysicalGPUs(snap, states)
+	e.updateIdleUsers(snap, states)
+
+	// Integrate idle memory over the real time elapsed since the previous
+	// snapshot, so delayed polls are weighted correctly. Memory idle at the
+	// previous poll is assumed to have stayed idle until this one.
+	// Utilization is already averaged over the poll window, so the current
+	// value covers the elapsed time.
+	if !e.prevTimestamp.IsZero() {
+		if elapsed := snap.Timestamp.Sub(e.prevTimestamp).Seconds(); elapsed > 0 {
+			for gpu, mem := range e.prevIdleMemByGPU {
+				if !e.prevDeviceGPUs[strconv.Itoa(gpu)] {
+					continue // gone, its series dropped above
+				}
+				e.deviceIdleMemByteSecs.With(prometheus.Labels{"gpu": strconv.Itoa(gpu)}).Add(float64(mem) * elapsed)
+			}
+			for _, d := range snap.Devices {
+				e.deviceBusySecs.With(prometheus.Labels{"gpu": strconv.Itoa(d.Index)}).Add(d.UtilizationFine / 100 * elapsed)
+			}
+		}
 	}
+	e.prevTimestamp = snap.Timestamp
+	e.prevIdleMemByGPU = idleMemByGPU
 
 	// --- Stale series cleanup ---
 	for prevKey := range e.prevProcessKeys {
 		if !currentKeys[prevKey] {
-			parts := strings.SplitN(prevKey, "\x00", 3)
-			if len(parts) == 3 {
-				labels := prometheus.Labels{"gpu": parts[0], "pid": parts[1], "process": parts[2]}
+			parts := strings.SplitN(prevKey, "\x00", 4)
+			if len(parts) == 4 {
+				labels := prometheus.Labels{"gpu": parts[0], "pid": parts[1], "process": parts[2], "mig_instance": parts[3]}
 				e.processComputeUtil.Delete(labels)
+				e.processSmoothUtil.Delete(labels)
 				e.processMemUsed.Delete(labels)
+				e.processMemFraction.Delete(labels)
 				e.processIdleSecs.Delete(labels)
+				e.processIdleTotal.Delete(labels)
+				e.processResidency.Delete(labels)
+				e.processSuspended.Delete(labels)
 				e.processIdleMem.Delete(labels)
+				e.processGPUFds.Delete(labels)
+				e.processMemoryless.Delete(labels)
+				e.processConfidence.Delete(labels)
+				e.processEfficiency.Delete(labels)
+				e.processStreams.Delete(labels)
+				e.processDataMoved.Delete(labels)
+				for _, engine := range processEngines {
+					e.processEngineUtil.Delete(prometheus.Labels{"gpu": parts[0], "pid": parts[1], "process": parts[2], "mig_instance": parts[3], "engine": engine})
+				}
 			}
 		}
 	}
 	e.prevProcessKeys = currentKeys
+	e.prevIdleTotals = idleTotals
+
+	// Status series outlive the other per-process series while the process is
+	// stale; all three status variants are removed together.
+	for prevKey := range e.prevStatusKeys {
+		if !currentStatusKeys[prevKey] {
+			parts := strings.SplitN(prevKey, "\x00", 4)
+			if len(parts) == 4 {
+				for _, s := range processStatuses {
+					e.processStatus.Delete(prometheus.Labels{"gpu": parts[0], "pid": parts[1], "process": parts[2], "mig_instance": parts[3], "status": s})
+				}
+			}
+		}
+	}
+	e.prevStatusKeys = currentStatusKeys
+
+	// Drop reason series whose process went active, changed reason or vanished
+	for prevKey, reason := range e.prevReasons {
+		if currentReasons[prevKey] != reason {
+			parts := strings.SplitN(prevKey, "\x00", 4)
+			if len(parts) == 4 {
+				e.processIdleReason.Delete(prometheus.Labels{"gpu": parts[0], "pid": parts[1], "process": parts[2], "mig_instance": parts[3], "reason": reason})
+			}
+		}
+	}
+	e.prevReasons = currentReasons
 }
@@ -0,0 +1,1779 @@
+package exporter
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+	"github.com/affinode/gpu-idle-exporter/internal/idle"
+	"github.com/affinode/gpu-idle-exporter/internal/listconfig"
+)
+
+func snapshotAt(ts time.Time, gpus ...int) *collector.Snapshot {
+	snap := &collector.Snapshot{Timestamp: ts, ProcessNames: map[uint32]string{}}
+	for _, g := range gpus {
+		snap.Devices = append(snap.Devices, collector.DeviceInfo{Index: g, MemoryTotal: 40 << 30})
+	}
+	return snap
+}
+
+func idleState(gpu int, pid uint32, mem uint64) idle.ProcessIdleState {
+	return idle.ProcessIdleState{
+		GPU: gpu, PID: pid, ProcessName: "python",
+		UsedMemory: mem, IsIdle: true, IdleMemory: mem,
+	}
+}
+
+func TestDeviceIdleMemoryByteSeconds(t *testing.T) {
+	e := New(prometheus.Labels{})
+	t0 := time.Now()
+	const gib = 1 << 30
+
+	// Poll 1: 2 GiB idle on GPU 0. Nothing to integrate yet.
+	e.UpdateMetrics(snapshotAt(t0, 0, 1), []idle.ProcessIdleState{idleState(0, 100, 2*gib)}, nil)
+	// Poll 2 after 5s: 2 GiB * 5s accrued. Now 4 GiB idle on GPU 0, 1 GiB on GPU 1.
+	e.UpdateMetrics(snapshotAt(t0.Add(5*time.Second), 0, 1), []idle.ProcessIdleState{
+		idleState(0, 100, 2*gib), idleState(0, 200, 2*gib), idleState(1, 300, gib),
+	}, nil)
+
+	// Poll 3 delayed to 17s after poll 2: 4 GiB * 17s on GPU 0, 1 GiB * 17s on GPU 1.
+	e.UpdateMetrics(snapshotAt(t0.Add(22*time.Second), 0, 1), nil, nil)
+	// Poll 4: nothing was idle at poll 3, so nothing accrues.
+	e.UpdateMetrics(snapshotAt(t0.Add(27*time.Second), 0, 1), nil, nil)
+
+	got0 := testutil.ToFloat64(e.deviceIdleMemByteSecs.WithLabelValues("0"))
+	if want := float64(2*gib*5 + 4*gib*17); math.Abs(got0-want) > 1 {
+		t.Errorf("GPU 0: expected %v byte-seconds, got %v", want, got0)
+	}
+	got1 := testutil.ToFloat64(e.deviceIdleMemByteSecs.WithLabelValues("1"))
+	if want := float64(gib * 17); math.Abs(got1-want) > 1 {
+		t.Errorf("GPU 1: expected %v byte-seconds, got %v", want, got1)
+	}
+}
+
+func TestCollectorPanicsCounter(t *testing.T) {
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 1)
+	snap.PanickedGPUs = []int{0}
+	e.UpdateMetrics(snap, nil, nil)
+	e.UpdateMetrics(snap, nil, nil)
+
+	if got := testutil.ToFloat64(e.collectorPanics.WithLabelValues("0")); got != 2 {
+		t.Errorf("expected 2 panics recorded for GPU 0, got %v", got)
+	}
+}
+
+func TestStaleDeviceSeries(t *testing.T) {
+	e := New(prometheus.Labels{})
+	t0 := time.Now()
+	e.UpdateMetrics(snapshotAt(t0, 0, 1), []idle.ProcessIdleState{idleState(1, 100, 1<<30)}, nil)
+	snap := snapshotAt(t0.Add(5*time.Second), 0, 1)
+	snap.PanickedGPUs = []int{1}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{idleState(1, 100, 1<<30)}, nil)
+
+	// GPU 1 times out: its counters stay, they record the failure
+	snap = snapshotAt(t0.Add(10*time.Second), 0)
+	snap.TimedOutGPUs = []int{1}
+	e.UpdateMetrics(snap, nil, nil)
+	if got := testutil.ToFloat64(e.deviceIdleMemByteSecs.WithLabelValues("1")); got == 0 {
+		t.Error("expected GPU 1's idle byte-seconds to survive a timeout")
+	}
+	if got := testutil.ToFloat64(e.collectorPanics.WithLabelValues("1")); got != 1 {
+		t.Errorf("expected GPU 1's panic to survive a timeout, got %v", got)
+	}
+	// GPU 1 is removed
+	e.UpdateMetrics(snapshotAt(t0.Add(15*time.Second), 0), nil, nil)
+
+	for name, c := range map[string]prometheus.Collector{
+		"device_utilization_percent":   e.deviceUtil,
+		"device_memory_used_bytes":     e.deviceMemUsed,
+		"device_temperature_celsius":   e.deviceTemp,
+		"memory_total_bytes":           e.idleMemTotal,
+		"safely_reclaimable_bytes":     e.safelyReclaimable,
+		"device_utilization_histogram": e.deviceUtilHist,
+		"device_busy_seconds_total":    e.deviceBusySecs,
+	} {
+		if n := testutil.CollectAndCount(c); n != 1 {
+			t.Errorf("%s: expected only GPU 0's series, got %d", name, n)
+		}
+	}
+	// Only GPU 1 ever had these
+	for name, c := range map[string]prometheus.Collector{
+		"device_idle_memory_byte_seconds_total": e.deviceIdleMemByteSecs,
+		"collector_panics_total":                e.collectorPanics,
+		"device_collection_timeout_total":       e.deviceTimeouts,
+	} {
+		if n := testutil.CollectAndCount(c); n != 0 {
+			t.Errorf("%s: expected GPU 1's series to be dropped, got %d", name, n)
+		}
+	}
+}
+
+func TestDeviceSetChanges(t *testing.T) {
+	e := New(prometheus.Labels{}, WithDCGMMetrics())
+	snap := snapshotAt(time.Now(), 0, 1)
+	snap.Devices[0].UUID, snap.Devices[1].UUID = "GPU-a", "GPU-b"
+	snap.Devices[0].HasThrottleReasons, snap.Devices[1].HasThrottleReasons = true, true
+	snap.DeviceCount = 2
+	e.UpdateMetrics(snap, nil, nil)
+
+	// After a reset the GPUs come back renumbered, then GPU-a is lost
+	snap.Devices[0].UUID, snap.Devices[1].UUID = "GPU-b", "GPU-a"
+	e.UpdateMetrics(snap, nil, nil)
+	snap.Devices = snap.Devices[:1]
+	snap.DeviceCount = 1
+	e.UpdateMetrics(snap, nil, nil)
+
+	if n := testutil.CollectAndCount(e.deviceMemTotal); n != 1 {
+		t.Errorf("expected 1 device series, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.deviceMemTotal.WithLabelValues("0", "", "GPU-b")); got != 40<<30 {
+		t.Errorf("expected GPU-b at index 0, got %v", got)
+	}
+	if n := testutil.CollectAndCount(e.deviceThrottled); n != len(collector.ThrottleReasons) {
+		t.Errorf("expected throttle series for one GPU, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.deviceCount); got != 1 {
+		t.Errorf("expected a device count of 1, got %v", got)
+	}
+	if n := testutil.CollectAndCount(e.dcgm.gpuUtil); n != 1 {
+		t.Errorf("expected 1 DCGM series, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.dcgm.fbFree.WithLabelValues("0", "GPU-b", "nvidia0", snap.Devices[0].Name)); got != 40<<10 {
+		t.Errorf("expected GPU-b's DCGM series at index 0, got %v", got)
+	}
+}
+
+func TestDeviceCollectionTimeoutCounter(t *testing.T) {
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 1)
+	snap.TimedOutGPUs = []int{1}
+	e.UpdateMetrics(snap, nil, nil)
+
+	if got := testutil.ToFloat64(e.deviceTimeouts.WithLabelValues("1")); got != 1 {
+		t.Errorf("expected 1 timeout recorded for GPU 1, got %v", got)
+	}
+}
+
+// statusValues returns the status -> value map emitted for one process.
+func statusValues(t *testing.T, e *Exporter, gpu, pid, process, mig string) map[string]float64 {
+	t.Helper()
+	vals := make(map[string]float64)
+	for _, s := range processStatuses {
+		g, err := e.processStatus.GetMetricWith(prometheus.Labels{"gpu": gpu, "pid": pid, "process": process, "mig_instance": mig, "status": s})
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals[s] = testutil.ToFloat64(g)
+	}
+	return vals
+}
+
+func TestProcessStatusStateSet(t *testing.T) {
+	e := New(prometheus.Labels{})
+	now := time.Now()
+
+	active := idle.ProcessIdleState{GPU: 0, PID: 100, ProcessName: "python", UsedMemory: 1 << 30, SmUtil: 50}
+	idling := idleState(0, 200, 1<<30)
+	e.UpdateMetrics(snapshotAt(now, 0, 1), []idle.ProcessIdleState{active, idling}, []idle.ProcessIdleState{{GPU: 1, PID: 300, ProcessName: "train"}})
+
+	for _, tc := range []struct {
+		gpu, pid, process, want string
+	}{
+		{"0", "100", "python", "active"},
+		{"0", "200", "python", "idle"},
+		{"1", "300", "train", "stale"},
+	} {
+		vals := statusValues(t, e, tc.gpu, tc.pid, tc.process, "")
+		for status, v := range vals {
+			if want := map[bool]float64{true: 1, false: 0}[status == tc.want]; v != want {
+				t.Errorf("pid %s: status=%q = %v, want %v", tc.pid, status, v, want)
+			}
+		}
+	}
+	if n := testutil.CollectAndCount(e.processStatus); n != 9 {
+		t.Errorf("expected 9 status series (3 processes x 3 states), got %d", n)
+	}
+
+	// The stale process is cleaned up by the tracker: all three variants go.
+	e.UpdateMetrics(snapshotAt(now.Add(5*time.Second), 0, 1), []idle.ProcessIdleState{active, idling}, nil)
+	if n := testutil.CollectAndCount(e.processStatus); n != 6 {
+		t.Errorf("expected stale process status series to be removed, got %d series", n)
+	}
+}
+
+func TestProcessNodeIdle(t *testing.T) {
+	e := New(prometheus.Labels{})
+
+	// PID 100 is idle on both of its GPUs; PID 200 is idle on GPU 0 but busy on GPU 1.
+	a0 := idleState(0, 100, 1<<30)
+	a0.IdleDuration = 120 * time.Second
+	a1 := idleState(1, 100, 1<<30)
+	a1.IdleDuration = 60 * time.Second
+	b0 := idleState(0, 200, 1<<30)
+	b0.IdleDuration = 300 * time.Second
+	b1 := idle.ProcessIdleState{GPU: 1, PID: 200, ProcessName: "python", UsedMemory: 1 << 30, SmUtil: 90}
+
+	e.UpdateMetrics(snapshotAt(time.Now(), 0, 1), []idle.ProcessIdleState{a0, a1, b0, b1}, nil)
+
+	if got := testutil.ToFloat64(e.processNodeIdle.WithLabelValues("100", "python")); got != 1 {
+		t.Errorf("PID 100 idle on all GPUs: expected node_idle 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processNodeIdleSecs.WithLabelValues("100", "python")); got != 60 {
+		t.Errorf("PID 100: expected minimum idle duration 60s, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processNodeIdle.WithLabelValues("200", "python")); got != 0 {
+		t.Errorf("PID 200 busy on GPU 1: expected node_idle 0, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processNodeIdleSecs.WithLabelValues("200", "python")); got != 0 {
+		t.Errorf("PID 200: expected node idle seconds 0, got %v", got)
+	}
+
+	// PID 200 exits: its node-level series are removed.
+	e.UpdateMetrics(snapshotAt(time.Now(), 0, 1), []idle.ProcessIdleState{a0, a1}, nil)
+	if n := testutil.CollectAndCount(e.processNodeIdle); n != 1 {
+		t.Errorf("expected 1 node_idle series after PID 200 exits, got %d", n)
+	}
+}
+
+func TestMigInstanceMemory(t *testing.T) {
+	e := New(prometheus.Labels{})
+	const gib = 1 << 30
+
+	snap := snapshotAt(time.Now())
+	snap.Devices = []collector.DeviceInfo{{
+		Index: 0, MigEnabled: true,
+		MigInstances: []collector.MigInstance{
+			{ID: "1", MemoryTotal: 10 * gib, Utilization: 70, HasUtilization: true},
+			{ID: "2", MemoryTotal: 20 * gib},
+			{ID: "3", MemoryTotal: 10 * gib}, // no processes
+		},
+	}}
+	snap.Processes = []collector.ProcessSample{
+		{GPU: 0, PID: 100, UsedMemory: 4 * gib, MigInstance: "1"},
+		{GPU: 0, PID: 200, UsedMemory: 2 * gib, MigInstance: "2"},
+		{GPU: 0, PID: 300, UsedMemory: 8 * gib, MigInstance: "2"},
+	}
+	busy := idle.ProcessIdleState{GPU: 0, PID: 100, ProcessName: "python", MigInstance: "1", UsedMemory: 4 * gib, SmUtil: 70}
+	idle2 := idleState(0, 200, 2*gib)
+	idle2.MigInstance = "2"
+	idle3 := idleState(0, 300, 8*gib)
+	idle3.MigInstance = "2"
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{busy, idle2, idle3}, nil)
+
+	for _, tc := range []struct {
+		instance    string
+		used, total float64
+		ratio       float64
+		procs       float64
+	}{
+		{"1", 4 * gib, 10 * gib, 0, 1},
+		{"2", 10 * gib, 20 * gib, 0.5, 2},
+		{"3", 0, 10 * gib, 0, 0},
+	} {
+		if got := testutil.ToFloat64(e.migMemUsed.WithLabelValues("0", tc.instance)); got != tc.used {
+			t.Errorf("instance %s: used = %v, want %v", tc.instance, got, tc.used)
+		}
+		if got := testutil.ToFloat64(e.migMemTotal.WithLabelValues("0", tc.instance)); got != tc.total {
+			t.Errorf("instance %s: total = %v, want %v", tc.instance, got, tc.total)
+		}
+		if got := testutil.ToFloat64(e.migIdleMemRatio.WithLabelValues("0", tc.instance)); got != tc.ratio {
+			t.Errorf("instance %s: idle ratio = %v, want %v", tc.instance, got, tc.ratio)
+		}
+		if got := testutil.ToFloat64(e.migProcesses.WithLabelValues("0", tc.instance)); got != tc.procs {
+			t.Errorf("instance %s: processes = %v, want %v", tc.instance, got, tc.procs)
+		}
+	}
+	// Only instance 1 reports utilization
+	if n := testutil.CollectAndCount(e.migUtil); n != 1 {
+		t.Errorf("expected utilization for 1 instance, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.migUtil.WithLabelValues("0", "1")); got != 70 {
+		t.Errorf("instance 1: utilization = %v, want 70", got)
+	}
+}
+
+func TestProcessMigInstanceLabel(t *testing.T) {
+	e := New(prometheus.Labels{}, WithProcessMetricsMode(ProcessMetricsAggregated))
+	const gib = 1 << 30
+
+	snap := snapshotAt(time.Now(), 0, 1)
+	inInstance := func(ps idle.ProcessIdleState, instance string) idle.ProcessIdleState {
+		ps.MigInstance = instance
+		return ps
+	}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{
+		inInstance(idleState(0, 100, gib), "1"),
+		inInstance(idleState(0, 101, 2*gib), "2"),
+		idleState(1, 200, 3*gib),
+	}, nil)
+
+	// Same-named processes in different instances of a GPU aren't merged
+	for _, tc := range []struct {
+		gpu, instance string
+		mem           float64
+	}{{"0", "1", gib}, {"0", "2", 2 * gib}, {"1", "", 3 * gib}} {
+		if got := testutil.ToFloat64(e.processMemUsed.WithLabelValues(tc.gpu, "", "python", tc.instance)); got != tc.mem {
+			t.Errorf("gpu %s instance %q: memory = %v, want %v", tc.gpu, tc.instance, got, tc.mem)
+		}
+		if v := statusValues(t, e, tc.gpu, "", "python", tc.instance)["idle"]; v != 1 {
+			t.Errorf("gpu %s instance %q: expected status idle, got %v", tc.gpu, tc.instance, v)
+		}
+	}
+	if n := testutil.CollectAndCount(e.processMemUsed); n != 3 {
+		t.Errorf("expected 3 memory series, got %d", n)
+	}
+
+	// The instance's process exits: its series go with it
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{inInstance(idleState(0, 100, gib), "1"), idleState(1, 200, 3*gib)}, nil)
+	if n := testutil.CollectAndCount(e.processMemUsed); n != 2 {
+		t.Errorf("expected 2 memory series after instance 2 emptied, got %d", n)
+	}
+	if n := testutil.CollectAndCount(e.processStatus); n != 2*len(processStatuses) {
+		t.Errorf("expected status series for 2 processes, got %d", n)
+	}
+}
+
+func TestPhysicalGPURollup(t *testing.T) {
+	e := New(prometheus.Labels{})
+	const gib = 1 << 30
+
+	snap := snapshotAt(time.Now())
+	snap.Devices = []collector.DeviceInfo{
+		{Index: 0, UUID: "GPU-mig", MigEnabled: true, MigInstances: []collector.MigInstance{
+			{ID: "1", MemoryTotal: 20 * gib},
+			{ID: "2", MemoryTotal: 20 * gib},
+		}},
+		{Index: 1, UUID: "GPU-plain"},
+	}
+	inInstance := func(ps idle.ProcessIdleState, instance string) idle.ProcessIdleState {
+		ps.MigInstance = instance
+		return ps
+	}
+	busy := idle.ProcessIdleState{GPU: 0, PID: 100, ProcessName: "python", MigInstance: "1", UsedMemory: 4 * gib, SmUtil: 70}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{
+		busy,
+		inInstance(idleState(0, 101, 3*gib), "1"),
+		inInstance(idleState(0, 200, 5*gib), "2"),
+		inInstance(idleState(0, 201, 2*gib), "2"),
+		idleState(1, 300, 6*gib),
+	}, nil)
+
+	// Per instance
+	for _, tc := range []struct {
+		instance   string
+		mem, procs float64
+	}{
+		{"1", 3 * gib, 1},
+		{"2", 7 * gib, 2},
+	} {
+		if got := testutil.ToFloat64(e.migIdleMem.WithLabelValues("0", tc.instance)); got != tc.mem {
+			t.Errorf("instance %s: idle memory = %v, want %v", tc.instance, got, tc.mem)
+		}
+		if got := testutil.ToFloat64(e.migIdleProcs.WithLabelValues("0", tc.instance)); got != tc.procs {
+			t.Errorf("instance %s: idle processes = %v, want %v", tc.instance, got, tc.procs)
+		}
+	}
+	// Rolled up: the MIG GPU sums its instances, the plain GPU is itself
+	for _, tc := range []struct {
+		gpu, uuid  string
+		mem, procs float64
+	}{
+		{"0", "GPU-mig", 10 * gib, 3},
+		{"1", "GPU-plain", 6 * gib, 1},
+	} {
+		if got := testutil.ToFloat64(e.physIdleMem.WithLabelValues(tc.gpu, tc.uuid)); got != tc.mem {
+			t.Errorf("GPU %s: idle memory = %v, want %v", tc.gpu, got, tc.mem)
+		}
+		if got := testutil.ToFloat64(e.physIdleProcs.WithLabelValues(tc.gpu, tc.uuid)); got != tc.procs {
+			t.Errorf("GPU %s: idle processes = %v, want %v", tc.gpu, got, tc.procs)
+		}
+	}
+
+	// GPU 1 goes away
+	snap.Devices = snap.Devices[:1]
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{busy}, nil)
+	if n := testutil.CollectAndCount(e.physIdleMem); n != 1 {
+		t.Errorf("expected the vanished GPU's rollup removed, got %d series", n)
+	}
+}
+
+func TestProcessIdleReasonSeries(t *testing.T) {
+	e := New(prometheus.Labels{})
+	now := time.Now()
+
+	st := idleState(0, 100, 1<<30)
+	st.IdleReason = idle.ReasonWaiting
+	e.UpdateMetrics(snapshotAt(now, 0), []idle.ProcessIdleState{st}, nil)
+	if got := testutil.ToFloat64(e.processIdleReason.WithLabelValues("0", "100", "python", "", idle.ReasonWaiting)); got != 1 {
+		t.Errorf("expected waiting reason = 1, got %v", got)
+	}
+
+	// Reason changes: only the new reason series remains
+	st.IdleReason = idle.ReasonFinished
+	e.UpdateMetrics(snapshotAt(now.Add(time.Minute), 0), []idle.ProcessIdleState{st}, nil)
+	if n := testutil.CollectAndCount(e.processIdleReason); n != 1 {
+		t.Errorf("expected exactly one reason series, got %d", n)
+	}
+
+	// Process becomes active: no reason series
+	st = idle.ProcessIdleState{GPU: 0, PID: 100, ProcessName: "python", UsedMemory: 1 << 30, SmUtil: 60}
+	e.UpdateMetrics(snapshotAt(now.Add(2*time.Minute), 0), []idle.ProcessIdleState{st}, nil)
+	if n := testutil.CollectAndCount(e.processIdleReason); n != 0 {
+		t.Errorf("expected no reason series for an active process, got %d", n)
+	}
+}
+
+func TestConstLabelsOnEmittedSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e := newExporter(reg, prometheus.Labels{"mode": "sidecar"})
+	e.Register()
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{idleState(0, 100, 1<<30)}, nil)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) == 0 {
+		t.Fatal("no metrics gathered")
+	}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			found := false
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "mode" && lp.GetValue() == "sidecar" {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s series %v is missing mode=\"sidecar\"", mf.GetName(), m.GetLabel())
+			}
+		}
+	}
+}
+
+func TestConstLabelsInRenderedMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e := newExporter(reg, prometheus.Labels{"node": "gpu-node-1", "pod": "exporter-abc", "namespace": "monitoring"})
+	e.Register()
+	snap := snapshotAt(time.Now(), 0)
+	snap.Devices[0].UUID = "GPU-a"
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{idleState(0, 100, 1<<30)}, nil)
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`gpu_idle_process_memory_used_bytes{gpu="0",mig_instance="",namespace="monitoring",node="gpu-node-1",pid="100",pod="exporter-abc",process="python"} 1.073741824e+09`,
+		`gpu_idle_device_memory_total_bytes{gpu="0",model="",namespace="monitoring",node="gpu-node-1",pod="exporter-abc",uuid="GPU-a"} 4.294967296e+10`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("rendered metrics missing %s", want)
+		}
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.Contains(line, `node="gpu-node-1"`) {
+			t.Errorf("series without the node label: %s", line)
+		}
+	}
+}
+
+func TestBoardPower(t *testing.T) {
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 0, 1, 2)
+	snap.Boards = []collector.BoardInfo{
+		{ID: "0xabc", GPUs: []int{0, 1}, PowerWatts: 480},
+		{ID: "GPU-2", GPUs: []int{2}, PowerWatts: 250},
+	}
+	e.UpdateMetrics(snap, nil, nil)
+
+	if got := testutil.ToFloat64(e.boardPower.WithLabelValues("0xabc")); got != 480 {
+		t.Errorf("board 0xabc power = %v, want 480", got)
+	}
+	if got := testutil.CollectAndCount(e.deviceBoard); got != 3 {
+		t.Errorf("expected 3 GPU-to-board series, got %d", got)
+	}
+
+	// GPU 2 disappears along with its board
+	snap = snapshotAt(time.Now(), 0, 1)
+	snap.Boards = []collector.BoardInfo{{ID: "0xabc", GPUs: []int{0, 1}, PowerWatts: 470}}
+	e.UpdateMetrics(snap, nil, nil)
+
+	if got := testutil.CollectAndCount(e.boardPower); got != 1 {
+		t.Errorf("expected 1 board power series after GPU 2 vanished, got %d", got)
+	}
+	if got := testutil.CollectAndCount(e.deviceBoard); got != 2 {
+		t.Errorf("expected 2 GPU-to-board series after GPU 2 vanished, got %d", got)
+	}
+}
+
+func TestDCGMCompatibleSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e := newExporter(reg, prometheus.Labels{}, WithDCGMMetrics())
+	e.Register()
+
+	snap := snapshotAt(time.Now())
+	snap.Devices = []collector.DeviceInfo{{
+		Index: 1, UUID: "GPU-abc", Name: "NVIDIA A100-SXM4-40GB",
+		MemoryUsed: 8 << 30, MemoryTotal: 40 << 30, Utilization: 42, PowerWatts: 250, TempCelsius: 55,
+	}}
+	e.UpdateMetrics(snap, nil, nil)
+
+	labels := prometheus.Labels{"gpu": "1", "UUID": "GPU-abc", "device": "nvidia1", "modelName": "NVIDIA A100-SXM4-40GB"}
+	for name, tc := range map[string]struct {
+		vec  *prometheus.GaugeVec
+		want float64
+	}{
+		"DCGM_FI_DEV_GPU_UTIL":    {e.dcgm.gpuUtil, 42},
+		"DCGM_FI_DEV_FB_USED":     {e.dcgm.fbUsed, 8 << 10},
+		"DCGM_FI_DEV_FB_FREE":     {e.dcgm.fbFree, 32 << 10},
+		"DCGM_FI_DEV_POWER_USAGE": {e.dcgm.powerUsage, 250},
+		"DCGM_FI_DEV_GPU_TEMP":    {e.dcgm.gpuTemp, 55},
+	} {
+		if got := testutil.ToFloat64(tc.vec.With(labels)); got != tc.want {
+			t.Errorf("%s = %v, want %v", name, got, tc.want)
+		}
+	}
+
+	// Native metrics are still emitted alongside
+	if n, err := testutil.GatherAndCount(reg, "DCGM_FI_DEV_GPU_UTIL", "gpu_idle_device_utilization_percent"); err != nil || n != 2 {
+		t.Errorf("expected one DCGM and one native utilization series, got %d (%v)", n, err)
+	}
+}
+
+func TestProcessActiveStreams(t *testing.T) {
+	e := New(prometheus.Labels{})
+	now := time.Now()
+
+	withStreams := idleState(0, 100, 1<<30)
+	withStreams.ActiveStreams, withStreams.HasActiveStreams = 3, true
+	unknown := idleState(0, 200, 1<<30)
+	e.UpdateMetrics(snapshotAt(now, 0), []idle.ProcessIdleState{withStreams, unknown}, nil)
+
+	if got := testutil.ToFloat64(e.processStreams.WithLabelValues("0", "100", "python", "")); got != 3 {
+		t.Errorf("expected 3 active streams, got %v", got)
+	}
+	if n := testutil.CollectAndCount(e.processStreams); n != 1 {
+		t.Errorf("expected the series only for the process with a known count, got %d", n)
+	}
+
+	// The count becomes unavailable: the series is dropped
+	withStreams.HasActiveStreams = false
+	e.UpdateMetrics(snapshotAt(now.Add(time.Minute), 0), []idle.ProcessIdleState{withStreams, unknown}, nil)
+	if n := testutil.CollectAndCount(e.processStreams); n != 0 {
+		t.Errorf("expected no stream series once the count is unavailable, got %d", n)
+	}
+}
+
+func TestDeviceUtilizationHistogram(t *testing.T) {
+	e := New(prometheus.Labels{})
+	now := time.Now()
+
+	for i, util := range []uint32{0, 0, 0, 3, 60, 100} {
+		snap := snapshotAt(now.Add(time.Duration(i)*5*time.Second), 0, 1)
+		snap.Devices[0].Utilization = util
+		e.UpdateMetrics(snap, nil, nil)
+	}
+
+	// GPU 1 stayed at 0% throughout
+	want := `
+# HELP gpu_idle_device_utilization_histogram Distribution of GPU compute utilization percentage, observed once per poll. Shows how much of the time a GPU spends near 0%. [unit=percent] [stability=stable]
+# TYPE gpu_idle_device_utilization_histogram histogram
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="0"} 3
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="1"} 3
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="5"} 4
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="10"} 4
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="25"} 4
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="50"} 4
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="75"} 5
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="90"} 5
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="100"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="0",le="+Inf"} 6
+gpu_idle_device_utilization_histogram_sum{gpu="0"} 163
+gpu_idle_device_utilization_histogram_count{gpu="0"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="0"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="1"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="5"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="10"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="25"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="50"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="75"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="90"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="100"} 6
+gpu_idle_device_utilization_histogram_bucket{gpu="1",le="+Inf"} 6
+gpu_idle_device_utilization_histogram_sum{gpu="1"} 0
+gpu_idle_device_utilization_histogram_count{gpu="1"} 6
+`
+	if err := testutil.CollectAndCompare(e.deviceUtilHist, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// GPU 1 disappears: its histogram is dropped
+	e.UpdateMetrics(snapshotAt(now.Add(time.Minute), 0), nil, nil)
+	if n := testutil.CollectAndCount(e.deviceUtilHist); n != 1 {
+		t.Errorf("expected 1 histogram after GPU 1 vanished, got %d", n)
+	}
+}
+
+func TestParseDeviceLabels(t *testing.T) {
+	got, err := ParseDeviceLabels(" gpu, pci_bus_id,gpu ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "gpu,pci_bus_id" {
+		t.Errorf("unexpected labels %v", got)
+	}
+	for _, bad := range []string{"model,uuid", "gpu,serial", ""} {
+		if _, err := ParseDeviceLabels(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	// An unknown label is dropped; the rest are still usable
+	got, err = ParseDeviceLabels("gpu,serial,uuid")
+	if strings.Join(got, ",") != "gpu,uuid" || listconfig.Dropped(err) != 1 {
+		t.Errorf("expected gpu,uuid with 1 dropped, got %v (%v)", got, err)
+	}
+	if got, _ := ParseDeviceLabels("model,serial"); got != nil {
+		t.Errorf("expected no labels without gpu, got %v", got)
+	}
+}
+
+func TestConfigurableDeviceLabels(t *testing.T) {
+	dev := collector.DeviceInfo{Index: 0, UUID: "GPU-abc", Name: "NVIDIA A100", PCIBusID: "00000000:07:00.0", Utilization: 42}
+
+	tests := []struct {
+		labels []string
+		want   string
+	}{
+		{DefaultDeviceLabels, `gpu_idle_device_utilization_percent{gpu="0",model="NVIDIA A100",uuid="GPU-abc"} 42`},
+		{[]string{"gpu"}, `gpu_idle_device_utilization_percent{gpu="0"} 42`},
+		{[]string{"gpu", "pci_bus_id"}, `gpu_idle_device_utilization_percent{gpu="0",pci_bus_id="00000000:07:00.0"} 42`},
+	}
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.labels, ","), func(t *testing.T) {
+			e := New(prometheus.Labels{}, WithDeviceLabels(tc.labels))
+			snap := snapshotAt(time.Now())
+			snap.Devices = []collector.DeviceInfo{dev}
+			e.UpdateMetrics(snap, nil, nil)
+
+			want := `
+# HELP gpu_idle_device_utilization_percent GPU compute utilization percentage (device-level). [unit=percent] [stability=stable]
+# TYPE gpu_idle_device_utilization_percent gauge
+` + tc.want + "\n"
+			if err := testutil.CollectAndCompare(e.deviceUtil, strings.NewReader(want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// userNames resolves UIDs from names, and others to their number.
+func userNames(names map[uint32]string) func(uint32) string {
+	return func(uid uint32) string {
+		if name, ok := names[uid]; ok {
+			return name
+		}
+		return strconv.FormatUint(uint64(uid), 10)
+	}
+}
+
+func TestDistinctIdleUsers(t *testing.T) {
+	const gib = 1 << 30
+	reg := prometheus.NewRegistry()
+	e := newExporter(reg, prometheus.Labels{}, WithUserNames(userNames(map[uint32]string{1001: "alice", 1002: "bob"})))
+	e.Register()
+
+	snap := snapshotAt(time.Now(), 0, 1)
+	snap.ProcessUIDs = map[uint32]uint32{100: 1001, 101: 1001, 200: 1002, 300: 1003, 400: 1004}
+	active := idle.ProcessIdleState{GPU: 1, PID: 400, ProcessName: "python", UsedMemory: gib, SmUtil: 70}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{
+		idleState(0, 100, gib),
+		idleState(0, 101, 2*gib),
+		idleState(0, 200, gib),
+		idleState(1, 300, 4*gib),
+		idleState(1, 500, gib),
+		active,
+	}, nil)
+
+	if got := testutil.ToFloat64(e.distinctIdleUsers.WithLabelValues("0")); got != 2 {
+		t.Errorf("GPU 0: expected 2 distinct idle users, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.distinctIdleUsers.WithLabelValues("1")); got != 1 {
+		t.Errorf("GPU 1: expected 1 distinct idle user, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.nodeDistinctIdleUsers); got != 3 {
+		t.Errorf("expected 3 distinct idle users on the node, got %v", got)
+	}
+	for user, want := range map[string]float64{"alice": 3 * gib, "bob": gib, "1003": 4 * gib} {
+		if got := testutil.ToFloat64(e.userIdleMem.WithLabelValues(user)); got != want {
+			t.Errorf("user %s: expected %v idle bytes, got %v", user, want, got)
+		}
+	}
+
+	// Only bob stays idle
+	snap.Timestamp = snap.Timestamp.Add(time.Minute)
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{idleState(0, 200, gib)}, nil)
+	if n := testutil.CollectAndCount(e.userIdleMem); n != 1 {
+		t.Errorf("expected 1 per-user series, got %d", n)
+	}
+}
+
+func TestUserIdleMemoryRatio(t *testing.T) {
+	const gib = 1 << 30
+	reg := prometheus.NewRegistry()
+	e := newExporter(reg, nil, WithUserNames(userNames(map[uint32]string{1001: "alice", 1002: "bob", 1003: "carol", 1004: "dave"})))
+	e.Register()
+
+	busy := func(gpu int, pid uint32, mem uint64) idle.ProcessIdleState {
+		return idle.ProcessIdleState{GPU: gpu, PID: pid, ProcessName: "python", UsedMemory: mem, SmUtil: 80}
+	}
+	snap := snapshotAt(time.Now(), 0, 1)
+	snap.ProcessUIDs = map[uint32]uint32{100: 1001, 101: 1001, 200: 1002, 201: 1002, 300: 1003, 400: 1004}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{
+		idleState(0, 100, 3*gib),
+		idleState(1, 101, gib),
+		idleState(0, 200, gib),
+		busy(1, 201, 3*gib),
+		busy(0, 300, 2*gib),
+		{GPU: 1, PID: 400, ProcessName: "python", Memoryless: true, SmUtil: 10},
+	}, nil)
+
+	for user, want := range map[string]float64{"alice": 1, "bob": 0.25, "carol": 0} {
+		if got := testutil.ToFloat64(e.userIdleRatio.WithLabelValues(user)); got != want {
+			t.Errorf("user %s: expected idle memory ratio %v, got %v", user, want, got)
+		}
+	}
+	if n := testutil.CollectAndCount(e.userIdleRatio); n != 3 {
+		t.Errorf("expected no ratio for a user holding no memory, got %d series", n)
+	}
+
+	// Everyone but alice exits; their series go
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{idleState(0, 100, 3*gib)}, nil)
+	if n := testutil.CollectAndCount(e.userIdleRatio); n != 1 {
+		t.Errorf("expected only alice's ratio after the others exited, got %d series", n)
+	}
+}
+
+func TestUserIdleMemoryDisabledByDefault(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e := newExporter(reg, prometheus.Labels{})
+	e.Register()
+	snap := snapshotAt(time.Now(), 0)
+	snap.ProcessUIDs = map[uint32]uint32{100: 1001}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{idleState(0, 100, 1<<30)}, nil)
+
+	if n, err := testutil.GatherAndCount(reg, "gpu_idle_user_idle_memory_bytes"); err != nil || n != 0 {
+		t.Errorf("expected no per-user series without user names, got %d (%v)", n, err)
+	}
+	if got := testutil.ToFloat64(e.nodeDistinctIdleUsers); got != 1 {
+		t.Errorf("expected 1 distinct idle user, got %v", got)
+	}
+}
+
+func TestProcessEngineUtilization(t *testing.T) {
+	e := New(prometheus.Labels{})
+	st := idle.ProcessIdleState{
+		GPU: 0, PID: 100, ProcessName: "ffmpeg", UsedMemory: 1 << 30,
+		EngineUtil: collector.EngineUtil{Memory: 12, Decoder: 80},
+	}
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{st}, nil)
+
+	for engine, want := range map[string]float64{"sm": 0, "memory": 12, "encoder": 0, "decoder": 80} {
+		if got := testutil.ToFloat64(e.processEngineUtil.WithLabelValues("0", "100", "ffmpeg", "", engine)); got != want {
+			t.Errorf("engine %s: expected %v, got %v", engine, want, got)
+		}
+	}
+
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), nil, nil)
+	if n := testutil.CollectAndCount(e.processEngineUtil); n != 0 {
+		t.Errorf("expected engine series to be removed with the process, got %d", n)
+	}
+}
+
+func TestDeviceUnattributedUtilization(t *testing.T) {
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 0, 1, 2)
+	snap.Devices[0].Utilization = 80 // busy, explained by PID 100
+	snap.Devices[1].Utilization = 80 // busy, but its only process shows no SM activity
+	snap.Devices[2].Utilization = 5  // below the device threshold
+
+	attributed := idle.ProcessIdleState{GPU: 0, PID: 100, ProcessName: "python", UsedMemory: 1 << 30, SmUtil: 75}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{attributed, idleState(1, 200, 1<<30)}, nil)
+
+	for gpu, want := range map[string]float64{"0": 0, "1": 1, "2": 0} {
+		if got := testutil.ToFloat64(e.deviceUnattributed.WithLabelValues(gpu)); got != want {
+			t.Errorf("GPU %s: expected %v, got %v", gpu, want, got)
+		}
+	}
+}
+
+func TestRecordEpisodes(t *testing.T) {
+	e := New(prometheus.Labels{})
+	e.RecordEpisodes([]idle.Episode{{GPU: 0, PID: 1}, {GPU: 0, PID: 2}, {GPU: 1, PID: 3}})
+	e.RecordEpisodes(nil)
+
+	if got := testutil.ToFloat64(e.idleEpisodes.WithLabelValues("0")); got != 2 {
+		t.Errorf("GPU 0: expected 2 episodes, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.idleEpisodes.WithLabelValues("1")); got != 1 {
+		t.Errorf("GPU 1: expected 1 episode, got %v", got)
+	}
+}
+
+func TestEpisodeDurationHistogram(t *testing.T) {
+	e := New(prometheus.Labels{})
+	t0 := time.Now()
+	e.RecordEpisodes([]idle.Episode{
+		{GPU: 0, PID: 1, Start: t0, End: t0.Add(20 * time.Second)},
+		{GPU: 1, PID: 2, Start: t0, End: t0.Add(2 * time.Hour)},
+	})
+	e.RecordEpisodes(nil) // a poll in which no episode ended
+
+	expected := `
+# HELP gpu_idle_episode_duration_seconds Duration of ended idle episodes, observed once per episode. Episodes shorter than the minimum episode duration are not observed. [unit=seconds] [stability=stable]
+# TYPE gpu_idle_episode_duration_seconds histogram
+gpu_idle_episode_duration_seconds_bucket{le="10"} 0
+gpu_idle_episode_duration_seconds_bucket{le="30"} 1
+gpu_idle_episode_duration_seconds_bucket{le="60"} 1
+gpu_idle_episode_duration_seconds_bucket{le="300"} 1
+gpu_idle_episode_duration_seconds_bucket{le="900"} 1
+gpu_idle_episode_duration_seconds_bucket{le="3600"} 1
+gpu_idle_episode_duration_seconds_bucket{le="21600"} 2
+gpu_idle_episode_duration_seconds_bucket{le="+Inf"} 2
+gpu_idle_episode_duration_seconds_sum 7220
+gpu_idle_episode_duration_seconds_count 2
+`
+	if err := testutil.CollectAndCompare(e.episodeDurations, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSafelyReclaimableMemory(t *testing.T) {
+	e := New(prometheus.Labels{}, WithReclaimSafetyDuration(30*time.Minute))
+	const gib = 1 << 30
+
+	recent := idleState(0, 100, 1*gib)
+	recent.IdleDuration = 5 * time.Minute
+	longIdle := idleState(0, 101, 2*gib)
+	longIdle.IdleDuration = 2 * time.Hour
+	exempt := idleState(0, 102, 4*gib)
+	exempt.IdleDuration = 2 * time.Hour
+	exempt.Exempt = true
+	active := idle.ProcessIdleState{GPU: 0, PID: 103, ProcessName: "python", UsedMemory: 8 * gib, SmUtil: 90}
+	otherGPU := idleState(1, 104, 16*gib)
+	otherGPU.IdleDuration = 30 * time.Minute
+
+	e.UpdateMetrics(snapshotAt(time.Now(), 0, 1), []idle.ProcessIdleState{recent, longIdle, exempt, active, otherGPU}, nil)
+
+	if got := testutil.ToFloat64(e.safelyReclaimable.WithLabelValues("0")); got != 2*gib {
+		t.Errorf("GPU 0: expected only the long-idle process's 2 GiB, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.safelyReclaimable.WithLabelValues("1")); got != 16*gib {
+		t.Errorf("GPU 1: expected 16 GiB idle exactly the safety duration, got %v", got)
+	}
+	// Raw idle memory is unaffected by the safety policy
+	if got := testutil.ToFloat64(e.idleMemTotal.WithLabelValues("0")); got != 7*gib {
+		t.Errorf("GPU 0: expected 7 GiB idle, got %v", got)
+	}
+}
+
+func TestDeviceSquatted(t *testing.T) {
+	e := New(prometheus.Labels{}, WithSquatDetection(0.9, time.Hour))
+	const gib = 1 << 30
+
+	squatter := idleState(0, 100, 38*gib)
+	squatter.IdleDuration = 2 * time.Hour
+	recent := idleState(1, 101, 38*gib)
+	recent.IdleDuration = 5 * time.Minute
+	// Two idle processes together hold the GPU, but neither alone does
+	shared1, shared2 := idleState(2, 102, 20*gib), idleState(2, 103, 20*gib)
+	shared1.IdleDuration, shared2.IdleDuration = 2*time.Hour, 2*time.Hour
+	exempt := idleState(3, 104, 38*gib)
+	exempt.IdleDuration = 2 * time.Hour
+	exempt.Exempt = true
+
+	snap := snapshotAt(time.Now(), 0, 1, 2, 3)
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{squatter, recent, shared1, shared2, exempt}, nil)
+	if n := testutil.CollectAndCount(e.deviceSquatted); n != 1 {
+		t.Fatalf("expected only GPU 0 squatted, got %d series", n)
+	}
+	if got := testutil.ToFloat64(e.deviceSquatted.WithLabelValues("0", "100", "python", "")); got != 1 {
+		t.Errorf("expected GPU 0 squatted by PID 100, got %v", got)
+	}
+
+	// The squatter resumes work: the GPU is no longer squatted
+	squatter.IsIdle, squatter.IdleDuration, squatter.IdleMemory = false, 0, 0
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{squatter, recent, shared1, shared2, exempt}, nil)
+	if n := testutil.CollectAndCount(e.deviceSquatted); n != 0 {
+		t.Errorf("expected no squatted GPUs, got %d series", n)
+	}
+}
+
+func TestIdleProcessesOnly(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e := newExporter(reg, nil, WithIdleProcessesOnly())
+	e.Register()
+	const gib = 1 << 30
+
+	active := idle.ProcessIdleState{GPU: 0, PID: 100, ProcessName: "python", UsedMemory: 8 * gib, SmUtil: 90}
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{active, idleState(0, 101, 2*gib)}, nil)
+
+	if n := testutil.CollectAndCount(e.processMemUsed); n != 1 {
+		t.Errorf("expected only the idle process's series, got %d", n)
+	}
+	if n := testutil.CollectAndCount(e.processStatus); n != len(processStatuses) {
+		t.Errorf("expected status series for the idle process only, got %d", n)
+	}
+	if n := testutil.CollectAndCount(e.processNodeIdle); n != 1 {
+		t.Errorf("expected node idle series for the idle process only, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.idleMemTotal.WithLabelValues("0")); got != 2*gib {
+		t.Errorf("expected aggregate idle memory 2 GiB, got %v", got)
+	}
+
+	// The idle process becomes active: its series are cleaned up
+	nowActive := idle.ProcessIdleState{GPU: 0, PID: 101, ProcessName: "python", UsedMemory: 2 * gib, SmUtil: 50}
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{active, nowActive}, nil)
+	for name, c := range map[string]prometheus.Collector{
+		"memory": e.processMemUsed, "status": e.processStatus, "node idle": e.processNodeIdle, "engine": e.processEngineUtil,
+	} {
+		if n := testutil.CollectAndCount(c); n != 0 {
+			t.Errorf("%s: expected no series once every process is active, got %d", name, n)
+		}
+	}
+	if n := testutil.CollectAndCount(e.deviceUtil); n != 1 {
+		t.Errorf("device metrics should still be emitted, got %d series", n)
+	}
+}
+
+func TestAggregatedProcessMetrics(t *testing.T) {
+	e := New(prometheus.Labels{}, WithProcessMetricsMode(ProcessMetricsAggregated))
+	const gib = 1 << 30
+
+	first, second := idleState(0, 100, 2*gib), idleState(0, 101, gib)
+	first.IdleDuration, second.IdleDuration = time.Minute, 30*time.Second
+	first.IdleTotal, second.IdleTotal = time.Minute, 30*time.Second
+	active := idle.ProcessIdleState{GPU: 0, PID: 200, ProcessName: "trainer", UsedMemory: 8 * gib, SmUtil: 90}
+	other := idleState(1, 300, gib)
+	snap := snapshotAt(time.Now(), 0, 1)
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{first, second, active, other}, nil)
+
+	// One series per process name and GPU, without pid
+	if n := testutil.CollectAndCount(e.processMemUsed); n != 3 {
+		t.Errorf("expected 3 series, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.processMemUsed.WithLabelValues("0", "", "python", "")); got != 3*gib {
+		t.Errorf("expected memory summed to 3 GiB, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processIdleMem.WithLabelValues("0", "", "python", "")); got != 3*gib {
+		t.Errorf("expected idle memory summed to 3 GiB, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processIdleSecs.WithLabelValues("0", "", "python", "")); got != 60 {
+		t.Errorf("expected the longest idle duration, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processIdleTotal.WithLabelValues("0", "", "python", "")); got != 90 {
+		t.Errorf("expected idle time summed to 90s, got %v", got)
+	}
+	if vals := statusValues(t, e, "0", "", "python", ""); vals["idle"] != 1 {
+		t.Errorf("expected the python group idle, got %v", vals)
+	}
+	if vals := statusValues(t, e, "0", "", "trainer", ""); vals["active"] != 1 {
+		t.Errorf("expected the trainer group active, got %v", vals)
+	}
+	if got := testutil.ToFloat64(e.processNodeIdle.WithLabelValues("", "python")); got != 1 {
+		t.Errorf("expected python idle across the node, got %v", got)
+	}
+
+	// A process exits: the group's idle time counter doesn't go back
+	second.IdleTotal += 5 * time.Second
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{second, active, other}, nil)
+	if got := testutil.ToFloat64(e.processIdleTotal.WithLabelValues("0", "", "python", "")); got != 95 {
+		t.Errorf("expected idle time 95s after PID 100 exited, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processMemUsed.WithLabelValues("0", "", "python", "")); got != gib {
+		t.Errorf("expected 1 GiB after PID 100 exited, got %v", got)
+	}
+
+	// A busy process makes the group active
+	busy := idle.ProcessIdleState{GPU: 0, PID: 102, ProcessName: "python", UsedMemory: gib, SmUtil: 40}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{second, busy, active, other}, nil)
+	if vals := statusValues(t, e, "0", "", "python", ""); vals["active"] != 1 {
+		t.Errorf("expected the python group active, got %v", vals)
+	}
+	if got := testutil.ToFloat64(e.processComputeUtil.WithLabelValues("0", "", "python", "")); got != 40 {
+		t.Errorf("expected the highest utilization, got %v", got)
+	}
+}
+
+func TestProcessIdleConfidence(t *testing.T) {
+	e := New(prometheus.Labels{})
+	sure := idleState(0, 100, 1<<30)
+	sure.Confidence = idle.ConfidenceHigh
+	unsure := idleState(0, 101, 1<<30)
+	unsure.Confidence = idle.ConfidenceFallback
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{sure, unsure}, nil)
+
+	if got := testutil.ToFloat64(e.processConfidence.WithLabelValues("0", "100", "python", "")); got != 1 {
+		t.Errorf("expected confidence 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processConfidence.WithLabelValues("0", "101", "python", "")); got != 0.25 {
+		t.Errorf("expected confidence 0.25, got %v", got)
+	}
+
+	// Series are removed with the process
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{sure}, nil)
+	if n := testutil.CollectAndCount(e.processConfidence); n != 1 {
+		t.Errorf("expected 1 series after PID 101 exited, got %d", n)
+	}
+}
+
+func TestProcessSmoothedUtilization(t *testing.T) {
+	e := New(prometheus.Labels{})
+	ps := idle.ProcessIdleState{GPU: 0, PID: 100, ProcessName: "python", UsedMemory: 1 << 30, SmUtil: 80, SmoothedUtil: 42.5}
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{ps}, nil)
+
+	if got := testutil.ToFloat64(e.processComputeUtil.WithLabelValues("0", "100", "python", "")); got != 80 {
+		t.Errorf("expected raw utilization 80, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.processSmoothUtil.WithLabelValues("0", "100", "python", "")); got != 42.5 {
+		t.Errorf("expected smoothed utilization 42.5, got %v", got)
+	}
+
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), nil, nil)
+	if n := testutil.CollectAndCount(e.processSmoothUtil); n != 0 {
+		t.Errorf("expected series removed with the process, got %d", n)
+	}
+}
+
+func TestDeviceSerialInfo(t *testing.T) {
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 0, 1)
+	snap.Devices[0].UUID, snap.Devices[0].Serial = "GPU-a", "1320221234567"
+	snap.Devices[1].UUID = "GPU-b" // consumer card, no serial
+	e.UpdateMetrics(snap, nil, nil)
+
+	if n := testutil.CollectAndCount(e.deviceSerial); n != 1 {
+		t.Fatalf("expected 1 serial series, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.deviceSerial.WithLabelValues("0", "GPU-a", "1320221234567")); got != 1 {
+		t.Errorf("expected serial info 1, got %v", got)
+	}
+
+	// The board is replaced: the old serial's series goes away
+	snap = snapshotAt(time.Now(), 0, 1)
+	snap.Devices[0].UUID, snap.Devices[0].Serial = "GPU-c", "1320229999999"
+	e.UpdateMetrics(snap, nil, nil)
+	if n := testutil.CollectAndCount(e.deviceSerial); n != 1 {
+		t.Errorf("expected only the new serial's series, got %d", n)
+	}
+}
+
+func TestDeviceFirmwareInfo(t *testing.T) {
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 0, 1, 2)
+	snap.Devices[0].UUID, snap.Devices[0].VbiosVersion, snap.Devices[0].BoardPartNumber = "GPU-a", "92.00.45.00.03", "900-21001-0000-000"
+	snap.Devices[1].UUID, snap.Devices[1].VbiosVersion = "GPU-b", "92.00.45.00.03" // part number unsupported
+	snap.Devices[2].UUID = "GPU-c"                                                 // neither
+	e.UpdateMetrics(snap, nil, nil)
+
+	if n := testutil.CollectAndCount(e.deviceFirmware); n != 2 {
+		t.Fatalf("expected 2 firmware series, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.deviceFirmware.WithLabelValues("0", "GPU-a", "92.00.45.00.03", "900-21001-0000-000")); got != 1 {
+		t.Errorf("expected firmware info 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.deviceFirmware.WithLabelValues("1", "GPU-b", "92.00.45.00.03", "")); got != 1 {
+		t.Errorf("expected firmware info 1 with an empty part number, got %v", got)
+	}
+
+	// The VBIOS is updated: the old version's series goes away
+	snap.Devices[0].VbiosVersion = "92.00.5E.00.01"
+	e.UpdateMetrics(snap, nil, nil)
+	if n := testutil.CollectAndCount(e.deviceFirmware); n != 2 {
+		t.Errorf("expected only the updated version's series, got %d", n)
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	e := New(prometheus.Labels{})
+	e.SetBuildInfo("v1.2.0", "abc123")
+
+	if n := testutil.CollectAndCount(e.buildInfo); n != 1 {
+		t.Fatalf("expected 1 build info series, got %d", n)
+	}
+	if got := testutil.ToFloat64(e.buildInfo.WithLabelValues("v1.2.0", "abc123", runtime.Version())); got != 1 {
+		t.Errorf("expected build info 1, got %v", got)
+	}
+}
+
+func TestSystemInfo(t *testing.T) {
+	e := New(prometheus.Labels{})
+	if n := testutil.CollectAndCount(e.systemInfo); n != 0 {
+		t.Fatalf("expected no system info before NVML is initialized, got %d series", n)
+	}
+	e.SetSystemInfo("550.54.15", "12.4", "12.550.54.15")
+	if got := testutil.ToFloat64(e.systemInfo.WithLabelValues("550.54.15", "12.4", "12.550.54.15")); got != 1 {
+		t.Errorf("expected system info 1, got %v", got)
+	}
+}
+
+func TestSetDeviceIdle(t *testing.T) {
+	e := New(prometheus.Labels{})
+	e.SetDeviceIdle([]idle.DeviceIdleState{{GPU: 0, IsIdle: true, IdleDuration: 90 * time.Second}, {GPU: 1}})
+	if got := testutil.ToFloat64(e.deviceIdleSecs.WithLabelValues("0")); got != 90 {
+		t.Errorf("GPU 0: expected idle for 90s, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.deviceIdleSecs.WithLabelValues("1")); got != 0 {
+		t.Errorf("GPU 1: expected 0 while in use, got %v", got)
+	}
+
+	// GPU 1 disappears
+	e.SetDeviceIdle([]idle.DeviceIdleState{{GPU: 0, IsIdle: true, IdleDuration: 95 * time.Second}})
+	if n := testutil.CollectAndCount(e.deviceIdleSecs); n != 1 {
+		t.Errorf("expected the vanished GPU's series removed, got %d series", n)
+	}
+}
+
+func TestRecordProcessChurn(t *testing.T) {
+	e := New(prometheus.Labels{})
+	e.RecordProcessChurn(20, 4)
+	e.RecordProcessChurn(1, 0.1)
+
+	if got := testutil.ToFloat64(e.processesSeen); got != 21 {
+		t.Errorf("expected 21 processes seen, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.newProcessRate); got != 0.1 {
+		t.Errorf("expected the latest rate 0.1/s, got %v", got)
+	}
+}
+
+func TestConfigGauges(t *testing.T) {
+	e := New(prometheus.Labels{})
+	e.SetConfig(idle.Policy{SmThreshold: 3, GracePeriod: 2 * time.Minute}, 30*time.Second, 5*time.Second)
+
+	for name, tc := range map[string]struct {
+		g    prometheus.Gauge
+		want float64
+	}{
+		"sm threshold":  {e.configSmThreshold, 3},
+		"grace period":  {e.configGracePeriod, 120},
+		"stale timeout": {e.configStaleTimeout, 30},
+		"poll interval": {e.configPollInterval, 5},
+	} {
+		if got := testutil.ToFloat64(tc.g); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, got)
+		}
+	}
+
+	e.SetSampleWindows(map[int]time.Duration{1: 1500 * time.Millisecond})
+	if got := testutil.ToFloat64(e.sampleWindow.WithLabelValues("1")); got != 1.5 {
+		t.Errorf("expected a 1.5s sample window on GPU 1, got %v", got)
+	}
+	if n := testutil.CollectAndCount(e.sampleWindow); n != 1 {
+		t.Errorf("expected only probed GPUs to have a sample window, got %d series", n)
+	}
+}
+
+// jobEnricher labels processes with a job ID by PID.
+type jobEnricher map[uint32]string
+
+func (j jobEnricher) LabelNames() []string { return []string{"job", "pid"} }
+
+func (j jobEnricher) Labels(ps idle.ProcessIdleState) map[string]string {
+	return map[string]string{"job": j[ps.PID], "pid": "overridden"}
+}
+
+func TestProcessInfoEnrichers(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	jobs := jobEnricher{100: "train-42"}
+	e := newExporter(reg, nil, WithEnrichers(jobs))
+	e.Register()
+
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{idleState(0, 100, 1<<30), idleState(0, 101, 1<<30)}, nil)
+	expected := `
+# HELP gpu_idle_process_info Site-specific labels of this process, from the configured enrichers. Join on gpu, pid, process and mig_instance. Always 1. [unit=info] [stability=stable]
+# TYPE gpu_idle_process_info gauge
+gpu_idle_process_info{gpu="0",job="train-42",mig_instance="",pid="100",process="python"} 1
+gpu_idle_process_info{gpu="0",job="",mig_instance="",pid="101",process="python"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gpu_idle_process_info"); err != nil {
+		t.Error(err)
+	}
+
+	// Relabelled and exited processes lose their old series
+	jobs[100] = "train-43"
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{idleState(0, 100, 1<<30)}, nil)
+	expected = `
+# HELP gpu_idle_process_info Site-specific labels of this process, from the configured enrichers. Join on gpu, pid, process and mig_instance. Always 1. [unit=info] [stability=stable]
+# TYPE gpu_idle_process_info gauge
+gpu_idle_process_info{gpu="0",job="train-43",mig_instance="",pid="100",process="python"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gpu_idle_process_info"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessInfoAggregated(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	jobs := jobEnricher{100: "train-42", 101: "train-42", 200: "train-42", 201: "train-43"}
+	e := newExporter(reg, nil, WithEnrichers(jobs), WithProcessMetricsMode(ProcessMetricsAggregated))
+	e.Register()
+
+	trainer := func(pid uint32) idle.ProcessIdleState {
+		ps := idleState(0, pid, 1<<30)
+		ps.ProcessName = "trainer"
+		return ps
+	}
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{
+		idleState(0, 100, 1<<30), idleState(0, 101, 1<<30), trainer(200), trainer(201),
+	}, nil)
+
+	// One series per group, so the join on the per-process labels stays
+	// one-to-one; labels the processes disagree on are left empty
+	expected := `
+# HELP gpu_idle_process_info Site-specific labels of this process, from the configured enrichers. Join on gpu, pid, process and mig_instance. Always 1. [unit=info] [stability=stable]
+# TYPE gpu_idle_process_info gauge
+gpu_idle_process_info{gpu="0",job="train-42",mig_instance="",pid="",process="python"} 1
+gpu_idle_process_info{gpu="0",job="",mig_instance="",pid="",process="trainer"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gpu_idle_process_info"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessInfoDisabledByDefault(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e := newExporter(reg, nil)
+	e.Register()
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{idleState(0, 100, 1<<30)}, nil)
+	if n, err := testutil.GatherAndCount(reg, "gpu_idle_process_info"); err != nil || n != 0 {
+		t.Errorf("expected no process info series without enrichers, got %d (%v)", n, err)
+	}
+}
+
+func TestBusyGPUSeconds(t *testing.T) {
+	e := New(prometheus.Labels{})
+	t0 := time.Now()
+	poll := func(offset time.Duration, util0, util1 float64) {
+		snap := snapshotAt(t0.Add(offset), 0, 1)
+		snap.Devices[0].UtilizationFine = util0
+		snap.Devices[1].UtilizationFine = util1
+		e.UpdateMetrics(snap, nil, nil)
+	}
+
+	poll(0, 100, 100)             // nothing to integrate yet
+	poll(10*time.Second, 50, 0)   // GPU 0: 10s * 0.5
+	poll(15*time.Second, 100, 20) // GPU 0: 5s * 1, GPU 1: 5s * 0.2
+	poll(35*time.Second, 12.5, 0) // delayed poll: GPU 0: 20s * 0.125
+
+	if got := testutil.ToFloat64(e.deviceBusySecs.WithLabelValues("0")); math.Abs(got-12.5) > 1e-9 {
+		t.Errorf("GPU 0: expected 12.5 busy seconds, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.deviceBusySecs.WithLabelValues("1")); math.Abs(got-1) > 1e-9 {
+		t.Errorf("GPU 1: expected 1 busy second, got %v", got)
+	}
+}
+
+func TestDeviceClocks(t *testing.T) {
+	e := New(prometheus.Labels{}, WithDeviceLabels([]string{"gpu"}))
+	snap := snapshotAt(time.Now(), 0, 1)
+	snap.Devices[0].SmClockMHz, snap.Devices[0].MemClockMHz, snap.Devices[0].GraphicsClockMHz = 1410, 1215, 1400
+	e.UpdateMetrics(snap, nil, nil) // GPU 1 doesn't report clocks
+
+	for name, tc := range map[string]struct {
+		g    *prometheus.GaugeVec
+		want float64
+	}{
+		"sm":       {e.deviceSmClock, 1410},
+		"memory":   {e.deviceMemClock, 1215},
+		"graphics": {e.deviceGraphicsClock, 1400},
+	} {
+		if n := testutil.CollectAndCount(tc.g); n != 1 {
+			t.Errorf("%s: expected a series for GPU 0 only, got %d", name, n)
+		}
+		if got := testutil.ToFloat64(tc.g.WithLabelValues("0")); got != tc.want {
+			t.Errorf("%s: expected %v MHz, got %v", name, tc.want, got)
+		}
+	}
+
+	// The query starts failing: the series goes away rather than reading 0
+	snap.Devices[0].SmClockMHz = 0
+	e.UpdateMetrics(snap, nil, nil)
+	if n := testutil.CollectAndCount(e.deviceSmClock); n != 0 {
+		t.Errorf("expected the SM clock series removed, got %d", n)
+	}
+}
+
+func TestDeviceMigMode(t *testing.T) {
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 0, 1, 2)
+	snap.Devices[0].MigCapable, snap.Devices[0].MigEnabled, snap.Devices[0].MigPending = true, false, true
+	snap.Devices[1].MigCapable, snap.Devices[1].MigEnabled, snap.Devices[1].MigPending = true, true, true
+	e.UpdateMetrics(snap, nil, nil) // GPU 2 doesn't support MIG
+
+	if n := testutil.CollectAndCount(e.deviceMigMode); n != 2 {
+		t.Fatalf("expected series for the 2 MIG-capable GPUs, got %d", n)
+	}
+	if v := testutil.ToFloat64(e.deviceMigMode.WithLabelValues("0", "disabled", "enabled")); v != 1 {
+		t.Errorf("expected GPU 0 flagged as awaiting reset, got %v", v)
+	}
+	if v := testutil.ToFloat64(e.deviceMigMode.WithLabelValues("1", "enabled", "enabled")); v != 0 {
+		t.Errorf("expected GPU 1 not flagged, got %v", v)
+	}
+
+	// After the reset the pending mode is applied and the old series goes away
+	snap.Devices[0].MigEnabled = true
+	e.UpdateMetrics(snap, nil, nil)
+	if n := testutil.CollectAndCount(e.deviceMigMode); n != 2 {
+		t.Errorf("expected the stale mode series dropped, got %d series", n)
+	}
+}
+
+func TestProcessEfficiency(t *testing.T) {
+	tests := []struct {
+		name   string
+		util   float64
+		memory uint64
+		want   float64
+	}{
+		{"large and nearly idle", 5, 40 << 30, 0.1},
+		{"small and busy", 90, 2 << 30, 36},
+		{"whole GPU fully used", 100, 80 << 30, 1},
+		{"holding memory with no compute", 0, 10 << 30, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := New(prometheus.Labels{})
+			snap := snapshotAt(time.Now(), 0)
+			snap.Devices[0].MemoryTotal = 80 << 30
+			ps := idleState(0, 100, tc.memory)
+			ps.SmoothedUtil = tc.util
+			e.UpdateMetrics(snap, []idle.ProcessIdleState{ps}, nil)
+
+			got := testutil.ToFloat64(e.processEfficiency.WithLabelValues("0", "100", ps.ProcessName, ""))
+			if math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("expected efficiency %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	// No memory held: the score is undefined and not emitted
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 0)
+	snap.Devices[0].MemoryTotal = 80 << 30
+	ps := idleState(0, 100, 0)
+	ps.SmoothedUtil = 50
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{ps}, nil)
+	if n := testutil.CollectAndCount(e.processEfficiency); n != 0 {
+		t.Errorf("expected no efficiency series without memory, got %d", n)
+	}
+}
+
+func TestProcessOccupancy(t *testing.T) {
+	e := New(prometheus.Labels{}, WithMaxProcessesPerGPU(4))
+	e.UpdateMetrics(snapshotAt(time.Now(), 0, 1, 2), []idle.ProcessIdleState{
+		idleState(0, 100, 1<<30),
+		idleState(1, 200, 1<<30), idleState(1, 201, 1<<30), idleState(1, 202, 1<<30), idleState(1, 203, 1<<30),
+		idleState(2, 300, 1<<30), idleState(2, 301, 1<<30), idleState(2, 302, 1<<30), idleState(2, 303, 1<<30),
+		idleState(2, 304, 1<<30),
+	}, nil)
+
+	for gpu, want := range map[string]float64{"0": 0.25, "1": 1, "2": 1} {
+		if got := testutil.ToFloat64(e.occupancy.WithLabelValues(gpu)); got != want {
+			t.Errorf("GPU %s: expected occupancy %v, got %v", gpu, want, got)
+		}
+	}
+
+	// Unconfigured: no ratio at all
+	e = New(prometheus.Labels{})
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), []idle.ProcessIdleState{idleState(0, 100, 1<<30)}, nil)
+	if n := testutil.CollectAndCount(e.occupancy); n != 0 {
+		t.Errorf("expected no occupancy series without a configured maximum, got %d", n)
+	}
+}
+
+func TestPersistencedHealthy(t *testing.T) {
+	tests := []struct {
+		name        string
+		running     bool
+		persistence []bool
+		want        float64
+	}{
+		{"daemon running, persistence on", true, []bool{true, true}, 1},
+		{"daemon down", false, []bool{true, true}, 0},
+		{"persistence off on one GPU", true, []bool{true, false}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := New(prometheus.Labels{})
+			snap := snapshotAt(time.Now(), 0, 1)
+			snap.PersistencedChecked, snap.PersistencedRunning = true, tc.running
+			for i, on := range tc.persistence {
+				snap.Devices[i].PersistenceMode, snap.Devices[i].HasPersistenceMode = on, true
+			}
+			e.UpdateMetrics(snap, nil, nil)
+			if got := testutil.ToFloat64(e.persistencedHealthy.WithLabelValues()); got != tc.want {
+				t.Errorf("expected healthy=%v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	// Check disabled: no series
+	e := New(prometheus.Labels{})
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), nil, nil)
+	if n := testutil.CollectAndCount(e.persistencedHealthy); n != 0 {
+		t.Errorf("expected no series without the check, got %d", n)
+	}
+}
+
+func TestDeviceThrottled(t *testing.T) {
+	e := New(prometheus.Labels{}, WithDeviceLabels([]string{"gpu"}))
+	snap := snapshotAt(time.Now(), 0, 1)
+	snap.Devices[0].ThrottleReasons = 0x4 | 0x40 // sw_power_cap, hw_thermal
+	snap.Devices[0].HasThrottleReasons = true
+	e.UpdateMetrics(snap, nil, nil) // GPU 1 doesn't report throttle reasons
+
+	if n := testutil.CollectAndCount(e.deviceThrottled); n != len(collector.ThrottleReasons) {
+		t.Errorf("expected one series per reason for GPU 0 only, got %d", n)
+	}
+	for reason, want := range map[string]float64{"sw_power_cap": 1, "hw_thermal": 1, "idle": 0, "hw_slowdown": 0} {
+		if got := testutil.ToFloat64(e.deviceThrottled.WithLabelValues("0", reason)); got != want {
+			t.Errorf("reason %s: expected %v, got %v", reason, want, got)
+		}
+	}
+}
+
+func TestProcessMemoryFraction(t *testing.T) {
+	e := New(prometheus.Labels{})
+	snap := snapshotAt(time.Now(), 0, 1)
+	snap.Devices[0].MemoryTotal = 80 << 30
+	snap.Devices[1].MemoryTotal = 0 // unknown
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{
+		idleState(0, 100, 20<<30),
+		idleState(0, 101, 80<<30),
+		idleState(1, 200, 4<<30),
+	}, nil)
+
+	for _, tc := range []struct {
+		gpu, pid string
+		want     float64
+	}{
+		{"0", "100", 0.25},
+		{"0", "101", 1},
+		{"1", "200", 0}, // no division by a zero total
+	} {
+		if got := testutil.ToFloat64(e.processMemFraction.WithLabelValues(tc.gpu, tc.pid, "python", "")); got != tc.want {
+			t.Errorf("GPU %s PID %s: expected fraction %v, got %v", tc.gpu, tc.pid, tc.want, got)
+		}
+	}
+}
+
+func TestIdleMemoryByDuration(t *testing.T) {
+	withIdle := func(gpu int, pid uint32, mem uint64, d time.Duration) idle.ProcessIdleState {
+		ps := idleState(gpu, pid, mem)
+		ps.IdleDuration = d
+		return ps
+	}
+	const gib = 1 << 30
+	e := New(prometheus.Labels{})
+	e.UpdateMetrics(snapshotAt(time.Now(), 0, 1), []idle.ProcessIdleState{
+		withIdle(0, 100, 1*gib, 5*time.Second),
+		withIdle(0, 101, 2*gib, 59*time.Second),
+		withIdle(0, 102, 4*gib, time.Minute),
+		withIdle(0, 103, 8*gib, 30*time.Minute),
+		withIdle(0, 104, 16*gib, 5*time.Hour),
+		{GPU: 1, PID: 200, ProcessName: "python", UsedMemory: 32 * gib},
+	}, nil)
+
+	for bucket, want := range map[string]float64{"0-1m": 3 * gib, "1m-10m": 4 * gib, "10m-1h": 8 * gib, "1h+": 16 * gib} {
+		if got := testutil.ToFloat64(e.idleMemByDuration.WithLabelValues("0", bucket)); got != want {
+			t.Errorf("GPU 0 bucket %s: expected %v, got %v", bucket, want, got)
+		}
+		if got := testutil.ToFloat64(e.idleMemByDuration.WithLabelValues("1", bucket)); got != 0 {
+			t.Errorf("GPU 1 bucket %s: expected 0 for an active process, got %v", bucket, got)
+		}
+	}
+
+	// GPU 1 disappears: its buckets go with it
+	e.UpdateMetrics(snapshotAt(time.Now(), 0), nil, nil)
+	if n := testutil.CollectAndCount(e.idleMemByDuration); n != len(idleDurationBuckets) {
+		t.Errorf("expected only GPU 0's %d buckets, got %d series", len(idleDurationBuckets), n)
+	}
+}
+
+func TestProcessDataMoved(t *testing.T) {
+	e := New(prometheus.Labels{})
+	now := time.Now()
+	ps := idleState(0, 100, 1<<30)
+	ps.DataMoved, ps.HasDataMoved = 3<<30, true
+	e.UpdateMetrics(snapshotAt(now, 0), []idle.ProcessIdleState{ps, idleState(0, 200, 1<<30)}, nil)
+	ps.DataMoved = 1 << 30
+	e.UpdateMetrics(snapshotAt(now.Add(10*time.Second), 0), []idle.ProcessIdleState{ps, idleState(0, 200, 1<<30)}, nil)
+
+	if got := testutil.ToFloat64(e.processDataMoved.WithLabelValues("0", "100", "python", "")); got != 4<<30 {
+		t.Errorf("expected 4 GiB moved in total, got %v", got)
+	}
+	if n := testutil.CollectAndCount(e.processDataMoved); n != 1 {
+		t.Errorf("expected the series only for the process with a counter, got %d", n)
+	}
+}
+
+func TestProcessIdleSecondsTotal(t *testing.T) {
+	e := New(prometheus.Labels{})
+	now := time.Now()
+	ps := idleState(0, 100, 1<<30)
+	for _, total := range []time.Duration{0, 20 * time.Second, 20 * time.Second, 45 * time.Second} {
+		ps.IdleTotal = total
+		e.UpdateMetrics(snapshotAt(now, 0), []idle.ProcessIdleState{ps}, nil)
+	}
+	if got := testutil.ToFloat64(e.processIdleTotal.WithLabelValues("0", "100", "python", "")); got != 45 {
+		t.Errorf("expected 45s idle in total, got %v", got)
+	}
+
+	// The process goes away: the counter is cleaned up with the gauges
+	e.UpdateMetrics(snapshotAt(now, 0), nil, nil)
+	if n := testutil.CollectAndCount(e.processIdleTotal); n != 0 {
+		t.Errorf("expected the counter removed with the process, got %d series", n)
+	}
+}
+
+// recordingRegisterer records the collectors registered through it.
+type recordingRegisterer struct {
+	prometheus.Registerer
+	collectors []prometheus.Collector
+}
+
+func (r *recordingRegisterer) MustRegister(cs ...prometheus.Collector) {
+	r.Registerer.MustRegister(cs...)
+	r.collectors = append(r.collectors, cs...)
+}
+
+func TestEveryMetricHasUnitMetadata(t *testing.T) {
+	reg := &recordingRegisterer{Registerer: prometheus.NewRegistry()}
+	e := newExporter(reg, nil,
+		WithDCGMMetrics(),
+		WithEnrichers(jobEnricher{}),
+		WithUserNames(userNames(map[uint32]string{1001: "alice"})),
+	)
+	e.Register()
+
+	byName := make(map[string]MetricMetadata)
+	for _, md := range e.Metadata() {
+		byName[md.Name] = md
+	}
+	for _, c := range reg.collectors {
+		ch := make(chan *prometheus.Desc, 1)
+		go func() { c.Describe(ch); close(ch) }()
+		for d := range ch {
+			if !strings.Contains(d.String(), "[unit=") || !strings.Contains(d.String(), "[stability=") {
+				t.Errorf("help lacks unit or stability marker: %s", d)
+			}
+		}
+	}
+	if len(byName) != len(reg.collectors) {
+		t.Errorf("expected metadata for %d metrics, got %d", len(reg.collectors), len(byName))
+	}
+	for name, md := range byName {
+		if md.Unit == "" || md.Stability == "" || md.Type == "" {
+			t.Errorf("%s: incomplete metadata %+v", name, md)
+		}
+		// Help strings are literal text, not format strings
+		if strings.Contains(md.Help, "%%") {
+			t.Errorf("%s: help has an escaped %%: %q", name, md.Help)
+		}
+	}
+	if got := byName["gpu_idle_process_memory_used_bytes"]; got.Unit != "bytes" || got.Type != "gauge" ||
+		strings.Join(got.Labels, ",") != "gpu,pid,process,mig_instance" {
+		t.Errorf("unexpected metadata for gpu_idle_process_memory_used_bytes: %+v", got)
+	}
+}
+
+func TestMetadataHandler(t *testing.T) {
+	e := newExporter(prometheus.NewRegistry(), nil)
+	e.Register()
+
+	rec := httptest.NewRecorder()
+	e.MetadataHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics/metadata", nil))
+	var got []MetricMetadata
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(got) == 0 || len(got) != len(e.Metadata()) {
+		t.Fatalf("expected %d metrics, got %d", len(e.Metadata()), len(got))
+	}
+	for _, md := range got {
+		if md.Name == "gpu_idle_process_info" || md.Name == "DCGM_FI_DEV_GPU_UTIL" {
+			t.Errorf("%s listed but not registered", md.Name)
+		}
+	}
+}
+
+func TestMarkProcessesEnded(t *testing.T) {
+	e := New(prometheus.Labels{})
+	now := time.Now()
+	active := idle.ProcessIdleState{GPU: 0, PID: 100, ProcessName: "python", UsedMemory: 1 << 30, SmUtil: 50}
+	e.UpdateMetrics(snapshotAt(now, 0, 1), []idle.ProcessIdleState{active, idleState(0, 200, 1<<30)}, []idle.ProcessIdleState{{GPU: 1, PID: 300, ProcessName: "train"}})
+
+	// Shutdown: the polling loop has stopped, then every process is ended
+	if n := e.MarkProcessesEnded(); n != 3 {
+		t.Errorf("expected 3 processes marked ended, got %d", n)
+	}
+	for _, p := range []struct{ gpu, pid, process string }{{"0", "100", "python"}, {"0", "200", "python"}, {"1", "300", "train"}} {
+		for status, v := range statusValues(t, e, p.gpu, p.pid, p.process, "") {
+			if v != 0 {
+				t.Errorf("pid %s: expected status=%q to be 0 once ended, got %v", p.pid, status, v)
+			}
+		}
+		ended := e.processStatus.With(prometheus.Labels{"gpu": p.gpu, "pid": p.pid, "process": p.process, "mig_instance": "", "status": "ended"})
+		if got := testutil.ToFloat64(ended); got != 1 {
+			t.Errorf("pid %s: expected status=\"ended\" 1, got %v", p.pid, got)
+		}
+	}
+	if n := testutil.CollectAndCount(e.processStatus); n != 12 {
+		t.Errorf("expected 12 status series (3 processes x 4 states), got %d", n)
+	}
+}
+
+func TestECCPolicyViolation(t *testing.T) {
+	e := New(prometheus.Labels{}, WithECCExpected([]string{"a100", " GPU-bench ", ""}))
+	snap := snapshotAt(time.Now(), 0, 1, 2, 3)
+	devices := []struct {
+		name, uuid string
+		ecc        bool
+	}{
+		{"NVIDIA A100-SXM4-80GB", "GPU-a", true},  // expected by model, correctly configured
+		{"NVIDIA A100-SXM4-80GB", "GPU-b", false}, // expected by model, ECC left off
+		{"NVIDIA L4", "GPU-bench", false},         // expected by UUID, ECC left off
+		{"NVIDIA L4", "GPU-d", false},             // not expected
+	}
+	for i, d := range devices {
+		snap.Devices[i].Name, snap.Devices[i].UUID = d.name, d.uuid
+		snap.Devices[i].EccEnabled, snap.Devices[i].HasEccMode = d.ecc, true
+	}
+	e.UpdateMetrics(snap, nil, nil)
+
+	for gpu, want := range map[string]float64{"0": 0, "1": 1, "2": 1} {
+		if got := testutil.ToFloat64(e.eccViolation.WithLabelValues(gpu)); got != want {
+			t.Errorf("GPU %s: expected violation %v, got %v", gpu, want, got)
+		}
+	}
+	if n := testutil.CollectAndCount(e.eccViolation); n != 3 {
+		t.Errorf("expected no series for a GPU not expected to have ECC, got %d series", n)
+	}
+
+	// ECC re-enabled on GPU 1; GPU 2 stops reporting an ECC mode
+	snap.Devices[1].EccEnabled = true
+	snap.Devices[2].HasEccMode = false
+	e.UpdateMetrics(snap, nil, nil)
+	if got := testutil.ToFloat64(e.eccViolation.WithLabelValues("1")); got != 0 {
+		t.Errorf("GPU 1: expected no violation once ECC is enabled, got %v", got)
+	}
+	if n := testutil.CollectAndCount(e.eccViolation); n != 2 {
+		t.Errorf("expected GPU 2's series to be removed, got %d series", n)
+	}
+
+	// Not configured: no series
+	e = New(prometheus.Labels{})
+	e.UpdateMetrics(snap, nil, nil)
+	if n := testutil.CollectAndCount(e.eccViolation); n != 0 {
+		t.Errorf("expected no series without expected-ECC GPUs, got %d", n)
+	}
+}
+
+func TestSystemGPUs(t *testing.T) {
+	system, err := collector.ParseGPUSet("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(prometheus.Labels{}, WithSystemGPUs(system), WithSquatDetection(0.9, time.Hour))
+	const gib = 1 << 30
+
+	// The window manager on GPU 0 holds nearly all of it, idle for hours
+	display := idleState(0, 100, 38*gib)
+	display.IdleDuration = 2 * time.Hour
+	display.System = true
+	job := idleState(1, 200, 4*gib)
+	job.IdleDuration = 2 * time.Hour
+	snap := snapshotAt(time.Now(), 0, 1)
+	snap.ProcessUIDs = map[uint32]uint32{100: 1000, 200: 1001}
+	e.UpdateMetrics(snap, []idle.ProcessIdleState{display, job}, nil)
+
+	// Still tracked per process
+	if got := testutil.ToFloat64(e.processIdleMem.WithLabelValues("0", "100", "python", "")); got != 38*gib {
+		t.Errorf("expected the system GPU's process still reported, got %v idle bytes", got)
+	}
+	// but left out of the aggregates
+	for _, c := range []struct {
+		name string
+		got  float64
+	}{
+		{"idle memory", testutil.ToFloat64(e.idleMemTotal.WithLabelValues("0"))},
+		{"reclaimable", testutil.ToFloat64(e.safelyReclaimable.WithLabelValues("0"))},
+		{"physical idle memory", testutil.ToFloat64(e.physIdleMem.WithLabelValues("0", ""))},
+		{"idle users", testutil.ToFloat64(e.distinctIdleUsers.WithLabelValues("0"))},
+	} {
+		if c.got != 0 {
+			t.Errorf("GPU 0: expected no %s, got %v", c.name, c.got)
+		}
+	}
+	if got := testutil.ToFloat64(e.idleMemTotal.WithLabelValues("1")); got != 4*gib {
+		t.Errorf("GPU 1: expected 4 GiB idle, got %v", got)
+	}
+	if n := testutil.CollectAndCount(e.deviceSquatted); n != 0 {
+		t.Errorf("expected the system GPU never squatted, got %d series", n)
+	}
+	if got := testutil.ToFloat64(e.processNodeIdle.WithLabelValues("100", "python")); got != 0 {
+		t.Errorf("expected the system GPU's process not node-idle, got %v", got)
+	}
+
+	// Device metrics carry role="system" on the system GPU only
+	if got := testutil.ToFloat64(e.deviceMemTotal.With(prometheus.Labels{"gpu": "0", "model": "", "uuid": "", "role": "system"})); got != 40*gib {
+		t.Errorf("expected GPU 0 labelled role=system, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.deviceMemTotal.With(prometheus.Labels{"gpu": "1", "model": "", "uuid": "", "role": ""})); got != 40*gib {
+		t.Errorf("expected GPU 1 without a role, got %v", got)
+	}
+}
+
+func TestNativeIdleMemoryHistogram(t *testing.T) {
+	if New(prometheus.Labels{}).idleMemNative != nil {
+		t.Fatal("expected no native histogram unless enabled")
+	}
+	e := New(prometheus.Labels{}, WithNativeHistograms(DefaultNativeBucketFactor))
+	const gib = 1 << 30
+	busy := idle.ProcessIdleState{GPU: 0, PID: 300, ProcessName: "python", UsedMemory: 8 * gib, SmUtil: 90}
+	t0 := time.Now()
+	e.UpdateMetrics(snapshotAt(t0, 0, 1), []idle.ProcessIdleState{idleState(0, 100, gib), idleState(1, 200, 4*gib), busy}, nil)
+	e.UpdateMetrics(snapshotAt(t0.Add(5*time.Second), 0, 1), []idle.ProcessIdleState{idleState(0, 100, gib), busy}, nil)
+
+	m := &dto.Metric{}
+	if err := e.idleMemNative.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	h := m.GetHistogram()
+	// Idle processes only, once per poll
+	if h.GetSampleCount() != 3 || h.GetSampleSum() != 6*gib {
+		t.Errorf("expected 3 observations summing to 6 GiB, got %d summing to %v", h.GetSampleCount(), h.GetSampleSum())
+	}
+	// 1.1 is between 2^(2^-3) and 2^(2^-2), so schema 3
+	if h.GetSchema() != 3 {
+		t.Errorf("expected schema 3, got %d", h.GetSchema())
+	}
+	// Two sparse buckets: 1 GiB observed twice, then 4 GiB once. Counts
+	// are encoded as deltas from the previous bucket's.
+	if deltas := h.GetPositiveDelta(); len(deltas) != 2 || deltas[0] != 2 || deltas[1] != -1 {
+		t.Errorf("expected buckets for 1 GiB (2) and 4 GiB (1), got spans %v deltas %v", h.GetPositiveSpan(), deltas)
+	}
+}
@@ -0,0 +1,57 @@
+package exporter
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/affinode/gpu-idle-exporter/internal/idle"
+)
+
+// Defaults for WithSquatDetection.
+const (
+	DefaultSquatMemoryFraction = 0.9
+	DefaultSquatMinIdle        = 30 * time.Minute
+)
+
+// WithSquatDetection sets when a GPU counts as squatted for
+// gpu_idle_device_squatted: a single idle process holds more than fraction
+// of its memory and has been idle for at least minIdle.
+func WithSquatDetection(fraction float64, minIdle time.Duration) Option {
+	return func(e *Exporter) {
+		e.squatFraction = fraction
+		e.squatMinIdle = minIdle
+	}
+}
+
+// squatting reports whether ps alone squats on its GPU, whose memory is
+// total bytes. Exempt processes and processes on system GPUs are never
+// reported, since they aren't reclamation targets.
+func (e *Exporter) squatting(ps idle.ProcessIdleState, total uint64) bool {
+	if !ps.IsIdle || ps.Exempt || ps.System || total == 0 || ps.IdleDuration < e.squatMinIdle {
+		return false
+	}
+	return float64(ps.IdleMemory) > e.squatFraction*float64(total)
+}
+
+// updateSquatted flags GPUs held almost entirely by one idle process, such
+// as a crashed job that never released its memory: the highest-value
+// reclamation targets. Series exist only while the GPU is squatted.
+func (e *Exporter) updateSquatted(states []idle.ProcessIdleState, memTotalByGPU map[int]uint64) {
+	current := make(map[string]bool)
+	for _, ps := range states {
+		if !e.squatting(ps, memTotalByGPU[ps.GPU]) {
+			continue
+		}
+		gpuStr := strconv.Itoa(ps.GPU)
+		pidStr := e.pidLabel(ps.PID)
+		current[gpuStr+"\x00"+pidStr+"\x00"+ps.ProcessName+"\x00"+ps.MigInstance] = true
+		e.deviceSquatted.WithLabelValues(gpuStr, pidStr, ps.ProcessName, ps.MigInstance).Set(1)
+	}
+	for key := range e.prevSquatted {
+		if !current[key] {
+			e.deviceSquatted.DeleteLabelValues(strings.SplitN(key, "\x00", 4)...)
+		}
+	}
+	e.prevSquatted = current
+}
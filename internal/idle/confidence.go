@@ -0,0 +1,44 @@
+package idle
+
+import (
+	"time"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+)
+
+// MaxSampleAge is how old a process's newest utilization sample may be,
+// relative to the snapshot, and still count as fresh.
+const MaxSampleAge = 30 * time.Second
+
+// Confidence levels for a process's idle determination, from most to
+// least trustworthy:
+//
+//   - ConfidenceHigh: a fresh per-process utilization sample, or no sample
+//     on a GPU that is itself at 0%, which corroborates the absence.
+//   - ConfidenceAbsent: no sample while something on the GPU is busy. NVML
+//     omits processes without activity, but the busy time may belong to a
+//     process we can't see.
+//   - ConfidenceStale: the newest sample is older than MaxSampleAge.
+//   - ConfidenceFallback: per-process utilization is unavailable and the
+//     process's utilization is only a default.
+const (
+	ConfidenceHigh     = 1.0
+	ConfidenceAbsent   = 0.75
+	ConfidenceStale    = 0.5
+	ConfidenceFallback = 0.25
+)
+
+// confidence rates how far the utilization behind a process's idle state
+// can be trusted. deviceUtil is the utilization of the process's GPU.
+func confidence(p collector.ProcessSample, now time.Time, deviceUtil uint32) float64 {
+	switch {
+	case p.UtilUnavailable:
+		return ConfidenceFallback
+	case p.UtilSampled && now.Sub(p.UtilSampleTime) > MaxSampleAge:
+		return ConfidenceStale
+	case p.UtilSampled, deviceUtil == 0:
+		return ConfidenceHigh
+	default:
+		return ConfidenceAbsent
+	}
+}
@@ -0,0 +1,45 @@
+package idle
+
+import (
+	"time"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+)
+
+// DefaultDataMovementThreshold is the data movement rate, in bytes per
+// second, at or above which a process counts as active regardless of its
+// utilization, unless overridden with WithDataMovementThreshold.
+const DefaultDataMovementThreshold = 10 << 20
+
+// WithDataMovementThreshold sets the data movement rate in bytes per second
+// at or above which a process is active even with idle SMs, e.g. during
+// the communication phases of distributed training. It only applies to
+// processes with data movement counters; others are judged on utilization
+// alone. Values of 0 or less disable the check.
+func WithDataMovementThreshold(bytesPerSec float64) Option {
+	return func(t *Tracker) { t.dataMovementThreshold = bytesPerSec }
+}
+
+// recordDataMoved updates the process's cumulative data movement baseline
+// and returns the bytes moved since the previous poll. ok is false when
+// there's no previous reading to compare with, or the counter went
+// backwards (e.g. it was reset).
+func recordDataMoved(st *processState, p collector.ProcessSample) (moved uint64, ok bool) {
+	if !p.HasDataMoved {
+		st.HasDataMoved = false
+		return 0, false
+	}
+	prev, hadPrev := st.DataMoved, st.HasDataMoved
+	st.DataMoved, st.HasDataMoved = p.DataMovedBytes, true
+	if !hadPrev || p.DataMovedBytes < prev {
+		return 0, false
+	}
+	return p.DataMovedBytes - prev, true
+}
+
+// dataMovementActive reports whether moved bytes over elapsed reach the data
+// movement threshold.
+func (t *Tracker) dataMovementActive(moved uint64, elapsed time.Duration) bool {
+	return t.dataMovementThreshold > 0 && elapsed > 0 &&
+		float64(moved)/elapsed.Seconds() >= t.dataMovementThreshold
+}
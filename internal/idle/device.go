@@ -0,0 +1,48 @@
+package idle
+
+import (
+	"sort"
+	"time"
+
+	"github.com/affinode/gpu-idle-exporter/internal/collector"
+)
+
+// DeviceIdleState is the idle state of a whole GPU.
+type DeviceIdleState struct {
+	GPU          int
+	IsIdle       bool
+	IdleDuration time.Duration // how long the GPU has been idle; 0 while active
+}
+
+// updateDevices tracks which GPUs are idle as a whole: utilization at or
+// below the global policy's threshold, power below the floor if one is
+// set, and no active process. Processes that hold no memory don't count,
+// since they don't keep the GPU from being given back. activeGPUs holds
+// the GPUs with an active process in this snapshot. System GPUs are never
+// idle, since they aren't there to be given back.
+func (t *Tracker) updateDevices(devices []collector.DeviceInfo, activeGPUs, systemGPUs map[int]bool, now time.Time) {
+	since := make(map[int]time.Time, len(devices))
+	t.devices = make([]DeviceIdleState, 0, len(devices))
+	for _, d := range devices {
+		ds := DeviceIdleState{GPU: d.Index}
+		if d.Utilization <= t.defaultPolicy.SmThreshold && !t.abovePowerFloor(d.PowerWatts) && !activeGPUs[d.Index] && !systemGPUs[d.Index] {
+			start, ok := t.deviceIdleSince[d.Index]
+			if !ok || start.After(now) {
+				// Newly idle, or the clock stepped back: count from now
+				start = now
+			}
+			since[d.Index] = start
+			ds.IsIdle = true
+			ds.IdleDuration = now.Sub(start)
+		}
+		t.devices = append(t.devices, ds)
+	}
+	sort.Slice(t.devices, func(i, j int) bool { return t.devices[i].GPU < t.devices[j].GPU })
+	t.deviceIdleSince = since
+}
+
+// Devices returns the idle state of every GPU in the most recent snapshot,
+// ordered by index. Call after Update.
+func (t *Tracker) Devices() []DeviceIdleState {
+	return t.devices
+}
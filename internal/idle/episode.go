@@ -0,0 +1,67 @@
+package idle
+
+import "time"
+
+// Episode is a completed idle episode: a process held memory on a GPU
+// without doing meaningful work from Start until End.
+type Episode struct {
+	GPU         int
+	PID         uint32
+	ProcessName string
+	Start       time.Time
+	End         time.Time // when the process became active again or was last seen
+	Memory      uint64    // bytes held when the episode began
+}
+
+// Duration returns how long the episode lasted.
+func (e Episode) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// WithMinEpisodeDuration suppresses idle episodes shorter than d: they are
+// neither logged nor returned by ClosedEpisodes. Bursty workloads dip to
+// idle between steps, and each dip would otherwise be reported as an
+// episode. The idle state itself, and so the live metrics, are unaffected.
+func WithMinEpisodeDuration(d time.Duration) Option {
+	return func(t *Tracker) { t.minEpisode = d }
+}
+
+// reportEpisode reports the process's current idle episode once it has
+// lasted the minimum episode duration. Until then the episode may still
+// turn out to be a blip. Episodes on system GPUs are never reported.
+func (t *Tracker) reportEpisode(key processKey, st *processState, now time.Time) {
+	if st.Reported || st.System || now.Sub(st.IdleSince) < t.minEpisode {
+		return
+	}
+	st.Reported = true
+	t.logTransition("idle", "idle: process became idle", "gpu", key.GPU, "pid", key.PID, "process", st.ProcessName)
+}
+
+// closeEpisode ends the process's idle episode at end. Episodes that
+// never reached the minimum duration are dropped silently.
+func (t *Tracker) closeEpisode(key processKey, st *processState, end time.Time) {
+	st.IsIdle = false
+	if !st.Reported {
+		return
+	}
+	st.Reported = false
+	ep := Episode{
+		GPU:         key.GPU,
+		PID:         key.PID,
+		ProcessName: st.ProcessName,
+		Start:       st.IdleSince,
+		End:         end,
+		Memory:      st.IdleStartMem,
+	}
+	t.closed = append(t.closed, ep)
+	t.logTransition("episode_ended", "idle: idle episode ended", "gpu", key.GPU, "pid", key.PID, "process", st.ProcessName,
+		"duration", ep.Duration().Round(time.Second))
+}
+
+// ClosedEpisodes returns the idle episodes that ended during the most
+// recent Update, either because the process became active again or
+// because it disappeared. Episodes shorter than the minimum episode
+// duration are not included.
+func (t *Tracker) ClosedEpisodes() []Episode {
+	return t.closed
+}
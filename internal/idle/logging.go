@@ -0,0 +1,69 @@
+package idle
+
+import (
+	"context"
+	"log/slog"
+)
+
+// TransitionLogging controls the per-process logs: new processes, idle
+// transitions, ended episodes and stale cleanup. They're logged at debug
+// level, so only show with a logger enabled for it. Warnings such as clock
+// skew are always logged.
+type TransitionLogging string
+
+const (
+	// LogTransitionsOff logs no transitions.
+	LogTransitionsOff TransitionLogging = "off"
+	// LogTransitionsSampled logs at most maxTransitionLogs transitions per
+	// poll and summarizes the rest. The processes already running when the
+	// tracker starts are summarized in one line instead of one each.
+	LogTransitionsSampled TransitionLogging = "sampled"
+	// LogTransitionsAll logs every transition, including the startup
+	// burst. Meant for debugging.
+	LogTransitionsAll TransitionLogging = "all"
+)
+
+// maxTransitionLogs is how many transitions are logged per poll in
+// sampled mode.
+const maxTransitionLogs = 10
+
+// WithTransitionLogging sets how transitions are logged. The default is
+// LogTransitionsSampled.
+func WithTransitionLogging(mode TransitionLogging) Option {
+	return func(t *Tracker) { t.logMode = mode }
+}
+
+// WithLogger sets the tracker's logger. Transitions are logged at debug
+// level, clock skew at warn. The default is slog.Default() at construction.
+func WithLogger(l *slog.Logger) Option {
+	return func(t *Tracker) { t.logger = l }
+}
+
+// logTransition logs a transition at debug level, subject to the logging
+// mode. args are slog key-value pairs, such as the GPU and PID; event
+// names the transition. Nothing is counted while debug is disabled, so no
+// suppression summary is logged either.
+func (t *Tracker) logTransition(event, msg string, args ...any) {
+	switch {
+	case t.logMode == LogTransitionsOff, !t.logger.Enabled(context.Background(), slog.LevelDebug):
+		return
+	case t.logMode == LogTransitionsSampled:
+		if t.transitionLogs >= maxTransitionLogs {
+			t.suppressedLogs++
+			return
+		}
+	}
+	t.transitionLogs++
+	t.logger.Debug(msg, append([]any{"event", event}, args...)...)
+}
+
+// flushTransitionLogs summarizes the transitions suppressed during the
+// current Update and resets the per-poll counts.
+func (t *Tracker) flushTransitionLogs() {
+	if t.suppressedLogs > 0 {
+		t.logger.Debug("idle: more transitions not logged", "event", "transitions_suppressed",
+			"suppressed", t.suppressedLogs, "log_transitions", string(t.logMode))
+	}
+	t.transitionLogs = 0
+	t.suppressedLogs = 0
+}
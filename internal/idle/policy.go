@@ -0,0 +1,75 @@
+package idle
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Policy controls when a process is considered idle.
+type Policy struct {
+	// SmThreshold is the SM utilization (percent) at or below which a
+	// process counts as doing no compute work.
+	SmThreshold uint32
+	// GracePeriod is how long a process must stay at or below SmThreshold
+	// before it is marked idle. Zero marks it idle on the first such poll
+	// after it was first seen.
+	GracePeriod time.Duration
+	// Exempt marks processes that must never be reclaimed automatically
+	// (e.g. interactive notebooks). They are still tracked and reported
+	// as idle.
+	Exempt bool
+}
+
+// DefaultPolicy treats only 0% SM utilization as idle, with no grace period.
+var DefaultPolicy = Policy{}
+
+// namespacePolicyJSON is the per-namespace entry in NAMESPACE_IDLE_CONFIG.
+// Omitted fields inherit from the global default policy.
+type namespacePolicyJSON struct {
+	SmThreshold *uint32 `json:"smThreshold"`
+	GracePeriod string  `json:"gracePeriod"`
+	Exempt      *bool   `json:"exempt"`
+}
+
+// ParseNamespacePolicies parses a JSON object mapping namespace names to
+// policy overrides, e.g.
+//
+//	{"research": {"gracePeriod": "30m", "exempt": true}, "inference": {"smThreshold": 2, "gracePeriod": "1m"}}
+//
+// Fields omitted for a namespace are taken from defaults.
+func ParseNamespacePolicies(data string, defaults Policy) (map[string]Policy, error) {
+	var raw map[string]namespacePolicyJSON
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("parsing namespace idle config: %w", err)
+	}
+
+	policies := make(map[string]Policy, len(raw))
+	for ns, entry := range raw {
+		if ns == "" {
+			return nil, fmt.Errorf("namespace idle config: empty namespace name")
+		}
+		p := defaults
+		if entry.SmThreshold != nil {
+			if *entry.SmThreshold > 100 {
+				return nil, fmt.Errorf("namespace %q: smThreshold %d exceeds 100", ns, *entry.SmThreshold)
+			}
+			p.SmThreshold = *entry.SmThreshold
+		}
+		if entry.GracePeriod != "" {
+			d, err := time.ParseDuration(entry.GracePeriod)
+			if err != nil {
+				return nil, fmt.Errorf("namespace %q: invalid gracePeriod: %w", ns, err)
+			}
+			if d < 0 {
+				return nil, fmt.Errorf("namespace %q: negative gracePeriod %v", ns, d)
+			}
+			p.GracePeriod = d
+		}
+		if entry.Exempt != nil {
+			p.Exempt = *entry.Exempt
+		}
+		policies[ns] = p
+	}
+	return policies, nil
+}
@@ -0,0 +1,36 @@
+package idle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNamespacePolicies(t *testing.T) {
+	defaults := Policy{SmThreshold: 1, GracePeriod: time.Minute}
+	policies, err := ParseNamespacePolicies(
+		`{"research": {"gracePeriod": "30m", "exempt": true}, "inference": {"smThreshold": 5, "gracePeriod": "10s"}}`,
+		defaults)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := policies["research"]; got != (Policy{SmThreshold: 1, GracePeriod: 30 * time.Minute, Exempt: true}) {
+		t.Errorf("research: omitted smThreshold should inherit default, got %+v", got)
+	}
+	if got := policies["inference"]; got != (Policy{SmThreshold: 5, GracePeriod: 10 * time.Second}) {
+		t.Errorf("inference: got %+v", got)
+	}
+}
+
+func TestParseNamespacePoliciesInvalid(t *testing.T) {
+	for _, input := range []string{
+		`not json`,
+		`{"a": {"gracePeriod": "soon"}}`,
+		`{"a": {"gracePeriod": "-1m"}}`,
+		`{"a": {"smThreshold": 101}}`,
+		`{"": {"smThreshold": 1}}`,
+	} {
+		if _, err := ParseNamespacePolicies(input, DefaultPolicy); err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package idle
+
+// WithPowerFloorWatts additionally requires a GPU's power draw to be below
+// w watts before its processes can go idle. Tiny persistent kernels (e.g.
+// a polling loop) can keep SM utilization at or near 0 while the GPU draws
+// far more than its idle floor; with a floor, every process on a GPU
+// drawing at or above it counts as active. Set w just above the GPU's idle
+// power. A GPU that doesn't report power draw (0 W) is judged on
+// utilization alone. Values of 0 or less disable the check, the default.
+func WithPowerFloorWatts(w float64) Option {
+	return func(t *Tracker) { t.powerFloorWatts = w }
+}
+
+// abovePowerFloor reports whether a GPU drawing watts is too busy for its
+// processes to be idle.
+func (t *Tracker) abovePowerFloor(watts float64) bool {
+	return t.powerFloorWatts > 0 && watts >= t.powerFloorWatts
+}
@@ -0,0 +1,46 @@
+package idle
+
+import "time"
+
+// Idle reasons, as exported in the reason label. They are best-effort
+// inferences from the process's observed history:
+//
+//   - never-active: the process has never been seen above the SM threshold.
+//     Typically a job that allocated memory and then blocked before doing
+//     any work (born idle).
+//   - stalled: the process was active before and its memory has grown since
+//     it went idle. Something is still allocating, but no kernels run.
+//   - waiting: the process was active before, its memory is stable, and it
+//     has been idle for less than FinishedAfter (e.g. waiting on input, a
+//     barrier or a scheduler).
+//   - finished: the process was active before, its memory is stable, and it
+//     has been idle for at least FinishedAfter. Most likely the work is done
+//     and the memory was never freed.
+const (
+	ReasonNeverActive = "never-active"
+	ReasonStalled     = "stalled"
+	ReasonWaiting     = "waiting"
+	ReasonFinished    = "finished"
+)
+
+// IdleReasons lists every reason classifyIdle can return.
+var IdleReasons = []string{ReasonNeverActive, ReasonStalled, ReasonWaiting, ReasonFinished}
+
+// FinishedAfter is how long a previously active process with stable memory
+// must stay idle before it is classified as finished rather than waiting.
+const FinishedAfter = 10 * time.Minute
+
+// classifyIdle returns the idle reason for an idle process. idleStartMemory
+// is the memory the process held when it went idle.
+func classifyIdle(wasEverActive bool, idleStartMemory, usedMemory uint64, idleDuration time.Duration) string {
+	switch {
+	case !wasEverActive:
+		return ReasonNeverActive
+	case usedMemory > idleStartMemory:
+		return ReasonStalled
+	case idleDuration < FinishedAfter:
+		return ReasonWaiting
+	default:
+		return ReasonFinished
+	}
+}
@@ -0,0 +1,26 @@
+package idle
+
+import "github.com/affinode/gpu-idle-exporter/internal/collector"
+
+// WithSystemGPUs designates GPUs that serve the node itself, e.g. GPU 0
+// driving the display and window manager on a workstation or login node.
+// Their processes are tracked as usual, but are marked System and never
+// open idle episodes, and the GPUs are never idle as a whole, so they
+// don't show up as waste.
+func WithSystemGPUs(gpus collector.GPUSet) Option {
+	return func(t *Tracker) { t.systemGPUs = gpus }
+}
+
+// systemIndices returns the indices of the system GPUs among devices.
+func (t *Tracker) systemIndices(devices []collector.DeviceInfo) map[int]bool {
+	system := make(map[int]bool)
+	if t.systemGPUs.Empty() {
+		return system
+	}
+	for _, d := range devices {
+		if t.systemGPUs.Contains(d.Index, d.UUID) {
+			system[d.Index] = true
+		}
+	}
+	return system
+}
@@ -1,7 +1,7 @@
 package idle
 
 import (
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/affinode/gpu-idle-exporter/internal/collector"
@@ -15,11 +15,25 @@ type processKey struct {
 
 // processState tracks idle state for a single process.
 type processState struct {
+	ProcessName    string    // last known process name
 	LastActiveTime time.Time // last time smUtil > 0
 	LastSeenTime   time.Time // last time process appeared in NVML results
 	FirstSeenTime  time.Time // when we first observed this process
-	IsIdle         bool      // current idle state (smUtil == 0 while holding memory)
+	BelowSince     time.Time // start of the current run of polls at or below the SM threshold; zero if above
+	BelowPolls     int       // length of the current run of polls at or below the SM threshold
+	IsIdle         bool      // current idle state (smUtil at or below threshold while holding memory)
 	IdleSince      time.Time // when the process transitioned to idle
+	WasEverActive  bool      // observed above the SM threshold at least once
+	IdleStartMem   uint64    // memory held when the current idle episode began
+	MemHistory     []uint64  // memory of the most recent polls, oldest first; only kept with memory stability enabled
+	Reported       bool      // the current idle episode has lasted the minimum episode duration
+	SmoothedUtil   float64   // EWMA of SmUtil across polls
+	DataMoved      uint64    // cumulative data movement at the last poll, valid only if HasDataMoved
+	HasDataMoved   bool
+	IdleTotal      time.Duration // idle time accumulated over all idle episodes, excluding suspended intervals
+	Suspended      bool          // stopped or frozen at the last poll
+	SuspendedIdle  time.Duration // time spent suspended during the current idle episode
+	System         bool          // on a system GPU at the last poll; its idle episodes aren't reported
 }
 
 // ProcessIdleState is the exported view of one process's idle state.
@@ -27,37 +41,272 @@ type ProcessIdleState struct {
 	GPU          int
 	PID          uint32
 	ProcessName  string
-	UsedMemory   uint64        // bytes
-	SmUtil       uint32        // percent 0-100
-	IsIdle       bool          // true if smUtil==0 while holding memory
-	IdleDuration time.Duration // time since process became idle; 0 if active
-	IdleMemory   uint64        // bytes held while idle; 0 if active
+	MigInstance  string               // GPU instance ID on MIG-enabled GPUs; empty otherwise
+	UsedMemory   uint64               // bytes
+	SmUtil       uint32               // percent 0-100
+	EngineUtil   collector.EngineUtil // per-engine utilization; SM only on drivers without a breakdown
+	IsIdle       bool                 // true if every engine is at or below the idle threshold while holding memory
+	IdleDuration time.Duration        // time since process became idle, less time it spent suspended; 0 if active
+	IdleTotal    time.Duration        // idle time accumulated across idle/active cycles while tracked, less time suspended
+	Residency    time.Duration        // time since the process was first seen, active or idle
+	IdleMemory   uint64               // bytes held while idle; 0 if active
+	IdleReason   string               // one of IdleReasons while idle; empty if active
+	Memoryless   bool                 // seen only in utilization samples, holding no memory; never idle
+	Exempt       bool                 // the process's policy exempts it from reclamation
+	Confidence   float64              // 0-1, how far the utilization behind IsIdle can be trusted; see confidence
+	SmoothedUtil float64              // exponentially weighted moving average of SmUtil, percent 0-100
+
+	ActiveStreams    int  // live CUDA streams, valid only if HasActiveStreams
+	HasActiveStreams bool // stream count is known for this process
+
+	DataMoved    uint64 // bytes moved over NVLink and PCIe since the previous poll, valid only if HasDataMoved
+	HasDataMoved bool
+
+	// Suspended is set while the process is stopped or frozen, e.g. paused
+	// by its scheduler. Time suspended doesn't count towards IdleDuration
+	// or IdleTotal, since the process couldn't have done work anyway.
+	Suspended bool
+
+	// System is set for processes on a system GPU (see WithSystemGPUs).
+	// They're tracked as usual but aren't idle waste, so they should be
+	// left out of idle aggregates.
+	System bool
+
+	UID    uint32 // real UID of the owner, valid only if HasUID
+	HasUID bool
+
+	Cgroup      string // cgroup path; empty without pod attribution
+	PodUID      string // Kubernetes pod UID; empty outside a pod or without pod attribution
+	ContainerID string // container runtime ID; empty outside a container or without pod attribution
 }
 
 // Tracker maintains per-process idle state across polling cycles.
 type Tracker struct {
 	states       map[processKey]*processState
 	staleTimeout time.Duration // how long after disappearing before cleanup
+
+	lastUpdate time.Time // timestamp of the most recent snapshot
+	clockSkew  bool      // the most recent snapshot's timestamp went backwards
+
+	defaultPolicy     Policy            // applied to processes without a namespace override
+	namespacePolicies map[string]Policy // namespace -> policy override
+
+	// Memory stability hysteresis: an idle episode only opens once memory
+	// has stayed within memStableDelta bytes for memStablePolls polls.
+	// Disabled when memStablePolls is 0.
+	memStablePolls int
+	memStableDelta uint64
+
+	// idleConfirmCount is how many consecutive polls at or below the SM
+	// threshold a process needs before it can go idle.
+	idleConfirmCount int
+
+	minEpisode time.Duration // idle episodes shorter than this are not reported
+
+	// Churn: processes first seen in the most recent Update, and their
+	// rate over the time since the previous one
+	newProcesses   int
+	newProcessRate float64
+
+	// smoothing is the EWMA weight of the newest SmUtil sample in
+	// SmoothedUtil, in (0, 1]; 1 disables smoothing.
+	smoothing float64
+	closed    []Episode // episodes that ended during the most recent Update
+
+	// Whole-GPU idle state: when each idle GPU went idle, and the states
+	// from the most recent Update
+	deviceIdleSince map[int]time.Time
+	devices         []DeviceIdleState
+
+	// dataMovementThreshold is the data movement rate in bytes per second
+	// at or above which a process is active; 0 disables the check.
+	dataMovementThreshold float64
+	// powerFloorWatts is the device power draw at or above which processes
+	// are active; 0 disables the check.
+	powerFloorWatts float64
+
+	systemGPUs collector.GPUSet // GPUs serving the node itself, e.g. a display GPU
+
+	// Transition logging: the logger, the mode, and how many transitions
+	// were logged and suppressed so far in the current Update
+	logger         *slog.Logger
+	logMode        TransitionLogging
+	transitionLogs int
+	suppressedLogs int
+}
+
+// Option configures a Tracker.
+type Option func(*Tracker)
+
+// WithDefaultPolicy sets the idle policy for processes whose namespace has no override.
+func WithDefaultPolicy(p Policy) Option {
+	return func(t *Tracker) { t.defaultPolicy = p }
+}
+
+// WithNamespacePolicies sets per-namespace idle policies. Processes in other
+// namespaces, or not attributed to any namespace, use the default policy.
+func WithNamespacePolicies(policies map[string]Policy) Option {
+	return func(t *Tracker) { t.namespacePolicies = policies }
+}
+
+// WithMemoryStability requires a process's memory to be stable, varying by
+// at most delta bytes over the last polls polls, before it can go idle.
+// Step-based workloads free and reallocate memory around short dips in
+// utilization; without this each dip opens a new idle episode.
+func WithMemoryStability(polls int, delta uint64) Option {
+	return func(t *Tracker) {
+		t.memStablePolls = polls
+		t.memStableDelta = delta
+	}
+}
+
+// DefaultUtilSmoothing is the EWMA weight of the newest utilization sample
+// unless overridden with WithUtilSmoothing.
+const DefaultUtilSmoothing = 0.3
+
+// WithUtilSmoothing sets the weight, in (0, 1], of the newest sample in the
+// smoothed per-process utilization. Lower values smooth more but react
+// more slowly; 1 reports the raw value. Out-of-range values are ignored.
+// Smoothing only affects SmoothedUtil, not idle detection.
+func WithUtilSmoothing(alpha float64) Option {
+	return func(t *Tracker) {
+		if alpha > 0 && alpha <= 1 {
+			t.smoothing = alpha
+		}
+	}
+}
+
+// DefaultStaleTimeout is how long a vanished process is remembered unless
+// overridden with WithStaleTimeout.
+const DefaultStaleTimeout = 30 * time.Second
+
+// WithStaleTimeout sets how long a process that vanished from the
+// snapshots is remembered, reported as stale, before it is forgotten. It
+// should span a few polls and at least one scrape interval, or processes
+// can disappear between scrapes. Values of 0 or less are ignored.
+func WithStaleTimeout(d time.Duration) Option {
+	return func(t *Tracker) {
+		if d > 0 {
+			t.staleTimeout = d
+		}
+	}
+}
+
+// WithIdleConfirmCount requires n consecutive polls at or below the SM
+// threshold, in addition to the grace period, before a process is marked
+// idle. A single poll without utilization samples then can't flip a busy
+// process to idle. Values below 1 are treated as 1, the default.
+func WithIdleConfirmCount(n int) Option {
+	return func(t *Tracker) { t.idleConfirmCount = max(n, 1) }
 }
 
 // NewTracker creates a new idle tracker.
-func NewTracker() *Tracker {
-	return &Tracker{
-		states:       make(map[processKey]*processState),
-		staleTimeout: 30 * time.Second,
+func NewTracker(opts ...Option) *Tracker {
+	t := &Tracker{
+		states:        make(map[processKey]*processState),
+		staleTimeout:  DefaultStaleTimeout,
+		defaultPolicy: DefaultPolicy,
+		smoothing:     DefaultUtilSmoothing,
+		logMode:       LogTransitionsSampled,
+		logger:        slog.Default(),
+
+		dataMovementThreshold: DefaultDataMovementThreshold,
+
+		idleConfirmCount: 1,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// GlobalPolicy returns the idle policy for processes without a namespace override.
+func (t *Tracker) GlobalPolicy() Policy {
+	return t.defaultPolicy
+}
+
+// StaleTimeout returns how long a vanished process is remembered.
+func (t *Tracker) StaleTimeout() time.Duration {
+	return t.staleTimeout
+}
+
+// policyFor returns the idle policy that applies to a process in namespace ns.
+func (t *Tracker) policyFor(ns string) Policy {
+	if p, ok := t.namespacePolicies[ns]; ok && ns != "" {
+		return p
 	}
+	return t.defaultPolicy
+}
+
+// recordMemory appends a memory sample to the process's history, keeping
+// only as many samples as the stability check needs.
+func (t *Tracker) recordMemory(st *processState, mem uint64) {
+	if t.memStablePolls <= 0 {
+		return
+	}
+	st.MemHistory = append(st.MemHistory, mem)
+	if n := len(st.MemHistory); n > t.memStablePolls {
+		st.MemHistory = st.MemHistory[n-t.memStablePolls:]
+	}
+}
+
+// memoryStable reports whether the process's memory has varied by at most
+// memStableDelta over the last memStablePolls polls. Always true when the
+// hysteresis is disabled.
+func (t *Tracker) memoryStable(st *processState) bool {
+	if t.memStablePolls <= 0 {
+		return true
+	}
+	if len(st.MemHistory) < t.memStablePolls {
+		return false
+	}
+	lo, hi := st.MemHistory[0], st.MemHistory[0]
+	for _, m := range st.MemHistory[1:] {
+		lo = min(lo, m)
+		hi = max(hi, m)
+	}
+	return hi-lo <= t.memStableDelta
 }
 
 // Update processes a new NVML snapshot and returns the current idle state for all processes.
 func (t *Tracker) Update(snap *collector.Snapshot) []ProcessIdleState {
 	now := snap.Timestamp
+	prevUpdate := t.lastUpdate
+	// Every process is new to a fresh tracker; unless logging everything,
+	// they're summarized rather than logged one by one.
+	startup := prevUpdate.IsZero() && t.logMode != LogTransitionsAll
+	// Snapshot timestamps normally carry a monotonic reading, but one that
+	// was persisted or constructed elsewhere only has the wall clock, which
+	// can step backwards (e.g. NTP adjustments).
+	t.clockSkew = now.Before(t.lastUpdate)
+	if t.clockSkew {
+		t.logger.Warn("idle: clock skew: snapshot timestamp before the previous one", "event", "clock_skew",
+			"timestamp", now.Format(time.RFC3339Nano), "behind", t.lastUpdate.Sub(now))
+	}
+	t.lastUpdate = now
+	t.closed = nil
+	t.newProcesses = 0
 	seen := make(map[processKey]bool, len(snap.Processes))
+	activeGPUs := make(map[int]bool, len(snap.Devices))
+	systemGPUs := t.systemIndices(snap.Devices)
+	deviceUtil := make(map[int]uint32, len(snap.Devices))
+	devicePower := make(map[int]float64, len(snap.Devices))
+	for _, d := range snap.Devices {
+		deviceUtil[d.Index] = d.Utilization
+		devicePower[d.Index] = d.PowerWatts
+	}
 
 	results := make([]ProcessIdleState, 0, len(snap.Processes))
 
 	for _, p := range snap.Processes {
 		key := processKey{GPU: p.GPU, PID: p.PID}
 		seen[key] = true
+		policy := t.policyFor(p.Namespace)
+		// A process busy on any engine (e.g. only copying or decoding) is
+		// active, even with idle SMs.
+		util := max(p.SmUtil, p.EngineUtil.Busiest())
+		var dataMoved uint64
+		var hasDataMoved, movingData, drawingPower bool
 
 		st, exists := t.states[key]
 		if !exists {
@@ -70,30 +319,80 @@ func (t *Tracker) Update(snap *collector.Snapshot) []ProcessIdleState {
 				FirstSeenTime:  now,
 				LastSeenTime:   now,
 				IsIdle:         false,
+				ProcessName:    snap.ProcessNames[p.PID],
+				WasEverActive:  util > policy.SmThreshold,
+				SmoothedUtil:   float64(p.SmUtil),
+				Suspended:      snap.Suspended[p.PID],
+				System:         systemGPUs[p.GPU],
 			}
 			t.states[key] = st
-			log.Printf("idle: new process detected: GPU=%d PID=%d name=%s mem=%d MiB",
-				p.GPU, p.PID, snap.ProcessNames[p.PID], p.UsedMemory/(1024*1024))
+			t.newProcesses++
+			t.recordMemory(st, p.UsedMemory)
+			recordDataMoved(st, p)
+			if !startup {
+				t.logTransition("new", "idle: new process detected", "gpu", p.GPU, "pid", p.PID,
+					"process", snap.ProcessNames[p.PID], "memory_mib", p.UsedMemory/(1024*1024))
+			}
 
 			// Skip idle transition on first observation
 			goto emit
 		}
 
+		// Moving data (e.g. exchanging gradients) is work even with idle SMs
+		dataMoved, hasDataMoved = recordDataMoved(st, p)
+		movingData = hasDataMoved && t.dataMovementActive(dataMoved, now.Sub(st.LastSeenTime))
+		// Drawing power above the floor means something is running, even if
+		// too little to register as utilization
+		drawingPower = t.abovePowerFloor(devicePower[p.GPU])
+		// Idle at the previous poll: the interval since counts as idle,
+		// unless the process was suspended and so couldn't have worked
+		if st.IsIdle && now.After(st.LastSeenTime) {
+			if st.Suspended {
+				st.SuspendedIdle += now.Sub(st.LastSeenTime)
+			} else {
+				st.IdleTotal += now.Sub(st.LastSeenTime)
+			}
+		}
 		st.LastSeenTime = now
+		st.Suspended = snap.Suspended[p.PID]
+		st.System = systemGPUs[p.GPU]
+		st.ProcessName = snap.ProcessNames[p.PID]
+		st.SmoothedUtil += t.smoothing * (float64(p.SmUtil) - st.SmoothedUtil)
+		t.recordMemory(st, p.UsedMemory)
 
-		if p.SmUtil > 0 {
+		if util > policy.SmThreshold || movingData || drawingPower {
 			// Process is active
 			st.LastActiveTime = now
+			st.WasEverActive = true
+			st.BelowSince = time.Time{}
+			st.BelowPolls = 0
+			if st.IsIdle {
+				t.closeEpisode(key, st, now)
+			}
+		} else if p.Memoryless {
+			// Holds no memory, so nothing is wasted: never idle
+			st.BelowSince = time.Time{}
+			st.BelowPolls = 0
 			if st.IsIdle {
-				st.IsIdle = false
-				log.Printf("idle: process became active: GPU=%d PID=%d", p.GPU, p.PID)
+				t.closeEpisode(key, st, now)
 			}
 		} else {
-			// SmUtil == 0: process is idle (holding memory but no compute)
-			if !st.IsIdle {
+			// At or below threshold: holding memory but no meaningful compute.
+			// The process is marked idle once this has lasted the grace period
+			// and enough polls, and its memory has settled.
+			if st.BelowSince.IsZero() {
+				st.BelowSince = now
+			}
+			st.BelowPolls++
+			if !st.IsIdle && now.Sub(st.BelowSince) >= policy.GracePeriod &&
+				st.BelowPolls >= t.idleConfirmCount && t.memoryStable(st) {
 				st.IsIdle = true
-				st.IdleSince = now
-				log.Printf("idle: process became idle: GPU=%d PID=%d", p.GPU, p.PID)
+				st.IdleSince = st.BelowSince
+				st.IdleStartMem = p.UsedMemory
+				st.SuspendedIdle = 0
+			}
+			if st.IsIdle {
+				t.reportEpisode(key, st, now)
 			}
 		}
 
@@ -101,31 +400,127 @@ func (t *Tracker) Update(snap *collector.Snapshot) []ProcessIdleState {
 
 		var idleDuration time.Duration
 		var idleMemory uint64
+		var idleReason string
 		if st.IsIdle {
 			idleDuration = now.Sub(st.IdleSince)
+			if idleDuration < 0 {
+				// Restart the episode at the skewed clock, so the duration
+				// grows again from 0 instead of staying clamped until the
+				// clock catches up.
+				t.logger.Warn("idle: clock skew: idle since after the snapshot time; restarting idle duration at 0", "event", "clock_skew",
+					"gpu", p.GPU, "pid", p.PID, "idle_since", st.IdleSince.Format(time.RFC3339Nano), "timestamp", now.Format(time.RFC3339Nano))
+				st.IdleSince = now
+				st.BelowSince = now
+				st.SuspendedIdle = 0
+				idleDuration = 0
+				t.clockSkew = true
+			}
+			idleDuration = max(idleDuration-st.SuspendedIdle, 0)
 			idleMemory = p.UsedMemory
+			idleReason = classifyIdle(st.WasEverActive, st.IdleStartMem, p.UsedMemory, idleDuration)
+		}
+
+		if !st.IsIdle && !p.Memoryless {
+			activeGPUs[p.GPU] = true
 		}
 
+		uid, hasUID := snap.ProcessUIDs[p.PID]
 		results = append(results, ProcessIdleState{
 			GPU:          p.GPU,
 			PID:          p.PID,
 			ProcessName:  snap.ProcessNames[p.PID],
+			MigInstance:  p.MigInstance,
 			UsedMemory:   p.UsedMemory,
 			SmUtil:       p.SmUtil,
+			EngineUtil:   p.EngineUtil,
 			IsIdle:       st.IsIdle,
 			IdleDuration: idleDuration,
+			IdleTotal:    st.IdleTotal,
+			Residency:    max(now.Sub(st.FirstSeenTime), 0),
 			IdleMemory:   idleMemory,
+			IdleReason:   idleReason,
+			Memoryless:   p.Memoryless,
+			Exempt:       policy.Exempt,
+			Confidence:   confidence(p, now, deviceUtil[p.GPU]),
+			SmoothedUtil: st.SmoothedUtil,
+
+			ActiveStreams:    p.ActiveStreams,
+			HasActiveStreams: p.HasActiveStreams,
+
+			DataMoved:    dataMoved,
+			HasDataMoved: hasDataMoved,
+
+			Suspended: st.Suspended,
+			System:    st.System,
+
+			UID:    uid,
+			HasUID: hasUID,
+
+			Cgroup:      p.Cgroup,
+			PodUID:      p.PodUID,
+			ContainerID: p.ContainerID,
 		})
 	}
 
+	t.updateDevices(snap.Devices, activeGPUs, systemGPUs, now)
+
+	if startup && t.newProcesses > 0 && t.logMode != LogTransitionsOff {
+		t.logger.Info("idle: tracking processes already running at startup", "event", "startup", "processes", t.newProcesses)
+	}
+	t.newProcessRate = 0
+	if elapsed := now.Sub(prevUpdate).Seconds(); !prevUpdate.IsZero() && elapsed > 0 {
+		t.newProcessRate = float64(t.newProcesses) / elapsed
+	}
+
 	// Clean up stale processes (no longer in NVML results)
 	for key, st := range t.states {
 		if !seen[key] && now.Sub(st.LastSeenTime) > t.staleTimeout {
-			log.Printf("idle: cleaning up stale process: GPU=%d PID=%d (last seen %v ago)",
-				key.GPU, key.PID, now.Sub(st.LastSeenTime).Round(time.Second))
+			t.logTransition("stale", "idle: cleaning up stale process", "gpu", key.GPU, "pid", key.PID,
+				"process", st.ProcessName, "last_seen_ago", now.Sub(st.LastSeenTime).Round(time.Second))
+			if st.IsIdle {
+				t.closeEpisode(key, st, st.LastSeenTime)
+			}
 			delete(t.states, key)
 		}
 	}
+	t.flushTransitionLogs()
 
 	return results
 }
+
+// NewProcesses returns how many processes were seen for the first time in
+// the most recent Update. A process on several GPUs counts once per GPU.
+func (t *Tracker) NewProcesses() int {
+	return t.newProcesses
+}
+
+// NewProcessRate returns NewProcesses per second of time elapsed since the
+// previous Update; 0 after the first Update. A sustained high rate means
+// jobs are crash-looping or otherwise churning.
+func (t *Tracker) NewProcessRate() float64 {
+	return t.newProcessRate
+}
+
+// ClockSkewed reports whether the most recent Update saw time go backwards:
+// the snapshot was older than the previous one, or than the start of some
+// process's idle episode. Affected idle durations are clamped to zero.
+func (t *Tracker) ClockSkewed() bool {
+	return t.clockSkew
+}
+
+// Stale returns processes that are still tracked but were absent from the
+// most recent snapshot, i.e. disappeared less than the stale timeout ago.
+// Call after Update. The returned states carry no utilization or memory.
+func (t *Tracker) Stale() []ProcessIdleState {
+	var stale []ProcessIdleState
+	for key, st := range t.states {
+		if st.LastSeenTime.Before(t.lastUpdate) {
+			stale = append(stale, ProcessIdleState{
+				GPU:         key.GPU,
+				PID:         key.PID,
+				ProcessName: st.ProcessName,
+			})
+		}
+	}
+	return stale
+}
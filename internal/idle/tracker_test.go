@@ -1,6 +1,10 @@
 package idle
 
 import (
+	"context"
+	"log/slog"
+	"math"
+	"strings"
 	"testing"
 	"time"
 
@@ -239,8 +243,7 @@ func TestMultipleProcesses(t *testing.T) {
 }
 
 func TestStaleProcessCleanup(t *testing.T) {
-	tracker := NewTracker()
-	tracker.staleTimeout = 10 * time.Second // short timeout for testing
+	tracker := NewTracker(WithStaleTimeout(10 * time.Second)) // short timeout for testing
 	t0 := time.Now()
 
 	// Poll 1: process appears
@@ -296,3 +299,872 @@ func TestMultiGPUProcesses(t *testing.T) {
 		}
 	}
 }
+
+func nsProc(gpu int, pid uint32, smUtil uint32, ns string) collector.ProcessSample {
+	p := proc(gpu, pid, 1<<30, smUtil)
+	p.Namespace = ns
+	return p
+}
+
+func TestNamespacePolicies(t *testing.T) {
+	tracker := NewTracker(WithNamespacePolicies(map[string]Policy{
+		"research":  {SmThreshold: 0, GracePeriod: 30 * time.Minute},
+		"inference": {SmThreshold: 5, GracePeriod: 10 * time.Second},
+	}))
+	t0 := time.Now()
+
+	snapAt := func(ts time.Time) *collector.Snapshot {
+		return makeSnapshot(ts, []collector.ProcessSample{
+			nsProc(0, 100, 3, "research"),  // 3% is active under research's 0% threshold
+			nsProc(0, 200, 3, "inference"), // 3% is idle under inference's 5% threshold
+			nsProc(0, 300, 0, "research"),  // idle, but research has a long grace period
+			nsProc(0, 400, 0, ""),          // unattributed: global default
+		})
+	}
+	byPID := func(states []ProcessIdleState) map[uint32]ProcessIdleState {
+		m := make(map[uint32]ProcessIdleState, len(states))
+		for _, s := range states {
+			m[s.PID] = s
+		}
+		return m
+	}
+
+	tracker.Update(snapAt(t0))
+	tracker.Update(snapAt(t0.Add(5 * time.Second)))
+	states := byPID(tracker.Update(snapAt(t0.Add(20 * time.Second))))
+
+	if states[100].IsIdle {
+		t.Error("PID 100 at 3% should be active under research threshold 0")
+	}
+	if !states[200].IsIdle {
+		t.Error("PID 200 at 3% should be idle under inference threshold 5 after its 10s grace period")
+	}
+	if states[200].IdleDuration != 15*time.Second {
+		t.Errorf("PID 200 idle duration should count from first sub-threshold poll, got %v", states[200].IdleDuration)
+	}
+	if states[300].IsIdle {
+		t.Error("PID 300 should still be within research's 30m grace period")
+	}
+	if !states[400].IsIdle {
+		t.Error("unattributed PID 400 should use the global default policy")
+	}
+
+	states = byPID(tracker.Update(snapAt(t0.Add(31 * time.Minute))))
+	if !states[300].IsIdle {
+		t.Error("PID 300 should be idle once research's grace period has elapsed")
+	}
+}
+
+func TestStaleProcessesReported(t *testing.T) {
+	tracker := NewTracker(WithStaleTimeout(10 * time.Second))
+	t0 := time.Now()
+
+	tracker.Update(makeSnapshot(t0, []collector.ProcessSample{
+		proc(0, 100, 1<<30, 50),
+		proc(0, 200, 1<<30, 50),
+	}))
+	if stale := tracker.Stale(); len(stale) != 0 {
+		t.Fatalf("expected no stale processes, got %+v", stale)
+	}
+
+	// PID 200 disappears: stale until the timeout elapses
+	tracker.Update(makeSnapshot(t0.Add(5*time.Second), []collector.ProcessSample{
+		proc(0, 100, 1<<30, 50),
+	}))
+	stale := tracker.Stale()
+	if len(stale) != 1 || stale[0].PID != 200 || stale[0].ProcessName != "python" {
+		t.Fatalf("expected PID 200 to be stale, got %+v", stale)
+	}
+
+	tracker.Update(makeSnapshot(t0.Add(20*time.Second), []collector.ProcessSample{
+		proc(0, 100, 1<<30, 50),
+	}))
+	if stale := tracker.Stale(); len(stale) != 0 {
+		t.Errorf("expected PID 200 to be cleaned up, got %+v", stale)
+	}
+}
+
+func TestIdleReasonClassification(t *testing.T) {
+	t0 := time.Now()
+	const gib = 1 << 30
+
+	run := func(samples []collector.ProcessSample, step time.Duration) ProcessIdleState {
+		tracker := NewTracker()
+		var states []ProcessIdleState
+		for i, s := range samples {
+			states = tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*step), []collector.ProcessSample{s}))
+		}
+		return states[0]
+	}
+
+	tests := []struct {
+		name    string
+		samples []collector.ProcessSample
+		step    time.Duration
+		want    string
+	}{
+		{
+			name:    "born idle",
+			samples: []collector.ProcessSample{proc(0, 1, gib, 0), proc(0, 1, gib, 0), proc(0, 1, gib, 0)},
+			step:    time.Minute,
+			want:    ReasonNeverActive,
+		},
+		{
+			name:    "memory growing after activity",
+			samples: []collector.ProcessSample{proc(0, 1, gib, 80), proc(0, 1, gib, 0), proc(0, 1, 2*gib, 0)},
+			step:    time.Minute,
+			want:    ReasonStalled,
+		},
+		{
+			name:    "recently active, stable memory",
+			samples: []collector.ProcessSample{proc(0, 1, gib, 80), proc(0, 1, gib, 0), proc(0, 1, gib, 0)},
+			step:    time.Minute,
+			want:    ReasonWaiting,
+		},
+		{
+			name:    "long idle after activity, memory not freed",
+			samples: []collector.ProcessSample{proc(0, 1, gib, 80), proc(0, 1, gib, 0), proc(0, 1, gib, 0)},
+			step:    FinishedAfter,
+			want:    ReasonFinished,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			st := run(tc.samples, tc.step)
+			if !st.IsIdle {
+				t.Fatal("expected process to be idle")
+			}
+			if st.IdleReason != tc.want {
+				t.Errorf("expected reason %q, got %q", tc.want, st.IdleReason)
+			}
+		})
+	}
+
+	// Active processes carry no reason
+	st := run([]collector.ProcessSample{proc(0, 1, gib, 0), proc(0, 1, gib, 0), proc(0, 1, gib, 90)}, time.Minute)
+	if st.IdleReason != "" {
+		t.Errorf("active process should have no idle reason, got %q", st.IdleReason)
+	}
+}
+
+func TestTrackerBoundedUnderChurn(t *testing.T) {
+	const processes = 2000
+	mock := collector.NewMock(collector.MockConfig{GPUs: 8, Processes: processes, ChurnRate: 0.1, Seed: 1})
+	tracker := NewTracker()
+	t0 := time.Now()
+	interval := 5 * time.Second
+
+	// Churned PIDs linger for the stale timeout before cleanup, so at most
+	// staleTimeout/interval cycles' worth of departed processes can be tracked.
+	lingering := int(tracker.staleTimeout/interval+1) * processes / 10
+	for i := 0; i < 100; i++ {
+		snap, err := mock.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("cycle %d: %v", i, err)
+		}
+		snap.Timestamp = t0.Add(time.Duration(i) * interval)
+
+		results := tracker.Update(snap)
+		if len(results) != processes {
+			t.Fatalf("cycle %d: got %d results, want %d", i, len(results), processes)
+		}
+		if n := len(tracker.states); n > processes+lingering {
+			t.Fatalf("cycle %d: tracking %d processes, want at most %d", i, n, processes+lingering)
+		}
+	}
+}
+
+func TestMemorylessProcessNeverIdle(t *testing.T) {
+	// The threshold is above the util-only process's utilization, so only
+	// the memoryless flag keeps it from going idle.
+	tracker := NewTracker(WithDefaultPolicy(Policy{SmThreshold: 50}))
+	t0 := time.Now()
+
+	util := collector.ProcessSample{GPU: 0, PID: 200, SmUtil: 35, Memoryless: true}
+	for i := 0; i < 4; i++ {
+		states := tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*time.Minute), []collector.ProcessSample{
+			proc(0, 100, 1<<30, 35), util,
+		}))
+		if len(states) != 2 {
+			t.Fatalf("poll %d: expected 2 states, got %d", i, len(states))
+		}
+		mem, utilOnly := states[0], states[1]
+		if !utilOnly.Memoryless || utilOnly.SmUtil != 35 {
+			t.Errorf("poll %d: util-only process not attributed: %+v", i, utilOnly)
+		}
+		if utilOnly.IsIdle || utilOnly.IdleMemory != 0 {
+			t.Errorf("poll %d: memoryless process marked idle: %+v", i, utilOnly)
+		}
+		if i > 0 && !mem.IsIdle {
+			t.Errorf("poll %d: process holding memory below threshold should be idle", i)
+		}
+	}
+}
+
+func TestMemoryStabilityHysteresis(t *testing.T) {
+	const gib = 1 << 30
+	t0 := time.Now()
+
+	// Training steps: utilization dips to 0 at each step boundary while
+	// activations are freed and reallocated, then the job finishes and
+	// holds its memory.
+	var samples []collector.ProcessSample
+	for i := 0; i < 4; i++ {
+		samples = append(samples, proc(0, 1, 10*gib, 90), proc(0, 1, 6*gib, 0))
+	}
+	steps := len(samples)
+	for i := 0; i < 3; i++ {
+		samples = append(samples, proc(0, 1, 6*gib, 0))
+	}
+
+	countEpisodes := func(tracker *Tracker) (episodes int, final ProcessIdleState) {
+		wasIdle := false
+		for i, s := range samples {
+			final = tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*5*time.Second), []collector.ProcessSample{s}))[0]
+			if final.IsIdle && !wasIdle {
+				episodes++
+			}
+			wasIdle = final.IsIdle
+		}
+		return episodes, final
+	}
+
+	// Without hysteresis, every step boundary opens an idle episode
+	if n, _ := countEpisodes(NewTracker()); n != 4 {
+		t.Errorf("without hysteresis: expected 4 idle episodes, got %d", n)
+	}
+
+	// With hysteresis only the final, stable idle period counts
+	tracker := NewTracker(WithMemoryStability(3, 64<<20))
+	n, final := countEpisodes(tracker)
+	if n != 1 {
+		t.Errorf("with hysteresis: expected 1 idle episode, got %d", n)
+	}
+	if !final.IsIdle {
+		t.Fatal("expected the finished job to be idle")
+	}
+	// The episode started at the last step boundary, when utilization dropped
+	if want := time.Duration(len(samples)-steps) * 5 * time.Second; final.IdleDuration != want {
+		t.Errorf("expected idle duration %v, got %v", want, final.IdleDuration)
+	}
+}
+
+func TestClockSkewClampsIdleDuration(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Now().Round(0) // wall clock only, as after persisting a timestamp
+
+	tracker.Update(makeSnapshot(t0, []collector.ProcessSample{proc(0, 1, 1<<30, 0)}))
+	tracker.Update(makeSnapshot(t0.Add(10*time.Second), []collector.ProcessSample{proc(0, 1, 1<<30, 0)}))
+	if tracker.ClockSkewed() {
+		t.Fatal("no skew expected for increasing timestamps")
+	}
+
+	// The clock steps back 30s: the process went idle "after" this snapshot
+	states := tracker.Update(makeSnapshot(t0.Add(-20*time.Second), []collector.ProcessSample{proc(0, 1, 1<<30, 0)}))
+	if !tracker.ClockSkewed() {
+		t.Error("expected clock skew to be detected")
+	}
+	if !states[0].IsIdle {
+		t.Error("expected process to stay idle")
+	}
+	if states[0].IdleDuration != 0 {
+		t.Errorf("expected idle duration clamped to 0, got %v", states[0].IdleDuration)
+	}
+
+	// Time moves forward again: the idle duration grows from the skewed clock
+	states = tracker.Update(makeSnapshot(t0.Add(-15*time.Second), []collector.ProcessSample{proc(0, 1, 1<<30, 0)}))
+	if tracker.ClockSkewed() {
+		t.Error("skew should only be reported for the poll where time went backwards")
+	}
+	if states[0].IdleDuration != 5*time.Second {
+		t.Errorf("expected idle duration 5s after the skew, got %v", states[0].IdleDuration)
+	}
+}
+
+func TestCopyEngineBusyProcessIsActive(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Now()
+
+	// SMs idle, but the memory/copy engine is busy (e.g. staging data)
+	copying := proc(0, 1, 1<<30, 0)
+	copying.EngineUtil = collector.EngineUtil{Memory: 40}
+	smOnly := proc(0, 2, 1<<30, 0) // driver without a per-engine breakdown
+
+	var states []ProcessIdleState
+	for i := 0; i < 3; i++ {
+		states = tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*time.Minute), []collector.ProcessSample{copying, smOnly}))
+	}
+	if states[0].IsIdle {
+		t.Error("process busy on the copy engine should be active")
+	}
+	if !states[1].IsIdle {
+		t.Error("process with no engine activity should be idle")
+	}
+}
+
+func TestMinEpisodeDuration(t *testing.T) {
+	tracker := NewTracker(WithMinEpisodeDuration(30 * time.Second))
+	t0 := time.Now()
+	at := func(i int) time.Time { return t0.Add(time.Duration(i) * 5 * time.Second) }
+
+	// A 10s blip between steps (idle from poll 1, active again at poll 3),
+	// then a 45s idle period (polls 4-12, active again at poll 13)
+	utils := []uint32{90, 0, 0, 90, 0, 0, 0, 0, 0, 0, 0, 0, 0, 90}
+	var episodes []Episode
+	for i, u := range utils {
+		states := tracker.Update(makeSnapshot(at(i), []collector.ProcessSample{proc(0, 1, 1<<30, u)}))
+		if i == 1 && !states[0].IsIdle {
+			t.Error("the live state should reflect sub-threshold idle periods")
+		}
+		episodes = append(episodes, tracker.ClosedEpisodes()...)
+	}
+
+	if len(episodes) != 1 {
+		t.Fatalf("expected only the 45s episode to be reported, got %+v", episodes)
+	}
+	if ep := episodes[0]; !ep.Start.Equal(at(4)) || !ep.End.Equal(at(13)) || ep.Duration() != 45*time.Second {
+		t.Errorf("unexpected episode %+v", ep)
+	}
+
+	// Without a minimum, both are reported
+	tracker = NewTracker()
+	episodes = nil
+	for i, u := range utils {
+		tracker.Update(makeSnapshot(at(i), []collector.ProcessSample{proc(0, 1, 1<<30, u)}))
+		episodes = append(episodes, tracker.ClosedEpisodes()...)
+	}
+	if len(episodes) != 2 {
+		t.Errorf("expected 2 episodes without a minimum, got %d", len(episodes))
+	}
+}
+
+func TestEpisodeClosedWhenProcessDisappears(t *testing.T) {
+	tracker := NewTracker(WithMinEpisodeDuration(time.Minute))
+	t0 := time.Now()
+
+	for i := 0; i < 4; i++ {
+		tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*time.Minute), []collector.ProcessSample{proc(0, 1, 1<<30, 0)}))
+	}
+	// Gone past the stale timeout
+	tracker.Update(makeSnapshot(t0.Add(5*time.Minute), nil))
+
+	episodes := tracker.ClosedEpisodes()
+	if len(episodes) != 1 {
+		t.Fatalf("expected 1 episode, got %d", len(episodes))
+	}
+	if want := t0.Add(3 * time.Minute); !episodes[0].End.Equal(want) {
+		t.Errorf("expected episode to end when last seen (%v), got %v", want, episodes[0].End)
+	}
+}
+
+func TestIdleConfidence(t *testing.T) {
+	t0 := time.Now()
+
+	fresh := proc(0, 1, 1<<30, 0)
+	fresh.UtilSampled, fresh.UtilSampleTime = true, t0
+	stale := proc(0, 2, 1<<30, 0)
+	stale.UtilSampled, stale.UtilSampleTime = true, t0.Add(-time.Minute)
+	fallback := proc(0, 3, 1<<30, 0)
+	fallback.UtilUnavailable = true
+	unsampledBusyGPU := proc(0, 4, 1<<30, 0)
+	unsampledIdleGPU := proc(1, 5, 1<<30, 0)
+
+	snap := makeSnapshot(t0, []collector.ProcessSample{fresh, stale, fallback, unsampledBusyGPU, unsampledIdleGPU})
+	snap.Devices = []collector.DeviceInfo{{Index: 0, Utilization: 30}, {Index: 1}}
+	want := map[uint32]float64{
+		1: ConfidenceHigh,
+		2: ConfidenceStale,
+		3: ConfidenceFallback,
+		4: ConfidenceAbsent,
+		5: ConfidenceHigh,
+	}
+	for _, s := range NewTracker().Update(snap) {
+		if s.Confidence != want[s.PID] {
+			t.Errorf("PID %d: expected confidence %v, got %v", s.PID, want[s.PID], s.Confidence)
+		}
+	}
+}
+
+func TestSmoothedUtilization(t *testing.T) {
+	t0 := time.Now()
+	noisy := []uint32{40, 0, 80, 10, 60, 0, 50, 20, 70, 30}
+
+	run := func(tracker *Tracker) (smoothed []float64) {
+		for i, u := range noisy {
+			states := tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*5*time.Second), []collector.ProcessSample{proc(0, 1, 1<<30, u)}))
+			if states[0].SmUtil != u {
+				t.Fatalf("poll %d: raw utilization should pass through, got %d want %d", i, states[0].SmUtil, u)
+			}
+			smoothed = append(smoothed, states[0].SmoothedUtil)
+		}
+		return smoothed
+	}
+
+	// Swings between consecutive polls are much smaller than the raw ones
+	var rawSwing, smoothSwing float64
+	smoothed := run(NewTracker(WithUtilSmoothing(0.2)))
+	for i := 1; i < len(noisy); i++ {
+		rawSwing = max(rawSwing, math.Abs(float64(noisy[i])-float64(noisy[i-1])))
+		smoothSwing = max(smoothSwing, math.Abs(smoothed[i]-smoothed[i-1]))
+	}
+	if smoothSwing > rawSwing/4 {
+		t.Errorf("expected smoothed swings well below raw (max %v), got max %v: %v", rawSwing, smoothSwing, smoothed)
+	}
+	// Seeded with the first sample, then moves a fifth of the way each poll
+	if smoothed[0] != 40 || smoothed[1] != 32 || smoothed[2] != 41.6 {
+		t.Errorf("unexpected EWMA %v", smoothed[:3])
+	}
+
+	// A factor of 1 disables smoothing
+	for i, v := range run(NewTracker(WithUtilSmoothing(1))) {
+		if v != float64(noisy[i]) {
+			t.Errorf("poll %d: expected raw value %d with smoothing disabled, got %v", i, noisy[i], v)
+		}
+	}
+}
+
+func TestNewProcessRate(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Now()
+	update := func(offset time.Duration, pids ...uint32) {
+		var procs []collector.ProcessSample
+		for _, pid := range pids {
+			procs = append(procs, proc(0, pid, 1<<30, 50))
+		}
+		tracker.Update(makeSnapshot(t0.Add(offset), procs))
+	}
+
+	// Processes already running at startup are new, but there's no rate yet
+	update(0, 1, 2)
+	if tracker.NewProcesses() != 2 || tracker.NewProcessRate() != 0 {
+		t.Errorf("first update: expected 2 new at rate 0, got %d at %v", tracker.NewProcesses(), tracker.NewProcessRate())
+	}
+
+	// A burst of 20 short-lived processes within 5s
+	burst := []uint32{1, 2}
+	for pid := uint32(100); pid < 120; pid++ {
+		burst = append(burst, pid)
+	}
+	update(5*time.Second, burst...)
+	if tracker.NewProcesses() != 20 || tracker.NewProcessRate() != 4 {
+		t.Errorf("burst: expected 20 new at 4/s, got %d at %v", tracker.NewProcesses(), tracker.NewProcessRate())
+	}
+
+	// Steady state
+	update(15*time.Second, 1, 2)
+	if tracker.NewProcesses() != 0 || tracker.NewProcessRate() != 0 {
+		t.Errorf("steady: expected no new processes, got %d at %v", tracker.NewProcesses(), tracker.NewProcessRate())
+	}
+	update(25*time.Second, 1, 2, 200)
+	if tracker.NewProcessRate() != 0.1 {
+		t.Errorf("expected 1 new process over 10s = 0.1/s, got %v", tracker.NewProcessRate())
+	}
+}
+
+func TestGlobalSmThresholdBoundary(t *testing.T) {
+	tracker := NewTracker(WithDefaultPolicy(Policy{SmThreshold: 3}))
+	t0 := time.Now()
+	update := func(i int, utilA, utilB uint32) map[uint32]ProcessIdleState {
+		states := tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*5*time.Second), []collector.ProcessSample{
+			proc(0, 1, 1<<30, utilA), proc(0, 2, 1<<30, utilB),
+		}))
+		m := make(map[uint32]ProcessIdleState, len(states))
+		for _, s := range states {
+			m[s.PID] = s
+		}
+		return m
+	}
+
+	// Keepalive work exactly at the threshold is idle; one above is active
+	update(0, 3, 4)
+	states := update(1, 3, 4)
+	if !states[1].IsIdle {
+		t.Error("3% should be idle with threshold 3")
+	}
+	if states[2].IsIdle {
+		t.Error("4% should be active with threshold 3")
+	}
+
+	// Once idle, staying at the threshold keeps the episode going; going one
+	// above resets it
+	update(2, 3, 0)
+	states = update(3, 4, 3)
+	if states[1].IsIdle {
+		t.Error("rising to 4% should make PID 1 active")
+	}
+	if !states[2].IsIdle || states[2].IdleDuration != 5*time.Second {
+		t.Errorf("PID 2 at 3%% should stay idle since poll 2, got idle=%v for %v", states[2].IsIdle, states[2].IdleDuration)
+	}
+}
+
+func TestIdleConfirmCount(t *testing.T) {
+	tracker := NewTracker(WithIdleConfirmCount(3))
+	t0 := time.Now()
+	update := func(i int, util uint32) ProcessIdleState {
+		return tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*5*time.Second), []collector.ProcessSample{proc(0, 1, 1<<30, util)}))[0]
+	}
+
+	update(0, 90)
+	// Two polls without samples, then activity again: no idle flap
+	for i, util := range []uint32{0, 0, 80} {
+		if s := update(1+i, util); s.IsIdle {
+			t.Fatalf("poll %d: marked idle after fewer than 3 consecutive idle polls", 1+i)
+		}
+	}
+	// Three consecutive idle polls are needed
+	for i := 4; i <= 5; i++ {
+		if s := update(i, 0); s.IsIdle {
+			t.Fatalf("poll %d: marked idle too early", i)
+		}
+	}
+	s := update(6, 0)
+	if !s.IsIdle {
+		t.Fatal("expected idle after 3 consecutive idle polls")
+	}
+	// Idle since the first of them
+	if s.IdleDuration != 10*time.Second {
+		t.Errorf("expected idle duration 10s, got %v", s.IdleDuration)
+	}
+
+	// The default still goes idle on the first idle poll
+	tracker = NewTracker()
+	update(0, 90)
+	if s := update(1, 0); !s.IsIdle {
+		t.Error("default: expected idle on the first idle poll")
+	}
+}
+
+func TestTransitionLogging(t *testing.T) {
+	var buf strings.Builder
+	debug := WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	t0 := time.Now()
+	existing := make([]collector.ProcessSample, 50)
+	for i := range existing {
+		existing[i] = proc(0, uint32(100+i), 1<<30, 50)
+	}
+
+	tracker := NewTracker(debug)
+	tracker.Update(makeSnapshot(t0, existing))
+	if n := strings.Count(buf.String(), "new process detected"); n != 0 {
+		t.Errorf("expected the startup burst to be suppressed, got %d new process lines:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "event=startup processes=50") {
+		t.Errorf("expected a startup summary:\n%s", buf.String())
+	}
+
+	// After startup, new processes are logged, sampled per poll
+	buf.Reset()
+	more := append(existing, proc(0, 900, 1<<30, 50), proc(0, 901, 1<<30, 50))
+	tracker.Update(makeSnapshot(t0.Add(5*time.Second), more))
+	if n := strings.Count(buf.String(), "new process detected"); n != 2 {
+		t.Errorf("expected 2 new process lines after startup, got %d:\n%s", n, buf.String())
+	}
+
+	buf.Reset()
+	burst := append([]collector.ProcessSample(nil), more...)
+	for i := 0; i < maxTransitionLogs+5; i++ {
+		burst = append(burst, proc(0, uint32(1000+i), 1<<30, 50))
+	}
+	tracker.Update(makeSnapshot(t0.Add(10*time.Second), burst))
+	if n := strings.Count(buf.String(), "new process detected"); n != maxTransitionLogs {
+		t.Errorf("expected %d sampled lines, got %d", maxTransitionLogs, n)
+	}
+	if !strings.Contains(buf.String(), "suppressed=5") {
+		t.Errorf("expected a summary of the suppressed lines:\n%s", buf.String())
+	}
+
+	// "all" logs the startup burst too, "off" logs nothing
+	buf.Reset()
+	NewTracker(debug, WithTransitionLogging(LogTransitionsAll)).Update(makeSnapshot(t0, existing))
+	if n := strings.Count(buf.String(), "new process detected"); n != len(existing) {
+		t.Errorf("expected every process logged with LogTransitionsAll, got %d", n)
+	}
+	buf.Reset()
+	quiet := NewTracker(debug, WithTransitionLogging(LogTransitionsOff))
+	quiet.Update(makeSnapshot(t0, existing))
+	quiet.Update(makeSnapshot(t0.Add(5*time.Second), more))
+	if buf.Len() != 0 {
+		t.Errorf("expected no logs with LogTransitionsOff, got:\n%s", buf.String())
+	}
+
+	// Transitions are debug logs: an info logger gets only the startup summary
+	buf.Reset()
+	info := NewTracker(WithLogger(slog.New(slog.NewTextHandler(&buf, nil))), WithTransitionLogging(LogTransitionsAll))
+	info.Update(makeSnapshot(t0, existing))
+	info.Update(makeSnapshot(t0.Add(5*time.Second), burst))
+	if strings.Contains(buf.String(), "new process detected") || strings.Contains(buf.String(), "not logged") {
+		t.Errorf("expected no transitions at info level, got:\n%s", buf.String())
+	}
+}
+
+func TestDataMovementKeepsProcessActive(t *testing.T) {
+	tracker := NewTracker(WithDefaultPolicy(Policy{GracePeriod: 0}))
+	t0 := time.Now()
+	moving := func(pid uint32, moved uint64) collector.ProcessSample {
+		p := proc(0, pid, 1<<30, 0)
+		p.DataMovedBytes, p.HasDataMoved = moved, true
+		return p
+	}
+
+	// PID 100 exchanges 1 GiB per 10s poll at 0% SM; PID 200 moves only
+	// 1 MiB per poll; PID 300 has no counter and is judged on SM alone
+	var moved100, moved200 uint64
+	var states []ProcessIdleState
+	for i := 0; i < 4; i++ {
+		states = tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*10*time.Second), []collector.ProcessSample{
+			moving(100, moved100), moving(200, moved200), proc(0, 300, 1<<30, 0),
+		}))
+		moved100 += 1 << 30
+		moved200 += 1 << 20
+	}
+	byPID := make(map[uint32]ProcessIdleState)
+	for _, s := range states {
+		byPID[s.PID] = s
+	}
+	if byPID[100].IsIdle {
+		t.Error("expected a zero-SM process moving 100 MiB/s to be active")
+	}
+	if byPID[100].DataMoved != 1<<30 || !byPID[100].HasDataMoved {
+		t.Errorf("expected 1 GiB moved since the previous poll, got %d (known=%v)", byPID[100].DataMoved, byPID[100].HasDataMoved)
+	}
+	if !byPID[200].IsIdle {
+		t.Error("expected a process moving 100 KiB/s to be idle")
+	}
+	if !byPID[300].IsIdle || byPID[300].HasDataMoved {
+		t.Error("expected a process without a counter to fall back to SM-only detection")
+	}
+
+	// Once the traffic stops, the process goes idle
+	states = tracker.Update(makeSnapshot(t0.Add(40*time.Second), []collector.ProcessSample{moving(100, moved100-1<<30)}))
+	if !states[0].IsIdle {
+		t.Error("expected the process to go idle once it stopped moving data")
+	}
+}
+
+func TestIdleTotalAccumulates(t *testing.T) {
+	tracker := NewTracker(WithDefaultPolicy(Policy{GracePeriod: 0}))
+	t0 := time.Now()
+	utils := []uint32{50, 0, 0, 0, 80, 0, 0} // polls every 10s
+	var states []ProcessIdleState
+	for i, u := range utils {
+		states = tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*10*time.Second), []collector.ProcessSample{proc(0, 100, 1<<30, u)}))
+	}
+	// Idle from poll 1 until turning active at poll 4 (30s), and again from
+	// poll 5 (10s so far)
+	if got := states[0].IdleTotal; got != 40*time.Second {
+		t.Errorf("expected 40s of accumulated idle time, got %v", got)
+	}
+	if got := states[0].IdleDuration; got != 10*time.Second {
+		t.Errorf("expected the current episode at 10s, got %v", got)
+	}
+}
+
+func TestSuspendedTimeNotIdle(t *testing.T) {
+	tracker := NewTracker(WithDefaultPolicy(Policy{GracePeriod: 0}))
+	t0 := time.Now()
+	// Polls every 10s: active, then idle throughout, stopped (SIGSTOP) at
+	// the 20s and 30s polls and resumed by the 40s poll
+	stopped := []bool{false, false, true, true, false, false}
+	wantDuration := []time.Duration{0, 0, 10 * time.Second, 10 * time.Second, 10 * time.Second, 20 * time.Second}
+	var states []ProcessIdleState
+	for i, s := range stopped {
+		util := uint32(0)
+		if i == 0 {
+			util = 50
+		}
+		snap := makeSnapshot(t0.Add(time.Duration(i)*10*time.Second), []collector.ProcessSample{proc(0, 100, 1<<30, util)})
+		snap.Suspended = map[uint32]bool{100: s}
+		states = tracker.Update(snap)
+		if states[0].Suspended != s {
+			t.Errorf("poll %d: expected suspended=%v, got %v", i, s, states[0].Suspended)
+		}
+		if got := states[0].IdleDuration; got != wantDuration[i] {
+			t.Errorf("poll %d: expected idle duration %v, got %v", i, wantDuration[i], got)
+		}
+	}
+	// 40s idle, of which 20s (from the 20s poll to the 40s poll) suspended
+	if got := states[0].IdleTotal; got != 20*time.Second {
+		t.Errorf("expected 20s of accumulated idle time, got %v", got)
+	}
+}
+
+func TestResidencyGrowsAcrossStates(t *testing.T) {
+	tracker := NewTracker(WithDefaultPolicy(Policy{GracePeriod: 0}), WithStaleTimeout(30*time.Second))
+	t0 := time.Now()
+	utils := []uint32{50, 0, 0, 80, 0} // polls every 10s: active, idle, active, idle
+	var prev time.Duration
+	for i, u := range utils {
+		states := tracker.Update(makeSnapshot(t0.Add(time.Duration(i)*10*time.Second), []collector.ProcessSample{proc(0, 100, 1<<30, u)}))
+		if got, want := states[0].Residency, time.Duration(i)*10*time.Second; got != want {
+			t.Errorf("poll %d: expected residency %v, got %v", i, want, got)
+		}
+		if i > 0 && states[0].Residency <= prev {
+			t.Errorf("poll %d: residency went from %v to %v", i, prev, states[0].Residency)
+		}
+		prev = states[0].Residency
+	}
+
+	// Once cleaned up as stale, a reappearing PID starts over
+	tracker.Update(makeSnapshot(t0.Add(100*time.Second), nil))
+	states := tracker.Update(makeSnapshot(t0.Add(110*time.Second), []collector.ProcessSample{proc(0, 100, 1<<30, 0)}))
+	if states[0].Residency != 0 {
+		t.Errorf("expected residency to restart after stale cleanup, got %v", states[0].Residency)
+	}
+}
+
+func TestPowerFloor(t *testing.T) {
+	t0 := time.Now()
+	snapAt := func(i int, watts float64) *collector.Snapshot {
+		snap := makeSnapshot(t0.Add(time.Duration(i)*10*time.Second), []collector.ProcessSample{proc(0, 100, 1<<30, 0)})
+		snap.Devices = []collector.DeviceInfo{{Index: 0, PowerWatts: watts}}
+		return snap
+	}
+
+	t.Run("above floor", func(t *testing.T) {
+		tracker := NewTracker(WithDefaultPolicy(Policy{GracePeriod: 0}), WithPowerFloorWatts(80))
+		var states []ProcessIdleState
+		for i := 0; i < 3; i++ {
+			states = tracker.Update(snapAt(i, 200))
+		}
+		if states[0].IsIdle {
+			t.Error("expected a 0% SM process on a GPU drawing 200W to be active")
+		}
+		// Power drops to the idle floor: the process goes idle
+		tracker.Update(snapAt(3, 60))
+		states = tracker.Update(snapAt(4, 60))
+		if !states[0].IsIdle {
+			t.Error("expected the process to go idle once power fell below the floor")
+		}
+	})
+
+	t.Run("below floor", func(t *testing.T) {
+		tracker := NewTracker(WithDefaultPolicy(Policy{GracePeriod: 0}), WithPowerFloorWatts(80))
+		var states []ProcessIdleState
+		for i := 0; i < 3; i++ {
+			states = tracker.Update(snapAt(i, 60))
+		}
+		if !states[0].IsIdle {
+			t.Error("expected a 0% SM process on a GPU at idle power to be idle")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		tracker := NewTracker(WithDefaultPolicy(Policy{GracePeriod: 0}))
+		var states []ProcessIdleState
+		for i := 0; i < 3; i++ {
+			states = tracker.Update(snapAt(i, 200))
+		}
+		if !states[0].IsIdle {
+			t.Error("expected power to be ignored without a floor")
+		}
+	})
+}
+
+func TestStaleTimeoutOption(t *testing.T) {
+	if got := NewTracker().StaleTimeout(); got != DefaultStaleTimeout {
+		t.Errorf("expected the default stale timeout %v, got %v", DefaultStaleTimeout, got)
+	}
+	if got := NewTracker(WithStaleTimeout(0)).StaleTimeout(); got != DefaultStaleTimeout {
+		t.Errorf("expected a zero stale timeout to be ignored, got %v", got)
+	}
+
+	// A process gone for 90s is still remembered with a 2m timeout
+	tracker := NewTracker(WithStaleTimeout(2 * time.Minute))
+	t0 := time.Now()
+	tracker.Update(makeSnapshot(t0, []collector.ProcessSample{proc(0, 100, 1<<30, 0)}))
+	tracker.Update(makeSnapshot(t0.Add(90*time.Second), nil))
+	if n := len(tracker.Stale()); n != 1 {
+		t.Fatalf("expected the vanished process to still be tracked, got %d stale", n)
+	}
+	tracker.Update(makeSnapshot(t0.Add(121*time.Second), nil))
+	if n := len(tracker.Stale()); n != 0 {
+		t.Errorf("expected the process to be forgotten after 2m, got %d stale", n)
+	}
+}
+
+func TestDeviceIdle(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Now()
+	device := func(gpu int, util uint32) collector.DeviceInfo {
+		return collector.DeviceInfo{Index: gpu, Utilization: util}
+	}
+	update := func(at time.Time, devices []collector.DeviceInfo, procs ...collector.ProcessSample) []DeviceIdleState {
+		snap := makeSnapshot(at, procs)
+		snap.Devices = devices
+		tracker.Update(snap)
+		return tracker.Devices()
+	}
+
+	// GPU 0 is unused; GPU 1 runs a process that is new, so assumed active
+	devs := update(t0, []collector.DeviceInfo{device(0, 0), device(1, 0)}, proc(1, 100, 1<<30, 0))
+	if !devs[0].IsIdle || devs[0].IdleDuration != 0 {
+		t.Errorf("GPU 0: expected idle for 0s, got %+v", devs[0])
+	}
+	if devs[1].IsIdle {
+		t.Errorf("GPU 1: expected active with a new process, got %+v", devs[1])
+	}
+
+	// The process goes idle, so GPU 1 is idle too
+	devs = update(t0.Add(10*time.Second), []collector.DeviceInfo{device(0, 0), device(1, 0)}, proc(1, 100, 1<<30, 0))
+	if devs[0].IdleDuration != 10*time.Second {
+		t.Errorf("GPU 0: expected idle for 10s, got %v", devs[0].IdleDuration)
+	}
+	if !devs[1].IsIdle || devs[1].IdleDuration != 0 {
+		t.Errorf("GPU 1: expected newly idle, got %+v", devs[1])
+	}
+
+	// GPU 0 is busy with no visible process, e.g. another container's;
+	// GPU 1's process resumes work
+	devs = update(t0.Add(20*time.Second), []collector.DeviceInfo{device(0, 80), device(1, 90)}, proc(1, 100, 1<<30, 90))
+	for _, d := range devs {
+		if d.IsIdle || d.IdleDuration != 0 {
+			t.Errorf("GPU %d: expected active, got %+v", d.GPU, d)
+		}
+	}
+
+	// Idle again: durations restart rather than carrying the old episode
+	update(t0.Add(30*time.Second), []collector.DeviceInfo{device(0, 0)})
+	devs = update(t0.Add(40*time.Second), []collector.DeviceInfo{device(0, 0)})
+	if len(devs) != 1 || devs[0].IdleDuration != 10*time.Second {
+		t.Errorf("expected GPU 0 idle for 10s since going idle again, got %+v", devs)
+	}
+}
+
+func TestSystemGPUs(t *testing.T) {
+	system, err := collector.ParseGPUSet("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(WithSystemGPUs(system))
+	t0 := time.Now()
+	devices := []collector.DeviceInfo{{Index: 0, UUID: "GPU-a"}, {Index: 1, UUID: "GPU-b"}}
+	update := func(at time.Time, util uint32) []ProcessIdleState {
+		snap := makeSnapshot(at, []collector.ProcessSample{proc(0, 100, 1<<30, util), proc(1, 200, 1<<30, util)})
+		snap.Devices = devices
+		return tracker.Update(snap)
+	}
+
+	update(t0, 0)
+	states := update(t0.Add(10*time.Second), 0)
+	// The window manager on GPU 0 is still tracked as idle, but marked system
+	for _, ps := range states {
+		if !ps.IsIdle {
+			t.Errorf("PID %d: expected idle, got %+v", ps.PID, ps)
+		}
+		if ps.System != (ps.GPU == 0) {
+			t.Errorf("PID %d on GPU %d: expected System %v, got %v", ps.PID, ps.GPU, ps.GPU == 0, ps.System)
+		}
+	}
+	devs := tracker.Devices()
+	if devs[0].IsIdle || !devs[1].IsIdle {
+		t.Errorf("expected only GPU 1 idle as a whole, got %+v", devs)
+	}
+
+	// Both resume work: only the episode on GPU 1 is reported
+	update(t0.Add(20*time.Second), 50)
+	episodes := tracker.ClosedEpisodes()
+	if len(episodes) != 1 || episodes[0].GPU != 1 {
+		t.Errorf("expected only the episode on GPU 1, got %+v", episodes)
+	}
+}
@@ -0,0 +1,73 @@
+// Package listconfig parses the comma-separated list options of the
+// exporter's configuration, such as GPU_INCLUDE or DEVICE_LABELS, with the
+// same validation and error reporting for all of them.
+package listconfig
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxEntries bounds the entries of a list option. Longer lists are almost
+// certainly a mistake, e.g. a file pasted into the variable.
+const MaxEntries = 256
+
+// Error reports the entries dropped from a list option.
+type Error struct {
+	Problems []string // one per invalid entry, plus one for entries over the limit
+	Dropped  int      // number of entries dropped
+}
+
+func (e *Error) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Parse splits a comma-separated list option into its trimmed entries,
+// skipping empty ones and repeats. Entries that fail validate (if not nil)
+// and those beyond the first max valid ones are dropped. The valid entries
+// are returned along with an *Error describing every dropped one, or a nil
+// error if none were.
+func Parse(s string, max int, validate func(entry string) error) ([]string, error) {
+	var entries []string
+	seen := make(map[string]bool)
+	lerr := &Error{}
+	over := 0
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		if validate != nil {
+			if err := validate(entry); err != nil {
+				lerr.Problems = append(lerr.Problems, fmt.Sprintf("%q: %v", entry, err))
+				lerr.Dropped++
+				continue
+			}
+		}
+		if len(entries) >= max {
+			over++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if over > 0 {
+		lerr.Problems = append(lerr.Problems, fmt.Sprintf("%d entries over the limit of %d", over, max))
+		lerr.Dropped += over
+	}
+	if lerr.Dropped == 0 {
+		return entries, nil
+	}
+	return entries, lerr
+}
+
+// Dropped returns how many entries err reports as dropped, 0 if it isn't
+// an *Error.
+func Dropped(err error) int {
+	var lerr *Error
+	if errors.As(err, &lerr) {
+		return lerr.Dropped
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package listconfig
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// digits accepts decimal numbers only.
+func digits(entry string) error {
+	if _, err := strconv.Atoi(entry); err != nil {
+		return errors.New("not a number")
+	}
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		in      string
+		max     int
+		want    []string
+		dropped int
+	}{
+		{"valid", " 1, 2 ,,3,", 10, []string{"1", "2", "3"}, 0},
+		{"empty", "", 10, nil, 0},
+		{"repeats", "1,2,1", 10, []string{"1", "2"}, 0},
+		{"malformed", "1,x,2,y", 10, []string{"1", "2"}, 2},
+		{"oversized", "1,2,3,4,5", 3, []string{"1", "2", "3"}, 2},
+		{"malformed and oversized", "1,x,2,3", 2, []string{"1", "2"}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.in, tc.max, digits)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected entries %v, got %v", tc.want, got)
+			}
+			if n := Dropped(err); n != tc.dropped {
+				t.Errorf("expected %d dropped, got %d (%v)", tc.dropped, n, err)
+			}
+			if (err == nil) != (tc.dropped == 0) {
+				t.Errorf("expected an error only if entries were dropped, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseAggregatesErrors(t *testing.T) {
+	_, err := Parse("x,1,y,2,3", 2, digits)
+	for _, want := range []string{`"x": not a number`, `"y": not a number`, "1 entries over the limit of 2"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to mention %s, got %v", want, err)
+		}
+	}
+	if n := Dropped(fmt.Errorf("loading: %w", err)); n != 3 {
+		t.Errorf("expected 3 dropped through wrapping, got %d", n)
+	}
+	if n := Dropped(errors.New("other")); n != 0 {
+		t.Errorf("expected 0 dropped for another error, got %d", n)
+	}
+}